@@ -42,6 +42,16 @@ func initModels() error {
 		&model.HistoryOfSeeders{},
 		&model.SlaveSetting{},
 		&model.SlaveCert{},
+		&model.SlaveEnrollToken{},
+		&model.Geofile{},
+		&model.SlaveGeofile{},
+		&model.StoredCert{},
+		&model.SlaveStoredCert{},
+		&model.SlaveGroup{},
+		&model.SlaveGroupMember{},
+		&model.ReplicatedInbound{},
+		&model.ReplicaOverride{},
+		&model.ReplicatedClientAccount{},
 	}
 	for _, model := range models {
 		if err := db.AutoMigrate(model); err != nil {
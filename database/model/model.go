@@ -78,8 +78,55 @@ type Slave struct {
 	LastSeen    int64  `json:"lastSeen" form:"lastSeen"`
 	Version     string `json:"version" form:"version"` // Slave version
 	SystemStats string `json:"systemStats" form:"systemStats"` // CPU/Mem stats (JSON)
+
+	// Config push tracking
+	DesiredRevision int64  `json:"desiredRevision" form:"desiredRevision" gorm:"default:0"` // Revision of the last config pushed by the master
+	AppliedRevision int64  `json:"appliedRevision" form:"appliedRevision" gorm:"default:0"` // Revision the slave confirmed running
+	ApplyStatus     string `json:"applyStatus" form:"applyStatus"`                          // pending, applied, failed, unacknowledged
+	ApplyError      string `json:"applyError" form:"applyError"`                            // Xray start error and per-inbound bind failures
+	AppliedAt       int64  `json:"appliedAt" form:"appliedAt" gorm:"default:0"`             // Time of the last acknowledgement
+
+	// Drift: the config the slave reports running differs from the one the master would push now
+	ConfigDrift     bool  `json:"configDrift" form:"configDrift" gorm:"default:false"`
+	DriftDetectedAt int64 `json:"driftDetectedAt" form:"driftDetectedAt" gorm:"default:0"`
+
+	TrafficSeq int64 `json:"trafficSeq" form:"trafficSeq" gorm:"default:0"` // Sequence number of the last traffic report counted
+
+	// Authentication
+	NextSecret      string `json:"-" form:"-"`                             // Secret handed to the slave during rotation, valid until it confirms
+	CertFingerprint string `json:"certFingerprint" form:"certFingerprint"` // SHA-256 of the client certificate issued for mutual TLS
+
+	Geodata string `json:"geodata" form:"geodata"` // Geofile versions the slave reported, JSON object of name to version
+
+	Labels string `json:"labels" form:"labels"` // JSON object of label name to value, e.g. {"region":"eu","tier":"premium"}
+
+	// Maintenance: a draining slave is left out of subscriptions and account placement and its alerts are muted
+	Draining        bool  `json:"draining" form:"draining" gorm:"default:false"`
+	DrainStartedAt  int64 `json:"drainStartedAt" form:"drainStartedAt" gorm:"default:0"`
+	DrainXrayStopAt int64 `json:"drainXrayStopAt" form:"drainXrayStopAt" gorm:"default:0"` // When Xray is shut down for the maintenance, 0 keeps it running
+
+	Weight int `json:"weight" form:"weight" gorm:"default:100"` // Share of subscription users relative to other slaves when links are ordered by load
+
+	// Where Address comes from; subscription links point at it
+	AddressSource   string `json:"addressSource" form:"addressSource" gorm:"default:auto"` // auto, observed or manual
+	ObservedAddress string `json:"observedAddress" form:"observedAddress"`                 // Remote address of the slave's connection as the master sees it
 }
 
+// Config apply states reported in Slave.ApplyStatus
+const (
+	ApplyStatusPending        = "pending"
+	ApplyStatusApplied        = "applied"
+	ApplyStatusFailed         = "failed"
+	ApplyStatusUnacknowledged = "unacknowledged" // slave does not send acknowledgements
+)
+
+// Sources of Slave.Address
+const (
+	AddressSourceAuto     = "auto"     // reported by the slave, else the observed address
+	AddressSourceObserved = "observed" // remote address of the slave's connection
+	AddressSourceManual   = "manual"   // set by the operator
+)
+
 func (Slave) TableName() string {
 	return "slaves"
 }
@@ -109,6 +156,8 @@ type Inbound struct {
 	Tag            string   `json:"tag" form:"tag" gorm:"unique"`
 	Sniffing       string   `json:"sniffing" form:"sniffing"`
 	Address        string   `json:"address" form:"address"` // Custom domain/IP for subscription links (optional)
+
+	ReplicaOf int `json:"replicaOf" form:"replicaOf" gorm:"default:0;index"` // ReplicatedInbound this inbound is rendered from (0 = standalone)
 }
 
 // OutboundTraffics tracks traffic statistics for Xray outbound connections.
@@ -257,8 +306,134 @@ type SlaveCert struct {
 	KeyPath     string `json:"keyPath" form:"keyPath" gorm:"not null"`
 	ExpiryTime  int64  `json:"expiryTime" form:"expiryTime"`  // Certificate expiry timestamp
 	LastUpdated int64  `json:"lastUpdated" form:"lastUpdated"` // Last time cert info was updated
+
+	// ACME parameters of certificates the master had the slave obtain; these are renewed before they expire
+	Acme          bool   `json:"acme" form:"acme"`
+	AcmeEmail     string `json:"acmeEmail" form:"acmeEmail"`
+	AcmeDirectory string `json:"acmeDirectory" form:"acmeDirectory"` // ACME directory URL, empty for Let's Encrypt
+	AcmeChallenge string `json:"acmeChallenge" form:"acmeChallenge"`
+	AcmePort      int    `json:"acmePort" form:"acmePort"`
+	LastAttempt   int64  `json:"lastAttempt" form:"lastAttempt"` // Last issuance or renewal attempt
+	LastError     string `json:"lastError" form:"lastError"`     // Error of the last attempt, empty if it succeeded
 }
 
 func (SlaveCert) TableName() string {
 	return "slave_certs"
 }
+
+// SlaveEnrollToken is a short-lived, single-use token a node presents to join the cluster.
+// Tokens for a new node create the slave on use; tokens for an existing slave give it a fresh secret.
+// Used tokens are kept as the record of the enrollment.
+type SlaveEnrollToken struct {
+	Id              int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	TokenHash       string `json:"-" gorm:"not null;uniqueIndex"`          // SHA-256 of the token, the token itself is never stored
+	Name            string `json:"name" form:"name"`                       // Preset name for the new slave
+	TemplateSlaveId int    `json:"templateSlaveId" form:"templateSlaveId"` // Slave whose settings are copied to the new one (0 = defaults)
+	GroupId         int    `json:"groupId" form:"groupId"`                 // Group the new slave joins (0 = none)
+	SlaveId         int    `json:"slaveId" form:"slaveId" gorm:"index"`    // Existing slave to re-enroll, or the slave created on use
+	ExpiresAt       int64  `json:"expiresAt" form:"expiresAt"`
+	CreatedAt       int64  `json:"createdAt" form:"createdAt"`
+	UsedAt          int64  `json:"usedAt" form:"usedAt" gorm:"default:0"`
+	UsedIp          string `json:"usedIp" form:"usedIp"`             // Address the node enrolled from
+	UsedHostname    string `json:"usedHostname" form:"usedHostname"` // Hostname reported by the node
+}
+
+func (SlaveEnrollToken) TableName() string {
+	return "slave_enroll_tokens"
+}
+
+// Geofile is a geodata file the master keeps for distribution to slaves, either refreshed from
+// the master's own Xray assets or uploaded. The file itself lives in the master's geodata folder.
+type Geofile struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string `json:"name" form:"name" gorm:"not null;uniqueIndex"` // File name as Xray references it, e.g. geosite_custom.dat
+	Version   int64  `json:"version" form:"version"`                       // Bumped whenever the content changes
+	Sha256    string `json:"sha256" form:"sha256"`
+	Size      int64  `json:"size" form:"size"`
+	UpdatedAt int64  `json:"updatedAt" form:"updatedAt"`
+}
+
+// SlaveGeofile assigns a geofile to a slave, which then keeps the latest version of it.
+type SlaveGeofile struct {
+	Id      int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	SlaveId int    `json:"slaveId" form:"slaveId" gorm:"not null;uniqueIndex:idx_slave_geofile"`
+	Name    string `json:"name" form:"name" gorm:"not null;uniqueIndex:idx_slave_geofile"`
+}
+
+// StoredCert is a certificate the master keeps for distribution to slaves, e.g. a wildcard or corporate
+// certificate obtained centrally. Slaves install it as fullchain.pem and privkey.pem in /root/cert/<Name>.
+type StoredCert struct {
+	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name       string `json:"name" form:"name" gorm:"not null;uniqueIndex"` // Directory name on the slaves
+	Domains    string `json:"domains" form:"domains"`                       // Comma separated names the certificate covers
+	Cert       string `json:"-" gorm:"type:text"`                           // PEM chain
+	KeyEnc     string `json:"-" gorm:"type:text"`                           // PEM private key, encrypted with the master's cert store key
+	Sha256     string `json:"sha256" form:"sha256"`                         // Of the chain and key, identifies the content
+	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"`
+	Version    int64  `json:"version" form:"version"` // Bumped whenever the content changes
+	UpdatedAt  int64  `json:"updatedAt" form:"updatedAt"`
+}
+
+// SlaveStoredCert assigns a stored certificate to a slave, which then keeps the latest version of it.
+type SlaveStoredCert struct {
+	Id      int `json:"id" gorm:"primaryKey;autoIncrement"`
+	SlaveId int `json:"slaveId" form:"slaveId" gorm:"not null;uniqueIndex:idx_slave_stored_cert"`
+	CertId  int `json:"certId" form:"certId" gorm:"not null;uniqueIndex:idx_slave_stored_cert"`
+}
+
+// SlaveGroup is a named set of slaves that operations can target as a whole.
+type SlaveGroup struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name        string `json:"name" form:"name" gorm:"not null;uniqueIndex"` // Referenced as group=<name> in slave selectors
+	Description string `json:"description" form:"description"`
+}
+
+// SlaveGroupMember puts a slave in a group. A slave may be in several groups.
+type SlaveGroupMember struct {
+	Id      int `json:"id" gorm:"primaryKey;autoIncrement"`
+	GroupId int `json:"groupId" form:"groupId" gorm:"not null;uniqueIndex:idx_slave_group_member"`
+	SlaveId int `json:"slaveId" form:"slaveId" gorm:"not null;uniqueIndex:idx_slave_group_member"`
+}
+
+// ReplicatedInbound is one logical inbound deployed as a concrete Inbound on every slave its selector matches.
+// Clients are kept here once; every replica carries them with a per-slave email.
+type ReplicatedInbound struct {
+	Id           int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	UserId       int    `json:"-"`
+	Remark       string `json:"remark" form:"remark"`
+	Enable       bool   `json:"enable" form:"enable"`
+	Selector     string `json:"selector" form:"selector"` // Slave selector of the members, e.g. group=edge
+	Total        int64  `json:"total" form:"total"`
+	ExpiryTime   int64  `json:"expiryTime" form:"expiryTime"`
+	TrafficReset string `json:"trafficReset" form:"trafficReset" gorm:"default:never"`
+
+	// Xray configuration fields, rendered into every replica
+	Listen         string   `json:"listen" form:"listen"`
+	Port           int      `json:"port" form:"port"`
+	Protocol       Protocol `json:"protocol" form:"protocol"`
+	Settings       string   `json:"settings" form:"settings"`
+	StreamSettings string   `json:"streamSettings" form:"streamSettings"`
+	Sniffing       string   `json:"sniffing" form:"sniffing"`
+	Address        string   `json:"address" form:"address"`
+
+	Overrides []ReplicaOverride `json:"overrides" form:"overrides" gorm:"foreignKey:ReplicatedInboundId;references:Id"`
+}
+
+// ReplicaOverride changes the port, SNI or subscription address of the replica on one slave.
+type ReplicaOverride struct {
+	Id                  int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ReplicatedInboundId int    `json:"replicatedInboundId" gorm:"not null;uniqueIndex:idx_replica_override"`
+	SlaveId             int    `json:"slaveId" form:"slaveId" gorm:"not null;uniqueIndex:idx_replica_override"`
+	Port                int    `json:"port" form:"port"`       // 0 keeps the port of the definition
+	Sni                 string `json:"sni" form:"sni"`         // Server name for TLS or Reality, empty keeps the definition's
+	Address             string `json:"address" form:"address"` // Address in subscription links, empty keeps the definition's
+}
+
+// ReplicatedClientAccount puts a client of a replicated inbound in an account; every replica's copy
+// of the client is associated with it.
+type ReplicatedClientAccount struct {
+	Id                  int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ReplicatedInboundId int    `json:"replicatedInboundId" gorm:"not null;uniqueIndex:idx_replicated_client"`
+	ClientEmail         string `json:"clientEmail" gorm:"not null;uniqueIndex:idx_replicated_client"`
+	AccountId           int    `json:"accountId" gorm:"not null;index"`
+}
@@ -789,6 +789,7 @@ install_x-ui_slave() {
     local master_url="$1"
     local slave_secret="$2"
     local skip_cert="$3"  # Optional: "--skip-cert" to skip certificate configuration during updates
+    local enroll_token="$4"  # Optional: single-use enrollment token, exchanged for the secret
     
     cd ${xui_folder%/x-ui}/
     
@@ -846,6 +847,17 @@ install_x-ui_slave() {
     
     mkdir -p /var/log/x-ui
 
+    # Exchange the enrollment token for this node's permanent secret
+    if [[ -n "$enroll_token" ]]; then
+        echo -e "${green}Enrolling with master...${plain}"
+        slave_secret=$(./x-ui enroll "${master_url}" "${enroll_token}")
+        if [[ $? -ne 0 || -z "$slave_secret" ]]; then
+            echo -e "${red}Enrollment failed. The token may be used or expired, ask for a new install command.${plain}"
+            exit 1
+        fi
+        echo -e "${green}Enrolled successfully${plain}"
+    fi
+
     # Install management script for slave
     echo -e "${green}Downloading x-ui.sh management script...${plain}"
     local temp_script="/tmp/x-ui-slave-$$.sh"
@@ -866,6 +878,10 @@ install_x-ui_slave() {
     # Mark this installation as slave mode
     mkdir -p /etc/x-ui
     echo "slave" > /etc/x-ui/.slave
+
+    # Keep the secret out of the service command line, which every local user can read
+    (umask 077 && echo "XUI_SLAVE_SECRET=${slave_secret}" > /etc/x-ui/slave.env)
+    chmod 600 /etc/x-ui/slave.env
     
     # Create slave systemd service
     # Convert WebSocket URL for slave
@@ -880,7 +896,7 @@ install_x-ui_slave() {
 #!/sbin/openrc-run
 
 command="${xui_folder}/x-ui"
-command_args="slave ${slave_ws_url} ${slave_secret}"
+command_args="slave ${slave_ws_url}"
 command_background=true
 pidfile="/run/x-ui.pid"
 name="x-ui"
@@ -894,6 +910,9 @@ depend() {
 
 start_pre() {
     cd ${xui_folder}
+    set -a
+    . /etc/x-ui/slave.env
+    set +a
 }
 EOF
         chmod +x /etc/init.d/x-ui
@@ -914,7 +933,8 @@ After=network.target nss-lookup.target
 Type=simple
 User=root
 WorkingDirectory=${xui_folder}
-ExecStart=${xui_folder}/x-ui slave ${slave_ws_url} ${slave_secret}
+EnvironmentFile=/etc/x-ui/slave.env
+ExecStart=${xui_folder}/x-ui slave ${slave_ws_url}
 Restart=on-failure
 RestartPreventExitStatus=23
 RestartSec=10s
@@ -1272,22 +1292,28 @@ install_x-ui() {
 
 # Check if running in slave mode
 if [[ "$1" == "slave" ]]; then
-    if [[ -z "$2" || -z "$3" ]]; then
-        echo -e "${red}Error: Slave mode requires master URL and secret${plain}"
-        echo -e "${yellow}Usage: bash install.sh slave <master_url> <secret>${plain}"
-        echo -e "${yellow}Example: bash install.sh slave http://master-ip:2053 abc123xyz${plain}"
+    if [[ -z "$2" || -z "$3" || ( "$3" == "--token" && -z "$4" ) ]]; then
+        echo -e "${red}Error: Slave mode requires master URL and an enrollment token or secret${plain}"
+        echo -e "${yellow}Usage: bash install.sh slave <master_url> --token <token>${plain}"
+        echo -e "${yellow}   Or: bash install.sh slave <master_url> <secret>${plain}"
+        echo -e "${yellow}Example: bash install.sh slave http://master-ip:2053 --token abc123xyz${plain}"
         exit 1
     fi
     
     MASTER_URL="$2"
-    SLAVE_SECRET="$3"
+    SLAVE_SECRET=""
+    ENROLL_TOKEN=""
+    if [[ "$3" == "--token" ]]; then
+        ENROLL_TOKEN="$4"
+    else
+        SLAVE_SECRET="$3"
+    fi
     
     echo -e "${green}Installing x-ui in Slave mode...${plain}"
     echo -e "${blue}Master URL: ${MASTER_URL}${plain}"
-    echo -e "${blue}Secret: ${SLAVE_SECRET:0:8}...${plain}"
     
     install_base
-    install_x-ui_slave "$MASTER_URL" "$SLAVE_SECRET"
+    install_x-ui_slave "$MASTER_URL" "$SLAVE_SECRET" "" "$ENROLL_TOKEN"
 else
     echo -e "${green}Running...${plain}"
     install_base
@@ -25,10 +25,14 @@ var (
 
 	// logBuffer maintains recent log entries in memory for web UI retrieval
 	logBuffer []struct {
+		seq   uint64
 		time  string
 		level logging.Level
 		log   string
 	}
+
+	// logSeq numbers buffered entries so that followers can ask for what came after the last one they saw
+	logSeq uint64
 )
 
 // InitLogger initializes dual logging backends: console/syslog and file.
@@ -229,11 +233,14 @@ func addToBuffer(level string, newLog string) {
 	}
 
 	logLevel, _ := logging.LogLevel(level)
+	logSeq++
 	logBuffer = append(logBuffer, struct {
+		seq   uint64
 		time  string
 		level logging.Level
 		log   string
 	}{
+		seq:   logSeq,
 		time:  t.Format(timeFormat),
 		level: logLevel,
 		log:   newLog,
@@ -252,3 +259,18 @@ func GetLogs(c int, level string) []string {
 	}
 	return output
 }
+
+// GetLogsAfter returns, oldest first, the buffered entries at or below the specified level that were
+// logged after the entry numbered seq, together with the number of the latest entry. Passing 0 returns
+// the whole buffer; passing the returned number again yields only what was logged in between.
+func GetLogsAfter(seq uint64, level string) ([]string, uint64) {
+	var output []string
+	logLevel, _ := logging.LogLevel(level)
+
+	for _, entry := range logBuffer {
+		if entry.seq > seq && entry.level <= logLevel {
+			output = append(output, fmt.Sprintf("%s %s - %s", entry.time, entry.level, entry.log))
+		}
+	}
+	return output, logSeq
+}
@@ -470,6 +470,7 @@ func main() {
     slaveCmd := flag.NewFlagSet("slave", flag.ExitOnError)
     masterUrl := slaveCmd.String("master", "", "Master Server URL")
     slaveSecret := slaveCmd.String("secret", "", "Slave Secret")
+    slaveLegacySecret := slaveCmd.Bool("legacy-secret-url", false, "Retry with the secret in the URL for masters predating the secret header")
 
 	var port int
 	var username string
@@ -512,6 +513,8 @@ func main() {
 		fmt.Println("    run            run web panel")
 		fmt.Println("    migrate        migrate form other/old x-ui")
 		fmt.Println("    setting        set settings")
+		fmt.Println("    slave          run as slave of a master panel")
+		fmt.Println("    enroll         join a master with an enrollment token and print the slave secret")
 	}
 
 	flag.Parse()
@@ -533,14 +536,23 @@ func main() {
         logger.InitLogger(logging.INFO)
         
         // Support both positional arguments and flags
-        // Usage: 3x-ui slave <master_url> <secret>
+        // Usage: 3x-ui slave <master_url> [secret]
         // Or: 3x-ui slave --master <url> --secret <key>
+        // Without a secret it is read from XUI_SLAVE_SECRET, which keeps it out of the process list
         var masterUrlVal, secretVal string
         
-        if len(os.Args) >= 4 && !strings.HasPrefix(os.Args[2], "-") {
+        if len(os.Args) >= 3 && !strings.HasPrefix(os.Args[2], "-") {
             // Positional arguments
             masterUrlVal = os.Args[2]
-            secretVal = os.Args[3]
+            rest := os.Args[3:]
+            if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+                secretVal = rest[0]
+                rest = rest[1:]
+            }
+            if err := slaveCmd.Parse(rest); err != nil {
+                fmt.Println(err)
+                return
+            }
         } else {
             // Flag arguments
             err := slaveCmd.Parse(os.Args[2:])
@@ -551,14 +563,33 @@ func main() {
             masterUrlVal = *masterUrl
             secretVal = *slaveSecret
         }
+        if secretVal == "" {
+            secretVal = os.Getenv("XUI_SLAVE_SECRET")
+        }
         
         if masterUrlVal == "" || secretVal == "" {
             fmt.Println("Error: master URL and secret are required for slave mode")
-            fmt.Println("Usage: 3x-ui slave <master_url> <secret>")
+            fmt.Println("Usage: 3x-ui slave <master_url> [secret]")
             fmt.Println("   Or: 3x-ui slave --master <url> --secret <key>")
+            fmt.Println("The secret may also be set in the XUI_SLAVE_SECRET environment variable")
             return
         }
-        slave.Run(masterUrlVal, secretVal)
+        s := slave.NewSlave(masterUrlVal, secretVal)
+        s.LegacySecretUrl = *slaveLegacySecret
+        s.Run()
+	case "enroll":
+		// Usage: x-ui enroll <master_url> <token>
+		// Prints only the secret on stdout so the installer can capture it
+		if len(os.Args) < 4 {
+			fmt.Fprintln(os.Stderr, "Usage: x-ui enroll <master_url> <token>")
+			os.Exit(1)
+		}
+		secret, err := slave.Enroll(os.Args[2], os.Args[3])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Enrollment failed:", err)
+			os.Exit(1)
+		}
+		fmt.Println(secret)
 	case "migrate":
 		migrateDb()
 	case "setting":
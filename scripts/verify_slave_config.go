@@ -143,7 +143,8 @@ func main() {
 	}
 
 	// Compare configurations
-	fmt.Println("🔍 Comparing configurations...\n")
+	fmt.Println("🔍 Comparing configurations...")
+	fmt.Println()
 	diff := compareConfigs(expectedConfig, actualConfig, *verbose)
 
 	// Print results
@@ -0,0 +1,348 @@
+package slave
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"golang.org/x/crypto/acme"
+)
+
+// certBaseDir holds the slave's certificates, one directory per domain with fullchain.pem and privkey.pem.
+const certBaseDir = protocol.CertDir
+
+// acmeCAEnv names a PEM bundle trusted for the ACME directory in addition to the system roots,
+// e.g. the CA of a local Pebble server used for testing.
+const acmeCAEnv = "XUI_ACME_CA"
+
+var domainName = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]{2,63}$`)
+
+// getAcmeAccountKeyPath returns where the key of the slave's ACME account is kept.
+func getAcmeAccountKeyPath() string {
+	return filepath.Join(config.GetDBFolderPath(), "slave-acme-account.pem")
+}
+
+func (s *Slave) rpcIssueCert(ctx context.Context, params json.RawMessage) (any, error) {
+	var req protocol.CertIssue
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: err.Error()}
+	}
+	if !domainName.MatchString(req.Domain) {
+		return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: fmt.Sprintf("invalid domain %q", req.Domain)}
+	}
+	switch req.Challenge {
+	case protocol.ChallengeHTTP01:
+		if req.Port == 0 {
+			req.Port = 80
+		}
+	case protocol.ChallengeTLSALPN01:
+		if req.Port == 0 {
+			req.Port = 443
+		}
+	default:
+		return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: fmt.Sprintf("unsupported challenge %q", req.Challenge)}
+	}
+
+	// One order at a time, they would compete for the challenge port
+	s.acmeMu.Lock()
+	defer s.acmeMu.Unlock()
+
+	logger.Infof("Requesting certificate for %s (%s on port %d)", req.Domain, req.Challenge, req.Port)
+	cert, err := issueCert(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Certificate for %s installed, valid until %s", req.Domain, time.Unix(cert.ExpiryTime, 0).Format(time.DateOnly))
+
+	result := protocol.CertIssueResult{Cert: *cert}
+	restarted, err := s.restartXrayUsing(cert.CertPath)
+	if err != nil {
+		logger.Error("Xray failed to restart with the new certificate:", err)
+	}
+	result.Restarted = restarted
+	return result, nil
+}
+
+// issueCert runs an ACME order for the domain and installs the certificate under certBaseDir.
+func issueCert(ctx context.Context, req *protocol.CertIssue) (*protocol.CertInfo, error) {
+	accountKey, err := loadAcmeAccountKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ACME account key: %v", err)
+	}
+	httpClient, err := acmeHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{
+		Key:          accountKey,
+		DirectoryURL: req.DirectoryUrl,
+		HTTPClient:   httpClient,
+		UserAgent:    "3x-ui-slave",
+	}
+
+	account := &acme.Account{}
+	if req.Email != "" {
+		account.Contact = []string{"mailto:" + req.Email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("ACME account registration failed: %v", err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(req.Domain))
+	if err != nil {
+		return nil, fmt.Errorf("ACME order failed: %v", err)
+	}
+	for _, authzUrl := range order.AuthzURLs {
+		if err := solveAuthorization(ctx, client, authzUrl, req); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("ACME order not ready: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: req.Domain},
+		DNSNames: []string{req.Domain},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("ACME finalization failed: %v", err)
+	}
+	return installCert(req.Domain, chain, key)
+}
+
+// solveAuthorization proves control of one identifier of the order with the requested challenge.
+func solveAuthorization(ctx context.Context, client *acme.Client, authzUrl string, req *protocol.CertIssue) error {
+	authz, err := client.GetAuthorization(ctx, authzUrl)
+	if err != nil {
+		return err
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	domain := authz.Identifier.Value
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == req.Challenge {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("%s: the ACME server does not offer the %s challenge", domain, req.Challenge)
+	}
+
+	stop, err := serveChallenge(client, challenge, domain, req.Port)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("%s: %v", domain, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("%s: validation failed: %v", domain, err)
+	}
+	return nil
+}
+
+// serveChallenge answers the challenge on the port until the returned function is called.
+func serveChallenge(client *acme.Client, challenge *acme.Challenge, domain string, port int) (func(), error) {
+	server := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          log.New(io.Discard, "", 0), // validators probing the port are expected
+	}
+	switch challenge.Type {
+	case protocol.ChallengeHTTP01:
+		path := client.HTTP01ChallengePath(challenge.Token)
+		body, err := client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return nil, err
+		}
+		server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != path {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(body))
+		})
+	case protocol.ChallengeTLSALPN01:
+		cert, err := client.TLSALPN01ChallengeCert(challenge.Token, domain)
+		if err != nil {
+			return nil, err
+		}
+		server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{acme.ALPNProto},
+		}
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("cannot answer the %s challenge on port %d: %v", challenge.Type, port, err)
+	}
+	if server.TLSConfig != nil {
+		listener = tls.NewListener(listener, server.TLSConfig)
+	}
+	go server.Serve(listener)
+	return func() { server.Close() }, nil
+}
+
+// installCert writes the chain and key as fullchain.pem and privkey.pem of the domain.
+func installCert(domain string, chain [][]byte, key *ecdsa.PrivateKey) (*protocol.CertInfo, error) {
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	var certPem bytes.Buffer
+	for _, der := range chain {
+		pem.Encode(&certPem, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(certBaseDir, domain)
+	info := &protocol.CertInfo{
+		Domain:     domain,
+		CertPath:   filepath.Join(dir, "fullchain.pem"),
+		KeyPath:    filepath.Join(dir, "privkey.pem"),
+		ExpiryTime: leaf.NotAfter.Unix(),
+	}
+	if err := writeFileAtomic(info.KeyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(info.CertPath, certPem.Bytes()); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// restartXrayUsing restarts Xray if its config refers to any of the certificates, so it serves the new ones.
+func (s *Slave) restartXrayUsing(certPaths ...string) (bool, error) {
+	s.xrayMu.Lock()
+	defer s.xrayMu.Unlock()
+	if s.process == nil || !s.process.IsRunning() {
+		return false, nil
+	}
+	xrayConfig := s.process.GetConfig()
+	data, err := json.Marshal(xrayConfig)
+	if err != nil {
+		return false, err
+	}
+	if !slices.ContainsFunc(certPaths, func(path string) bool { return bytes.Contains(data, []byte(path)) }) {
+		return false, nil
+	}
+	s.process.Stop()
+	if err := s.startXray(xrayConfig); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// loadAcmeAccountKey returns the ACME account key, creating it on first use.
+func loadAcmeAccountKey() (crypto.Signer, error) {
+	path := getAcmeAccountKeyPath()
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("account key is not PEM encoded")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// acmeHTTPClient returns the client used to talk to the ACME server, trusting the bundle named by acmeCAEnv if set.
+func acmeHTTPClient() (*http.Client, error) {
+	caFile := os.Getenv(acmeCAEnv)
+	if caFile == "" {
+		return http.DefaultClient, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", acmeCAEnv, err)
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s contains no PEM certificates", acmeCAEnv)
+	}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}}, nil
+}
+
+// certNotAfter returns the expiry of the first certificate in a PEM file.
+func certNotAfter(certFile string) (time.Time, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return time.Time{}, errors.New("no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return cert.NotAfter, nil
+		}
+	}
+}
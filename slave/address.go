@@ -0,0 +1,128 @@
+package slave
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// addressEnv sets the address this slave reports, e.g. a domain or an IP the host cannot see itself.
+const addressEnv = "XUI_SLAVE_ADDRESS"
+
+// addressLookupEnv set to "external" lets the slave ask public IP services for its address when no
+// interface has a public one. It is off by default so the slave works in restricted networks and
+// does not reveal itself to third parties; the master then uses the address it sees the slave from.
+const addressLookupEnv = "XUI_SLAVE_ADDRESS_LOOKUP"
+
+// How long a discovered address is used before it is looked up again
+const (
+	localAddressTTL    = time.Minute
+	externalAddressTTL = time.Hour
+)
+
+var externalAddressServices = []string{
+	"https://api.ipify.org",
+	"https://ifconfig.me/ip",
+	"https://icanhazip.com",
+}
+
+// addressCache holds the discovered public address of this slave.
+type addressCache struct {
+	mu        sync.Mutex
+	address   string
+	expiresAt time.Time
+	looking   bool // an external lookup is running
+}
+
+// Address returns the address to report to the master, or "" if it should use the address the
+// connection comes from. It never blocks: external lookups run in the background and their
+// result is reported with a later heartbeat.
+func (c *addressCache) Address() string {
+	if address := strings.TrimSpace(os.Getenv(addressEnv)); address != "" {
+		return address
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().Before(c.expiresAt) {
+		return c.address
+	}
+	if address := localPublicAddress(); address != "" {
+		c.address = address
+		c.expiresAt = time.Now().Add(localAddressTTL)
+		return address
+	}
+	if os.Getenv(addressLookupEnv) == "external" && !c.looking {
+		c.looking = true
+		go c.lookupExternal()
+	}
+	return c.address
+}
+
+// lookupExternal asks the public IP services for the slave's address.
+func (c *addressCache) lookupExternal() {
+	address := externalPublicAddress()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.looking = false
+	if address == "" {
+		// Try again with a later heartbeat, keeping the last known address meanwhile
+		c.expiresAt = time.Now().Add(localAddressTTL)
+		return
+	}
+	c.address = address
+	c.expiresAt = time.Now().Add(externalAddressTTL)
+}
+
+// localPublicAddress returns a public address assigned to one of the host's interfaces, IPv4 first.
+func localPublicAddress() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	var ipv6 string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+			continue
+		}
+		if ip.To4() != nil {
+			return ip.String()
+		}
+		if ipv6 == "" {
+			ipv6 = ip.String()
+		}
+	}
+	return ipv6
+}
+
+// externalPublicAddress asks public IP services for the address the slave is seen from.
+func externalPublicAddress() string {
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, url := range externalAddressServices {
+		resp, err := client.Get(url)
+		if err != nil {
+			continue
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+		resp.Body.Close()
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(strings.TrimSpace(string(body))); ip != nil {
+			return ip.String()
+		}
+	}
+	logger.Warning("Failed to look up the public address of this slave")
+	return ""
+}
@@ -0,0 +1,115 @@
+package slave
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// cachedConfig is the on-disk copy of the last config the slave applied successfully.
+type cachedConfig struct {
+	Revision int64        `json:"revision"`
+	Config   *xray.Config `json:"config"`
+}
+
+// getConfigCachePath returns where the last applied config is kept between restarts.
+func getConfigCachePath() string {
+	return filepath.Join(config.GetDBFolderPath(), "slave-config.json")
+}
+
+// saveConfigCache writes the config so a crash mid-write never leaves a truncated cache.
+func saveConfigCache(revision int64, xrayConfig *xray.Config) error {
+	data, err := json.Marshal(cachedConfig{Revision: revision, Config: xrayConfig})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(getConfigCachePath(), data)
+}
+
+// writeFileAtomic replaces path with data through a temporary file and a rename.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// loadConfigCache reads the cached config. It returns a nil config when no cache exists yet.
+func loadConfigCache() (int64, *xray.Config, error) {
+	data, err := os.ReadFile(getConfigCachePath())
+	if os.IsNotExist(err) {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var cached cachedConfig
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return 0, nil, err
+	}
+	return cached.Revision, cached.Config, nil
+}
+
+// startFromCache brings Xray up with the cached config so the node serves traffic
+// even when the master cannot be reached. The master reconciles once it connects.
+func (s *Slave) startFromCache() {
+	revision, xrayConfig, err := loadConfigCache()
+	if err != nil {
+		logger.Warning("Failed to read cached config:", err)
+		return
+	}
+	if xrayConfig == nil {
+		logger.Info("No cached config, waiting for the master")
+		return
+	}
+
+	logger.Infof("Starting Xray from cached config (revision %d)", revision)
+	if err := s.startXray(xrayConfig); err != nil {
+		logger.Error("Failed to start Xray from cached config:", err)
+		return
+	}
+	s.appliedConfig = xrayConfig
+	s.appliedRevision = revision
+	s.storeAppliedHash(xrayConfig)
+}
+
+// setApplied records xrayConfig as the running config and persists it for the next start.
+func (s *Slave) setApplied(revision int64, xrayConfig *xray.Config) {
+	s.appliedConfig = xrayConfig
+	s.appliedRevision = revision
+	s.storeAppliedHash(xrayConfig)
+	if err := saveConfigCache(revision, xrayConfig); err != nil {
+		logger.Warning("Failed to cache applied config:", err)
+	}
+}
+
+// storeAppliedHash records the hash of the running config reported to the master for drift detection.
+func (s *Slave) storeAppliedHash(xrayConfig *xray.Config) {
+	hash, err := xrayConfig.Hash()
+	if err != nil {
+		logger.Warning("Failed to hash applied config:", err)
+	}
+	s.appliedHash.Store(hash)
+}
@@ -0,0 +1,93 @@
+package slave
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+)
+
+// storedCertName restricts the directory names the master may write certificates to.
+var storedCertName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,127}$`)
+
+func (s *Slave) rpcInstallCerts(ctx context.Context, params json.RawMessage) (any, error) {
+	var req protocol.CertInstall
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: err.Error()}
+	}
+	// Check everything before writing anything, so a bad bundle does not leave a partial install
+	pairs := make([]tls.Certificate, len(req.Certs))
+	for i, cert := range req.Certs {
+		if !storedCertName.MatchString(cert.Name) {
+			return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: fmt.Sprintf("invalid certificate name %q", cert.Name)}
+		}
+		pair, err := tls.X509KeyPair([]byte(cert.Cert), []byte(cert.Key))
+		if err != nil {
+			return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: fmt.Sprintf("%s: %v", cert.Name, err)}
+		}
+		pairs[i] = pair
+	}
+
+	result := protocol.CertInstallResult{Certs: make([]protocol.CertInfo, 0, len(req.Certs))}
+	var updatedPaths []string
+	for i, cert := range req.Certs {
+		info, updated, err := installStoredCert(&cert, pairs[i].Leaf.NotAfter.Unix())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", cert.Name, err)
+		}
+		result.Certs = append(result.Certs, *info)
+		if updated {
+			result.Updated = append(result.Updated, cert.Name)
+			updatedPaths = append(updatedPaths, info.CertPath)
+		}
+	}
+	if len(result.Updated) == 0 {
+		return result, nil
+	}
+	logger.Infof("Installed certificates from master: %v", result.Updated)
+
+	restarted, err := s.restartXrayUsing(updatedPaths...)
+	if err != nil {
+		logger.Error("Xray failed to restart with the new certificates:", err)
+	}
+	result.Restarted = restarted
+	return result, nil
+}
+
+// installStoredCert writes a certificate from the master unless the installed one has the same content.
+// The key is readable by root only; the chain is world readable like any public certificate.
+func installStoredCert(cert *protocol.StoredCert, expiryTime int64) (*protocol.CertInfo, bool, error) {
+	dir := filepath.Join(certBaseDir, cert.Name)
+	info := &protocol.CertInfo{
+		Domain:     cert.Name,
+		CertPath:   filepath.Join(dir, "fullchain.pem"),
+		KeyPath:    filepath.Join(dir, "privkey.pem"),
+		ExpiryTime: expiryTime,
+	}
+	if sameFileContent(info.CertPath, cert.Cert) && sameFileContent(info.KeyPath, cert.Key) {
+		return info, false, nil
+	}
+
+	if err := writeFileAtomic(info.KeyPath, []byte(cert.Key)); err != nil {
+		return nil, false, err
+	}
+	if err := writeFileAtomic(info.CertPath, []byte(cert.Cert)); err != nil {
+		return nil, false, err
+	}
+	if err := os.Chmod(info.CertPath, 0o644); err != nil {
+		return nil, false, err
+	}
+	return info, true, nil
+}
+
+func sameFileContent(path, content string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && bytes.Equal(data, []byte(content))
+}
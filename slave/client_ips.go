@@ -0,0 +1,200 @@
+package slave
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// clientIpsInterval is how often the slave reports the client source addresses to the master.
+const clientIpsInterval = 30 * time.Second
+
+// Access log patterns, as matched by the master's own IP limit job
+var (
+	accessIpRegex        = regexp.MustCompile(`from (?:tcp:|udp:)?\[?([0-9a-fA-F\.:]+)\]?:\d+ accepted`)
+	accessEmailRegex     = regexp.MustCompile(`email: (.+)$`)
+	accessTimestampRegex = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})`)
+)
+
+// fail2banWarning is logged once when addresses over an IP limit cannot be banned
+var fail2banWarning sync.Once
+
+// accessLogReader reads the Xray access log from where the previous read stopped.
+type accessLogReader struct {
+	mu     sync.Mutex
+	path   string
+	offset int64
+}
+
+// clientIps returns the source addresses of each client in the access log lines written since the
+// previous call, or nil if there are none or the access log is not enabled.
+func (r *accessLogReader) clientIps() map[string][]protocol.ClientIp {
+	path, err := xray.GetAccessLogPath()
+	if err != nil || path == "" || path == "none" {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil
+	}
+	// Start over after the log was switched, truncated or rotated
+	if path != r.path || info.Size() < r.offset {
+		r.path = path
+		r.offset = 0
+	}
+	if _, err := file.Seek(r.offset, io.SeekStart); err != nil {
+		return nil
+	}
+
+	seen := make(map[string]map[string]int64)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// A partial last line is read again once Xray finished writing it
+			break
+		}
+		r.offset += int64(len(line))
+		email, ip, timestamp, ok := parseAccessLine(line)
+		if !ok {
+			continue
+		}
+		if seen[email] == nil {
+			seen[email] = make(map[string]int64)
+		}
+		if timestamp > seen[email][ip] {
+			seen[email][ip] = timestamp
+		}
+	}
+
+	if len(seen) == 0 {
+		return nil
+	}
+	result := make(map[string][]protocol.ClientIp, len(seen))
+	for email, ips := range seen {
+		for ip, timestamp := range ips {
+			result[email] = append(result[email], protocol.ClientIp{IP: ip, Timestamp: timestamp})
+		}
+	}
+	return result
+}
+
+// parseAccessLine extracts the client, its source address and the time from an accepted connection.
+func parseAccessLine(line string) (email string, ip string, timestamp int64, ok bool) {
+	ipMatches := accessIpRegex.FindStringSubmatch(line)
+	if len(ipMatches) < 2 {
+		return "", "", 0, false
+	}
+	ip = ipMatches[1]
+	if ip == "127.0.0.1" || ip == "::1" {
+		return "", "", 0, false
+	}
+	emailMatches := accessEmailRegex.FindStringSubmatch(line)
+	if len(emailMatches) < 2 {
+		return "", "", 0, false
+	}
+	email = emailMatches[1]
+
+	timestamp = time.Now().Unix()
+	if matches := accessTimestampRegex.FindStringSubmatch(line); len(matches) >= 2 {
+		// Xray writes the access log in local time
+		if t, err := time.ParseInLocation("2006/01/02 15:04:05", matches[1], time.Local); err == nil {
+			timestamp = t.Unix()
+		}
+	}
+	return email, ip, timestamp, true
+}
+
+// sendClientIps reports the client source addresses seen since the last report.
+func (s *Slave) sendClientIps(conn *protocol.Conn) error {
+	if !conn.Has(protocol.CapClientIps) {
+		return nil
+	}
+	clients := s.accessLog.clientIps()
+	if clients == nil {
+		return nil
+	}
+	return conn.Send(protocol.TypeClientIps, "", protocol.ClientIps{Clients: clients})
+}
+
+// rpcKickClients drops the connections of clients over their IP limit by removing them from Xray
+// and adding them back. The addresses over the limit are written to the IP limit log first, where
+// the 3x-ipl fail2ban jail picks them up and bans them, so they cannot simply reconnect.
+func (s *Slave) rpcKickClients(ctx context.Context, params json.RawMessage) (any, error) {
+	var req protocol.KickClients
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: err.Error()}
+	}
+	if len(req.Ips) > 0 {
+		if err := logLimitedIps(req.Ips); err != nil {
+			logger.Warning("Failed to log the addresses over the IP limit:", err)
+		}
+	}
+
+	s.xrayMu.Lock()
+	defer s.xrayMu.Unlock()
+	if s.xrayAPI == nil || s.process == nil || !s.process.IsRunning() {
+		return nil, errors.New("xray is not running")
+	}
+	result := protocol.KickClientsResult{Kicked: []string{}}
+	for _, user := range req.Users {
+		email, _ := user.User["email"].(string)
+		if err := s.xrayAPI.RemoveUser(user.Tag, email); err != nil {
+			logger.Warningf("Failed to remove client %s for the IP limit: %v", email, err)
+			continue
+		}
+		// Give Xray a moment to drop the connections
+		time.Sleep(100 * time.Millisecond)
+		if err := s.xrayAPI.AddUser(user.Protocol, user.Tag, user.User); err != nil {
+			logger.Warningf("Failed to re-add client %s after the IP limit: %v", email, err)
+			continue
+		}
+		result.Kicked = append(result.Kicked, email)
+	}
+	return result, nil
+}
+
+// logLimitedIps appends the addresses to the IP limit log in the format the 3x-ipl fail2ban
+// filter matches. Each address is written twice, as the jail bans on the second match.
+func logLimitedIps(ips map[string][]string) error {
+	if _, err := exec.LookPath("fail2ban-client"); err != nil {
+		fail2banWarning.Do(func() {
+			logger.Warning("[LIMIT_IP] Fail2Ban is not installed, clients over their IP limit are only disconnected")
+		})
+	}
+	file, err := os.OpenFile(xray.GetIPLimitLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	ipLog := log.New(file, "", log.LstdFlags)
+	for email, addresses := range ips {
+		for _, ip := range addresses {
+			for range 2 {
+				ipLog.Printf("[LIMIT_IP] Email = %s || SRC = %s", email, ip)
+			}
+		}
+		logger.Infof("[LIMIT_IP] Banning %d addresses of client %s over its IP limit", len(addresses), email)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package slave
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+)
+
+// credentials are the slave's authentication material as handed out by the master.
+// InstallSecret is the secret the slave was installed with; as long as the command line
+// still carries it, the stored (possibly rotated) secret takes precedence.
+type credentials struct {
+	InstallSecret string `json:"installSecret"`
+	Secret        string `json:"secret"`
+	ClientCert    string `json:"clientCert,omitempty"`
+	ClientKey     string `json:"clientKey,omitempty"`
+}
+
+// getCredentialsPath returns where rotated secrets and the client certificate are kept.
+func getCredentialsPath() string {
+	return filepath.Join(config.GetDBFolderPath(), "slave-credentials.json")
+}
+
+// loadCredentials returns the stored credentials, or fresh ones when the slave was
+// reinstalled with a different secret than the one they derive from.
+func loadCredentials(installSecret string) *credentials {
+	fresh := &credentials{InstallSecret: installSecret, Secret: installSecret}
+
+	data, err := os.ReadFile(getCredentialsPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warning("Failed to read stored credentials:", err)
+		}
+		return fresh
+	}
+	var creds credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		logger.Warning("Stored credentials are corrupt, using the install secret:", err)
+		return fresh
+	}
+	if creds.InstallSecret != installSecret && creds.Secret != installSecret {
+		logger.Info("Install secret changed, discarding stored credentials")
+		return fresh
+	}
+	return &creds
+}
+
+func (c *credentials) save() error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(getCredentialsPath(), data)
+}
+
+// clientCertificate returns the TLS client certificate issued by the master, if any.
+func (c *credentials) clientCertificate() (*tls.Certificate, string) {
+	if c.ClientCert == "" || c.ClientKey == "" {
+		return nil, ""
+	}
+	cert, err := tls.X509KeyPair([]byte(c.ClientCert), []byte(c.ClientKey))
+	if err != nil {
+		logger.Warning("Stored client certificate is invalid:", err)
+		return nil, ""
+	}
+	return &cert, protocol.CertFingerprint(cert.Certificate[0])
+}
@@ -0,0 +1,64 @@
+package slave
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Enroll redeems a single-use enrollment token at the master and returns the permanent
+// secret the master assigned to this node.
+func Enroll(masterUrl, token string) (string, error) {
+	hostname, _ := os.Hostname()
+	body, err := json.Marshal(map[string]string{"token": token, "hostname": hostname})
+	if err != nil {
+		return "", err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(masterHttpUrl(masterUrl, "panel/api/slave/enroll"), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var msg struct {
+		Success bool   `json:"success"`
+		Msg     string `json:"msg"`
+		Obj     struct {
+			Id     int    `json:"id"`
+			Name   string `json:"name"`
+			Secret string `json:"secret"`
+		} `json:"obj"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return "", fmt.Errorf("unexpected response from master (HTTP %d): %v", resp.StatusCode, err)
+	}
+	if !msg.Success {
+		return "", fmt.Errorf("enrollment rejected: %s", msg.Msg)
+	}
+	if msg.Obj.Secret == "" {
+		return "", errors.New("master returned no secret")
+	}
+	return msg.Obj.Secret, nil
+}
+
+// masterHttpUrl derives the URL of a panel endpoint from either the panel URL or the slave connect URL.
+func masterHttpUrl(masterUrl, path string) string {
+	url := masterUrl
+	if strings.HasPrefix(url, "ws://") {
+		url = "http://" + strings.TrimPrefix(url, "ws://")
+	} else if strings.HasPrefix(url, "wss://") {
+		url = "https://" + strings.TrimPrefix(url, "wss://")
+	}
+	url = strings.TrimSuffix(url, "/panel/api/slave/connect")
+	if !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	return url + path
+}
@@ -0,0 +1,192 @@
+package slave
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+)
+
+var geofileName = regexp.MustCompile(`^[a-zA-Z0-9._-]+\.dat$`)
+
+// geodataManifest records which version of each geofile received from the master is installed.
+type geodataManifest map[string]protocol.GeofileInfo
+
+// getGeodataManifestPath returns where the installed geofile versions are recorded.
+func getGeodataManifestPath() string {
+	return filepath.Join(config.GetDBFolderPath(), "slave-geodata.json")
+}
+
+func loadGeodataManifest() geodataManifest {
+	manifest := make(geodataManifest)
+	data, err := os.ReadFile(getGeodataManifestPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warning("Failed to read geodata manifest:", err)
+		}
+		return manifest
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		logger.Warning("Geodata manifest is corrupt, geofiles will be fetched again:", err)
+		return make(geodataManifest)
+	}
+	return manifest
+}
+
+func (m geodataManifest) save() error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(getGeodataManifestPath(), data)
+}
+
+// versions returns the installed version of each geofile, as reported to the master.
+func (m geodataManifest) versions() map[string]int64 {
+	versions := make(map[string]int64, len(m))
+	for name, file := range m {
+		versions[name] = file.Version
+	}
+	return versions
+}
+
+func (s *Slave) rpcSyncGeodata(ctx context.Context, params json.RawMessage) (any, error) {
+	var req protocol.GeodataSync
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: err.Error()}
+	}
+	return s.syncGeodata(ctx, req.Files)
+}
+
+// syncGeodata installs the geofiles that differ from what the master lists and restarts Xray so it
+// loads them. If Xray does not come up with the new files, the previous ones are put back. Files
+// are downloaded before taking xrayMu, which config pushes from the read loop wait on.
+func (s *Slave) syncGeodata(ctx context.Context, files []protocol.GeofileInfo) (*protocol.GeodataSyncResult, error) {
+	binDir := config.GetBinFolderPath()
+	result := &protocol.GeodataSyncResult{}
+
+	for _, file := range files {
+		if !geofileName.MatchString(file.Name) {
+			return nil, fmt.Errorf("invalid geofile name %q", file.Name)
+		}
+	}
+	s.xrayMu.Lock()
+	var missing []protocol.GeofileInfo
+	for _, file := range files {
+		if installed, ok := s.geodata[file.Name]; ok && installed.Sha256 == file.Sha256 {
+			if _, err := os.Stat(filepath.Join(binDir, file.Name)); err == nil {
+				continue
+			}
+		}
+		missing = append(missing, file)
+	}
+	s.xrayMu.Unlock()
+
+	// Downloaded files by name, with the path of their verified temporary file
+	downloads := make(map[string]string, len(missing))
+	defer func() {
+		for _, tmp := range downloads {
+			os.Remove(tmp)
+		}
+	}()
+	for _, file := range missing {
+		tmp, err := s.downloadFromMaster(ctx, protocol.GeofilePath+file.Name, file.Sha256, binDir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file.Name, err)
+		}
+		downloads[file.Name] = tmp
+	}
+
+	s.xrayMu.Lock()
+	defer s.xrayMu.Unlock()
+
+	// Replaced files by name, with the path of their backup or "" if there was no previous file
+	backups := make(map[string]string)
+	restore := func() {
+		for name, backup := range backups {
+			path := filepath.Join(binDir, name)
+			if backup == "" {
+				os.Remove(path)
+			} else if err := os.Rename(backup, path); err != nil {
+				logger.Errorf("Failed to restore geofile %s: %v", name, err)
+			}
+		}
+	}
+
+	for _, file := range missing {
+		path := filepath.Join(binDir, file.Name)
+		backup := path + ".bak"
+		os.Remove(backup)
+		if _, err := os.Stat(path); err == nil {
+			if err := os.Link(path, backup); err != nil {
+				restore()
+				return nil, fmt.Errorf("failed to back up %s: %v", file.Name, err)
+			}
+		} else {
+			backup = ""
+		}
+		if err := os.Rename(downloads[file.Name], path); err != nil {
+			if backup != "" {
+				os.Remove(backup)
+			}
+			restore()
+			return nil, fmt.Errorf("failed to install %s: %v", file.Name, err)
+		}
+		delete(downloads, file.Name)
+		backups[file.Name] = backup
+		result.Updated = append(result.Updated, file.Name)
+	}
+
+	if len(result.Updated) > 0 && s.process != nil && s.process.IsRunning() {
+		xrayConfig := s.process.GetConfig()
+		s.process.Stop()
+		if err := s.startXray(xrayConfig); err != nil {
+			logger.Errorf("Xray failed to start with new geofiles %v: %v", result.Updated, err)
+			restore()
+			if rbErr := s.startXray(xrayConfig); rbErr != nil {
+				logger.Error("Xray does not start with the previous geofiles either:", rbErr)
+			}
+			return nil, fmt.Errorf("xray failed to start with the new geofiles, previous ones restored: %v", err)
+		}
+		result.Restarted = true
+	}
+
+	for _, backup := range backups {
+		if backup != "" {
+			os.Remove(backup)
+		}
+	}
+	for _, file := range files {
+		s.geodata[file.Name] = file
+	}
+	if err := s.geodata.save(); err != nil {
+		logger.Warning("Failed to record geodata versions:", err)
+	}
+	if len(result.Updated) > 0 {
+		logger.Infof("Installed geofiles from master: %v", result.Updated)
+	}
+	result.Geodata = s.geodata.versions()
+	return result, nil
+}
+
+// downloadFromMaster fetches a file from the master, authenticating like the connect request,
+// and returns the path of the verified temporary file in dir.
+func (s *Slave) downloadFromMaster(ctx context.Context, path, sum, dir string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, masterHttpUrl(s.MasterUrl, path), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(protocol.SecretHeader, s.Secret)
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: s.tlsConfig(),
+	}}
+	return downloadVerified(client, req, sum, dir)
+}
@@ -0,0 +1,354 @@
+package slave
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+	"github.com/op/go-logging"
+)
+
+const (
+	defaultLogCount    = 100
+	maxLogCount        = 1000
+	maxLogReadSize     = 4 << 20 // bytes read from the end of a log file per fetch or poll
+	logTailInterval    = time.Second
+	maxLogTailDuration = 30 * time.Minute
+	maxLogTails        = 4
+)
+
+// xrayLogLevel matches the severity in an Xray error log line, e.g. "2024/01/02 15:04:05.123456 [Warning] ..."
+var xrayLogLevel = regexp.MustCompile(`^\S+ \S+ \[(\w+)\]`)
+
+// xrayLogPrefix marks the Xray output the panel logger captured from the process.
+const xrayLogPrefix = "XRAY: "
+
+// logFilter selects the lines of a query. Filtering happens here so only matching lines cross the connection.
+type logFilter struct {
+	source  string
+	level   logging.Level
+	keyword string
+
+	// Log file to read, or "" to read the panel logger buffer
+	path string
+}
+
+func newLogFilter(query protocol.LogQuery) (*logFilter, error) {
+	filter := &logFilter{source: query.Source, level: logging.INFO, keyword: query.Keyword}
+	if query.Level != "" {
+		level, err := logging.LogLevel(query.Level)
+		if err != nil {
+			return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: "unknown log level " + query.Level}
+		}
+		filter.level = level
+	}
+
+	var err error
+	switch query.Source {
+	case protocol.LogSourcePanel:
+		return filter, nil
+	case protocol.LogSourceXrayAccess:
+		filter.path, err = xray.GetAccessLogPath()
+	case protocol.LogSourceXrayError:
+		filter.path, err = xray.GetErrorLogPath()
+	default:
+		return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: "unknown log source " + query.Source}
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if filter.path == "none" {
+		filter.path = ""
+	}
+	if filter.path == "" && query.Source == protocol.LogSourceXrayAccess {
+		return nil, errors.New("xray access log is not enabled")
+	}
+	return filter, nil
+}
+
+// match reports whether a line read from the log file passes the filter.
+func (f *logFilter) match(line string) bool {
+	if line == "" || (f.keyword != "" && !strings.Contains(line, f.keyword)) {
+		return false
+	}
+	if f.source == protocol.LogSourceXrayError {
+		if m := xrayLogLevel.FindStringSubmatch(line); m != nil {
+			if level, err := logging.LogLevel(m[1]); err == nil && level > f.level {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// bufferedLogs returns the panel logger entries after seq that pass the filter, and the latest entry number.
+// Without an error log file, Xray's output is taken from what the panel logger captured.
+func (f *logFilter) bufferedLogs(seq uint64) ([]string, uint64) {
+	entries, last := logger.GetLogsAfter(seq, f.level.String())
+	var lines []string
+	for _, line := range entries {
+		if f.source == protocol.LogSourceXrayError && !strings.Contains(line, xrayLogPrefix) {
+			continue
+		}
+		if f.keyword == "" || strings.Contains(line, f.keyword) {
+			lines = append(lines, line)
+		}
+	}
+	return lines, last
+}
+
+func (s *Slave) rpcGetLogs(ctx context.Context, params json.RawMessage) (any, error) {
+	var query protocol.LogQuery
+	if err := json.Unmarshal(params, &query); err != nil {
+		return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: err.Error()}
+	}
+	filter, err := newLogFilter(query)
+	if err != nil {
+		return nil, err
+	}
+	count := query.Count
+	if count <= 0 {
+		count = defaultLogCount
+	}
+	count = min(count, maxLogCount)
+
+	var lines []string
+	if filter.path == "" {
+		lines, _ = filter.bufferedLogs(0)
+	} else {
+		lines, err = readLogFile(filter, count)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+	return protocol.LogsResult{Lines: lines}, nil
+}
+
+// readLogFile returns the last count matching lines of the filter's log file, oldest first.
+// Only the end of the file is read, so huge logs do not stall the slave.
+func readLogFile(filter *logFilter, count int) ([]string, error) {
+	file, err := os.Open(filter.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := max(info.Size()-maxLogReadSize, 0)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if offset > 0 {
+		// Skip the line the read started in the middle of
+		scanner.Scan()
+	}
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !filter.match(line) {
+			continue
+		}
+		if len(lines) == count {
+			lines = lines[1:]
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// logTail follows a log from the point it was started at.
+type logTail struct {
+	filter *logFilter
+	cancel context.CancelFunc
+
+	// Position in the panel logger buffer
+	seq uint64
+
+	// Position in the log file and the unterminated line read so far
+	offset  int64
+	partial []byte
+}
+
+func newLogTail(filter *logFilter) *logTail {
+	tail := &logTail{filter: filter}
+	if filter.path == "" {
+		_, tail.seq = logger.GetLogsAfter(math.MaxUint64, filter.level.String())
+	} else if info, err := os.Stat(filter.path); err == nil {
+		tail.offset = info.Size()
+	}
+	return tail
+}
+
+// poll returns the matching lines written since the last poll.
+func (t *logTail) poll() ([]string, error) {
+	if t.filter.path == "" {
+		var lines []string
+		lines, t.seq = t.filter.bufferedLogs(t.seq)
+		return lines, nil
+	}
+
+	file, err := os.Open(t.filter.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Rotated away; follow the new file once Xray creates it
+			t.offset, t.partial = 0, nil
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < t.offset {
+		// Truncated or replaced, start over from its beginning
+		t.offset, t.partial = 0, nil
+	}
+	if size == t.offset {
+		return nil, nil
+	}
+	if size-t.offset > maxLogReadSize {
+		// Too much to catch up on; skip to the latest part
+		t.offset, t.partial = size-maxLogReadSize, nil
+	}
+
+	data := make([]byte, size-t.offset)
+	n, err := file.ReadAt(data, t.offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	t.offset += int64(n)
+	data = append(t.partial, data[:n]...)
+
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		t.partial = data
+		return nil, nil
+	}
+	t.partial = append([]byte(nil), data[end+1:]...)
+
+	var lines []string
+	for _, line := range strings.Split(string(data[:end]), "\n") {
+		line = strings.TrimSpace(line)
+		if t.filter.match(line) {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+func (s *Slave) rpcTailLogs(ctx context.Context, params json.RawMessage) (any, error) {
+	var req protocol.LogTail
+	if err := json.Unmarshal(params, &req); err != nil || req.TailId == "" {
+		return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: "tailId and source are required"}
+	}
+	conn := callConn(ctx)
+	if conn == nil || !conn.Has(protocol.CapLogTail) {
+		return nil, errors.New("master does not accept log lines")
+	}
+	filter, err := newLogFilter(req.LogQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	tail := newLogTail(filter)
+	s.tailsMu.Lock()
+	if s.tails == nil {
+		s.tails = make(map[string]*logTail)
+	}
+	if old, ok := s.tails[req.TailId]; ok {
+		old.cancel()
+	} else if len(s.tails) >= maxLogTails {
+		s.tailsMu.Unlock()
+		return nil, errors.New("too many logs are being followed")
+	}
+	var tailCtx context.Context
+	tailCtx, tail.cancel = context.WithTimeout(context.Background(), maxLogTailDuration)
+	s.tails[req.TailId] = tail
+	s.tailsMu.Unlock()
+
+	go s.followLog(tailCtx, conn, req.TailId, tail)
+	return nil, nil
+}
+
+func (s *Slave) rpcStopTail(ctx context.Context, params json.RawMessage) (any, error) {
+	var req protocol.LogTailStop
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: err.Error()}
+	}
+	s.tailsMu.Lock()
+	if tail, ok := s.tails[req.TailId]; ok {
+		tail.cancel()
+		delete(s.tails, req.TailId)
+	}
+	s.tailsMu.Unlock()
+	return nil, nil
+}
+
+// followLog sends the lines of a tail to the master until it is stopped, times out or the connection closes.
+func (s *Slave) followLog(ctx context.Context, conn *protocol.Conn, tailId string, tail *logTail) {
+	defer func() {
+		tail.cancel()
+		s.tailsMu.Lock()
+		if s.tails[tailId] == tail {
+			delete(s.tails, tailId)
+		}
+		s.tailsMu.Unlock()
+	}()
+
+	ticker := time.NewTicker(logTailInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				conn.Send(protocol.TypeLogLines, "", protocol.LogLines{TailId: tailId, Source: tail.filter.source, Ended: true})
+			}
+			return
+		case <-conn.Done():
+			return
+		case <-ticker.C:
+			lines, err := tail.poll()
+			if err != nil {
+				logger.Warningf("Failed to follow %s log: %v", tail.filter.source, err)
+				continue
+			}
+			if len(lines) == 0 {
+				continue
+			}
+			if len(lines) > maxLogCount {
+				lines = lines[len(lines)-maxLogCount:]
+			}
+			msg := protocol.LogLines{TailId: tailId, Source: tail.filter.source, Lines: lines}
+			if err := conn.Send(protocol.TypeLogLines, "", msg); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package protocol
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn wraps a WebSocket connection with the negotiated protocol state.
+// Writes are serialized so that several goroutines may send on the same connection.
+type Conn struct {
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+
+	mu           sync.RWMutex
+	version      int
+	capabilities []string
+
+	// Outstanding remote calls by request id
+	pendingMu  sync.Mutex
+	pending    map[string]chan *RpcResponse
+	nextCallId atomic.Uint64
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewConn wraps ws. Until SetNegotiated is called the peer is assumed to speak the legacy format.
+func NewConn(ws *websocket.Conn) *Conn {
+	return &Conn{
+		ws:      ws,
+		version: LegacyVersion,
+		pending: make(map[string]chan *RpcResponse),
+		closed:  make(chan struct{}),
+	}
+}
+
+// SetNegotiated records the outcome of the handshake.
+func (c *Conn) SetNegotiated(version int, capabilities []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version = version
+	c.capabilities = capabilities
+}
+
+// Version returns the negotiated protocol version.
+func (c *Conn) Version() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version
+}
+
+// Capabilities returns the capabilities advertised by the peer.
+func (c *Conn) Capabilities() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Clone(c.capabilities)
+}
+
+// Has reports whether the peer advertised the given capability.
+func (c *Conn) Has(capability string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Contains(c.capabilities, capability)
+}
+
+// Send encodes and writes a message using the negotiated protocol version.
+func (c *Conn) Send(msgType MessageType, requestId string, payload any) error {
+	data, err := Encode(c.Version(), msgType, requestId, payload)
+	if err != nil {
+		return err
+	}
+	return c.WriteRaw(data)
+}
+
+// SendError reports a rejected message to the peer.
+func (c *Conn) SendError(requestId, code, message string) error {
+	return c.Send(TypeError, requestId, ErrorPayload{Code: code, Message: message})
+}
+
+// WriteRaw writes an already encoded frame.
+func (c *Conn) WriteRaw(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+// Read blocks until the next message arrives and decodes it.
+// A frame that cannot be decoded is returned as a non-nil raw slice with a decode error,
+// so callers can distinguish it from a broken connection.
+func (c *Conn) Read() (*Envelope, []byte, error) {
+	_, data, err := c.ws.ReadMessage()
+	if err != nil {
+		return nil, nil, err
+	}
+	env, err := Decode(data)
+	return env, data, err
+}
+
+// WS returns the underlying WebSocket connection.
+func (c *Conn) WS() *websocket.Conn {
+	return c.ws
+}
+
+// Close closes the underlying connection. Calls still waiting for an answer fail with ErrConnClosed.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.ws.Close()
+}
+
+// Done returns a channel that is closed once the connection is closed.
+func (c *Conn) Done() <-chan struct{} {
+	return c.closed
+}
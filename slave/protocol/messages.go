@@ -0,0 +1,162 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Hello is sent by the slave right after connecting to announce its protocol range and features.
+type Hello struct {
+	ProtocolVersion    int      `json:"protocolVersion"`
+	MinProtocolVersion int      `json:"minProtocolVersion"`
+	Capabilities       []string `json:"capabilities"`
+	UIVersion          string   `json:"uiVersion"`
+	XrayVersion        string   `json:"xrayVersion"`
+	AppliedRevision    int64    `json:"appliedRevision"` // config revision the slave is running, 0 if none
+	ClientCert         string   `json:"clientCert"`      // SHA-256 fingerprint of the slave's client certificate, if any
+
+	Geodata map[string]int64 `json:"geodata,omitempty"` // installed geofile versions by name
+}
+
+// HelloAck is the master's answer to Hello with the negotiated version and the master's features.
+type HelloAck struct {
+	ProtocolVersion int      `json:"protocolVersion"`
+	Capabilities    []string `json:"capabilities"`
+	TrafficSeq      int64    `json:"trafficSeq"` // last traffic report the master counted
+}
+
+// ErrorPayload reports a rejected message back to the sender.
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// SystemStats is the periodic heartbeat a slave sends with its resource usage.
+type SystemStats struct {
+	Cpu         float64 `json:"cpu"`
+	Mem         float64 `json:"mem"`
+	Address     string  `json:"address"`
+	XrayVersion string  `json:"xrayVersion"`
+	UIVersion   string  `json:"uiVersion"`
+	ConfigHash  string  `json:"configHash,omitempty"` // canonical hash of the running Xray config, empty while Xray is stopped
+
+	Status *Status `json:"status,omitempty"` // full host status, missing from older slaves
+}
+
+// TrafficCounter holds the byte counters of a single inbound or outbound.
+type TrafficCounter struct {
+	Uplink   int64 `json:"uplink"`
+	Downlink int64 `json:"downlink"`
+}
+
+// UserTraffic holds the byte counters of a single client identified by email.
+type UserTraffic struct {
+	Email    string `json:"email"`
+	Uplink   int64  `json:"uplink"`
+	Downlink int64  `json:"downlink"`
+}
+
+// TrafficStats carries the traffic deltas collected from the slave's Xray since the last report.
+// Seq numbers reports from the slave's spool; the master counts each sequence number once.
+// Legacy slaves send no Seq and their reports are always counted.
+type TrafficStats struct {
+	Seq           int64                     `json:"seq,omitempty"`
+	Inbounds      map[string]TrafficCounter `json:"inbounds"`
+	Outbounds     map[string]TrafficCounter `json:"outbounds"`
+	Users         []UserTraffic             `json:"users"`
+	OnlineClients []string                  `json:"online_clients"`
+}
+
+// TrafficAck confirms that the master has counted every traffic report up to Seq.
+type TrafficAck struct {
+	Seq int64 `json:"seq"`
+}
+
+// RotateSecret hands the slave the secret it must use from now on.
+type RotateSecret struct {
+	Secret string `json:"secret"`
+}
+
+// SecretRotated confirms the slave has stored the new secret; the master then retires the old one.
+type SecretRotated struct{}
+
+// ClientCert is a client certificate issued by the master's cluster CA for mutual TLS.
+type ClientCert struct {
+	Cert string `json:"cert"` // PEM
+	Key  string `json:"key"`  // PEM
+}
+
+// CertInfo describes a certificate found on the slave.
+type CertInfo struct {
+	Domain     string `json:"domain"`
+	CertPath   string `json:"certPath"`
+	KeyPath    string `json:"keyPath"`
+	ExpiryTime int64  `json:"expiryTime"` // NotAfter of the leaf certificate, unix seconds
+}
+
+// CertReport lists the certificates available on the slave.
+type CertReport struct {
+	Certs []CertInfo `json:"certs"`
+}
+
+// ConfigFull carries a complete Xray configuration serialized as JSON.
+// Revision increases with every push so the slave's acknowledgement can be matched to it.
+type ConfigFull struct {
+	Revision int64  `json:"revision"`
+	Config   string `json:"config"`
+}
+
+// DeltaOp is a single live change to the slave's running Xray.
+type DeltaOp struct {
+	Op       string          `json:"op"`
+	Tag      string          `json:"tag"`
+	Protocol string          `json:"protocol,omitempty"`
+	Inbound  json.RawMessage `json:"inbound,omitempty"` // add_inbound: Xray inbound config
+	User     map[string]any  `json:"user,omitempty"`    // add_user: account fields as expected by XrayAPI.AddUser
+	Email    string          `json:"email,omitempty"`   // remove_user
+}
+
+// ConfigDelta describes how to go from the config at BaseRevision to the one at Revision without
+// restarting Xray. Config is the resulting full config, applied with a restart if the slave is
+// not at BaseRevision or any operation fails.
+type ConfigDelta struct {
+	Revision     int64     `json:"revision"`
+	BaseRevision int64     `json:"baseRevision"`
+	Ops          []DeltaOp `json:"ops"`
+	Config       string    `json:"config"`
+}
+
+// InboundError reports an inbound the slave could not bring up.
+type InboundError struct {
+	Tag   string `json:"tag"`
+	Port  int    `json:"port"`
+	Error string `json:"error"`
+}
+
+// ConfigApplied is the slave's answer to a config push.
+type ConfigApplied struct {
+	Revision      int64          `json:"revision"`
+	Success       bool           `json:"success"`
+	Restarted     bool           `json:"restarted"` // false when a delta was applied live
+	Error         string         `json:"error,omitempty"`
+	InboundErrors []InboundError `json:"inboundErrors,omitempty"`
+}
+
+// ClientIp is a source address a client connected from and when it was last seen, in unix seconds.
+type ClientIp struct {
+	IP        string `json:"ip"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ClientIps reports the source addresses of each client, by email, that the slave's Xray access
+// log recorded since the previous report.
+type ClientIps struct {
+	Clients map[string][]ClientIp `json:"clients"`
+}
+
+// CertFingerprint returns the hex SHA-256 of a DER encoded certificate, as carried in Hello.ClientCert.
+func CertFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,176 @@
+// Package protocol defines the wire protocol spoken between the master panel and its slave agents.
+// Every message is a typed envelope carrying a protocol version, an optional request id and a JSON payload.
+// Peers exchange a hello/hello_ack handshake to agree on a protocol version and the capabilities both sides
+// support, so that mixed master/slave versions can run side by side during a rolling upgrade.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Version is the highest protocol version spoken by this build.
+const Version = 1
+
+// MinVersion is the lowest protocol version this build still accepts from a peer.
+// Version 0 is the legacy untyped format used before the handshake existed.
+const MinVersion = 0
+
+// LegacyVersion identifies peers that never sent a hello and speak the flat, untyped message format.
+const LegacyVersion = 0
+
+// GeofilePath is where a slave downloads geofiles from the master, relative to the panel's base path.
+// The file name is appended; the slave authenticates as on the connect request.
+const GeofilePath = "panel/api/slave/geofile/"
+
+// CertDir is where a slave keeps its certificates, one directory per domain or stored certificate
+// holding fullchain.pem and privkey.pem.
+const CertDir = "/root/cert"
+
+// SecretHeader carries the slave's secret on the connect request, keeping it out of URLs and access logs.
+const SecretHeader = "X-Slave-Secret"
+
+// MessageType identifies the kind of payload carried by an Envelope.
+type MessageType string
+
+// Message types exchanged between master and slave.
+const (
+	TypeHello             MessageType = "hello"
+	TypeHelloAck          MessageType = "hello_ack"
+	TypeError             MessageType = "error"
+	TypeSystemStats       MessageType = "system_stats"
+	TypeTrafficStats      MessageType = "traffic_stats"
+	TypeCertReport        MessageType = "cert_report"
+	TypeUpdateConfigFull  MessageType = "update_config_full"
+	TypeRestartXray       MessageType = "restart_xray"
+	TypeConfigApplied     MessageType = "config_applied"
+	TypeUpdateConfigDelta MessageType = "update_config_delta"
+	TypeTrafficAck        MessageType = "traffic_ack"
+	TypeRotateSecret      MessageType = "rotate_secret"
+	TypeSecretRotated     MessageType = "secret_rotated"
+	TypeClientCert        MessageType = "client_cert"
+	TypeRpcRequest        MessageType = "rpc_request"
+	TypeRpcResponse       MessageType = "rpc_response"
+	TypeLogLines          MessageType = "log_lines"
+	TypeClientIps         MessageType = "client_ips"
+)
+
+// Capabilities advertised during the handshake. A peer only relies on a feature
+// once the other side has reported the matching capability.
+const (
+	CapConfigFull   = "config_full"
+	CapRestartXray  = "restart_xray"
+	CapCertReport   = "cert_report"
+	CapConfigAck    = "config_ack"
+	CapConfigDelta  = "config_delta"
+	CapTrafficSeq   = "traffic_seq"
+	CapSecretRotate = "secret_rotate"
+	CapClientCert   = "client_cert"
+	CapRpc          = "rpc"
+	CapLogTail      = "log_tail"
+	CapClientIps    = "client_ips"
+	CapConfigHash   = "config_hash"
+)
+
+// Operations carried by ConfigDelta, applied through the slave's Xray gRPC API.
+const (
+	OpAddInbound    = "add_inbound"
+	OpRemoveInbound = "remove_inbound"
+	OpAddUser       = "add_user"
+	OpRemoveUser    = "remove_user"
+)
+
+// Error codes carried by ErrorPayload.
+const (
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeBadPayload         = "bad_payload"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+)
+
+// ErrUnsupportedVersion is returned by Negotiate when the peers share no protocol version.
+var ErrUnsupportedVersion = errors.New("no common protocol version")
+
+// Envelope is the outer frame of every protocol message.
+type Envelope struct {
+	Type      MessageType     `json:"type"`
+	Version   int             `json:"version,omitempty"`
+	RequestId string          `json:"requestId,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// Decode parses a raw WebSocket frame into an Envelope.
+// Frames without a version are legacy messages whose fields sit at the top level; for those the
+// whole frame becomes the payload. Legacy slaves sent their heartbeat without a type, so an
+// untyped legacy frame is returned with an empty Type and left to the caller to interpret.
+func Decode(data []byte) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	if env.Version == LegacyVersion {
+		env.Payload = json.RawMessage(data)
+		return &env, nil
+	}
+	if env.Type == "" {
+		return nil, errors.New("message has no type")
+	}
+	return &env, nil
+}
+
+// Encode builds the wire form of a message for a peer speaking the given protocol version.
+// Legacy peers receive the payload fields flattened next to the type, as they expect.
+func Encode(version int, msgType MessageType, requestId string, payload any) ([]byte, error) {
+	raw, err := marshalPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	if version == LegacyVersion {
+		fields := make(map[string]json.RawMessage)
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &fields); err != nil {
+				return nil, fmt.Errorf("legacy payload for %s must be an object: %w", msgType, err)
+			}
+		}
+		typeBytes, _ := json.Marshal(msgType)
+		fields["type"] = typeBytes
+		return json.Marshal(fields)
+	}
+	return json.Marshal(Envelope{
+		Type:      msgType,
+		Version:   version,
+		RequestId: requestId,
+		Payload:   raw,
+	})
+}
+
+// DecodePayload unmarshals the envelope payload into v.
+func (e *Envelope) DecodePayload(v any) error {
+	if len(e.Payload) == 0 {
+		return fmt.Errorf("%s message has no payload", e.Type)
+	}
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		return fmt.Errorf("invalid %s payload: %w", e.Type, err)
+	}
+	return nil
+}
+
+// Negotiate picks the protocol version to use with a peer that sent the given hello.
+func Negotiate(hello *Hello) (int, error) {
+	version := min(hello.ProtocolVersion, Version)
+	if version < MinVersion || version < hello.MinProtocolVersion {
+		return 0, fmt.Errorf("%w: local %d-%d, remote %d-%d", ErrUnsupportedVersion,
+			MinVersion, Version, hello.MinProtocolVersion, hello.ProtocolVersion)
+	}
+	return version, nil
+}
+
+func marshalPayload(payload any) (json.RawMessage, error) {
+	if payload == nil {
+		return nil, nil
+	}
+	if raw, ok := payload.(json.RawMessage); ok {
+		return raw, nil
+	}
+	return json.Marshal(payload)
+}
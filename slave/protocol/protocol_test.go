@@ -0,0 +1,185 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   int
+		msgType   MessageType
+		requestId string
+		payload   any
+		wantWire  string
+		wantErr   bool
+	}{
+		{
+			name:      "typed envelope",
+			version:   1,
+			msgType:   TypeTrafficAck,
+			requestId: "r1",
+			payload:   TrafficAck{Seq: 7},
+			wantWire:  `{"type":"traffic_ack","version":1,"requestId":"r1","payload":{"seq":7}}`,
+		},
+		{
+			name:     "typed envelope without payload",
+			version:  1,
+			msgType:  TypeRestartXray,
+			wantWire: `{"type":"restart_xray","version":1}`,
+		},
+		{
+			name:     "raw payload is passed through",
+			version:  1,
+			msgType:  TypeSystemStats,
+			payload:  json.RawMessage(`{"cpu":1}`),
+			wantWire: `{"type":"system_stats","version":1,"payload":{"cpu":1}}`,
+		},
+		{
+			name:     "legacy fields are flattened",
+			version:  LegacyVersion,
+			msgType:  TypeTrafficAck,
+			payload:  TrafficAck{Seq: 7},
+			wantWire: `{"seq":7,"type":"traffic_ack"}`,
+		},
+		{
+			name:     "legacy without payload",
+			version:  LegacyVersion,
+			msgType:  TypeRestartXray,
+			wantWire: `{"type":"restart_xray"}`,
+		},
+		{
+			name:    "legacy payload must be an object",
+			version: LegacyVersion,
+			msgType: TypeLogLines,
+			payload: []string{"a"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Encode(tt.version, tt.msgType, tt.requestId, tt.payload)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Encode() = %s, want error", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if string(data) != tt.wantWire {
+				t.Fatalf("Encode() = %s, want %s", data, tt.wantWire)
+			}
+
+			env, err := Decode(data)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if env.Type != tt.msgType || env.Version != tt.version || env.RequestId != tt.requestId {
+				t.Fatalf("Decode() = %+v, want type %s, version %d, request %q", env, tt.msgType, tt.version, tt.requestId)
+			}
+			if ack, ok := tt.payload.(TrafficAck); ok {
+				var got TrafficAck
+				if err := env.DecodePayload(&got); err != nil {
+					t.Fatalf("DecodePayload() error = %v", err)
+				}
+				if got != ack {
+					t.Fatalf("DecodePayload() = %+v, want %+v", got, ack)
+				}
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		frame       string
+		wantType    MessageType
+		wantPayload string
+		wantErr     bool
+	}{
+		{
+			name:        "untyped legacy heartbeat",
+			frame:       `{"cpu":12.5,"xrayVersion":"1.8.0"}`,
+			wantType:    "",
+			wantPayload: `{"cpu":12.5,"xrayVersion":"1.8.0"}`,
+		},
+		{
+			name:        "typed legacy frame keeps its fields as payload",
+			frame:       `{"type":"traffic_stats","seq":3}`,
+			wantType:    TypeTrafficStats,
+			wantPayload: `{"type":"traffic_stats","seq":3}`,
+		},
+		{
+			name:        "envelope",
+			frame:       `{"type":"hello_ack","version":1,"payload":{"protocolVersion":1}}`,
+			wantType:    TypeHelloAck,
+			wantPayload: `{"protocolVersion":1}`,
+		},
+		{
+			name:    "envelope without type",
+			frame:   `{"version":1,"payload":{}}`,
+			wantErr: true,
+		},
+		{
+			name:    "not json",
+			frame:   `hello`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := Decode([]byte(tt.frame))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Decode() = %+v, want error", env)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if env.Type != tt.wantType {
+				t.Fatalf("Decode() type = %q, want %q", env.Type, tt.wantType)
+			}
+			if string(env.Payload) != tt.wantPayload {
+				t.Fatalf("Decode() payload = %s, want %s", env.Payload, tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hello   Hello
+		want    int
+		wantErr bool
+	}{
+		{"same version", Hello{ProtocolVersion: Version, MinProtocolVersion: MinVersion}, Version, false},
+		{"newer peer falls back to ours", Hello{ProtocolVersion: Version + 1, MinProtocolVersion: MinVersion}, Version, false},
+		{"older peer", Hello{ProtocolVersion: LegacyVersion}, LegacyVersion, false},
+		{"peer requires a newer version", Hello{ProtocolVersion: Version + 2, MinProtocolVersion: Version + 1}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Negotiate(&tt.hello)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsupportedVersion) {
+					t.Fatalf("Negotiate() error = %v, want %v", err, ErrUnsupportedVersion)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Negotiate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("Negotiate() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RPC methods a slave answers. New remote operations are added here as methods rather than
+// as message types of their own.
+const (
+	MethodPing         = "ping"
+	MethodStatus       = "status"
+	MethodUpdateXray   = "update_xray"
+	MethodSyncGeodata  = "sync_geodata"
+	MethodGetLogs      = "get_logs"
+	MethodTailLogs     = "tail_logs"
+	MethodStopTail     = "stop_tail"
+	MethodIssueCert    = "issue_cert"
+	MethodInstallCerts = "install_certs"
+	MethodStopXray     = "stop_xray"
+	MethodKickClients  = "kick_clients"
+)
+
+// ACME challenges a slave can answer while obtaining a certificate.
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// Log sources a slave serves.
+const (
+	LogSourcePanel      = "panel"       // the panel logger buffer
+	LogSourceXrayAccess = "xray_access" // the access log configured for Xray
+	LogSourceXrayError  = "xray_error"  // the error log configured for Xray, or its output captured by the panel logger
+)
+
+// Error codes carried by RpcError.
+const (
+	ErrCodeMethodNotFound = "method_not_found"
+	ErrCodeRpcFailed      = "rpc_failed"
+	ErrCodeTimeout        = "timeout"
+)
+
+var (
+	// ErrRpcUnsupported is returned by Call when the peer did not advertise CapRpc.
+	ErrRpcUnsupported = errors.New("peer does not support remote calls")
+	// ErrConnClosed is returned by Call when the connection goes away before the answer arrives.
+	ErrConnClosed = errors.New("connection closed")
+)
+
+// RpcRequest asks the peer to run Method. The envelope's request id correlates the response.
+// Timeout tells the callee how long the caller is going to wait, in milliseconds.
+type RpcRequest struct {
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Timeout int64           `json:"timeout,omitempty"`
+}
+
+// RpcResponse answers an RpcRequest with either a result or an error.
+type RpcResponse struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *RpcError       `json:"error,omitempty"`
+}
+
+// RpcError is a failed remote call as reported by the callee.
+type RpcError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RpcError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// PingResult answers MethodPing with the callee's clock.
+type PingResult struct {
+	Time int64 `json:"time"` // unix milliseconds
+}
+
+// StatusResult answers MethodStatus with the slave's runtime state.
+type StatusResult struct {
+	Os              string `json:"os"`   // GOOS, selects the Xray release archive
+	Arch            string `json:"arch"` // GOARCH
+	UIVersion       string `json:"uiVersion"`
+	XrayVersion     string `json:"xrayVersion"`
+	XrayRunning     bool   `json:"xrayRunning"`
+	XrayError       string `json:"xrayError,omitempty"`
+	XrayUptime      uint64 `json:"xrayUptime"` // seconds
+	AppliedRevision int64  `json:"appliedRevision"`
+	PendingTraffic  int    `json:"pendingTraffic"` // traffic reports not yet acknowledged
+
+	Geodata map[string]int64 `json:"geodata,omitempty"` // installed geofile versions by name
+}
+
+// StopXrayResult answers MethodStopXray. Xray stays down until the next config push or restart.
+type StopXrayResult struct {
+	Stopped bool `json:"stopped"` // false when Xray was not running
+}
+
+// KickClients asks the slave to drop the connections of clients by removing them from Xray and
+// adding them back. Users holds the add_user operation of each client. Ips lists, by email, the
+// source addresses over the client's IP limit, which the slave hands to fail2ban to ban.
+type KickClients struct {
+	Users []DeltaOp           `json:"users"`
+	Ips   map[string][]string `json:"ips,omitempty"`
+}
+
+// KickClientsResult answers MethodKickClients.
+type KickClientsResult struct {
+	Kicked []string `json:"kicked"` // emails of the clients that were re-added
+}
+
+// XrayUpdate asks the slave to install an Xray-core release archive. The slave downloads Url,
+// refuses it unless its SHA-256 matches, and restores the previous core if the new one fails to start.
+type XrayUpdate struct {
+	Version string `json:"version"`
+	Url     string `json:"url"`
+	Sha256  string `json:"sha256"`
+}
+
+// XrayUpdateResult answers MethodUpdateXray with the core version now running.
+type XrayUpdateResult struct {
+	XrayVersion string `json:"xrayVersion"`
+	Restarted   bool   `json:"restarted"` // false when Xray was not running and only the binary was replaced
+}
+
+// GeofileInfo identifies one version of a geodata file kept by the master.
+type GeofileInfo struct {
+	Name    string `json:"name"`
+	Version int64  `json:"version"`
+	Sha256  string `json:"sha256"`
+	Size    int64  `json:"size"`
+}
+
+// GeodataSync lists the geofiles the slave should have. The slave fetches each one it lacks from
+// GeofilePath on the master, verifies its checksum and restarts Xray so it picks them up.
+type GeodataSync struct {
+	Files []GeofileInfo `json:"files"`
+}
+
+// GeodataSyncResult answers MethodSyncGeodata with the geofile versions now installed.
+type GeodataSyncResult struct {
+	Geodata   map[string]int64 `json:"geodata"`
+	Updated   []string         `json:"updated,omitempty"`
+	Restarted bool             `json:"restarted"`
+}
+
+// LogQuery selects log lines on the slave. Level keeps lines at or above that severity and is
+// ignored for the access log, whose lines carry none; Keyword keeps lines containing it.
+type LogQuery struct {
+	Source  string `json:"source"`
+	Level   string `json:"level,omitempty"`
+	Keyword string `json:"keyword,omitempty"`
+	Count   int    `json:"count,omitempty"`
+}
+
+// LogsResult answers MethodGetLogs with the latest matching lines, oldest first.
+type LogsResult struct {
+	Lines []string `json:"lines"`
+}
+
+// LogTail asks the slave to follow a log and send the lines matching the query as they are written,
+// in LogLines messages tagged with TailId. Count is not used. A tail ends on MethodStopTail, when the
+// connection closes or after the slave's maximum tail duration.
+type LogTail struct {
+	TailId string `json:"tailId"`
+	LogQuery
+}
+
+// LogTailStop ends the tail with the given id.
+type LogTailStop struct {
+	TailId string `json:"tailId"`
+}
+
+// LogLines carries lines a slave read while following a log.
+type LogLines struct {
+	TailId string   `json:"tailId"`
+	Source string   `json:"source"`
+	Lines  []string `json:"lines"`
+	Ended  bool     `json:"ended,omitempty"` // set on the last message of a tail the slave ended itself
+}
+
+// CertIssue asks the slave to obtain a certificate for Domain from an ACME server, answering the
+// challenge itself on Port. The slave keeps one ACME account; an empty DirectoryUrl means Let's Encrypt.
+type CertIssue struct {
+	Domain       string `json:"domain"`
+	Email        string `json:"email,omitempty"`
+	DirectoryUrl string `json:"directoryUrl,omitempty"`
+	Challenge    string `json:"challenge"`
+	Port         int    `json:"port,omitempty"` // defaults to 80 for http-01 and 443 for tls-alpn-01
+}
+
+// CertIssueResult answers MethodIssueCert with the installed certificate.
+type CertIssueResult struct {
+	Cert      CertInfo `json:"cert"`
+	Restarted bool     `json:"restarted"` // Xray was restarted because its config uses the certificate
+}
+
+// StoredCert is a certificate kept by the master, installed by the slave in CertDir/Name.
+type StoredCert struct {
+	Name   string `json:"name"`
+	Cert   string `json:"cert"` // PEM chain
+	Key    string `json:"key"`  // PEM private key
+	Sha256 string `json:"sha256"`
+}
+
+// CertInstall hands the slave the stored certificates assigned to it. Certificates it already has
+// with the same content are left alone.
+type CertInstall struct {
+	Certs []StoredCert `json:"certs"`
+}
+
+// CertInstallResult answers MethodInstallCerts with the installed certificates.
+type CertInstallResult struct {
+	Certs     []CertInfo `json:"certs"`
+	Updated   []string   `json:"updated,omitempty"`
+	Restarted bool       `json:"restarted"` // Xray was restarted because its config uses an updated certificate
+}
+
+// Call runs method on the peer and waits for its answer until ctx is done. The response is
+// decoded into result, which may be nil. A failure reported by the peer is returned as *RpcError.
+func (c *Conn) Call(ctx context.Context, method string, params any, result any) error {
+	if !c.Has(CapRpc) {
+		return ErrRpcUnsupported
+	}
+	rawParams, err := marshalPayload(params)
+	if err != nil {
+		return err
+	}
+
+	requestId := "rpc-" + strconv.FormatUint(c.nextCallId.Add(1), 10)
+	ch := make(chan *RpcResponse, 1)
+	c.pendingMu.Lock()
+	c.pending[requestId] = ch
+	c.pendingMu.Unlock()
+	defer func() {
+		c.pendingMu.Lock()
+		delete(c.pending, requestId)
+		c.pendingMu.Unlock()
+	}()
+
+	req := RpcRequest{Method: method, Params: rawParams}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Timeout = time.Until(deadline).Milliseconds()
+	}
+	if err := c.Send(TypeRpcRequest, requestId, req); err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result != nil && len(resp.Result) > 0 {
+			if err := json.Unmarshal(resp.Result, result); err != nil {
+				return fmt.Errorf("invalid %s result: %w", method, err)
+			}
+		}
+		return nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return &RpcError{Code: ErrCodeTimeout, Message: fmt.Sprintf("no answer to %s in time", method)}
+		}
+		return ctx.Err()
+	case <-c.closed:
+		return ErrConnClosed
+	}
+}
+
+// Deliver hands an rpc_response to the Call waiting for it. It returns false when no call is
+// waiting, typically because it already timed out.
+func (c *Conn) Deliver(env *Envelope) (bool, error) {
+	var resp RpcResponse
+	if err := env.DecodePayload(&resp); err != nil {
+		return false, err
+	}
+	c.pendingMu.Lock()
+	ch, ok := c.pending[env.RequestId]
+	c.pendingMu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	select {
+	case ch <- &resp:
+	default:
+	}
+	return true, nil
+}
+
+// Reply answers the request with the given id. An error that is not an *RpcError is reported
+// with ErrCodeRpcFailed.
+func (c *Conn) Reply(requestId string, result any, err error) error {
+	if err != nil {
+		var rpcErr *RpcError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RpcError{Code: ErrCodeRpcFailed, Message: err.Error()}
+		}
+		return c.Send(TypeRpcResponse, requestId, RpcResponse{Error: rpcErr})
+	}
+	raw, err := marshalPayload(result)
+	if err != nil {
+		return c.Send(TypeRpcResponse, requestId, RpcResponse{Error: &RpcError{Code: ErrCodeRpcFailed, Message: err.Error()}})
+	}
+	return c.Send(TypeRpcResponse, requestId, RpcResponse{Result: raw})
+}
@@ -0,0 +1,116 @@
+package slave
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+)
+
+// defaultRpcTimeout bounds a call whose caller did not say how long it waits.
+const defaultRpcTimeout = 30 * time.Second
+
+// rpcHandler runs a remote call. Returning a *protocol.RpcError controls the error code the
+// master sees; any other error is reported as a failed call.
+type rpcHandler func(s *Slave, ctx context.Context, params json.RawMessage) (any, error)
+
+// rpcHandlers maps the methods the master may call to their implementation.
+var rpcHandlers = map[string]rpcHandler{
+	protocol.MethodPing:         (*Slave).rpcPing,
+	protocol.MethodStatus:       (*Slave).rpcStatus,
+	protocol.MethodUpdateXray:   (*Slave).rpcUpdateXray,
+	protocol.MethodSyncGeodata:  (*Slave).rpcSyncGeodata,
+	protocol.MethodGetLogs:      (*Slave).rpcGetLogs,
+	protocol.MethodTailLogs:     (*Slave).rpcTailLogs,
+	protocol.MethodStopTail:     (*Slave).rpcStopTail,
+	protocol.MethodIssueCert:    (*Slave).rpcIssueCert,
+	protocol.MethodInstallCerts: (*Slave).rpcInstallCerts,
+	protocol.MethodStopXray:     (*Slave).rpcStopXray,
+	protocol.MethodKickClients:  (*Slave).rpcKickClients,
+}
+
+// callConnKey is the context key under which handleRpc passes on the connection a call arrived on.
+type callConnKey struct{}
+
+// callConn returns the connection the remote call being handled arrived on.
+func callConn(ctx context.Context) *protocol.Conn {
+	conn, _ := ctx.Value(callConnKey{}).(*protocol.Conn)
+	return conn
+}
+
+// handleRpc runs a call from the master and sends back its result.
+func (s *Slave) handleRpc(conn *protocol.Conn, env *protocol.Envelope) {
+	var req protocol.RpcRequest
+	if err := env.DecodePayload(&req); err != nil {
+		conn.SendError(env.RequestId, protocol.ErrCodeBadPayload, err.Error())
+		return
+	}
+
+	handler, ok := rpcHandlers[req.Method]
+	if !ok {
+		conn.Reply(env.RequestId, nil, &protocol.RpcError{
+			Code:    protocol.ErrCodeMethodNotFound,
+			Message: fmt.Sprintf("unknown method %q", req.Method),
+		})
+		return
+	}
+
+	timeout := defaultRpcTimeout
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), callConnKey{}, conn), timeout)
+	defer cancel()
+
+	result, err := handler(s, ctx, req.Params)
+	if err != nil {
+		logger.Warningf("Remote call %s failed: %v", req.Method, err)
+	}
+	if err := conn.Reply(env.RequestId, result, err); err != nil {
+		logger.Warningf("Failed to answer remote call %s: %v", req.Method, err)
+	}
+}
+
+func (s *Slave) rpcPing(ctx context.Context, params json.RawMessage) (any, error) {
+	return protocol.PingResult{Time: time.Now().UnixMilli()}, nil
+}
+
+// rpcStopXray shuts Xray down, e.g. while the node is in maintenance.
+func (s *Slave) rpcStopXray(ctx context.Context, params json.RawMessage) (any, error) {
+	s.xrayMu.Lock()
+	defer s.xrayMu.Unlock()
+	if s.process == nil || !s.process.IsRunning() {
+		return protocol.StopXrayResult{}, nil
+	}
+	if err := s.process.Stop(); err != nil {
+		return nil, err
+	}
+	logger.Info("Xray stopped on request of the master")
+	return protocol.StopXrayResult{Stopped: true}, nil
+}
+
+func (s *Slave) rpcStatus(ctx context.Context, params json.RawMessage) (any, error) {
+	status := protocol.StatusResult{
+		Os:              runtime.GOOS,
+		Arch:            runtime.GOARCH,
+		UIVersion:       config.GetVersion(),
+		XrayVersion:     "Unknown",
+		AppliedRevision: s.appliedRevision,
+		PendingTraffic:  s.spool.Len(),
+		Geodata:         s.geodata.versions(),
+	}
+	if s.process != nil {
+		status.XrayVersion = s.process.GetVersion()
+		status.XrayRunning = s.process.IsRunning()
+		status.XrayUptime = s.process.GetUptime()
+		if err := s.process.GetErr(); err != nil {
+			status.XrayError = err.Error()
+		}
+	}
+	return status, nil
+}
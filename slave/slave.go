@@ -1,14 +1,19 @@
 package slave
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"io"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,24 +21,77 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
 	"github.com/mhsanaei/3x-ui/v2/xray"
-
-	"github.com/shirou/gopsutil/v4/cpu"
-	"github.com/shirou/gopsutil/v4/mem"
 )
 
+// slaveCapabilities lists the protocol features this slave build supports.
+var slaveCapabilities = []string{
+	protocol.CapConfigFull,
+	protocol.CapRestartXray,
+	protocol.CapCertReport,
+	protocol.CapConfigAck,
+	protocol.CapConfigDelta,
+	protocol.CapTrafficSeq,
+	protocol.CapSecretRotate,
+	protocol.CapClientCert,
+	protocol.CapRpc,
+	protocol.CapLogTail,
+	protocol.CapClientIps,
+	protocol.CapConfigHash,
+}
+
 type Slave struct {
 	MasterUrl string
 	Secret    string
 	process   *xray.Process
 	xrayAPI   *xray.XrayAPI
 	slaveId   int
+
+	// Retry a rejected connection with the secret in the URL, for masters predating the secret
+	// header. The URL, secret included, may end up in proxy logs.
+	LegacySecretUrl bool
+
+	// Last config that started successfully and the revision it was pushed with
+	appliedConfig   *xray.Config
+	appliedRevision int64
+	appliedHash     atomic.Value // canonical hash of appliedConfig, read by the heartbeat
+
+	// Traffic reports waiting for the master's acknowledgement
+	spool       *trafficSpool
+	trafficWake chan struct{}
+
+	// Rotated secret and client certificate received from the master
+	creds *credentials
+
+	// Geofiles installed from the master
+	geodata geodataManifest
+
+	// Serializes config changes, restarts and core updates of the running Xray
+	xrayMu sync.Mutex
+
+	// Logs the master is following, by tail id
+	tailsMu sync.Mutex
+	tails   map[string]*logTail
+
+	// Serializes ACME orders, which listen on the challenge port
+	acmeMu sync.Mutex
+
+	// Public address reported with the heartbeat
+	address addressCache
+
+	// Access log position of the last client IP report
+	accessLog accessLogReader
+
+	// Host status reported with the heartbeat
+	status statusCollector
 }
 
 func NewSlave(masterUrl, secret string) *Slave {
 	return &Slave{
-		MasterUrl: masterUrl,
-		Secret:    secret,
+		MasterUrl:   masterUrl,
+		Secret:      secret,
+		trafficWake: make(chan struct{}, 1),
 	}
 }
 
@@ -45,6 +103,17 @@ func Run(masterUrl, secret string) {
 func (s *Slave) Run() {
 	logger.Info("Starting Slave...")
 
+	s.creds = loadCredentials(s.Secret)
+	s.Secret = s.creds.Secret
+	s.geodata = loadGeodataManifest()
+
+	// Serve the last known config right away; a master outage must not take traffic down
+	s.startFromCache()
+
+	// Traffic is collected whether or not the master is reachable and delivered once it is
+	s.spool = newTrafficSpool(getTrafficSpoolPath())
+	go s.trafficLoop()
+
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
@@ -64,70 +133,68 @@ func (s *Slave) Run() {
 }
 
 func (s *Slave) connectAndLoop() {
-	// Build the URL - check if path already contains the endpoint
-	baseUrl := s.MasterUrl
-	var url string
-	
-	// If the URL already has the connect path, just append the secret
-	if strings.Contains(baseUrl, "/panel/api/slave/connect") {
-		if strings.Contains(baseUrl, "?") {
-			url = baseUrl + "&secret=" + s.Secret
-		} else {
-			url = baseUrl + "?secret=" + s.Secret
-		}
-	} else {
-		// Need to append the path
-		if baseUrl[len(baseUrl)-1] != '/' {
-			baseUrl += "/"
-		}
-		url = fmt.Sprintf("%spanel/api/slave/connect?secret=%s", baseUrl, s.Secret)
-	}
-	logger.Infof("Connecting to %s", url)
-	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	c, err := s.dial()
 	if err != nil {
 		logger.Error("Connect failed:", err)
 		return
 	}
-	defer c.Close()
+	conn := protocol.NewConn(c)
+	defer conn.Close()
 	logger.Info("Connected to Master")
 
+	// Announce our protocol range and features in a versioned envelope. An older
+	// master does not answer it: it stores any message it does not know as the
+	// slave's system stats, which the first heartbeat replaces seconds later.
+	// Everything else goes out in the legacy flat format until the master
+	// acknowledges, so without a hello_ack the connection stays on it.
+	hello, err := protocol.Encode(protocol.Version, protocol.TypeHello, "", s.hello())
+	if err == nil {
+		err = conn.WriteRaw(hello)
+	}
+	if err != nil {
+		logger.Error("Failed to send hello:", err)
+		return
+	}
+
 	done := make(chan struct{})
 
 	// heartbeat / stats loop
 	go func() {
 		ticker := time.NewTicker(5 * time.Second)
-		trafficTicker := time.NewTicker(10 * time.Second)
 		certTicker := time.NewTicker(60 * time.Minute) // Check certs every hour
+		ipTicker := time.NewTicker(clientIpsInterval)
 		defer ticker.Stop()
-		defer trafficTicker.Stop()
 		defer certTicker.Stop()
-		
+		defer ipTicker.Stop()
+
+		// Last traffic report sent on this connection; everything after it is replayed
+		var lastTrafficSeq int64
+
 		// Send certs immediately on connect
-		if certData := s.collectCertificates(); certData != "" {
-			if err := c.WriteMessage(websocket.TextMessage, []byte(certData)); err != nil {
+		if certData := s.collectCertificates(); certData != nil {
+			if err := conn.Send(protocol.TypeCertReport, "", certData); err != nil {
 				logger.Error("Failed to send initial certificates:", err)
 			}
 		}
-		
+
 		for {
 			select {
 			case <-ticker.C:
-				stats := s.collectStats()
-				if err := c.WriteMessage(websocket.TextMessage, []byte(stats)); err != nil {
-					close(done)
+				if err := conn.Send(protocol.TypeSystemStats, "", s.collectStats()); err != nil {
+					// Closing the connection unblocks the read loop, which ends the session
+					conn.Close()
 					return
 				}
-			case <-trafficTicker.C:
-				// Send traffic stats
-				if trafficData := s.collectTrafficStats(); trafficData != "" {
-					if err := c.WriteMessage(websocket.TextMessage, []byte(trafficData)); err != nil {
-						logger.Error("Failed to send traffic stats:", err)
-					}
+			case <-s.trafficWake:
+				s.sendTraffic(conn, &lastTrafficSeq)
+			case <-ipTicker.C:
+				if err := s.sendClientIps(conn); err != nil {
+					logger.Error("Failed to send client IPs:", err)
 				}
 			case <-certTicker.C:
 				// Send certificate info periodically
-				if certData := s.collectCertificates(); certData != "" {
-					if err := c.WriteMessage(websocket.TextMessage, []byte(certData)); err != nil {
+				if certData := s.collectCertificates(); certData != nil {
+					if err := conn.Send(protocol.TypeCertReport, "", certData); err != nil {
 						logger.Error("Failed to send certificates:", err)
 					}
 				}
@@ -138,166 +205,376 @@ func (s *Slave) connectAndLoop() {
 	}()
 
 	for {
-		_, message, err := c.ReadMessage()
+		env, raw, err := conn.Read()
 		if err != nil {
+			if raw != nil {
+				logger.Warning("Ignoring malformed message from master:", err)
+				continue
+			}
 			logger.Error("Read error:", err)
 			close(done)
 			break
 		}
+		s.handleMessage(conn, env)
+	}
+}
 
-		var msg map[string]interface{}
-		if err := json.Unmarshal(message, &msg); err != nil {
-			continue
+// connectUrl returns the master's slave endpoint, with the secret as query parameter only when legacy is set.
+func (s *Slave) connectUrl(legacy bool) string {
+	// Build the URL - check if path already contains the endpoint
+	url := s.MasterUrl
+	if !strings.Contains(url, "/panel/api/slave/connect") {
+		if !strings.HasSuffix(url, "/") {
+			url += "/"
 		}
+		url += "panel/api/slave/connect"
+	}
+	if !legacy {
+		return url
+	}
+	if strings.Contains(url, "?") {
+		return url + "&secret=" + s.Secret
+	}
+	return url + "?secret=" + s.Secret
+}
 
-		typeStr, ok := msg["type"].(string)
-		if !ok {
-			continue
+// dial opens the WebSocket to the master. The secret travels in a header and the client
+// certificate, once issued, is presented for mutual TLS. Masters predating the header only
+// read the secret from the URL, so with LegacySecretUrl a rejected attempt is retried that way.
+func (s *Slave) dial() (*websocket.Conn, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = s.tlsConfig()
+	header := http.Header{}
+	header.Set(protocol.SecretHeader, s.Secret)
+
+	url := s.connectUrl(false)
+	logger.Infof("Connecting to %s", url)
+	c, resp, err := dialer.Dial(url, header)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusUnauthorized && s.LegacySecretUrl {
+		logger.Warning("Master rejected the secret header, retrying with the secret in the URL for older masters")
+		c, _, err = dialer.Dial(s.connectUrl(true), header)
+	}
+	return c, err
+}
+
+// tlsConfig presents the client certificate issued by the master, if any, on connections to it.
+func (s *Slave) tlsConfig() *tls.Config {
+	if cert, _ := s.creds.clientCertificate(); cert != nil {
+		return &tls.Config{Certificates: []tls.Certificate{*cert}}
+	}
+	return nil
+}
+
+// hello builds the handshake message describing this slave.
+func (s *Slave) hello() protocol.Hello {
+	xrayVersion := "Unknown"
+	if s.process != nil {
+		xrayVersion = s.process.GetVersion()
+	}
+	_, fingerprint := s.creds.clientCertificate()
+	return protocol.Hello{
+		ProtocolVersion:    protocol.Version,
+		MinProtocolVersion: protocol.MinVersion,
+		Capabilities:       slaveCapabilities,
+		UIVersion:          config.GetVersion(),
+		XrayVersion:        xrayVersion,
+		AppliedRevision:    s.appliedRevision,
+		ClientCert:         fingerprint,
+		Geodata:            s.geodata.versions(),
+	}
+}
+
+// handleMessage dispatches a single message received from the master.
+func (s *Slave) handleMessage(conn *protocol.Conn, env *protocol.Envelope) {
+	switch env.Type {
+	case protocol.TypeHelloAck:
+		var ack protocol.HelloAck
+		if err := env.DecodePayload(&ack); err != nil {
+			logger.Error("Invalid hello_ack:", err)
+			return
 		}
+		conn.SetNegotiated(ack.ProtocolVersion, ack.Capabilities)
+		logger.Infof("Master negotiated protocol v%d, capabilities: %v", ack.ProtocolVersion, ack.Capabilities)
 
-		switch typeStr {
-		case "update_config_full":
-			configStr, ok := msg["config"].(string)
-			if !ok {
-				logger.Error("Invalid config format")
-				continue
+		// Drop what the master already counted and replay the rest right away
+		if conn.Has(protocol.CapTrafficSeq) {
+			s.spool.Resync(ack.TrafficSeq)
+		}
+		s.wakeTraffic()
+
+	case protocol.TypeTrafficAck:
+		var ack protocol.TrafficAck
+		if err := env.DecodePayload(&ack); err != nil {
+			logger.Error("Invalid traffic_ack:", err)
+			return
+		}
+		s.spool.Ack(ack.Seq)
+
+	case protocol.TypeUpdateConfigFull:
+		var payload protocol.ConfigFull
+		if err := env.DecodePayload(&payload); err != nil {
+			logger.Error("Invalid config format:", err)
+			return
+		}
+
+		var xrayConfig xray.Config
+		if err := json.Unmarshal([]byte(payload.Config), &xrayConfig); err != nil {
+			logger.Error("Failed to unmarshal config:", err)
+			if conn.Has(protocol.CapConfigAck) {
+				conn.Send(protocol.TypeConfigApplied, env.RequestId, protocol.ConfigApplied{
+					Revision: payload.Revision,
+					Error:    fmt.Sprintf("invalid config: %v", err),
+				})
 			}
+			return
+		}
 
-			var xrayConfig xray.Config
-			if err := json.Unmarshal([]byte(configStr), &xrayConfig); err != nil {
-				logger.Error("Failed to unmarshal config:", err)
-				continue
+		logger.Infof("Received full config update (revision %d). Inbounds: %d, Outbounds (raw length): %d",
+			payload.Revision, len(xrayConfig.InboundConfigs), len(xrayConfig.OutboundConfigs))
+
+		s.xrayMu.Lock()
+		var result *protocol.ConfigApplied
+		if s.isRunning(&xrayConfig) {
+			// Typically the master re-sending what we already started from cache
+			logger.Info("Config is already running, skipping restart")
+			s.setApplied(payload.Revision, &xrayConfig)
+			result = &protocol.ConfigApplied{Revision: payload.Revision, Success: true}
+		} else {
+			result = s.applyFullConfig(payload.Revision, &xrayConfig)
+			result.Restarted = true
+		}
+		s.xrayMu.Unlock()
+		if conn.Has(protocol.CapConfigAck) {
+			if err := conn.Send(protocol.TypeConfigApplied, env.RequestId, result); err != nil {
+				logger.Error("Failed to acknowledge config:", err)
 			}
+		}
+
+	case protocol.TypeUpdateConfigDelta:
+		var payload protocol.ConfigDelta
+		if err := env.DecodePayload(&payload); err != nil {
+			logger.Error("Invalid config delta:", err)
+			return
+		}
+
+		var xrayConfig xray.Config
+		if err := json.Unmarshal([]byte(payload.Config), &xrayConfig); err != nil {
+			logger.Error("Failed to unmarshal config:", err)
+			conn.Send(protocol.TypeConfigApplied, env.RequestId, protocol.ConfigApplied{
+				Revision: payload.Revision,
+				Error:    fmt.Sprintf("invalid config: %v", err),
+			})
+			return
+		}
 
-			logger.Infof("Received full config update. Inbounds: %d, Outbounds (raw length): %d", 
-				len(xrayConfig.InboundConfigs), len(xrayConfig.OutboundConfigs))
+		s.xrayMu.Lock()
+		result := s.applyConfigDelta(&payload, &xrayConfig)
+		s.xrayMu.Unlock()
+		if err := conn.Send(protocol.TypeConfigApplied, env.RequestId, result); err != nil {
+			logger.Error("Failed to acknowledge config:", err)
+		}
 
-			s.applyFullConfig(&xrayConfig)
+	case protocol.TypeRestartXray:
+		// Handle Xray Restart Request
+		s.xrayMu.Lock()
+		s.restartXray()
+		s.xrayMu.Unlock()
 
-		case "restart_xray":
-			// Handle Xray Restart Request
-			s.restartXray()
+	case protocol.TypeRotateSecret:
+		var payload protocol.RotateSecret
+		if err := env.DecodePayload(&payload); err != nil || payload.Secret == "" {
+			logger.Error("Invalid rotate_secret:", err)
+			return
+		}
+		// Store the secret before confirming, otherwise we could lock ourselves out
+		previous := s.creds.Secret
+		s.creds.Secret = payload.Secret
+		if err := s.creds.save(); err != nil {
+			s.creds.Secret = previous
+			logger.Error("Failed to store rotated secret:", err)
+			conn.SendError(env.RequestId, protocol.ErrCodeBadPayload, "failed to store secret")
+			return
+		}
+		s.Secret = payload.Secret
+		logger.Info("Secret rotated by master")
+		if err := conn.Send(protocol.TypeSecretRotated, env.RequestId, protocol.SecretRotated{}); err != nil {
+			logger.Error("Failed to confirm secret rotation:", err)
+		}
+
+	case protocol.TypeClientCert:
+		var payload protocol.ClientCert
+		if err := env.DecodePayload(&payload); err != nil {
+			logger.Error("Invalid client_cert:", err)
+			return
+		}
+		if _, err := tls.X509KeyPair([]byte(payload.Cert), []byte(payload.Key)); err != nil {
+			logger.Error("Master sent an unusable client certificate:", err)
+			return
+		}
+		s.creds.ClientCert = payload.Cert
+		s.creds.ClientKey = payload.Key
+		if err := s.creds.save(); err != nil {
+			logger.Error("Failed to store client certificate:", err)
+			return
+		}
+		logger.Info("Received client certificate from master, used from the next connection")
+
+	case protocol.TypeRpcRequest:
+		// Calls may take a while; keep reading so other messages are not held up
+		go s.handleRpc(conn, env)
+
+	case protocol.TypeRpcResponse:
+		if _, err := conn.Deliver(env); err != nil {
+			logger.Warning("Invalid rpc_response:", err)
+		}
+
+	case protocol.TypeError:
+		var payload protocol.ErrorPayload
+		if err := env.DecodePayload(&payload); err == nil {
+			logger.Warningf("Master rejected a message: %s: %s", payload.Code, payload.Message)
+		}
+
+	default:
+		logger.Warningf("Ignoring unknown message type from master: %s", env.Type)
+		if conn.Version() > protocol.LegacyVersion {
+			conn.SendError(env.RequestId, protocol.ErrCodeUnknownType, fmt.Sprintf("unknown message type %q", env.Type))
 		}
 	}
 }
 
-func (s *Slave) collectStats() string {
-	v, _ := mem.VirtualMemory()
-	c, _ := cpu.Percent(0, false)
-	cpuVal := 0.0
-	if len(c) > 0 {
-		cpuVal = c[0]
+func (s *Slave) collectStats() *protocol.SystemStats {
+	status := s.status.collect()
+	memVal := 0.0
+	if status.Mem.Total > 0 {
+		memVal = float64(status.Mem.Current) * 100 / float64(status.Mem.Total)
 	}
 
-	ip := s.getPublicIP()
-	
+	ip := s.address.Address()
+	setStatusAddress(status, ip)
+
 	// Get versions
 	xrayVersion := "Unknown"
+	status.Xray.State = protocol.ProcessStop
 	if s.process != nil {
 		xrayVersion = s.process.GetVersion()
+		if s.process.IsRunning() {
+			status.Xray.State = protocol.ProcessRunning
+			status.AppStats.Uptime = s.process.GetUptime()
+		} else if s.process.GetErr() != nil {
+			status.Xray.State = protocol.ProcessError
+			status.Xray.ErrorMsg = s.process.GetResult()
+		}
 	}
+	status.Xray.Version = xrayVersion
 	uiVersion := config.GetVersion()
-	
-	return fmt.Sprintf(`{"cpu": %.2f, "mem": %.2f, "address": "%s", "xrayVersion": "%s", "uiVersion": "%s"}`, 
-		cpuVal, v.UsedPercent, ip, xrayVersion, uiVersion)
-}
 
-// getPublicIP fetches the public IP address of this slave
-func (s *Slave) getPublicIP() string {
-	// Try multiple services for reliability
-	services := []string{
-		"https://api.ipify.org",
-		"https://ifconfig.me/ip",
-		"https://icanhazip.com",
+	// Only a running Xray has a config; the master reads an empty hash as stopped
+	configHash := ""
+	if status.Xray.State == protocol.ProcessRunning {
+		configHash, _ = s.appliedHash.Load().(string)
 	}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	for _, url := range services {
-		resp, err := client.Get(url)
-		if err != nil {
-			continue
-		}
-		defer resp.Body.Close()
+	return &protocol.SystemStats{
+		Cpu:         math.Round(status.Cpu*100) / 100,
+		Mem:         math.Round(memVal*100) / 100,
+		Address:     ip,
+		XrayVersion: xrayVersion,
+		UIVersion:   uiVersion,
+		ConfigHash:  configHash,
+		Status:      status,
+	}
+}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			continue
+// trafficLoop reads the Xray traffic counters periodically and journals them in the spool.
+func (s *Slave) trafficLoop() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if trafficData := s.collectTrafficStats(); trafficData != nil {
+			s.spool.Add(trafficData)
+			s.wakeTraffic()
 		}
+	}
+}
 
-		ip := strings.TrimSpace(string(body))
-		if ip != "" {
-			return ip
-		}
+func (s *Slave) wakeTraffic() {
+	select {
+	case s.trafficWake <- struct{}{}:
+	default:
 	}
+}
 
-	return ""
+// sendTraffic delivers the spooled reports not yet sent on this connection. A master without
+// sequence support never acknowledges, so for it a successful write counts as delivery.
+func (s *Slave) sendTraffic(conn *protocol.Conn, lastSeq *int64) {
+	for _, report := range s.spool.Take(*lastSeq) {
+		if err := conn.Send(protocol.TypeTrafficStats, "", report); err != nil {
+			logger.Error("Failed to send traffic stats:", err)
+			return
+		}
+		*lastSeq = report.Seq
+		if !conn.Has(protocol.CapTrafficSeq) {
+			s.spool.Ack(report.Seq)
+		}
+	}
 }
 
-func (s *Slave) collectTrafficStats() string {
+func (s *Slave) collectTrafficStats() *protocol.TrafficStats {
 	if s.xrayAPI == nil || s.process == nil || !s.process.IsRunning() {
 		logger.Debug("collectTrafficStats: Xray API or process not ready")
-		return ""
+		return nil
 	}
-	
+
 	traffics, clientTraffics, err := s.xrayAPI.GetTraffic(true)
 	if err != nil {
 		logger.Debug("Failed to get traffic stats:", err)
-		return ""
+		return nil
 	}
-	
+
 	logger.Debugf("collectTrafficStats: Got %d inbound/outbound entries, %d user entries", len(traffics), len(clientTraffics))
-	
+
 	if len(traffics) == 0 && len(clientTraffics) == 0 {
-		return ""
+		return nil
 	}
-	
+
 	// Build traffic stats message with inbound, outbound and user stats
-	type TrafficData struct {
-		Type          string                       `json:"type"`
-		Inbounds      map[string]map[string]int64  `json:"inbounds"`
-		Outbounds     map[string]map[string]int64  `json:"outbounds"`
-		Users         []map[string]interface{}     `json:"users"`
-		OnlineClients []string                     `json:"online_clients"`
-	}
-	
-	data := TrafficData{
-		Type:          "traffic_stats",
-		Inbounds:      make(map[string]map[string]int64),
-		Outbounds:     make(map[string]map[string]int64),
-		Users:         make([]map[string]interface{}, 0),
+	data := &protocol.TrafficStats{
+		Inbounds:      make(map[string]protocol.TrafficCounter),
+		Outbounds:     make(map[string]protocol.TrafficCounter),
+		Users:         make([]protocol.UserTraffic, 0),
 		OnlineClients: make([]string, 0),
 	}
-	
+
 	// Collect inbound and outbound traffic
 	for _, traffic := range traffics {
 		if traffic.IsInbound && traffic.Tag != "api" {
-			data.Inbounds[traffic.Tag] = map[string]int64{
-				"uplink":   traffic.Up,
-				"downlink": traffic.Down,
+			data.Inbounds[traffic.Tag] = protocol.TrafficCounter{
+				Uplink:   traffic.Up,
+				Downlink: traffic.Down,
 			}
 		} else if traffic.IsOutbound {
-			data.Outbounds[traffic.Tag] = map[string]int64{
-				"uplink":   traffic.Up,
-				"downlink": traffic.Down,
+			data.Outbounds[traffic.Tag] = protocol.TrafficCounter{
+				Uplink:   traffic.Up,
+				Downlink: traffic.Down,
 			}
 		}
 	}
-	
+
 	// Collect user traffic and online clients
 	for _, clientTraffic := range clientTraffics {
 		if clientTraffic.Email != "" {
 			// Only include user in traffic data if they have actual traffic this period
 			if clientTraffic.Up > 0 || clientTraffic.Down > 0 {
-				data.Users = append(data.Users, map[string]interface{}{
-					"email":    clientTraffic.Email,
-					"uplink":   clientTraffic.Up,
-					"downlink": clientTraffic.Down,
+				data.Users = append(data.Users, protocol.UserTraffic{
+					Email:    clientTraffic.Email,
+					Uplink:   clientTraffic.Up,
+					Downlink: clientTraffic.Down,
 				})
 				data.OnlineClients = append(data.OnlineClients, clientTraffic.Email)
 			}
 		}
 	}
-	
+
 	// Always send traffic stats message, even if no traffic occurred this period
 	// This ensures frontend receives regular updates about online status and accumulated traffic
 	if len(data.Inbounds) == 0 && len(data.Outbounds) == 0 && len(data.Users) == 0 {
@@ -305,62 +582,176 @@ func (s *Slave) collectTrafficStats() string {
 		// This triggers frontend updates from database values
 		logger.Debug("collectTrafficStats: No new traffic this period, sending status update")
 	}
-	
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		logger.Error("Failed to marshal traffic data:", err)
-		return ""
-	}
-	
-	logger.Infof("Sending traffic stats: %d inbounds, %d outbounds, %d users, %d online", 
+
+	logger.Infof("Sending traffic stats: %d inbounds, %d outbounds, %d users, %d online",
 		len(data.Inbounds), len(data.Outbounds), len(data.Users), len(data.OnlineClients))
-	return string(jsonData)
+	return data
 }
 
-func (s *Slave) applyFullConfig(xrayConfig *xray.Config) {
-	logger.Info("Applying new full configuration...")
+// applyFullConfig replaces the running Xray with one using xrayConfig and reports the outcome.
+// If the new config does not come up, the previously applied config is started again so the
+// node keeps serving traffic.
+func (s *Slave) applyFullConfig(revision int64, xrayConfig *xray.Config) *protocol.ConfigApplied {
+	logger.Infof("Applying new full configuration (revision %d)...", revision)
+	result := &protocol.ConfigApplied{Revision: revision}
 
 	// Stop previous process if running
 	if s.process != nil && s.process.IsRunning() {
 		s.process.Stop()
 	}
 
-	// Start new process
-	proc := xray.NewProcess(xrayConfig)
+	// Probe inbound ports now that our own Xray has released them,
+	// so bind conflicts can be reported per inbound.
+	result.InboundErrors = checkInboundPorts(xrayConfig)
 
-	if err := proc.Start(); err != nil {
+	if err := s.startXray(xrayConfig); err != nil {
 		logger.Error("Failed to start Xray:", err)
+		result.Error = err.Error()
+		if s.appliedConfig != nil {
+			logger.Warningf("Rolling back to config revision %d", s.appliedRevision)
+			if err := s.startXray(s.appliedConfig); err != nil {
+				logger.Error("Rollback failed, Xray is not running:", err)
+			}
+		}
+		return result
+	}
+
+	s.setApplied(revision, xrayConfig)
+	result.Success = true
+	return result
+}
+
+// applyConfigDelta applies the delta operations to the running Xray through its API. When the slave
+// is not running the delta's base revision, or an operation fails, it falls back to a full restart
+// with the resulting config carried in the delta.
+func (s *Slave) applyConfigDelta(delta *protocol.ConfigDelta, xrayConfig *xray.Config) *protocol.ConfigApplied {
+	if s.appliedRevision != delta.BaseRevision || s.process == nil || !s.process.IsRunning() ||
+		s.xrayAPI == nil || s.xrayAPI.HandlerServiceClient == nil {
+		logger.Infof("Cannot apply delta on top of revision %d (running %d), restarting with full config",
+			delta.BaseRevision, s.appliedRevision)
+		result := s.applyFullConfig(delta.Revision, xrayConfig)
+		result.Restarted = true
+		return result
+	}
+
+	logger.Infof("Applying config delta %d -> %d (%d ops)", delta.BaseRevision, delta.Revision, len(delta.Ops))
+	for _, op := range delta.Ops {
+		if err := s.applyDeltaOp(&op); err != nil {
+			logger.Warningf("Delta op %s on %s failed, restarting with full config: %v", op.Op, op.Tag, err)
+			result := s.applyFullConfig(delta.Revision, xrayConfig)
+			result.Restarted = true
+			return result
+		}
+	}
+
+	s.process.SetConfig(xrayConfig)
+	s.setApplied(delta.Revision, xrayConfig)
+	return &protocol.ConfigApplied{Revision: delta.Revision, Success: true}
+}
+
+// applyDeltaOp performs a single live change through the Xray API.
+func (s *Slave) applyDeltaOp(op *protocol.DeltaOp) error {
+	switch op.Op {
+	case protocol.OpAddInbound:
+		return s.xrayAPI.AddInbound(op.Inbound)
+	case protocol.OpRemoveInbound:
+		return s.xrayAPI.DelInbound(op.Tag)
+	case protocol.OpAddUser:
+		return s.xrayAPI.AddUser(op.Protocol, op.Tag, op.User)
+	case protocol.OpRemoveUser:
+		return s.xrayAPI.RemoveUser(op.Tag, op.Email)
+	default:
+		return fmt.Errorf("unknown delta operation %q", op.Op)
+	}
+}
+
+// isRunning reports whether Xray is up with exactly this config.
+func (s *Slave) isRunning(xrayConfig *xray.Config) bool {
+	return s.appliedConfig != nil && s.process != nil && s.process.IsRunning() &&
+		s.appliedConfig.Equals(xrayConfig)
+}
+
+// startXray launches Xray with the given config, waits for it to settle and connects the API client.
+func (s *Slave) startXray(xrayConfig *xray.Config) error {
+	proc := xray.NewProcess(xrayConfig)
+	if err := proc.Start(); err != nil {
+		return err
+	}
+
+	time.Sleep(2 * time.Second) // Wait for Xray to fully start
+	if !proc.IsRunning() {
+		return fmt.Errorf("xray exited during startup: %s", proc.GetResult())
+	}
+
+	s.process = proc
+	logger.Info("Xray started successfully")
+
+	// Initialize Xray API for traffic stats
+	// Dynamic API port extraction is handled by `proc.Start()` -> `proc.refreshAPIPort()`
+	apiPort := proc.GetAPIPort()
+	logger.Infof("Xray API Port discovered: %d", apiPort)
+
+	if s.xrayAPI == nil {
+		s.xrayAPI = &xray.XrayAPI{}
+	}
+	if err := s.xrayAPI.Init(apiPort); err != nil {
+		logger.Error("Failed to initialize Xray API:", err)
 	} else {
-		s.process = proc
-		logger.Info("Xray started successfully")
-		
-		// Initialize Xray API for traffic stats
-		// Dynamic API port extraction is handled by `proc.Start()` -> `proc.refreshAPIPort()`
-		apiPort := proc.GetAPIPort()
-		logger.Infof("Xray API Port discovered: %d", apiPort)
-
-		time.Sleep(2 * time.Second) // Wait for Xray to fully start
-		if s.xrayAPI == nil {
-			s.xrayAPI = &xray.XrayAPI{}
-		}
-		if err := s.xrayAPI.Init(apiPort); err != nil {
-			logger.Error("Failed to initialize Xray API:", err)
+		logger.Info("Xray API initialized successfully")
+	}
+	return nil
+}
+
+// checkInboundPorts tries to bind every inbound's listen address and returns those that are taken.
+func checkInboundPorts(xrayConfig *xray.Config) []protocol.InboundError {
+	var failures []protocol.InboundError
+	for _, inbound := range xrayConfig.InboundConfigs {
+		if inbound.Port <= 0 {
+			continue
+		}
+		var listen string
+		if len(inbound.Listen) > 0 {
+			_ = json.Unmarshal(inbound.Listen, &listen)
+		}
+		// Unix domain sockets are not port bound
+		if strings.HasPrefix(listen, "/") || strings.HasPrefix(listen, "@") {
+			continue
+		}
+		addr := net.JoinHostPort(listen, strconv.Itoa(inbound.Port))
+
+		var err error
+		if inbound.Protocol == "wireguard" {
+			var pc net.PacketConn
+			if pc, err = net.ListenPacket("udp", addr); err == nil {
+				pc.Close()
+			}
 		} else {
-			logger.Info("Xray API initialized successfully")
+			var l net.Listener
+			if l, err = net.Listen("tcp", addr); err == nil {
+				l.Close()
+			}
+		}
+		if err != nil {
+			failures = append(failures, protocol.InboundError{
+				Tag:   inbound.Tag,
+				Port:  inbound.Port,
+				Error: err.Error(),
+			})
 		}
 	}
+	return failures
 }
 
 func (s *Slave) restartXray() {
 	logger.Info("Restarting Xray...")
-	
+
 	if s.process != nil && s.process.IsRunning() {
 		if err := s.process.Stop(); err != nil {
 			logger.Error("Failed to stop Xray:", err)
 			return
 		}
 	}
-	
+
 	if s.process != nil {
 		if err := s.process.Start(); err != nil {
 			logger.Error("Failed to restart Xray:", err)
@@ -373,48 +764,33 @@ func (s *Slave) restartXray() {
 }
 
 // collectCertificates scans /root/cert directory and reports certificate paths
-func (s *Slave) collectCertificates() string {
-	certBaseDir := "/root/cert"
-	
+func (s *Slave) collectCertificates() *protocol.CertReport {
 	if _, err := os.Stat(certBaseDir); os.IsNotExist(err) {
 		logger.Debug("Certificate directory does not exist:", certBaseDir)
-		return ""
-	}
-	
-	type CertInfo struct {
-		Domain      string `json:"domain"`
-		CertPath    string `json:"certPath"`
-		KeyPath     string `json:"keyPath"`
-		ExpiryTime  int64  `json:"expiryTime"`
-	}
-	
-	type CertData struct {
-		Type  string     `json:"type"`
-		Certs []CertInfo `json:"certs"`
-	}
-	
-	data := CertData{
-		Type:  "cert_report",
-		Certs: make([]CertInfo, 0),
-	}
-	
+		return nil
+	}
+
+	data := &protocol.CertReport{
+		Certs: make([]protocol.CertInfo, 0),
+	}
+
 	// Scan subdirectories in /root/cert
 	entries, err := os.ReadDir(certBaseDir)
 	if err != nil {
 		logger.Error("Failed to read cert directory:", err)
-		return ""
+		return nil
 	}
-	
+
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue
 		}
-		
+
 		domain := entry.Name()
 		certDir := filepath.Join(certBaseDir, domain)
 		certFile := filepath.Join(certDir, "fullchain.pem")
 		keyFile := filepath.Join(certDir, "privkey.pem")
-		
+
 		// Check if both files exist
 		if _, err := os.Stat(certFile); err != nil {
 			continue
@@ -422,31 +798,28 @@ func (s *Slave) collectCertificates() string {
 		if _, err := os.Stat(keyFile); err != nil {
 			continue
 		}
-		
-		// Get certificate expiry (optional, requires parsing cert)
+
+		// Report the real expiry so the master knows when the certificate needs renewing
 		var expiryTime int64 = 0
-		// TODO: Parse certificate and extract expiry time using crypto/x509
-		// For now, we'll leave it as 0
-		
-		data.Certs = append(data.Certs, CertInfo{
+		if notAfter, err := certNotAfter(certFile); err != nil {
+			logger.Warningf("Failed to read certificate %s: %v", certFile, err)
+		} else {
+			expiryTime = notAfter.Unix()
+		}
+
+		data.Certs = append(data.Certs, protocol.CertInfo{
 			Domain:     domain,
 			CertPath:   certFile,
 			KeyPath:    keyFile,
 			ExpiryTime: expiryTime,
 		})
 	}
-	
+
 	if len(data.Certs) == 0 {
 		logger.Debug("No certificates found")
-		return ""
+		return nil
 	}
-	
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		logger.Error("Failed to marshal cert data:", err)
-		return ""
-	}
-	
+
 	logger.Infof("Reporting %d certificates to master", len(data.Certs))
-	return string(jsonData)
+	return data
 }
@@ -0,0 +1,199 @@
+package slave
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+)
+
+// maxInFlightReports bounds how many sent but unacknowledged reports a connection may have.
+// Beyond that new traffic is merged into a single unsent report so the spool stays small.
+const maxInFlightReports = 30
+
+// trafficSpool is an on-disk journal of traffic reports the master has not acknowledged yet.
+// Xray counters are reset when read, so every delta is written here before it is sent and
+// only dropped once the master confirms it was counted.
+type trafficSpool struct {
+	mu      sync.Mutex
+	path    string
+	lastSeq int64
+	pending []*protocol.TrafficStats
+	unsent  bool // the newest pending report has not been handed to a connection yet
+}
+
+type spoolFile struct {
+	LastSeq int64                    `json:"lastSeq"`
+	Pending []*protocol.TrafficStats `json:"pending"`
+}
+
+// getTrafficSpoolPath returns where unacknowledged traffic reports are kept between restarts.
+func getTrafficSpoolPath() string {
+	return filepath.Join(config.GetDBFolderPath(), "slave-traffic.json")
+}
+
+// newTrafficSpool loads the journal left by a previous run, if any.
+func newTrafficSpool(path string) *trafficSpool {
+	spool := &trafficSpool{path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warning("Failed to read traffic spool:", err)
+		}
+		return spool
+	}
+	var file spoolFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		logger.Warning("Traffic spool is corrupt, starting a new one:", err)
+		return spool
+	}
+	spool.lastSeq = file.LastSeq
+	spool.pending = file.Pending
+	if len(spool.pending) > 0 {
+		logger.Infof("Loaded %d unacknowledged traffic reports (last seq %d)", len(spool.pending), spool.lastSeq)
+	}
+	return spool
+}
+
+// Add journals a new traffic delta. While the newest report has not been sent yet,
+// the delta is merged into it instead of starting a new report.
+func (t *trafficSpool) Add(stats *protocol.TrafficStats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.unsent && len(t.pending) > 0 {
+		mergeTrafficStats(t.pending[len(t.pending)-1], stats)
+	} else {
+		t.lastSeq++
+		stats.Seq = t.lastSeq
+		t.pending = append(t.pending, stats)
+		t.unsent = true
+	}
+	t.save()
+}
+
+// Take returns the pending reports with a sequence number above after, which is the last
+// one sent on the current connection. It returns nothing while too many are in flight.
+func (t *trafficSpool) Take(after int64) []*protocol.TrafficStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var inFlight int
+	var reports []*protocol.TrafficStats
+	for _, report := range t.pending {
+		if report.Seq <= after {
+			inFlight++
+		} else {
+			reports = append(reports, report)
+		}
+	}
+	if inFlight >= maxInFlightReports || len(reports) == 0 {
+		return nil
+	}
+	t.unsent = false
+	return reports
+}
+
+// Ack drops every report up to seq, which the master has counted.
+func (t *trafficSpool) Ack(seq int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.drop(seq)
+}
+
+// drop removes the reports up to seq; the caller holds the lock.
+func (t *trafficSpool) drop(seq int64) {
+	kept := t.pending[:0]
+	for _, report := range t.pending {
+		if report.Seq > seq {
+			kept = append(kept, report)
+		}
+	}
+	if len(kept) == len(t.pending) {
+		return
+	}
+	t.pending = kept
+	t.save()
+}
+
+// Resync lines the journal up with seq, the last report the master counted as told on connect.
+// A master ahead of the journal means the journal was lost and numbering started over, so none
+// of the pending reports were counted yet: they are renumbered to follow seq rather than dropped.
+func (t *trafficSpool) Resync(seq int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if seq <= t.lastSeq {
+		t.drop(seq)
+		return
+	}
+	if len(t.pending) > 0 {
+		logger.Warningf("Master counted traffic up to %d, beyond the journal's %d; renumbering %d pending reports",
+			seq, t.lastSeq, len(t.pending))
+	}
+	t.lastSeq = seq
+	for _, report := range t.pending {
+		t.lastSeq++
+		report.Seq = t.lastSeq
+	}
+	t.save()
+}
+
+// Len returns the number of reports waiting for the master's acknowledgement.
+func (t *trafficSpool) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+func (t *trafficSpool) save() {
+	data, err := json.Marshal(spoolFile{LastSeq: t.lastSeq, Pending: t.pending})
+	if err == nil {
+		err = writeFileAtomic(t.path, data)
+	}
+	if err != nil {
+		logger.Warning("Failed to write traffic spool:", err)
+	}
+}
+
+// mergeTrafficStats adds the counters of src to dst. The online list is taken from the newer report.
+func mergeTrafficStats(dst, src *protocol.TrafficStats) {
+	if dst.Inbounds == nil {
+		dst.Inbounds = make(map[string]protocol.TrafficCounter)
+	}
+	for tag, counter := range src.Inbounds {
+		c := dst.Inbounds[tag]
+		c.Uplink += counter.Uplink
+		c.Downlink += counter.Downlink
+		dst.Inbounds[tag] = c
+	}
+	if dst.Outbounds == nil {
+		dst.Outbounds = make(map[string]protocol.TrafficCounter)
+	}
+	for tag, counter := range src.Outbounds {
+		c := dst.Outbounds[tag]
+		c.Uplink += counter.Uplink
+		c.Downlink += counter.Downlink
+		dst.Outbounds[tag] = c
+	}
+
+	index := make(map[string]int, len(dst.Users))
+	for i, user := range dst.Users {
+		index[user.Email] = i
+	}
+	for _, user := range src.Users {
+		if i, ok := index[user.Email]; ok {
+			dst.Users[i].Uplink += user.Uplink
+			dst.Users[i].Downlink += user.Downlink
+		} else {
+			index[user.Email] = len(dst.Users)
+			dst.Users = append(dst.Users, user)
+		}
+	}
+	dst.OnlineClients = src.OnlineClients
+}
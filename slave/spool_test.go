@@ -0,0 +1,135 @@
+package slave
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/op/go-logging"
+)
+
+func pendingSeqs(spool *trafficSpool) []int64 {
+	var seqs []int64
+	for _, report := range spool.pending {
+		seqs = append(seqs, report.Seq)
+	}
+	return seqs
+}
+
+func trafficReport(email string, up int64) *protocol.TrafficStats {
+	return &protocol.TrafficStats{Users: []protocol.UserTraffic{{Email: email, Uplink: up}}}
+}
+
+func TestTrafficSpool(t *testing.T) {
+	logger.InitLogger(logging.ERROR)
+
+	tests := []struct {
+		name     string
+		run      func(spool *trafficSpool)
+		wantSeqs []int64
+		wantLast int64
+	}{
+		{
+			name: "reports are numbered in order",
+			run: func(spool *trafficSpool) {
+				for range 3 {
+					spool.Add(trafficReport("a", 1))
+					spool.Take(0)
+				}
+			},
+			wantSeqs: []int64{1, 2, 3},
+			wantLast: 3,
+		},
+		{
+			name: "unsent traffic is merged into the newest report",
+			run: func(spool *trafficSpool) {
+				spool.Add(trafficReport("a", 1))
+				spool.Add(trafficReport("a", 2))
+			},
+			wantSeqs: []int64{1},
+			wantLast: 1,
+		},
+		{
+			name: "ack drops counted reports",
+			run: func(spool *trafficSpool) {
+				for range 3 {
+					spool.Add(trafficReport("a", 1))
+					spool.Take(0)
+				}
+				spool.Ack(2)
+			},
+			wantSeqs: []int64{3},
+			wantLast: 3,
+		},
+		{
+			name: "resync drops what the master counted",
+			run: func(spool *trafficSpool) {
+				for range 3 {
+					spool.Add(trafficReport("a", 1))
+					spool.Take(0)
+				}
+				spool.Resync(1)
+			},
+			wantSeqs: []int64{2, 3},
+			wantLast: 3,
+		},
+		{
+			name: "resync renumbers reports after a lost journal",
+			run: func(spool *trafficSpool) {
+				for range 2 {
+					spool.Add(trafficReport("a", 1))
+					spool.Take(0)
+				}
+				spool.Resync(40)
+			},
+			wantSeqs: []int64{41, 42},
+			wantLast: 42,
+		},
+		{
+			name: "numbering continues after a resync",
+			run: func(spool *trafficSpool) {
+				spool.Resync(40)
+				spool.Add(trafficReport("a", 1))
+			},
+			wantSeqs: []int64{41},
+			wantLast: 41,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "spool.json")
+			spool := newTrafficSpool(path)
+			tt.run(spool)
+			if got := pendingSeqs(spool); !slices.Equal(got, tt.wantSeqs) {
+				t.Fatalf("pending = %v, want %v", got, tt.wantSeqs)
+			}
+			if spool.lastSeq != tt.wantLast {
+				t.Fatalf("lastSeq = %d, want %d", spool.lastSeq, tt.wantLast)
+			}
+
+			// The journal survives a restart
+			reloaded := newTrafficSpool(path)
+			if got := pendingSeqs(reloaded); !slices.Equal(got, tt.wantSeqs) || reloaded.lastSeq != tt.wantLast {
+				t.Fatalf("reloaded pending = %v, last %d, want %v, last %d", got, reloaded.lastSeq, tt.wantSeqs, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestTrafficSpoolTake(t *testing.T) {
+	logger.InitLogger(logging.ERROR)
+	spool := newTrafficSpool(filepath.Join(t.TempDir(), "spool.json"))
+	for range maxInFlightReports + 1 {
+		spool.Add(trafficReport("a", 1))
+		spool.Take(0)
+	}
+
+	if got := spool.Take(int64(maxInFlightReports - 1)); len(got) != 2 {
+		t.Fatalf("Take() returned %d reports, want the 2 not yet sent", len(got))
+	}
+	if got := spool.Take(int64(maxInFlightReports)); got != nil {
+		t.Fatalf("Take() returned %d reports with %d in flight, want none", len(got), maxInFlightReports)
+	}
+}
@@ -0,0 +1,103 @@
+package slave
+
+import (
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/util/sys"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/shirou/gopsutil/v4/disk"
+	"github.com/shirou/gopsutil/v4/host"
+	"github.com/shirou/gopsutil/v4/load"
+	"github.com/shirou/gopsutil/v4/mem"
+	psnet "github.com/shirou/gopsutil/v4/net"
+)
+
+// statusCollector gathers the host status sent with each heartbeat. It keeps the previous
+// status to turn the network counters into rates.
+type statusCollector struct {
+	mu          sync.Mutex
+	last        *protocol.Status
+	cpuSpeedMhz float64
+}
+
+// collect reads the current host status. Values that cannot be read are left at zero.
+func (c *statusCollector) collect() *protocol.Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	status := &protocol.Status{T: now}
+
+	if percents, err := cpu.Percent(0, false); err == nil && len(percents) > 0 {
+		status.Cpu = percents[0]
+	}
+	status.CpuCores, _ = cpu.Counts(false)
+	status.LogicalPro = runtime.NumCPU()
+	if c.cpuSpeedMhz == 0 {
+		if infos, err := cpu.Info(); err == nil && len(infos) > 0 {
+			c.cpuSpeedMhz = infos[0].Mhz
+		}
+	}
+	status.CpuSpeedMhz = c.cpuSpeedMhz
+
+	if uptime, err := host.Uptime(); err == nil {
+		status.Uptime = uptime
+	}
+	if v, err := mem.VirtualMemory(); err == nil {
+		status.Mem.Current = v.Used
+		status.Mem.Total = v.Total
+	}
+	if swap, err := mem.SwapMemory(); err == nil {
+		status.Swap.Current = swap.Used
+		status.Swap.Total = swap.Total
+	}
+	if usage, err := disk.Usage("/"); err == nil {
+		status.Disk.Current = usage.Used
+		status.Disk.Total = usage.Total
+	}
+	if avg, err := load.Avg(); err == nil {
+		status.Loads = []float64{avg.Load1, avg.Load5, avg.Load15}
+	}
+
+	if counters, err := psnet.IOCounters(false); err == nil && len(counters) > 0 {
+		status.NetTraffic.Sent = counters[0].BytesSent
+		status.NetTraffic.Recv = counters[0].BytesRecv
+		if c.last != nil && status.NetTraffic.Sent >= c.last.NetTraffic.Sent && status.NetTraffic.Recv >= c.last.NetTraffic.Recv {
+			if seconds := now.Sub(c.last.T).Seconds(); seconds > 0 {
+				status.NetIO.Up = uint64(float64(status.NetTraffic.Sent-c.last.NetTraffic.Sent) / seconds)
+				status.NetIO.Down = uint64(float64(status.NetTraffic.Recv-c.last.NetTraffic.Recv) / seconds)
+			}
+		}
+	} else if err != nil {
+		logger.Debug("Failed to read network counters:", err)
+	}
+
+	status.TcpCount, _ = sys.GetTCPCount()
+	status.UdpCount, _ = sys.GetUDPCount()
+
+	var rtm runtime.MemStats
+	runtime.ReadMemStats(&rtm)
+	status.AppStats.Mem = rtm.Sys
+	status.AppStats.Threads = uint32(runtime.NumGoroutine())
+
+	c.last = status
+	return status
+}
+
+// setStatusAddress fills the public address fields from the address the slave reports.
+func setStatusAddress(status *protocol.Status, address string) {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+	case ip.To4() != nil:
+		status.PublicIP.IPv4 = address
+	default:
+		status.PublicIP.IPv6 = address
+	}
+}
@@ -0,0 +1,173 @@
+package slave
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// maxDownloadSize caps downloaded release archives and geofiles, which are a few tens of megabytes.
+const maxDownloadSize = 256 << 20
+
+func (s *Slave) rpcUpdateXray(ctx context.Context, params json.RawMessage) (any, error) {
+	var req protocol.XrayUpdate
+	if err := json.Unmarshal(params, &req); err != nil || req.Url == "" || req.Sha256 == "" {
+		return nil, &protocol.RpcError{Code: protocol.ErrCodeBadPayload, Message: "url and sha256 are required"}
+	}
+	return s.updateXray(ctx, &req)
+}
+
+// updateXray installs the Xray core from a verified release archive. The installed binary is only
+// replaced once the new one proved to run on this machine, and put back if Xray does not come up with it.
+// The download and checks run without xrayMu, which config pushes from the read loop wait on.
+func (s *Slave) updateXray(ctx context.Context, req *protocol.XrayUpdate) (*protocol.XrayUpdateResult, error) {
+	binPath := xray.GetBinaryPath()
+	binDir := filepath.Dir(binPath)
+	logger.Infof("Updating Xray core to %s", req.Version)
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, req.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := downloadVerified(http.DefaultClient, httpReq, req.Sha256, binDir)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(archive)
+
+	newBin, err := extractXrayBinary(archive, binDir)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(newBin)
+
+	if out, err := exec.CommandContext(ctx, newBin, "-version").CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("new core does not run on this node: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	s.xrayMu.Lock()
+	defer s.xrayMu.Unlock()
+
+	// Keep the current core under a second name; the rename below then swaps atomically
+	backup := binPath + ".bak"
+	os.Remove(backup)
+	hasBackup := false
+	if _, err := os.Stat(binPath); err == nil {
+		if err := os.Link(binPath, backup); err != nil {
+			return nil, fmt.Errorf("failed to back up current core: %v", err)
+		}
+		hasBackup = true
+	}
+	if err := os.Rename(newBin, binPath); err != nil {
+		os.Remove(backup)
+		return nil, fmt.Errorf("failed to install new core: %v", err)
+	}
+
+	if s.process == nil || !s.process.IsRunning() {
+		os.Remove(backup)
+		logger.Infof("Xray core %s installed, used on the next start", req.Version)
+		return &protocol.XrayUpdateResult{XrayVersion: req.Version}, nil
+	}
+
+	xrayConfig := s.process.GetConfig()
+	s.process.Stop()
+	if err := s.startXray(xrayConfig); err != nil {
+		logger.Errorf("Xray core %s failed to start: %v", req.Version, err)
+		if !hasBackup {
+			return nil, fmt.Errorf("new core failed to start: %v", err)
+		}
+		if rbErr := os.Rename(backup, binPath); rbErr != nil {
+			return nil, fmt.Errorf("new core failed to start (%v) and the previous one could not be restored: %v", err, rbErr)
+		}
+		if rbErr := s.startXray(xrayConfig); rbErr != nil {
+			logger.Error("Xray does not start with the previous core either:", rbErr)
+		}
+		return nil, fmt.Errorf("new core failed to start, previous core restored: %v", err)
+	}
+
+	os.Remove(backup)
+	logger.Infof("Xray core updated to %s", s.process.GetVersion())
+	return &protocol.XrayUpdateResult{XrayVersion: s.process.GetVersion(), Restarted: true}, nil
+}
+
+// downloadVerified downloads into a temporary file in dir and returns its path if the SHA-256 matches sum.
+func downloadVerified(client *http.Client, req *http.Request, sum, dir string) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	file, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), io.LimitReader(resp.Body, maxDownloadSize))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	if got := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(got, sum) {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("checksum mismatch: expected %s, got %s", sum, got)
+	}
+	return file.Name(), nil
+}
+
+// extractXrayBinary unpacks the xray executable from a release archive into a temporary file in dir.
+func extractXrayBinary(archive, dir string) (string, error) {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	name := "xray"
+	if runtime.GOOS == "windows" {
+		name = "xray.exe"
+	}
+	src, err := reader.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("archive has no %s: %v", name, err)
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(dir, ".xray-new-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(dst.Name(), 0o755)
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
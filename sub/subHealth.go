@@ -0,0 +1,102 @@
+package sub
+
+import (
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// Ways subscriptions present inbounds of offline slaves
+const (
+	OfflineModeShow = "show" // list them like any other inbound
+	OfflineModeHide = "hide" // leave them out
+	OfflineModeLast = "last" // move them to the end of the list
+	OfflineModeTag  = "tag"  // prefix their remark with the offline tag
+)
+
+// offlineRemarks holds the inbounds of offline slaves whose remark gets a tag in one request.
+type offlineRemarks struct {
+	tag      string
+	inbounds map[int]bool
+}
+
+// apply prefixes the remark of a tagged inbound with the offline tag. A nil receiver tags nothing.
+func (o *offlineRemarks) apply(inboundId int, remark string) string {
+	if o == nil || !o.inbounds[inboundId] {
+		return remark
+	}
+	return o.tag + " " + remark
+}
+
+// downSlaves returns the slaves that have not been heard from for longer than the grace period,
+// whatever their stored status, which stays "online" after a master restart or on a half-open
+// socket. A slave that drops off only briefly stays in place, so flapping nodes do not churn
+// users' client lists.
+func (s *SubService) downSlaves() map[int]bool {
+	grace, err := s.settingService.GetSubOfflineGrace()
+	if err != nil {
+		grace = 5
+	}
+	var slaves []model.Slave
+	err = database.GetDB().Select("id").
+		Where("last_seen <= ?", time.Now().Add(-time.Duration(grace)*time.Minute).Unix()).
+		Find(&slaves).Error
+	if err != nil {
+		logger.Warning("SubService - unable to load slave health:", err)
+		return nil
+	}
+	down := make(map[int]bool, len(slaves))
+	for _, slave := range slaves {
+		down[slave.Id] = true
+	}
+	return down
+}
+
+// applyOfflineMode drops, reorders or tags the inbounds of down slaves. The tagged inbounds are
+// returned for genRemark, as the service is shared between concurrent requests.
+func (s *SubService) applyOfflineMode(inbounds []*model.Inbound, mode string) ([]*model.Inbound, *offlineRemarks) {
+	if mode == "" || mode == OfflineModeShow {
+		return inbounds, nil
+	}
+	down := s.downSlaves()
+	if len(down) == 0 {
+		return inbounds, nil
+	}
+
+	var up, offline []*model.Inbound
+	for _, inbound := range inbounds {
+		if down[inbound.SlaveId] {
+			offline = append(offline, inbound)
+		} else {
+			up = append(up, inbound)
+		}
+	}
+	switch mode {
+	case OfflineModeHide:
+		return up, nil
+	case OfflineModeLast:
+		return append(up, offline...), nil
+	case OfflineModeTag:
+		tag, err := s.settingService.GetSubOfflineTag()
+		if err != nil || tag == "" {
+			tag = "[offline]"
+		}
+		remarks := &offlineRemarks{tag: tag, inbounds: make(map[int]bool, len(offline))}
+		for _, inbound := range offline {
+			remarks.inbounds[inbound.Id] = true
+		}
+		return inbounds, remarks
+	}
+	return inbounds, nil
+}
+
+// linkOfflineMode returns how link subscriptions present inbounds of offline slaves.
+func (s *SubService) linkOfflineMode() string {
+	mode, err := s.settingService.GetSubOfflineMode()
+	if err != nil {
+		return OfflineModeShow
+	}
+	return mode
+}
@@ -76,6 +76,11 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 	if err != nil || len(inbounds) == 0 {
 		return "", "", err
 	}
+	mode, err := s.SubService.settingService.GetSubJsonOfflineMode()
+	if err != nil {
+		mode = OfflineModeShow
+	}
+	inbounds, offline := s.SubService.applyOfflineMode(s.SubService.orderInbounds(inbounds), mode)
 
 	var header string
 	var traffic xray.ClientTraffic
@@ -103,7 +108,7 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 		for _, client := range clients {
 			if client.Enable && client.SubID == subId {
 				clientTraffics = append(clientTraffics, s.SubService.getClientTraffics(inbound.ClientStats, client.Email))
-				newConfigs := s.getConfig(inbound, client, host)
+				newConfigs := s.getConfig(inbound, client, host, offline)
 				configArray = append(configArray, newConfigs...)
 			}
 		}
@@ -148,7 +153,7 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 	return string(finalJson), header, nil
 }
 
-func (s *SubJsonService) getConfig(inbound *model.Inbound, client model.Client, host string) []json_util.RawMessage {
+func (s *SubJsonService) getConfig(inbound *model.Inbound, client model.Client, host string, offline *offlineRemarks) []json_util.RawMessage {
 	var newJsonArray []json_util.RawMessage
 	stream := s.streamData(inbound.StreamSettings)
 
@@ -201,7 +206,7 @@ func (s *SubJsonService) getConfig(inbound *model.Inbound, client model.Client,
 		maps.Copy(newConfigJson, s.configJson)
 
 		newConfigJson["outbounds"] = newOutbounds
-		newConfigJson["remarks"] = s.SubService.genRemark(inbound, client.Email, extPrxy["remark"].(string))
+		newConfigJson["remarks"] = s.SubService.genRemark(inbound, client.Email, extPrxy["remark"].(string), offline)
 
 		newConfig, _ := json.MarshalIndent(newConfigJson, "", "  ")
 		newJsonArray = append(newJsonArray, newConfig)
@@ -0,0 +1,55 @@
+package sub
+
+import (
+	"slices"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// Orders of subscription links
+const (
+	SubOrderNone = "none" // database order
+	SubOrderLoad = "load" // least busy slave first
+)
+
+// orderInbounds sorts inbounds by the load score of their slave, least busy first, so clients
+// that pick the first entry spread across the cluster. Inbounds of slaves without a score keep
+// their order after the scored ones.
+func (s *SubService) orderInbounds(inbounds []*model.Inbound) []*model.Inbound {
+	order, err := s.settingService.GetSubOrder()
+	if err != nil || order != SubOrderLoad || len(inbounds) < 2 {
+		return inbounds
+	}
+	weights, err := s.settingService.GetSubLoadWeights()
+	if err != nil {
+		logger.Warning("SubService - unable to load the load weights:", err)
+		return inbounds
+	}
+	scores, err := s.slaveService.GetSlaveLoadScores(weights)
+	if err != nil {
+		logger.Warning("SubService - unable to score slave load:", err)
+		return inbounds
+	}
+
+	slices.SortStableFunc(inbounds, func(a, b *model.Inbound) int {
+		scoreA, okA := scores[a.SlaveId]
+		scoreB, okB := scores[b.SlaveId]
+		switch {
+		case okA && okB:
+			if scoreA < scoreB {
+				return -1
+			}
+			if scoreA > scoreB {
+				return 1
+			}
+			return 0
+		case okA:
+			return -1
+		case okB:
+			return 1
+		}
+		return 0
+	})
+	return inbounds
+}
@@ -74,6 +74,7 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.C
 	if len(inbounds) == 0 {
 		return nil, 0, traffic, common.NewError("No inbounds found with ", subId)
 	}
+	inbounds, offline := s.applyOfflineMode(s.orderInbounds(inbounds), s.linkOfflineMode())
 
 	s.datepicker, err = s.settingService.GetDatepicker()
 	if err != nil {
@@ -97,7 +98,7 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, int64, xray.C
 		}
 		for _, client := range clients {
 			if client.Enable && client.SubID == subId {
-				link := s.getLink(inbound, client.Email)
+				link := s.getLink(inbound, client.Email, offline)
 				result = append(result, link)
 				ct := s.getClientTraffics(inbound.ClientStats, client.Email)
 				clientTraffics = append(clientTraffics, ct)
@@ -143,7 +144,7 @@ func (s *SubService) getInboundsBySubId(subId string) ([]*model.Inbound, error)
 		WHERE
 			protocol in ('vmess','vless','trojan','shadowsocks')
 			AND JSON_EXTRACT(client.value, '$.subId') = ? AND enable = ?
-	)`, subId, true).Find(&inbounds).Error
+	) AND slave_id NOT IN (SELECT id FROM slaves WHERE draining = ?)`, subId, true, true).Find(&inbounds).Error
 	if err != nil {
 		return nil, err
 	}
@@ -200,13 +201,23 @@ func (s *SubService) GetSubsByAccountId(accountId int, host string) ([]string, i
 	aggregatedTraffic.ExpiryTime = account.ExpiryTime
 	aggregatedTraffic.Enable = account.Enable
 
-	// Iterate through associations and generate links
+	// Collect the inbounds in association order, each with the account's clients on it
+	var inbounds []*model.Inbound
+	emails := make(map[int][]string)
 	for _, assoc := range associations {
-		inbound, err := s.inboundService.GetInbound(assoc.InboundId)
-		if err != nil || !inbound.Enable {
-			continue
+		if _, ok := emails[assoc.InboundId]; !ok {
+			inbound, err := s.inboundService.GetInbound(assoc.InboundId)
+			if err != nil || !inbound.Enable || s.slaveService.IsSlaveDraining(inbound.SlaveId) {
+				continue
+			}
+			inbounds = append(inbounds, inbound)
 		}
+		emails[assoc.InboundId] = append(emails[assoc.InboundId], assoc.ClientEmail)
+	}
+	inbounds, offline := s.applyOfflineMode(s.orderInbounds(inbounds), s.linkOfflineMode())
 
+	// Generate the links
+	for _, inbound := range inbounds {
 		clients, err := s.inboundService.GetClients(inbound)
 		if err != nil {
 			logger.Error("SubService - GetClients: Unable to get clients from inbound")
@@ -227,20 +238,22 @@ func (s *SubService) GetSubsByAccountId(accountId int, host string) ([]string, i
 			}
 		}
 
-		// Find the specific client
-		for _, client := range clients {
-			if client.Email == assoc.ClientEmail && client.Enable {
-				link := s.getLink(inbound, client.Email)
-				if link != "" {
-					result = append(result, link)
-				}
+		// Find the account's clients
+		for _, email := range emails[inbound.Id] {
+			for _, client := range clients {
+				if client.Email == email && client.Enable {
+					link := s.getLink(inbound, client.Email, offline)
+					if link != "" {
+						result = append(result, link)
+					}
 
-				// Update last online from client stats
-				ct := s.getClientTraffics(inbound.ClientStats, client.Email)
-				if ct.LastOnline > lastOnline {
-					lastOnline = ct.LastOnline
+					// Update last online from client stats
+					ct := s.getClientTraffics(inbound.ClientStats, client.Email)
+					if ct.LastOnline > lastOnline {
+						lastOnline = ct.LastOnline
+					}
+					break
 				}
-				break
 			}
 		}
 	}
@@ -257,6 +270,7 @@ func (s *SubService) getInboundsByAccountId(accountId int) ([]*model.Inbound, er
 		SELECT DISTINCT i.* FROM inbounds i
 		INNER JOIN account_clients ac ON ac.inbound_id = i.id
 		WHERE ac.account_id = ? AND i.enable = true
+			AND i.slave_id NOT IN (SELECT id FROM slaves WHERE draining = true)
 	`, accountId).Scan(&inbounds).Error
 
 	if err != nil {
@@ -303,21 +317,21 @@ func (s *SubService) getFallbackMaster(dest string, streamSettings string) (stri
 	return inbound.Listen, inbound.Port, string(modifiedStream), nil
 }
 
-func (s *SubService) getLink(inbound *model.Inbound, email string) string {
+func (s *SubService) getLink(inbound *model.Inbound, email string, offline *offlineRemarks) string {
 	switch inbound.Protocol {
 	case "vmess":
-		return s.genVmessLink(inbound, email)
+		return s.genVmessLink(inbound, email, offline)
 	case "vless":
-		return s.genVlessLink(inbound, email)
+		return s.genVlessLink(inbound, email, offline)
 	case "trojan":
-		return s.genTrojanLink(inbound, email)
+		return s.genTrojanLink(inbound, email, offline)
 	case "shadowsocks":
-		return s.genShadowsocksLink(inbound, email)
+		return s.genShadowsocksLink(inbound, email, offline)
 	}
 	return ""
 }
 
-func (s *SubService) genVmessLink(inbound *model.Inbound, email string) string {
+func (s *SubService) genVmessLink(inbound *model.Inbound, email string, offline *offlineRemarks) string {
 	if inbound.Protocol != model.VMESS {
 		return ""
 	}
@@ -439,7 +453,7 @@ func (s *SubService) genVmessLink(inbound *model.Inbound, email string) string {
 					newObj[key] = value
 				}
 			}
-			newObj["ps"] = s.genRemark(inbound, email, ep["remark"].(string))
+			newObj["ps"] = s.genRemark(inbound, email, ep["remark"].(string), offline)
 			newObj["add"] = ep["dest"].(string)
 			newObj["port"] = int(ep["port"].(float64))
 
@@ -455,13 +469,13 @@ func (s *SubService) genVmessLink(inbound *model.Inbound, email string) string {
 		return links
 	}
 
-	obj["ps"] = s.genRemark(inbound, email, "")
+	obj["ps"] = s.genRemark(inbound, email, "", offline)
 
 	jsonStr, _ := json.MarshalIndent(obj, "", "  ")
 	return "vmess://" + base64.StdEncoding.EncodeToString(jsonStr)
 }
 
-func (s *SubService) genVlessLink(inbound *model.Inbound, email string) string {
+func (s *SubService) genVlessLink(inbound *model.Inbound, email string, offline *offlineRemarks) string {
 	var address string
 	if inbound.Listen == "" || inbound.Listen == "0.0.0.0" || inbound.Listen == "::" || inbound.Listen == "::0" {
 		address = s.resolveInboundAddress(inbound)
@@ -643,7 +657,7 @@ func (s *SubService) genVlessLink(inbound *model.Inbound, email string) string {
 			// Set the new query values on the URL
 			url.RawQuery = q.Encode()
 
-			url.Fragment = s.genRemark(inbound, email, ep["remark"].(string))
+			url.Fragment = s.genRemark(inbound, email, ep["remark"].(string), offline)
 
 			links = append(links, url.String())
 		}
@@ -661,11 +675,11 @@ func (s *SubService) genVlessLink(inbound *model.Inbound, email string) string {
 	// Set the new query values on the URL
 	url.RawQuery = q.Encode()
 
-	url.Fragment = s.genRemark(inbound, email, "")
+	url.Fragment = s.genRemark(inbound, email, "", offline)
 	return url.String()
 }
 
-func (s *SubService) genTrojanLink(inbound *model.Inbound, email string) string {
+func (s *SubService) genTrojanLink(inbound *model.Inbound, email string, offline *offlineRemarks) string {
 	var address string
 	if inbound.Listen == "" || inbound.Listen == "0.0.0.0" || inbound.Listen == "::" || inbound.Listen == "::0" {
 		address = s.resolveInboundAddress(inbound)
@@ -835,7 +849,7 @@ func (s *SubService) genTrojanLink(inbound *model.Inbound, email string) string
 			// Set the new query values on the URL
 			url.RawQuery = q.Encode()
 
-			url.Fragment = s.genRemark(inbound, email, ep["remark"].(string))
+			url.Fragment = s.genRemark(inbound, email, ep["remark"].(string), offline)
 
 			if index > 0 {
 				links += "\n"
@@ -857,11 +871,11 @@ func (s *SubService) genTrojanLink(inbound *model.Inbound, email string) string
 	// Set the new query values on the URL
 	url.RawQuery = q.Encode()
 
-	url.Fragment = s.genRemark(inbound, email, "")
+	url.Fragment = s.genRemark(inbound, email, "", offline)
 	return url.String()
 }
 
-func (s *SubService) genShadowsocksLink(inbound *model.Inbound, email string) string {
+func (s *SubService) genShadowsocksLink(inbound *model.Inbound, email string, offline *offlineRemarks) string {
 	var address string
 	if inbound.Listen == "" || inbound.Listen == "0.0.0.0" || inbound.Listen == "::" || inbound.Listen == "::0" {
 		address = s.resolveInboundAddress(inbound)
@@ -1002,7 +1016,7 @@ func (s *SubService) genShadowsocksLink(inbound *model.Inbound, email string) st
 			// Set the new query values on the URL
 			url.RawQuery = q.Encode()
 
-			url.Fragment = s.genRemark(inbound, email, ep["remark"].(string))
+			url.Fragment = s.genRemark(inbound, email, ep["remark"].(string), offline)
 
 			if index > 0 {
 				links += "\n"
@@ -1023,11 +1037,11 @@ func (s *SubService) genShadowsocksLink(inbound *model.Inbound, email string) st
 	// Set the new query values on the URL
 	url.RawQuery = q.Encode()
 
-	url.Fragment = s.genRemark(inbound, email, "")
+	url.Fragment = s.genRemark(inbound, email, "", offline)
 	return url.String()
 }
 
-func (s *SubService) genRemark(inbound *model.Inbound, email string, extra string) string {
+func (s *SubService) genRemark(inbound *model.Inbound, email string, extra string, offline *offlineRemarks) string {
 	separationChar := string(s.remarkModel[0])
 	orderChars := s.remarkModel[1:]
 	orders := map[byte]string{
@@ -1109,7 +1123,7 @@ func (s *SubService) genRemark(inbound *model.Inbound, email string, extra strin
 			}
 		}
 	}
-	return strings.Join(remark, separationChar)
+	return offline.apply(inbound.Id, strings.Join(remark, separationChar))
 }
 
 func searchKey(data any, key string) (any, bool) {
@@ -1,4 +1,4 @@
-// Package crypto provides cryptographic utilities for password hashing and verification.
+// Package crypto provides cryptographic utilities for password hashing and verification and for encrypting secrets at rest.
 package crypto
 
 import (
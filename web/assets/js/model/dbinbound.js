@@ -4,6 +4,7 @@ class DBInbound {
         this.id = 0;
         this.userId = 0;
         this.slaveId = null; // Must be set to a valid slave
+        this.replicaOf = 0; // Id of the replicated inbound this inbound is rendered from
         this.up = 0;
         this.down = 0;
         this.total = 0;
@@ -681,6 +681,7 @@ TlsStreamSettings.Cert = class extends XrayCommonClass {
         oneTimeLoading = false,
         usage = USAGE_OPTION.ENCIPHERMENT,
         buildChain = false,
+        certId = 0,
     ) {
         super();
         this.useFile = useFile;
@@ -691,10 +692,20 @@ TlsStreamSettings.Cert = class extends XrayCommonClass {
         this.oneTimeLoading = oneTimeLoading;
         this.usage = usage;
         this.buildChain = buildChain
+        // Certificate from the master's store, installed on the slave when the config is pushed
+        this.certId = certId;
     }
 
     static fromJson(json = {}) {
-        if ('certificateFile' in json && 'keyFile' in json) {
+        if ('certId' in json) {
+            return new TlsStreamSettings.Cert(
+                true, '', '', '', '',
+                json.oneTimeLoading,
+                json.usage,
+                json.buildChain,
+                json.certId,
+            );
+        } else if ('certificateFile' in json && 'keyFile' in json) {
             return new TlsStreamSettings.Cert(
                 true,
                 json.certificateFile,
@@ -716,7 +727,14 @@ TlsStreamSettings.Cert = class extends XrayCommonClass {
     }
 
     toJson() {
-        if (this.useFile) {
+        if (this.useFile && this.certId) {
+            return {
+                certId: this.certId,
+                oneTimeLoading: this.oneTimeLoading,
+                usage: this.usage,
+                buildChain: this.buildChain,
+            };
+        } else if (this.useFile) {
             return {
                 certificateFile: this.certFile,
                 keyFile: this.keyFile,
@@ -25,6 +25,7 @@ class AllSetting {
         this.tgLang = "en-US";
         this.twoFactorEnable = false;
         this.twoFactorToken = "";
+        this.metricsToken = "";
         this.xrayTemplateConfig = "";
         this.subEnable = true;
         this.subJsonEnable = false;
@@ -52,6 +53,15 @@ class AllSetting {
         this.subJsonNoises = "";
         this.subJsonMux = "";
         this.subJsonRules = "";
+        this.subOfflineMode = "show";
+        this.subJsonOfflineMode = "show";
+        this.subOfflineGrace = 5;
+        this.subOfflineTag = "[offline]";
+        this.subOrder = "none";
+        this.subLoadCpuWeight = 1;
+        this.subLoadMemWeight = 1;
+        this.subLoadClientsWeight = 1;
+        this.subLoadLatencyWeight = 0;
 
         this.timeLocation = "Local";
 
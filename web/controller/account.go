@@ -49,11 +49,21 @@ func (a *AccountController) initRouter(g *gin.RouterGroup) {
 // @Description Returns all user accounts
 // @Tags Accounts
 // @Produce json
+// @Param selector query string false "Only accounts with clients on slaves matching this selector"
 // @Success 200 {object} entity.Msg
 // @Router /panel/api/account/list [get]
 // @route GET /panel/api/account/list
 func (a *AccountController) getAccounts(c *gin.Context) {
-	accounts, err := a.accountService.GetAccounts()
+	var accounts []*model.Account
+	var err error
+	if selector := c.Query("selector"); selector != "" {
+		var slaveIds []int
+		if slaveIds, err = a.slaveService.SelectSlaves(selector); err == nil {
+			accounts, err = a.accountService.GetAccountsForSlaves(slaveIds)
+		}
+	} else {
+		accounts, err = a.accountService.GetAccounts()
+	}
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.accounts.toasts.getAccounts"), err)
 		return
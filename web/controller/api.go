@@ -13,6 +13,7 @@ import (
 type APIController struct {
 	BaseController
 	inboundController     *InboundController
+	replicatedController  *ReplicatedInboundController
 	outboundController    *OutboundController
 	routingController     *RoutingController
 	serverController      *ServerController
@@ -47,6 +48,8 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 	// Slave connect without auth
 	slaveController := &SlaveController{slaveService: a.slaveService}
 	g.GET("/panel/api/slave/connect", slaveController.connectSlave)
+	g.POST("/panel/api/slave/enroll", slaveController.enrollSlave)
+	g.GET("/panel/api/slave/geofile/:name", slaveController.downloadGeofile)
 
 	// Main API group
 	api := g.Group("/panel/api")
@@ -56,6 +59,10 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 	inbounds := api.Group("/inbounds")
 	a.inboundController = NewInboundController(inbounds)
 
+	// Replicated inbounds API
+	replicated := api.Group("/replicated")
+	a.replicatedController = NewReplicatedInboundController(replicated)
+
 	// Outbounds API
 	outbounds := api.Group("/outbounds")
 	a.outboundController = NewOutboundController(outbounds)
@@ -70,7 +77,7 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 
 	// Slave Certificate API
 	slaveCerts := api.Group("/slave-certs")
-	a.slaveCertController = NewSlaveCertController(slaveCerts)
+	a.slaveCertController = NewSlaveCertController(slaveCerts, a.slaveService)
 
 	// Account API (multi-inbound user management)
 	accounts := api.Group("/account")
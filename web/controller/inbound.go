@@ -21,6 +21,8 @@ type InboundController struct {
 	inboundService service.InboundService
 	xrayService    service.XrayService
 	slaveService   service.SlaveService
+
+	replicatedService service.ReplicatedInboundService
 }
 
 // NewInboundController creates a new InboundController and sets up its routes.
@@ -42,6 +44,7 @@ func (a *InboundController) initRouter(g *gin.RouterGroup) {
 	g.POST("/add", a.addInbound)
 	g.POST("/del/:id", a.delInbound)
 	g.POST("/update/:id", a.updateInbound)
+	g.POST("/setEnable", a.setInboundsEnable)
 	g.POST("/clientIps/:email", a.getClientIps)
 	g.POST("/clearClientIps/:email", a.clearClientIps)
 	g.POST("/addClient", a.addInboundClient)
@@ -62,6 +65,7 @@ func (a *InboundController) initRouter(g *gin.RouterGroup) {
 // @Tags Inbounds
 // @Produce json
 // @Param slaveId query int false "Filter by slave ID (-1 for all)"
+// @Param selector query string false "Filter by slave selector instead, e.g. group=edge"
 // @Success 200 {object} entity.Msg
 // @Router /panel/api/inbounds/list [get]
 func (a *InboundController) getInbounds(c *gin.Context) {
@@ -72,7 +76,12 @@ func (a *InboundController) getInbounds(c *gin.Context) {
 	var inbounds []*model.Inbound
 	var err error
 	
-	if slaveId == -1 {
+	if selector := c.Query("selector"); selector != "" {
+		var slaveIds []int
+		if slaveIds, err = a.slaveService.SelectSlaves(selector); err == nil {
+			inbounds, err = a.inboundService.GetInboundsForSlaves(slaveIds)
+		}
+	} else if slaveId == -1 {
 		inbounds, err = a.inboundService.GetInbounds(user.Id)
 	} else {
 		inbounds, err = a.inboundService.GetInboundsForSlave(slaveId)
@@ -85,6 +94,61 @@ func (a *InboundController) getInbounds(c *gin.Context) {
 	jsonObj(c, inbounds, nil)
 }
 
+// inboundsEnableRequest switches the inbounds of the slaves matching a selector on or off.
+type inboundsEnableRequest struct {
+	Selector string `json:"selector" form:"selector"` // slave selector, e.g. group=edge
+	Tag      string `json:"tag" form:"tag"`           // only inbounds with this tag
+	Enable   bool   `json:"enable" form:"enable"`
+}
+
+// setInboundsEnable enables or disables the inbounds of all slaves matching a selector.
+// @Summary Enable or disable inbounds on slaves
+// @Description Switches the inbounds of the selected slaves on or off and pushes the config of the slaves that changed
+// @Tags Inbounds
+// @Accept json
+// @Produce json
+// @Param request body inboundsEnableRequest true "Slaves and inbounds to switch"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/inbounds/setEnable [post]
+func (a *InboundController) setInboundsEnable(c *gin.Context) {
+	var req inboundsEnableRequest
+	if err := c.ShouldBind(&req); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		return
+	}
+	slaveIds, err := a.slaveService.SelectSlaves(req.Selector)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		return
+	}
+	changed, err := a.inboundService.SetInboundsEnableForSlaves(slaveIds, req.Tag, req.Enable)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		return
+	}
+	a.pushConfigToSlaves(changed)
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), changed, nil)
+}
+
+// pushConfigToSlaves pushes the config of several slaves, logging the ones that fail.
+func (a *InboundController) pushConfigToSlaves(slaveIds []int) {
+	for _, result := range a.slaveService.PushConfigToSlaves(slaveIds) {
+		if !result.Success {
+			logger.Warningf("Failed to push config to slave %d: %s", result.SlaveId, result.Error)
+		}
+	}
+}
+
+// getReplica returns the inbound with the given id if it is the replica of a replicated inbound.
+// Replicas are rendered from their definition, so edits go to the definition instead.
+func (a *InboundController) getReplica(id int) *model.Inbound {
+	inbound, err := a.inboundService.GetInbound(id)
+	if err != nil || inbound.ReplicaOf == 0 {
+		return nil
+	}
+	return inbound
+}
+
 // getInbound retrieves a specific inbound by its ID.
 // @Summary Get inbound
 // @Description Returns a specific inbound configuration by ID
@@ -192,6 +256,7 @@ func (a *InboundController) addInbound(c *gin.Context) {
 	
 	user := session.GetLoginUser(c)
 	inbound.UserId = user.Id
+	inbound.ReplicaOf = 0
 
 	// Generate tag with format inbound-<SlaveName>-<Protocol>-<Port>
 	slaveName := "master"
@@ -209,12 +274,18 @@ func (a *InboundController) addInbound(c *gin.Context) {
 	
 	inbound.Tag = fmt.Sprintf("inbound-%s-%s-%d", slaveName, inbound.Protocol, inbound.Port)
 
+	// Refuse an inbound that would make Xray reject the slave's config
+	if err := a.slaveService.CheckSlaveInbound(inbound, ""); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+
 	inbound, needRestart, err := a.inboundService.AddInbound(inbound)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
-	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundCreateSuccess"), inbound, nil)
+	jsonMsgObj(c, withValidationNotice(I18nWeb(c, "pages.inbounds.toasts.inboundCreateSuccess"), inbound.SlaveId), inbound, nil)
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
 	}
@@ -243,6 +314,10 @@ func (a *InboundController) delInbound(c *gin.Context) {
     
     // Get inbound info before deletion to handle slave notification
     inbound, _ := a.inboundService.GetInbound(id)
+	if inbound != nil && inbound.ReplicaOf > 0 {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), common.NewError("This inbound is a replica, delete its replicated inbound instead"))
+		return
+	}
     
 	needRestart, err := a.inboundService.DelInbound(id)
 	if err != nil {
@@ -287,8 +362,14 @@ func (a *InboundController) updateInbound(c *gin.Context) {
 		return
 	}
 
+	if inbound.ReplicaOf > 0 {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), common.NewError("This inbound is a replica, edit its replicated inbound instead"))
+		return
+	}
+
 	// Backup original SlaveId for config push comparison
 	originalSlaveId := inbound.SlaveId
+	originalTag := inbound.Tag
 	logger.Infof("Original SlaveId: %d", originalSlaveId)
 
 	err = c.ShouldBindJSON(inbound)
@@ -298,6 +379,7 @@ func (a *InboundController) updateInbound(c *gin.Context) {
 	}
 
 	logger.Infof("New SlaveId after bind: %d", inbound.SlaveId)
+	inbound.ReplicaOf = 0
 
 	// Validate slave selection
 	if inbound.SlaveId <= 0 {
@@ -305,12 +387,22 @@ func (a *InboundController) updateInbound(c *gin.Context) {
 		return
 	}
 
+	// Refuse changes that would make Xray reject the slave's config
+	previousTag := ""
+	if inbound.SlaveId == originalSlaveId {
+		previousTag = originalTag
+	}
+	if err := a.slaveService.CheckSlaveInbound(inbound, previousTag); err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+
 	inbound, needRestart, err := a.inboundService.UpdateInbound(inbound)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
-	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), inbound, nil)
+	jsonMsgObj(c, withValidationNotice(I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), inbound.SlaveId), inbound, nil)
 	
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
@@ -418,17 +510,32 @@ func (a *InboundController) addInboundClient(c *gin.Context) {
 		return
 	}
 
+	if replica := a.getReplica(data.Id); replica != nil {
+		changed, err := a.replicatedService.AddReplicaClients(replica, data)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+		jsonMsg(c, withValidationNotice(I18nWeb(c, "pages.inbounds.toasts.inboundClientAddSuccess"), changed...), nil)
+		a.pushConfigToSlaves(changed)
+		return
+	}
+
 	needRestart, err := a.inboundService.AddInboundClient(data)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
-	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientAddSuccess"), nil)
+	inbound, _ := a.inboundService.GetInbound(data.Id)
+	slaveId := 0
+	if inbound != nil {
+		slaveId = inbound.SlaveId
+	}
+	jsonMsg(c, withValidationNotice(I18nWeb(c, "pages.inbounds.toasts.inboundClientAddSuccess"), slaveId), nil)
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
 	}
 	// Push config to slave
-	inbound, _ := a.inboundService.GetInbound(data.Id)
 	if inbound != nil && inbound.SlaveId > 0 {
 		a.slaveService.PushConfig(inbound.SlaveId)
 	}
@@ -451,6 +558,17 @@ func (a *InboundController) delInboundClient(c *gin.Context) {
 	}
 	clientId := c.Param("clientId")
 
+	if replica := a.getReplica(id); replica != nil {
+		changed, err := a.replicatedService.DelReplicaClient(replica, clientId)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+		jsonMsg(c, withValidationNotice(I18nWeb(c, "pages.inbounds.toasts.inboundClientDeleteSuccess"), changed...), nil)
+		a.pushConfigToSlaves(changed)
+		return
+	}
+
 	// Get inbound info before deletion
 	inbound, _ := a.inboundService.GetInbound(id)
 
@@ -459,7 +577,11 @@ func (a *InboundController) delInboundClient(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
-	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientDeleteSuccess"), nil)
+	slaveId := 0
+	if inbound != nil {
+		slaveId = inbound.SlaveId
+	}
+	jsonMsg(c, withValidationNotice(I18nWeb(c, "pages.inbounds.toasts.inboundClientDeleteSuccess"), slaveId), nil)
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
 	}
@@ -489,12 +611,23 @@ func (a *InboundController) updateInboundClient(c *gin.Context) {
 		return
 	}
 
+	if replica := a.getReplica(inbound.Id); replica != nil {
+		changed, err := a.replicatedService.UpdateReplicaClient(replica, clientId, inbound)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+			return
+		}
+		jsonMsg(c, withValidationNotice(I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), changed...), nil)
+		a.pushConfigToSlaves(changed)
+		return
+	}
+
 	needRestart, err := a.inboundService.UpdateInboundClient(inbound, clientId)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
 		return
 	}
-	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), nil)
+	jsonMsg(c, withValidationNotice(I18nWeb(c, "pages.inbounds.toasts.inboundClientUpdateSuccess"), inbound.SlaveId), nil)
 	if needRestart {
 		a.xrayService.SetToNeedRestart()
 	}
@@ -667,6 +800,17 @@ func (a *InboundController) delInboundClientByEmail(c *gin.Context) {
 	}
 
 	email := c.Param("email")
+	if replica := a.getReplica(inboundId); replica != nil {
+		changed, err := a.replicatedService.DelReplicaClientByEmail(replica, email)
+		if err != nil {
+			jsonMsg(c, "Failed to delete client by email", err)
+			return
+		}
+		jsonMsg(c, "Client deleted successfully", nil)
+		a.pushConfigToSlaves(changed)
+		return
+	}
+
 	needRestart, err := a.inboundService.DelInboundClientByEmail(inboundId, email)
 	if err != nil {
 		jsonMsg(c, "Failed to delete client by email", err)
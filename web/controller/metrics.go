@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsController serves the cluster metrics to Prometheus.
+type MetricsController struct {
+	metricsService service.MetricsService
+	settingService service.SettingService
+}
+
+// NewMetricsController creates a new MetricsController and initializes its routes.
+func NewMetricsController(g *gin.RouterGroup) *MetricsController {
+	a := &MetricsController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter sets up the metrics route.
+func (a *MetricsController) initRouter(g *gin.RouterGroup) {
+	g.GET("/metrics", a.metrics)
+}
+
+// metrics writes the metrics of the slaves, inbounds, outbounds, clients and accounts.
+// @Summary Prometheus metrics
+// @Description Cluster metrics in the Prometheus text format. Requires the metrics token from the security settings as a bearer token; the endpoint does not exist while no token is set.
+// @Tags Metrics
+// @Produce plain
+// @Param Authorization header string true "Bearer <metrics token>"
+// @Success 200 {string} string
+// @Router /metrics [get]
+func (a *MetricsController) metrics(c *gin.Context) {
+	token, err := a.settingService.GetMetricsToken()
+	if err != nil || token == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	// Only the header is accepted, a token in the URL would end up in access logs
+	given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	var body bytes.Buffer
+	if err := a.metricsService.WriteMetrics(&body); err != nil {
+		logger.Warning("Failed to collect metrics:", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", body.Bytes())
+}
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+	"github.com/mhsanaei/3x-ui/v2/web/websocket"
+)
+
+// ReplicatedInboundController handles replicated inbounds, which deploy one inbound on many slaves.
+type ReplicatedInboundController struct {
+	replicatedService service.ReplicatedInboundService
+	inboundService    service.InboundService
+	slaveService      service.SlaveService
+}
+
+// NewReplicatedInboundController creates a ReplicatedInboundController and sets up its routes.
+func NewReplicatedInboundController(g *gin.RouterGroup) *ReplicatedInboundController {
+	a := &ReplicatedInboundController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *ReplicatedInboundController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getReplicatedInbounds)
+	g.GET("/get/:id", a.getReplicatedInbound)
+
+	g.POST("/add", a.addReplicatedInbound)
+	g.POST("/update/:id", a.updateReplicatedInbound)
+	g.POST("/del/:id", a.delReplicatedInbound)
+	g.POST("/sync", a.syncReplicatedInbounds)
+}
+
+// getReplicatedInbounds lists the replicated inbounds.
+// @Summary List replicated inbounds
+// @Description Returns all replicated inbounds with their overrides and the slaves running a replica
+// @Tags ReplicatedInbounds
+// @Produce json
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/replicated/list [get]
+func (a *ReplicatedInboundController) getReplicatedInbounds(c *gin.Context) {
+	defs, err := a.replicatedService.GetReplicatedInbounds()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, defs, nil)
+}
+
+// getReplicatedInbound returns one replicated inbound.
+// @Summary Get replicated inbound
+// @Tags ReplicatedInbounds
+// @Produce json
+// @Param id path int true "Replicated inbound ID"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/replicated/get/{id} [get]
+func (a *ReplicatedInboundController) getReplicatedInbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	def, err := a.replicatedService.GetReplicatedInbound(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, def, nil)
+}
+
+// addReplicatedInbound creates a replicated inbound and deploys it to the slaves its selector matches.
+// @Summary Add replicated inbound
+// @Description Creates a replicated inbound; every slave matching the selector gets a replica
+// @Tags ReplicatedInbounds
+// @Accept json
+// @Produce json
+// @Param inbound body model.ReplicatedInbound true "Replicated inbound with selector and overrides"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/replicated/add [post]
+func (a *ReplicatedInboundController) addReplicatedInbound(c *gin.Context) {
+	def := &model.ReplicatedInbound{}
+	if err := c.ShouldBindJSON(def); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundCreateSuccess"), err)
+		return
+	}
+	def.Id = 0
+	def.UserId = session.GetLoginUser(c).Id
+
+	changed, err := a.replicatedService.SaveReplicatedInbound(def)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundCreateSuccess"), def, nil)
+	a.afterChange(c, changed)
+}
+
+// updateReplicatedInbound updates a replicated inbound and its replicas.
+// @Summary Update replicated inbound
+// @Description Updates a replicated inbound; replicas are created, updated or removed to match
+// @Tags ReplicatedInbounds
+// @Accept json
+// @Produce json
+// @Param id path int true "Replicated inbound ID"
+// @Param inbound body model.ReplicatedInbound true "Replicated inbound with selector and overrides"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/replicated/update/{id} [post]
+func (a *ReplicatedInboundController) updateReplicatedInbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		return
+	}
+	def, err := a.replicatedService.GetReplicatedInbound(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		return
+	}
+	userId := def.UserId
+	def.Overrides = nil
+	if err := c.ShouldBindJSON(def); err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		return
+	}
+	def.Id = id
+	def.UserId = userId
+
+	changed, err := a.replicatedService.SaveReplicatedInbound(def)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), def, nil)
+	a.afterChange(c, changed)
+}
+
+// delReplicatedInbound deletes a replicated inbound and all its replicas.
+// @Summary Delete replicated inbound
+// @Tags ReplicatedInbounds
+// @Produce json
+// @Param id path int true "Replicated inbound ID"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/replicated/del/{id} [post]
+func (a *ReplicatedInboundController) delReplicatedInbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundDeleteSuccess"), err)
+		return
+	}
+	changed, err := a.replicatedService.DelReplicatedInbound(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundDeleteSuccess"), id, nil)
+	a.afterChange(c, changed)
+}
+
+// syncReplicatedInbounds renders all replicated inbounds again and pushes the slaves that changed.
+// @Summary Sync replicated inbounds
+// @Description Re-renders every replicated inbound against the current slave labels and groups
+// @Tags ReplicatedInbounds
+// @Produce json
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/replicated/sync [post]
+func (a *ReplicatedInboundController) syncReplicatedInbounds(c *gin.Context) {
+	changed, err := a.replicatedService.SyncAllReplicatedInbounds()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), changed, nil)
+	a.afterChange(c, changed)
+}
+
+// afterChange pushes the config of the slaves whose replicas changed and broadcasts the inbounds.
+func (a *ReplicatedInboundController) afterChange(c *gin.Context, changed []int) {
+	for _, result := range a.slaveService.PushConfigToSlaves(changed) {
+		if !result.Success {
+			logger.Warningf("Failed to push config to slave %d: %s", result.SlaveId, result.Error)
+		}
+	}
+	user := session.GetLoginUser(c)
+	inbounds, _ := a.inboundService.GetInbounds(user.Id)
+	websocket.BroadcastInbounds(inbounds)
+}
@@ -1,14 +1,18 @@
 package controller
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/web/session"
 	"github.com/mhsanaei/3x-ui/v2/logger"
@@ -29,6 +33,38 @@ func (s *SlaveController) initRouter(g *gin.RouterGroup) {
 	g.POST("/add", s.addSlave)
 	g.POST("/del/:id", s.delSlave)
 	g.GET("/install/:id", s.getInstallCommand)
+	g.POST("/rotateSecret/:id", s.rotateSecret)
+	g.GET("/status/:id", s.getSlaveStatus)
+	g.GET("/history/:id/:bucket", s.getSlaveHistory)
+	g.POST("/logs/:id", s.getSlaveLogs)
+	g.POST("/logs/tail/:id", s.tailSlaveLogs)
+	g.POST("/logs/stopTail/:id", s.stopSlaveLogTail)
+	g.POST("/updateXray", s.updateXray)
+	g.POST("/pushConfig", s.pushSlavesConfig)
+	g.POST("/restartXray", s.restartSlavesXray)
+	g.POST("/labels/:id", s.setSlaveLabels)
+	g.POST("/weight/:id", s.setSlaveWeight)
+	g.POST("/address/:id", s.setSlaveAddress)
+	g.GET("/groups", s.getSlaveGroups)
+	g.POST("/group/save", s.saveSlaveGroup)
+	g.POST("/group/del/:id", s.delSlaveGroup)
+	g.POST("/drain/:id", s.drainSlave)
+	g.POST("/undrain/:id", s.undrainSlave)
+	g.GET("/geofiles", s.getGeofiles)
+	g.POST("/geofile/upload", s.uploadGeofile)
+	g.POST("/geofile/import", s.importGeofiles)
+	g.POST("/geofile/del/:name", s.delGeofile)
+	g.POST("/geofile/push", s.pushGeofiles)
+	g.POST("/geofile/unassign", s.unassignGeofiles)
+	g.GET("/mtls", s.getMtls)
+	g.POST("/mtls", s.setMtls)
+	g.GET("/driftRepush", s.getDriftRepush)
+	g.POST("/driftRepush", s.setDriftRepush)
+	g.GET("/legacySecret", s.getLegacySecret)
+	g.POST("/legacySecret", s.setLegacySecret)
+	g.GET("/enrollTokens", s.getEnrollTokens)
+	g.POST("/enrollToken", s.createEnrollToken)
+	g.POST("/enrollToken/del/:id", s.delEnrollToken)
 }
 
 // getSlaves retrieves all slave nodes with traffic info.
@@ -36,6 +72,7 @@ func (s *SlaveController) initRouter(g *gin.RouterGroup) {
 // @Description Returns all slave nodes with their system stats and traffic
 // @Tags Slaves
 // @Produce json
+// @Param selector query string false "Only slaves matching this selector, e.g. region=eu,tier!=free"
 // @Success 200 {object} entity.Msg
 // @Router /panel/api/slave/list [get]
 func (s *SlaveController) getSlaves(c *gin.Context) {
@@ -43,7 +80,7 @@ func (s *SlaveController) getSlaves(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
 		return
 	}
-    slaves, err := s.slaveService.GetAllSlavesWithTraffic()
+    slaves, err := s.slaveService.GetAllSlavesWithTraffic(c.Query("selector"))
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"success": false, "msg": err.Error()})
         return
@@ -149,6 +186,667 @@ func (s *SlaveController) getInstallCommand(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true, "obj": gin.H{"command": command}})
 }
 
+// rotateSecret replaces a slave's secret without reinstalling it.
+// @Summary Rotate slave secret
+// @Description Generates a new secret and hands it to the slave; the old one stays valid until the slave confirms
+// @Tags Slaves
+// @Produce json
+// @Param id path int true "Slave ID"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/rotateSecret/{id} [post]
+func (s *SlaveController) rotateSecret(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	if err := s.slaveService.RotateSecret(id); err != nil {
+		logger.Warningf("Failed to rotate secret of slave %d: %v", id, err)
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Secret rotation started"})
+}
+
+// getSlaveStatus asks a connected slave for its runtime state.
+// @Summary Get live slave status
+// @Description Queries the slave over its connection for Xray state, applied config revision and round-trip latency
+// @Tags Slaves
+// @Produce json
+// @Param id path int true "Slave ID"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/status/{id} [get]
+func (s *SlaveController) getSlaveStatus(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	status, err := s.slaveService.GetSlaveStatus(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": status})
+}
+
+// getSlaveHistory returns a slave's recent telemetry averaged over time buckets.
+// @Summary Get slave telemetry history
+// @Description Returns up to 60 points of CPU, memory, swap, disk, load, network rate, connection and online client figures, oldest first
+// @Tags Slaves
+// @Produce json
+// @Param id path int true "Slave ID"
+// @Param bucket path int true "Time bucket in seconds (5,30,60,120,180,300)"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/history/{id}/{bucket} [get]
+func (s *SlaveController) getSlaveHistory(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	bucket, _ := strconv.Atoi(c.Param("bucket"))
+	allowed := map[int]bool{5: true, 30: true, 60: true, 120: true, 180: true, 300: true}
+	if !allowed[bucket] {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "unsupported bucket"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": s.slaveService.GetSlaveHistory(id, bucket, 60)})
+}
+
+// getSlaveLogs fetches the latest lines of a slave's panel or Xray log.
+// @Summary Get slave logs
+// @Description Reads a log on the slave over its connection; level and keyword filtering happen on the slave
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param id path int true "Slave ID"
+// @Param request body logRequest true "Log and filters"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/logs/{id} [post]
+func (s *SlaveController) getSlaveLogs(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req logRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+	lines, err := s.slaveService.GetSlaveLogs(id, req.query())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": lines})
+}
+
+// tailSlaveLogs makes a slave stream new lines of a log to the UI.
+// @Summary Follow slave logs
+// @Description Starts following a log on the slave; matching lines arrive as slave_logs WebSocket messages carrying the returned tail id
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param id path int true "Slave ID"
+// @Param request body logRequest true "Log and filters; count is ignored"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/logs/tail/{id} [post]
+func (s *SlaveController) tailSlaveLogs(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req logRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+	tailId, err := s.slaveService.StartSlaveLogTail(id, req.query())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": tailId})
+}
+
+// stopSlaveLogTail stops streaming a slave's log.
+// @Summary Stop following slave logs
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param id path int true "Slave ID"
+// @Param request body stopTailRequest true "Tail ID returned when following started"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/logs/stopTail/{id} [post]
+func (s *SlaveController) stopSlaveLogTail(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req stopTailRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+	if err := s.slaveService.StopSlaveLogTail(id, req.TailId); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// xrayUpdateRequest installs an Xray-core release on the selected slaves.
+type xrayUpdateRequest struct {
+	slaveSelection
+	Version string `json:"version" form:"version"` // Xray release, e.g. v25.1.30
+}
+
+// updateXray installs an Xray-core release on the selected slaves.
+// @Summary Update slave Xray core
+// @Description Installs the given Xray-core release on the slaves; a slave keeps its previous core if the new one fails to start
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param request body xrayUpdateRequest true "Slaves and Xray release"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/updateXray [post]
+func (s *SlaveController) updateXray(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	var req xrayUpdateRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+	ids, err := req.slaves(&s.slaveService)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "no slaves selected"})
+		return
+	}
+
+	results, err := s.slaveService.UpdateSlavesXray(ids, req.Version)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": results})
+}
+
+// getGeofiles lists the geofiles kept for slaves and the slaves they are assigned to.
+// @Summary List geofiles
+// @Tags Slaves
+// @Produce json
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/geofiles [get]
+func (s *SlaveController) getGeofiles(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	geofiles, err := s.slaveService.GetGeofiles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": geofiles})
+}
+
+// uploadGeofile stores an uploaded .dat file as the next version of the geofile with its name.
+// @Summary Upload geofile
+// @Tags Slaves
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "Geodata file (.dat)"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/geofile/upload [post]
+func (s *SlaveController) uploadGeofile(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	geofile, err := s.slaveService.SaveGeofile(header.Filename, file)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": fmt.Sprintf("%s version %d", geofile.Name, geofile.Version), "obj": geofile})
+}
+
+// importGeofiles stores the master's own geofiles for distribution.
+// @Summary Import master geofiles
+// @Tags Slaves
+// @Produce json
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/geofile/import [post]
+func (s *SlaveController) importGeofiles(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	geofiles, err := s.slaveService.ImportMasterGeofiles()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": fmt.Sprintf("Imported %d geofiles", len(geofiles)), "obj": geofiles})
+}
+
+// delGeofile stops distributing a geofile.
+// @Summary Delete geofile
+// @Tags Slaves
+// @Produce json
+// @Param name path string true "Geofile name"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/geofile/del/{name} [post]
+func (s *SlaveController) delGeofile(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	if err := s.slaveService.DeleteGeofile(c.Param("name")); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Geofile deleted"})
+}
+
+// geofilesRequest names geofiles to assign to or take from the selected slaves.
+type geofilesRequest struct {
+	slaveSelection
+	Names string `json:"names" form:"names"` // comma-separated geofile names
+}
+
+// pushGeofiles assigns geofiles to slaves and syncs the connected ones.
+// @Summary Push geofiles to slaves
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param request body geofilesRequest true "Slaves and geofile names"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/geofile/push [post]
+func (s *SlaveController) pushGeofiles(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	var req geofilesRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+	ids, err := req.slaves(&s.slaveService)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	names := parseNameList(req.Names)
+	if len(ids) == 0 || len(names) == 0 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "select slaves and geofiles"})
+		return
+	}
+	results, err := s.slaveService.PushGeofiles(ids, names)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": results})
+}
+
+// unassignGeofiles stops keeping geofiles up to date on slaves; installed copies stay in place.
+// @Summary Unassign geofiles from slaves
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param request body geofilesRequest true "Slaves and geofile names"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/geofile/unassign [post]
+func (s *SlaveController) unassignGeofiles(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	var req geofilesRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+	ids, err := req.slaves(&s.slaveService)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	names := parseNameList(req.Names)
+	if err := s.slaveService.UnassignGeofiles(ids, names); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Saved"})
+}
+
+// downloadGeofile serves a geofile to a slave, which authenticates as on the connect request.
+// @Summary Download geofile (slave)
+// @Tags Slaves
+// @Param X-Slave-Secret header string true "Slave secret key"
+// @Param name path string true "Geofile name"
+// @Router /panel/api/slave/geofile/{name} [get]
+func (s *SlaveController) downloadGeofile(c *gin.Context) {
+	slave, err := s.slaveService.AuthenticateSlave(c.Request)
+	if err != nil {
+		logger.Warningf("Rejected geofile download from %s: %v", c.ClientIP(), err)
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	path, err := s.slaveService.GetGeofilePath(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "msg": "geofile not found"})
+		return
+	}
+	logger.Debugf("Slave %d downloads geofile %s", slave.Id, c.Param("name"))
+	c.File(path)
+}
+
+// getMtls reports whether slaves must present a client certificate.
+// @Summary Get slave mTLS mode
+// @Tags Slaves
+// @Produce json
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/mtls [get]
+func (s *SlaveController) getMtls(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	settingService := service.SettingService{}
+	required, err := settingService.GetSlaveMtlsRequired()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": gin.H{"required": required}})
+}
+
+// mtlsRequest turns mandatory client certificates for slave connections on or off.
+type mtlsRequest struct {
+	Required bool `json:"required" form:"required"`
+}
+
+// setMtls turns mandatory client certificates for slave connections on or off.
+// @Summary Set slave mTLS mode
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param request body mtlsRequest true "Require client certificates"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/mtls [post]
+func (s *SlaveController) setMtls(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	var req mtlsRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+
+	if err := s.slaveService.SetSlaveMtlsRequired(req.Required); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	logger.Infof("Slave client certificates required: %v", req.Required)
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Saved"})
+}
+
+// getDriftRepush reports whether drifted slaves get their config pushed again automatically.
+// @Summary Get drift re-push mode
+// @Tags Slaves
+// @Produce json
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/driftRepush [get]
+func (s *SlaveController) getDriftRepush(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	settingService := service.SettingService{}
+	enabled, err := settingService.GetSlaveDriftRepush()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": gin.H{"enabled": enabled}})
+}
+
+// driftRepushRequest turns the automatic config push to drifted slaves on or off.
+type driftRepushRequest struct {
+	Enabled bool `json:"enabled" form:"enabled"`
+}
+
+// setDriftRepush turns the automatic config push to drifted slaves on or off.
+// @Summary Set drift re-push mode
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param request body driftRepushRequest true "Push the config again when a slave drifts"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/driftRepush [post]
+func (s *SlaveController) setDriftRepush(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	var req driftRepushRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+
+	settingService := service.SettingService{}
+	if err := settingService.SetSlaveDriftRepush(req.Enabled); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	logger.Infof("Config re-push to drifted slaves: %v", req.Enabled)
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Saved"})
+}
+
+// getLegacySecret reports whether slaves may send their secret in the connect URL.
+// @Summary Get legacy slave secret mode
+// @Tags Slaves
+// @Produce json
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/legacySecret [get]
+func (s *SlaveController) getLegacySecret(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	settingService := service.SettingService{}
+	enabled, err := settingService.GetSlaveLegacySecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": gin.H{"enabled": enabled}})
+}
+
+// legacySecretRequest allows or forbids slave secrets in the connect URL.
+type legacySecretRequest struct {
+	Enabled bool `json:"enabled" form:"enabled"`
+}
+
+// setLegacySecret allows or forbids slave secrets in the connect URL, as sent by slaves predating
+// the secret header.
+// @Summary Set legacy slave secret mode
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param request body legacySecretRequest true "Accept the secret in the connect URL"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/legacySecret [post]
+func (s *SlaveController) setLegacySecret(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	var req legacySecretRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+
+	settingService := service.SettingService{}
+	if err := settingService.SetSlaveLegacySecret(req.Enabled); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	logger.Infof("Slave secrets in the connect URL accepted: %v", req.Enabled)
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Saved"})
+}
+
+// getEnrollTokens lists enrollment tokens and the enrollments they recorded.
+// @Summary List enrollment tokens
+// @Tags Slaves
+// @Produce json
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/enrollTokens [get]
+func (s *SlaveController) getEnrollTokens(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	tokens, err := s.slaveService.GetEnrollTokens()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": tokens})
+}
+
+// enrollTokenRequest presets the slave an enrollment token creates.
+type enrollTokenRequest struct {
+	Name            string `json:"name" form:"name"`                       // preset slave name
+	TemplateSlaveId int    `json:"templateSlaveId" form:"templateSlaveId"` // slave whose settings the new slave starts with
+	GroupId         int    `json:"groupId" form:"groupId"`                 // group the new slave joins
+	ValidHours      int    `json:"validHours" form:"validHours"`           // token validity in hours (default 1, max 168)
+}
+
+// createEnrollToken creates a single-use token for joining a new slave and returns its install command.
+// @Summary Create enrollment token
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param request body enrollTokenRequest true "Preset name, template and group of the new slave"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/enrollToken [post]
+func (s *SlaveController) createEnrollToken(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	var req enrollTokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+
+	basePath := "/"
+	if bp, exists := c.Get("base_path"); exists {
+		basePath = bp.(string)
+	}
+
+	command, token, err := s.slaveService.GenerateEnrollCommand(req.Name, req.TemplateSlaveId, req.GroupId, time.Duration(req.ValidHours)*time.Hour, c.Request, basePath)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": gin.H{"command": command, "expiresAt": token.ExpiresAt}})
+}
+
+// delEnrollToken revokes an unused enrollment token.
+// @Summary Revoke enrollment token
+// @Tags Slaves
+// @Produce json
+// @Param id path int true "Token ID"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/enrollToken/del/{id} [post]
+func (s *SlaveController) delEnrollToken(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := s.slaveService.RevokeEnrollToken(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Token revoked"})
+}
+
+// enrollRequest is sent by a node joining the cluster with an enrollment token.
+type enrollRequest struct {
+	Token    string `json:"token"`
+	Hostname string `json:"hostname"`
+}
+
+// enrollSlave redeems an enrollment token and returns the node's permanent secret.
+// @Summary Enroll slave
+// @Description Redeems a single-use enrollment token; called by the slave installer without a session
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/enroll [post]
+func (s *SlaveController) enrollSlave(c *gin.Context) {
+	var req enrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+
+	slave, err := s.slaveService.EnrollSlave(req.Token, c.ClientIP(), req.Hostname)
+	if err != nil {
+		logger.Warningf("Rejected enrollment from %s: %v", c.ClientIP(), err)
+		if errors.Is(err, service.ErrInvalidEnrollToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "msg": "enrollment failed"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": gin.H{"id": slave.Id, "name": slave.Name, "secret": slave.Secret}})
+}
+
+// slaveHandshakeTimeout bounds how long the initial config push waits for a slave's hello.
+const slaveHandshakeTimeout = 3 * time.Second
+
 var slaveUpgrader = websocket.Upgrader{
     CheckOrigin: func(r *http.Request) bool { return true },
 }
@@ -157,13 +855,13 @@ var slaveUpgrader = websocket.Upgrader{
 // @Summary Connect slave (WebSocket)
 // @Description WebSocket endpoint for slave-to-master communication
 // @Tags Slaves
-// @Param secret query string true "Slave secret key"
+// @Param X-Slave-Secret header string true "Slave secret key"
 // @Router /panel/api/slave/connect [get]
 func (s *SlaveController) connectSlave(c *gin.Context) {
-    secret := c.Query("secret")
-    slave, err := s.slaveService.GetSlaveBySecret(secret)
+    slave, err := s.slaveService.AuthenticateSlave(c.Request)
     if err != nil {
-         c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "Invalid secret"})
+         logger.Warningf("Rejected slave connection from %s: %v", c.ClientIP(), err)
+         c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
          return
     }
     
@@ -172,38 +870,122 @@ func (s *SlaveController) connectSlave(c *gin.Context) {
         return
     }
     
-    s.slaveService.AddSlaveConn(slave.Id, ws)
+    conn := protocol.NewConn(ws)
+    s.slaveService.AddSlaveConn(slave.Id, conn)
+    if err := s.slaveService.SetSlaveObservedAddress(slave.Id, c.ClientIP()); err != nil {
+        logger.Warningf("Failed to store the address of slave %d: %v", slave.Id, err)
+    }
     
-    // Initial Config Push
-    s.slaveService.PushConfig(slave.Id)
+    // Initial Config Push, once we know which protocol the slave speaks.
+    // Current slaves open with a hello; legacy slaves never do, so their
+    // first regular message (or the timeout) settles it instead.
+    var handshakeOnce sync.Once
+    handshakeDone := make(chan struct{})
+    finishHandshake := func() { handshakeOnce.Do(func() { close(handshakeDone) }) }
+    go func() {
+        select {
+        case <-handshakeDone:
+        case <-time.After(slaveHandshakeTimeout):
+        }
+        s.slaveService.PushConfig(slave.Id)
+    }()
 
     for {
-        _, msg, err := ws.ReadMessage()
+        env, raw, err := conn.Read()
         if err != nil {
-            break
+            if raw == nil {
+                break
+            }
+            logger.Warningf("Rejected malformed message from slave %d: %v", slave.Id, err)
+            continue
         }
         
-        // Try to parse message as JSON
-        var msgData map[string]interface{}
-        if err := json.Unmarshal(msg, &msgData); err == nil {
-            // Check message type
-            if msgType, ok := msgData["type"].(string); ok {
-                switch msgType {
-                case "traffic_stats":
-                    s.slaveService.ProcessTrafficStats(slave.Id, msgData)
-                    continue
-                case "cert_report":
-                    s.slaveService.ProcessCertReport(slave.Id, msgData)
-                    continue
-                }
+        if env.Type == protocol.TypeHello {
+            if err := s.slaveService.HandleHello(slave.Id, conn, env, c.Request.TLS); err != nil {
+                logger.Errorf("Handshake with slave %d failed: %v", slave.Id, err)
+                break
             }
+            finishHandshake()
+            continue
         }
+        finishHandshake()
         
-        // Otherwise treat as system stats
-        s.slaveService.UpdateSlaveStatus(slave.Id, "online", string(msg))
-        logger.Debug("Received from slave %d: %s", slave.Id, string(msg))
+        if err := s.slaveService.HandleMessage(slave.Id, conn, env); err != nil {
+            logger.Warningf("Failed to handle %s message from slave %d: %v", env.Type, slave.Id, err)
+        }
     }
+    finishHandshake()
     
-    s.slaveService.RemoveSlaveConn(slave.Id)
-    s.slaveService.UpdateSlaveStatus(slave.Id, "offline", "")
+    if s.slaveService.ReleaseSlaveConn(slave.Id, conn) {
+        s.slaveService.UpdateSlaveStatus(slave.Id, "offline", nil)
+    }
+}
+
+// parseIdList parses a comma-separated list of IDs, skipping anything that is not a number.
+func parseIdList(value string) []int {
+	var ids []int
+	for _, part := range strings.Split(value, ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// slaveSelection is the target of a bulk slave operation: the slaves matching Selector, or the
+// comma-separated Ids when no selector is given.
+type slaveSelection struct {
+	Ids      string `json:"ids" form:"ids"`           // comma-separated slave IDs
+	Selector string `json:"selector" form:"selector"` // slave selector used instead of ids, e.g. region=eu,group=edge
+}
+
+// slaves returns the IDs of the selected slaves.
+func (sel slaveSelection) slaves(slaveService *service.SlaveService) ([]int, error) {
+	if selector := strings.TrimSpace(sel.Selector); selector != "" {
+		return slaveService.SelectSlaves(selector)
+	}
+	return parseIdList(sel.Ids), nil
+}
+
+// selectedSlaves returns the slaves a request whose body only holds a slaveSelection targets.
+func selectedSlaves(c *gin.Context, slaveService *service.SlaveService) ([]int, error) {
+	var sel slaveSelection
+	if err := c.ShouldBind(&sel); err != nil {
+		return nil, err
+	}
+	return sel.slaves(slaveService)
+}
+
+// parseNameList parses a comma-separated list of names, skipping empty entries.
+func parseNameList(value string) []string {
+	var names []string
+	for _, part := range strings.Split(value, ",") {
+		if name := strings.TrimSpace(part); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// logRequest selects a slave log and filters its lines.
+type logRequest struct {
+	Source  string `json:"source" form:"source"`   // panel, xray_access or xray_error
+	Level   string `json:"level" form:"level"`     // lowest severity to include, e.g. warning
+	Keyword string `json:"keyword" form:"keyword"` // only lines containing this text
+	Count   int    `json:"count" form:"count"`     // number of lines
+}
+
+// query converts the request to the query sent to the slave.
+func (r logRequest) query() protocol.LogQuery {
+	return protocol.LogQuery{
+		Source:  r.Source,
+		Level:   r.Level,
+		Keyword: r.Keyword,
+		Count:   r.Count,
+	}
+}
+
+// stopTailRequest names the log tail to stop.
+type stopTailRequest struct {
+	TailId string `json:"tailId" form:"tailId"`
 }
@@ -1,20 +1,24 @@
 package controller
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
 	"github.com/mhsanaei/3x-ui/v2/web/service"
 	"github.com/mhsanaei/3x-ui/v2/web/session"
 )
 
 type SlaveCertController struct {
-	certService service.SlaveCertService
+	certService  service.SlaveCertService
+	slaveService service.SlaveService
 }
 
-func NewSlaveCertController(g *gin.RouterGroup) *SlaveCertController {
-	c := &SlaveCertController{}
+func NewSlaveCertController(g *gin.RouterGroup, slaveService service.SlaveService) *SlaveCertController {
+	c := &SlaveCertController{slaveService: slaveService}
 	c.initRouter(g)
 	return c
 }
@@ -23,6 +27,13 @@ func (c *SlaveCertController) initRouter(g *gin.RouterGroup) {
 	g.GET("/list", c.getAllCerts)
 	g.GET("/slave/:slaveId", c.getCertsForSlave)
 	g.POST("/del/:id", c.deleteCert)
+	g.POST("/issue", c.issueCert)
+	g.POST("/renew/:id", c.renewCert)
+	g.GET("/store/list", c.getStoredCerts)
+	g.POST("/store/save", c.saveStoredCert)
+	g.POST("/store/del/:id", c.delStoredCert)
+	g.POST("/store/push", c.pushStoredCerts)
+	g.POST("/store/unassign", c.unassignStoredCerts)
 }
 
 // getAllCerts retrieves all slave certificates.
@@ -103,3 +114,227 @@ func (c *SlaveCertController) deleteCert(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, gin.H{"success": true, "msg": "Certificate deleted"})
 }
+
+// certIssueRequest has a slave obtain a certificate through ACME.
+type certIssueRequest struct {
+	SlaveId      int    `json:"slaveId" form:"slaveId"`
+	Domain       string `json:"domain" form:"domain"`
+	Email        string `json:"email" form:"email"`               // contact email of the ACME account
+	DirectoryUrl string `json:"directoryUrl" form:"directoryUrl"` // ACME directory URL, Let's Encrypt if empty
+	Challenge    string `json:"challenge" form:"challenge"`       // http-01 or tls-alpn-01
+	Port         int    `json:"port" form:"port"`                 // port the challenge is answered on, 80 or 443 if empty
+}
+
+// issueCert has a slave obtain a certificate through ACME.
+// @Summary Issue certificate on slave
+// @Description The slave runs the ACME order and answers the challenge itself; the certificate is renewed before it expires
+// @Tags SlaveCerts
+// @Accept json
+// @Produce json
+// @Param request body certIssueRequest true "Slave, domain and ACME challenge"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave-certs/issue [post]
+func (c *SlaveCertController) issueCert(ctx *gin.Context) {
+	if !session.IsLogin(ctx) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+
+	var form certIssueRequest
+	if err := ctx.ShouldBind(&form); err != nil || form.SlaveId <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid slave ID"})
+		return
+	}
+	req := protocol.CertIssue{
+		Domain:       strings.TrimSpace(form.Domain),
+		Email:        strings.TrimSpace(form.Email),
+		DirectoryUrl: strings.TrimSpace(form.DirectoryUrl),
+		Challenge:    form.Challenge,
+		Port:         form.Port,
+	}
+
+	cert, err := c.slaveService.ObtainAcmeCert(form.SlaveId, req)
+	if err != nil {
+		ctx.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"success": true, "msg": "Certificate issued", "obj": cert})
+}
+
+// renewCert renews an ACME certificate right away.
+// @Summary Renew certificate
+// @Description Renews a certificate obtained through ACME with the parameters it was issued with
+// @Tags SlaveCerts
+// @Produce json
+// @Param id path int true "Certificate ID"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave-certs/renew/{id} [post]
+func (c *SlaveCertController) renewCert(ctx *gin.Context) {
+	if !session.IsLogin(ctx) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid cert ID"})
+		return
+	}
+
+	cert, err := c.slaveService.RenewAcmeCert(id)
+	if err != nil {
+		ctx.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"success": true, "msg": "Certificate renewed", "obj": cert})
+}
+
+// getStoredCerts lists the certificates kept on the master and the slaves they are assigned to.
+// @Summary List stored certificates
+// @Tags SlaveCerts
+// @Produce json
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave-certs/store/list [get]
+func (c *SlaveCertController) getStoredCerts(ctx *gin.Context) {
+	if !session.IsLogin(ctx) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	certs, err := c.slaveService.GetStoredCerts()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"success": true, "obj": certs})
+}
+
+// storedCertRequest is a certificate to keep on the master.
+type storedCertRequest struct {
+	Name string `json:"name" form:"name"` // directory name on the slaves, the certificate's first domain if empty
+	Cert string `json:"cert" form:"cert"` // PEM certificate chain
+	Key  string `json:"key" form:"key"`   // PEM private key
+}
+
+// saveStoredCert stores a certificate and key on the master, replacing the one with the same name.
+// @Summary Store certificate
+// @Description The private key is kept encrypted; slaves the certificate is assigned to receive a changed one right away
+// @Tags SlaveCerts
+// @Accept json
+// @Produce json
+// @Param request body storedCertRequest true "Certificate and key"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave-certs/store/save [post]
+func (c *SlaveCertController) saveStoredCert(ctx *gin.Context) {
+	if !session.IsLogin(ctx) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	var req storedCertRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+	cert, err := c.slaveService.SaveStoredCert(strings.TrimSpace(req.Name), req.Cert, req.Key)
+	if err != nil {
+		ctx.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"success": true, "msg": fmt.Sprintf("%s version %d", cert.Name, cert.Version), "obj": cert})
+}
+
+// delStoredCert stops distributing a certificate; installed copies stay in place.
+// @Summary Delete stored certificate
+// @Tags SlaveCerts
+// @Produce json
+// @Param id path int true "Stored certificate ID"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave-certs/store/del/{id} [post]
+func (c *SlaveCertController) delStoredCert(ctx *gin.Context) {
+	if !session.IsLogin(ctx) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid cert ID"})
+		return
+	}
+	if err := c.slaveService.DeleteStoredCert(id); err != nil {
+		ctx.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"success": true, "msg": "Certificate deleted"})
+}
+
+// storedCertsRequest names stored certificates to assign to or take from the selected slaves.
+type storedCertsRequest struct {
+	slaveSelection
+	CertIds string `json:"certIds" form:"certIds"` // comma-separated stored certificate IDs
+}
+
+// pushStoredCerts assigns stored certificates to slaves and installs them on the connected ones.
+// @Summary Push stored certificates to slaves
+// @Tags SlaveCerts
+// @Accept json
+// @Produce json
+// @Param request body storedCertsRequest true "Slaves and stored certificate IDs"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave-certs/store/push [post]
+func (c *SlaveCertController) pushStoredCerts(ctx *gin.Context) {
+	if !session.IsLogin(ctx) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	var req storedCertsRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+	ids, err := req.slaves(&c.slaveService)
+	if err != nil {
+		ctx.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	certIds := parseIdList(req.CertIds)
+	if len(ids) == 0 || len(certIds) == 0 {
+		ctx.JSON(http.StatusOK, gin.H{"success": false, "msg": "select slaves and certificates"})
+		return
+	}
+	results, err := c.slaveService.PushStoredCerts(ids, certIds)
+	if err != nil {
+		ctx.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"success": true, "obj": results})
+}
+
+// unassignStoredCerts stops keeping stored certificates up to date on slaves; installed copies stay in place.
+// @Summary Unassign stored certificates from slaves
+// @Tags SlaveCerts
+// @Accept json
+// @Produce json
+// @Param request body storedCertsRequest true "Slaves and stored certificate IDs"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave-certs/store/unassign [post]
+func (c *SlaveCertController) unassignStoredCerts(ctx *gin.Context) {
+	if !session.IsLogin(ctx) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	var req storedCertsRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+	ids, err := req.slaves(&c.slaveService)
+	if err != nil {
+		ctx.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	certIds := parseIdList(req.CertIds)
+	if err := c.slaveService.UnassignStoredCerts(ids, certIds); err != nil {
+		ctx.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"success": true, "msg": "Saved"})
+}
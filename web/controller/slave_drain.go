@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+)
+
+// drainRequest configures the maintenance of a slave.
+type drainRequest struct {
+	StopXray bool `json:"stopXray" form:"stopXray"` // shut Xray down after the grace period
+	Grace    int  `json:"grace" form:"grace"`       // grace period in minutes before Xray is shut down
+}
+
+// drainSlave puts a slave into maintenance.
+// @Summary Start slave maintenance
+// @Description Takes the slave out of subscriptions and account placement and mutes its alerts; optionally stops Xray after a grace period
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param id path int true "Slave ID"
+// @Param request body drainRequest false "Whether and when to stop Xray"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/drain/{id} [post]
+func (s *SlaveController) drainSlave(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req drainRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+	if req.Grace < 0 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "grace period must not be negative"})
+		return
+	}
+	if err := s.slaveService.StartSlaveDrain(id, req.StopXray, time.Duration(req.Grace)*time.Minute); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Maintenance started"})
+}
+
+// undrainSlave ends the maintenance of a slave.
+// @Summary End slave maintenance
+// @Description Puts the slave back into rotation and pushes its config, which starts Xray again if it was stopped
+// @Tags Slaves
+// @Produce json
+// @Param id path int true "Slave ID"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/undrain/{id} [post]
+func (s *SlaveController) undrainSlave(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := s.slaveService.EndSlaveDrain(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Maintenance ended"})
+}
@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/web/session"
+)
+
+// slaveLabelsRequest replaces the labels of a slave.
+type slaveLabelsRequest struct {
+	Labels string `json:"labels" form:"labels"` // JSON object of label names to values, e.g. {"region":"eu"}
+}
+
+// setSlaveLabels replaces the labels of a slave.
+// @Summary Set slave labels
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param id path int true "Slave ID"
+// @Param request body slaveLabelsRequest true "Labels of the slave"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/labels/{id} [post]
+func (s *SlaveController) setSlaveLabels(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req slaveLabelsRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+	labels := map[string]string{}
+	if req.Labels != "" {
+		if err := json.Unmarshal([]byte(req.Labels), &labels); err != nil {
+			c.JSON(http.StatusOK, gin.H{"success": false, "msg": "labels must be a JSON object of strings"})
+			return
+		}
+	}
+	if err := s.slaveService.SetSlaveLabels(id, labels); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Saved"})
+}
+
+// slaveWeightRequest sets the weight of a slave.
+type slaveWeightRequest struct {
+	Weight int `json:"weight" form:"weight"` // relative to other slaves, 100 is the default
+}
+
+// setSlaveWeight sets the share of subscription users a slave takes when links are ordered by load.
+// @Summary Set slave weight
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param id path int true "Slave ID"
+// @Param request body slaveWeightRequest true "Weight of the slave"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/weight/{id} [post]
+func (s *SlaveController) setSlaveWeight(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req slaveWeightRequest
+	if err := c.ShouldBind(&req); err != nil || req.Weight <= 0 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "weight must be a positive number"})
+		return
+	}
+	if err := s.slaveService.SetSlaveWeight(id, req.Weight); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Saved"})
+}
+
+// slaveAddressRequest sets where the address of a slave comes from.
+type slaveAddressRequest struct {
+	Source  string `json:"source" form:"source"`   // auto, observed or manual
+	Address string `json:"address" form:"address"` // address for the manual source
+}
+
+// setSlaveAddress sets where the address of a slave, which subscription links point at, comes from.
+// @Summary Set slave address
+// @Description auto takes the address the slave reports and falls back to the one it connects from, observed always takes the latter, manual takes the given address
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param id path int true "Slave ID"
+// @Param request body slaveAddressRequest true "Address source"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/address/{id} [post]
+func (s *SlaveController) setSlaveAddress(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req slaveAddressRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+	if err := s.slaveService.SetSlaveAddress(id, req.Source, req.Address); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Saved"})
+}
+
+// getSlaveGroups lists the slave groups and their members.
+// @Summary List slave groups
+// @Tags Slaves
+// @Produce json
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/groups [get]
+func (s *SlaveController) getSlaveGroups(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	groups, err := s.slaveService.GetSlaveGroups()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": groups})
+}
+
+// slaveGroupRequest creates or updates a slave group and sets its members.
+type slaveGroupRequest struct {
+	Id          int    `json:"id" form:"id"` // 0 creates a group
+	Name        string `json:"name" form:"name"`
+	Description string `json:"description" form:"description"`
+	Ids         string `json:"ids" form:"ids"` // comma-separated slave IDs of the members
+}
+
+// saveSlaveGroup creates or updates a slave group and sets its members.
+// @Summary Save slave group
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param request body slaveGroupRequest true "Group and its members"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/group/save [post]
+func (s *SlaveController) saveSlaveGroup(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	var req slaveGroupRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "msg": "invalid request"})
+		return
+	}
+	group := &model.SlaveGroup{
+		Id:          req.Id,
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	if err := s.slaveService.SaveSlaveGroup(group, parseIdList(req.Ids)); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Saved", "obj": group})
+}
+
+// delSlaveGroup deletes a slave group; its slaves are kept.
+// @Summary Delete slave group
+// @Tags Slaves
+// @Produce json
+// @Param id path int true "Group ID"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/group/del/{id} [post]
+func (s *SlaveController) delSlaveGroup(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := s.slaveService.DeleteSlaveGroup(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "Group deleted"})
+}
+
+// pushSlavesConfig rebuilds and pushes the config of the selected slaves.
+// @Summary Push config to slaves
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param request body slaveSelection true "Slaves by ID or selector"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/pushConfig [post]
+func (s *SlaveController) pushSlavesConfig(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	ids, err := selectedSlaves(c, &s.slaveService)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "no slaves selected"})
+		return
+	}
+	results := s.slaveService.PushConfigToSlaves(ids)
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": s.slaveService.SlaveValidationNotice(ids...), "obj": results})
+}
+
+// restartSlavesXray restarts Xray on the selected slaves.
+// @Summary Restart Xray on slaves
+// @Tags Slaves
+// @Accept json
+// @Produce json
+// @Param request body slaveSelection true "Slaves by ID or selector"
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/slave/restartXray [post]
+func (s *SlaveController) restartSlavesXray(c *gin.Context) {
+	if !session.IsLogin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "unauthorized"})
+		return
+	}
+	ids, err := selectedSlaves(c, &s.slaveService)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "no slaves selected"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "obj": s.slaveService.RestartSlavesXray(ids)})
+}
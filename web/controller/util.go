@@ -8,6 +8,7 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/config"
 	"github.com/mhsanaei/3x-ui/v2/logger"
 	"github.com/mhsanaei/3x-ui/v2/web/entity"
+	"github.com/mhsanaei/3x-ui/v2/web/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -56,6 +57,16 @@ func jsonMsgObj(c *gin.Context, msg string, obj any, err error) {
 	c.JSON(http.StatusOK, m)
 }
 
+// withValidationNotice appends to a success message why the configs of the given slaves go out
+// without being checked by Xray on the master, so the admin is not left assuming they were.
+func withValidationNotice(msg string, slaveIds ...int) string {
+	slaveService := service.SlaveService{}
+	if notice := slaveService.SlaveValidationNotice(slaveIds...); notice != "" {
+		return msg + " (" + notice + ")"
+	}
+	return msg
+}
+
 // pureJsonMsg sends a pure JSON message response with custom status code.
 func pureJsonMsg(c *gin.Context, statusCode int, success bool, msg string) {
 	c.JSON(statusCode, entity.Msg{
@@ -88,10 +88,12 @@ func (a *OutboundController) addOutbound(c *gin.Context) {
 	delete(req, "slaveId")
 
 	err := a.outboundService.AddOutbound(slaveId, req)
-	if err == nil {
-		go a.pushConfigToSlave(slaveId)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "success"), err)
+		return
 	}
-	jsonMsg(c, I18nWeb(c, "success"), err)
+	go a.pushConfigToSlave(slaveId)
+	jsonMsg(c, withValidationNotice(I18nWeb(c, "success"), slaveId), nil)
 }
 
 // updateOutbound updates an existing outbound configuration.
@@ -133,7 +135,7 @@ func (a *OutboundController) updateOutbound(c *gin.Context) {
 	}
 
 	go a.pushConfigToSlave(slaveId)
-	jsonMsg(c, I18nWeb(c, "success"), nil)
+	jsonMsg(c, withValidationNotice(I18nWeb(c, "success"), slaveId), nil)
 }
 
 // deleteOutbound deletes an outbound configuration.
@@ -90,13 +90,14 @@ func (a *RoutingController) addRoutingRule(c *gin.Context) {
 	logger.Infof("RoutingController: calling AddRoutingRule for slave %d", slaveId)
 	err := a.routingService.AddRoutingRule(slaveId, req)
 	logger.Infof("RoutingController: AddRoutingRule returned, error: %v", err)
-	if err == nil {
-		logger.Infof("RoutingController: spawning pushConfigToSlave for slave %d", slaveId)
-		go a.pushConfigToSlave(slaveId)
-	} else {
+	if err != nil {
 		logger.Errorf("RoutingController: skipping pushConfigToSlave due to error: %v", err)
+		jsonMsg(c, I18nWeb(c, "success"), err)
+		return
 	}
-	jsonMsg(c, I18nWeb(c, "success"), err)
+	logger.Infof("RoutingController: spawning pushConfigToSlave for slave %d", slaveId)
+	go a.pushConfigToSlave(slaveId)
+	jsonMsg(c, withValidationNotice(I18nWeb(c, "success"), slaveId), nil)
 }
 
 // updateRoutingRule updates an existing routing rule.
@@ -138,7 +139,7 @@ func (a *RoutingController) updateRoutingRule(c *gin.Context) {
 	}
 
 	go a.pushConfigToSlave(slaveId)
-	jsonMsg(c, I18nWeb(c, "success"), nil)
+	jsonMsg(c, withValidationNotice(I18nWeb(c, "success"), slaveId), nil)
 }
 
 // deleteRoutingRule deletes a routing rule.
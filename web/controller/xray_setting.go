@@ -40,6 +40,7 @@ func (a *XraySettingController) initRouter(g *gin.RouterGroup) {
 	g.POST("/", a.getXraySetting)
 	g.POST("/warp/:action", a.warp)
 	g.POST("/update", a.updateSetting)
+	g.POST("/preview", a.previewSetting)
 	g.POST("/resetOutboundsTraffic", a.resetOutboundsTraffic)
 
 }
@@ -92,7 +93,7 @@ func (a *XraySettingController) getXraySetting(c *gin.Context) {
 
 // updateSetting updates the Xray configuration settings.
 // @Summary Update Xray settings
-// @Description Updates the Xray configuration for a specific slave
+// @Description Updates the Xray configuration for a specific slave, or for every slave matching "selector"
 // @Tags XraySettings
 // @Accept json
 // @Produce json
@@ -105,13 +106,28 @@ func (a *XraySettingController) updateSetting(c *gin.Context) {
 		return
 	}
 
-	// Extract slaveId from request body
-	slaveIdFloat, ok := req["slaveId"].(float64)
-	if !ok || int(slaveIdFloat) <= 0 {
-		jsonMsg(c, "请选择一个Slave节点", fmt.Errorf("slaveId is required"))
-		return
+	// Extract the target slaves from request body: a single slaveId or a selector
+	slaveService := service.SlaveService{}
+	var slaveIds []int
+	if selector, _ := req["selector"].(string); selector != "" {
+		ids, err := slaveService.SelectSlaves(selector)
+		if err != nil {
+			jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+			return
+		}
+		if len(ids) == 0 {
+			jsonMsg(c, "请选择一个Slave节点", fmt.Errorf("no slave matches selector %q", selector))
+			return
+		}
+		slaveIds = ids
+	} else {
+		slaveIdFloat, ok := req["slaveId"].(float64)
+		if !ok || int(slaveIdFloat) <= 0 {
+			jsonMsg(c, "请选择一个Slave节点", fmt.Errorf("slaveId is required"))
+			return
+		}
+		slaveIds = []int{int(slaveIdFloat)}
 	}
-	slaveId := int(slaveIdFloat)
 	
 	// Use SlaveSettingService to save per-slave configuration
 	xraySetting, ok := req["xraySetting"].(string)
@@ -125,17 +141,67 @@ func (a *XraySettingController) updateSetting(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
 		return
 	}
+
+	// Every slave's config generated from the template must pass xray -test before it is saved
+	for _, slaveId := range slaveIds {
+		if err := slaveService.CheckSlaveTemplate(slaveId, xraySetting); err != nil {
+			jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+			return
+		}
+	}
 	
-	err := a.SlaveSettingService.SaveXrayConfigForSlave(slaveId, xraySetting)
-	if err == nil {
-		go func() {
-			slaveService := service.SlaveService{}
-			if err := slaveService.PushConfig(slaveId); err != nil {
-				logger.Warningf("XraySettingController: failed to push config to slave %d: %v", slaveId, err)
+	err := a.SlaveSettingService.SaveXrayConfigForSlaves(slaveIds, xraySetting)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+		return
+	}
+	go func() {
+		for _, result := range slaveService.PushConfigToSlaves(slaveIds) {
+			if !result.Success {
+				logger.Warningf("XraySettingController: failed to push config to slave %d: %s", result.SlaveId, result.Error)
 			}
-		}()
+		}
+	}()
+	jsonMsg(c, withValidationNotice(I18nWeb(c, "pages.settings.toasts.modifySettings"), slaveIds...), nil)
+}
+
+// previewSetting returns the config a push would send to a slave and its diff against the config
+// the slave last applied, without saving or sending anything.
+// @Summary Preview slave config
+// @Description Generates the Xray config for a slave from its saved template, or from "xraySetting" if given, and diffs it against the slave's last applied config. Works while the slave is offline.
+// @Tags XraySettings
+// @Accept json
+// @Produce json
+// @Success 200 {object} entity.Msg
+// @Router /panel/api/xray/preview [post]
+func (a *XraySettingController) previewSetting(c *gin.Context) {
+	var req map[string]interface{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		jsonMsg(c, I18nWeb(c, "error"), err)
+		return
+	}
+	slaveIdFloat, ok := req["slaveId"].(float64)
+	if !ok || int(slaveIdFloat) <= 0 {
+		jsonMsg(c, "请选择一个Slave节点", fmt.Errorf("slaveId is required"))
+		return
+	}
+
+	// An unsaved template replaces the saved one in the preview
+	xraySetting, _ := req["xraySetting"].(string)
+	if xraySetting != "" {
+		if err := a.XraySettingService.CheckXrayConfig(xraySetting); err != nil {
+			jsonMsg(c, I18nWeb(c, "pages.xray.preview"), err)
+			return
+		}
+	}
+
+	slaveService := service.SlaveService{}
+	preview, err := slaveService.PreviewSlaveConfig(int(slaveIdFloat), xraySetting)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.preview"), err)
+		return
 	}
-	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+	jsonObj(c, preview, nil)
 }
 
 // getDefaultXrayConfig retrieves the default Xray configuration.
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"math"
 	"net"
+	"slices"
 	"strings"
 	"time"
 
@@ -52,6 +53,7 @@ type AllSetting struct {
 	TimeLocation    string `json:"timeLocation" form:"timeLocation"`       // Time zone location
 	TwoFactorEnable bool   `json:"twoFactorEnable" form:"twoFactorEnable"` // Enable two-factor authentication
 	TwoFactorToken  string `json:"twoFactorToken" form:"twoFactorToken"`   // Two-factor authentication token
+	MetricsToken    string `json:"metricsToken" form:"metricsToken"`       // Bearer token for the Prometheus endpoint (empty = disabled)
 
 	// Subscription server settings
 	SubEnable                   bool   `json:"subEnable" form:"subEnable"`                                     // Enable subscription server
@@ -80,6 +82,15 @@ type AllSetting struct {
 	SubJsonNoises               string `json:"subJsonNoises" form:"subJsonNoises"`                             // JSON subscription noise configuration
 	SubJsonMux                  string `json:"subJsonMux" form:"subJsonMux"`                                   // JSON subscription mux configuration
 	SubJsonRules                string `json:"subJsonRules" form:"subJsonRules"`
+	SubOfflineMode              string `json:"subOfflineMode" form:"subOfflineMode"`             // How link subscriptions show inbounds of offline slaves: show, hide, last or tag
+	SubJsonOfflineMode          string `json:"subJsonOfflineMode" form:"subJsonOfflineMode"`     // How JSON subscriptions show inbounds of offline slaves
+	SubOfflineGrace             int    `json:"subOfflineGrace" form:"subOfflineGrace"`           // Minutes a slave must be offline before subscriptions treat it as down
+	SubOfflineTag               string `json:"subOfflineTag" form:"subOfflineTag"`               // Remark prefix for inbounds of offline slaves in tag mode
+	SubOrder                    string `json:"subOrder" form:"subOrder"`                         // Order of subscription links: none keeps database order, load puts the least busy slaves first
+	SubLoadCpuWeight            int    `json:"subLoadCpuWeight" form:"subLoadCpuWeight"`         // Load score per CPU percent
+	SubLoadMemWeight            int    `json:"subLoadMemWeight" form:"subLoadMemWeight"`         // Load score per memory percent
+	SubLoadClientsWeight        int    `json:"subLoadClientsWeight" form:"subLoadClientsWeight"` // Load score per online client
+	SubLoadLatencyWeight        int    `json:"subLoadLatencyWeight" form:"subLoadLatencyWeight"` // Load score per 10 ms round trip from the master
 
 	// LDAP settings
 	LdapEnable     bool   `json:"ldapEnable" form:"ldapEnable"`
@@ -161,6 +172,26 @@ func (s *AllSetting) CheckValid() error {
 		s.SubPath += "/"
 	}
 
+	for _, mode := range []string{s.SubOfflineMode, s.SubJsonOfflineMode} {
+		if !slices.Contains([]string{"show", "hide", "last", "tag"}, mode) {
+			return common.NewError("Sub offline mode is not valid:", mode)
+		}
+	}
+
+	if s.SubOfflineGrace < 0 {
+		return common.NewError("Sub offline grace period is not valid:", s.SubOfflineGrace)
+	}
+
+	if s.SubOrder != "none" && s.SubOrder != "load" {
+		return common.NewError("Sub order is not valid:", s.SubOrder)
+	}
+
+	for _, weight := range []int{s.SubLoadCpuWeight, s.SubLoadMemWeight, s.SubLoadClientsWeight, s.SubLoadLatencyWeight} {
+		if weight < 0 {
+			return common.NewError("Sub load weight is not valid:", weight)
+		}
+	}
+
 	if !strings.HasPrefix(s.SubJsonPath, "/") {
 		s.SubJsonPath = "/" + s.SubJsonPath
 	}
@@ -0,0 +1,20 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// DrainSlavesJob shuts Xray down on slaves in maintenance once their grace period has passed.
+type DrainSlavesJob struct {
+	slaveService service.SlaveService
+}
+
+// NewDrainSlavesJob creates a new maintenance job instance.
+func NewDrainSlavesJob() *DrainSlavesJob {
+	return &DrainSlavesJob{}
+}
+
+// Run stops Xray on draining slaves that are due.
+func (j *DrainSlavesJob) Run() {
+	j.slaveService.StopDrainedSlavesXray()
+}
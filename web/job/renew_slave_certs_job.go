@@ -0,0 +1,20 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// RenewSlaveCertsJob renews the ACME certificates of slaves before they expire.
+type RenewSlaveCertsJob struct {
+	slaveService service.SlaveService
+}
+
+// NewRenewSlaveCertsJob creates a new certificate renewal job instance.
+func NewRenewSlaveCertsJob() *RenewSlaveCertsJob {
+	return &RenewSlaveCertsJob{}
+}
+
+// Run asks connected slaves to renew their ACME certificates that are close to expiry.
+func (j *RenewSlaveCertsJob) Run() {
+	j.slaveService.RenewExpiringAcmeCerts()
+}
@@ -0,0 +1,20 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// SlaveDriftJob compares the config running on each slave with the one the master would push.
+type SlaveDriftJob struct {
+	slaveService service.SlaveService
+}
+
+// NewSlaveDriftJob creates a new config drift job instance.
+func NewSlaveDriftJob() *SlaveDriftJob {
+	return &SlaveDriftJob{}
+}
+
+// Run flags slaves whose config has drifted and re-pushes it if configured.
+func (j *SlaveDriftJob) Run() {
+	j.slaveService.CheckConfigDrift()
+}
@@ -0,0 +1,20 @@
+package job
+
+import (
+	"github.com/mhsanaei/3x-ui/v2/web/service"
+)
+
+// SlaveLatencyJob measures the round trip to each connected slave for load-ordered subscriptions.
+type SlaveLatencyJob struct {
+	slaveService service.SlaveService
+}
+
+// NewSlaveLatencyJob creates a new latency measurement job instance.
+func NewSlaveLatencyJob() *SlaveLatencyJob {
+	return &SlaveLatencyJob{}
+}
+
+// Run pings the connected slaves.
+func (j *SlaveLatencyJob) Run() {
+	j.slaveService.MeasureSlaveLatencies()
+}
@@ -17,6 +17,7 @@ import (
 // It handles account CRUD operations, client associations, and aggregated traffic management.
 type AccountService struct {
 	inboundService InboundService
+	slaveService   SlaveService
 }
 
 // GetAccounts retrieves all accounts from the database with their client count.
@@ -27,8 +28,31 @@ func (s *AccountService) GetAccounts() ([]*model.Account, error) {
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return nil, err
 	}
+	s.fillAccountTraffic(accounts)
+	return accounts, nil
+}
+
+// GetAccountsForSlaves retrieves the accounts that have clients on inbounds of the given slaves.
+func (s *AccountService) GetAccountsForSlaves(slaveIds []int) ([]*model.Account, error) {
+	db := database.GetDB()
+	var accountIds []int
+	err := db.Model(&model.AccountClient{}).
+		Joins("JOIN inbounds ON inbounds.id = account_clients.inbound_id").
+		Where("inbounds.slave_id IN ?", slaveIds).
+		Distinct().Pluck("account_clients.account_id", &accountIds).Error
+	if err != nil {
+		return nil, err
+	}
+	var accounts []*model.Account
+	if err := db.Model(model.Account{}).Where("id IN ?", accountIds).Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	s.fillAccountTraffic(accounts)
+	return accounts, nil
+}
 
-	// Populate real-time aggregated traffic for each account
+// fillAccountTraffic populates real-time aggregated traffic for each account.
+func (s *AccountService) fillAccountTraffic(accounts []*model.Account) {
 	for _, account := range accounts {
 		up, down, err := s.GetAccountTraffic(account.Id)
 		if err != nil {
@@ -38,8 +62,6 @@ func (s *AccountService) GetAccounts() ([]*model.Account, error) {
 		account.Up = up
 		account.Down = down
 	}
-
-	return accounts, nil
 }
 
 // GetAccount retrieves a single account by ID.
@@ -295,6 +317,18 @@ func (s *AccountService) GetAccountAffectedSlaves(accountId int) ([]int, error)
 // AddClientToAccount associates a client with an account.
 // This creates the client in the inbound if it doesn't exist, or links an existing client.
 func (s *AccountService) AddClientToAccount(accountId, inboundId int, client *model.Client) error {
+	if inbound, err := s.inboundService.GetInbound(inboundId); err == nil {
+		// New account clients are not placed on slaves in maintenance
+		if err := s.slaveService.checkSlaveNotDraining(inbound.SlaveId); err != nil {
+			return err
+		}
+		if inbound.ReplicaOf > 0 {
+			// The account of a replica client is kept on its replicated inbound and covers every replica
+			replicatedService := ReplicatedInboundService{}
+			return replicatedService.SetReplicaClientAccount(inbound, client.Email, accountId)
+		}
+	}
+
 	db := database.GetDB()
 
 	return db.Transaction(func(tx *gorm.DB) error {
@@ -370,6 +404,14 @@ func (s *AccountService) AddClientToAccount(accountId, inboundId int, client *mo
 func (s *AccountService) RemoveClientFromAccount(accountId int, clientEmail string) error {
 	db := database.GetDB()
 
+	assoc := &model.AccountClient{}
+	if err := db.Where("account_id = ? AND client_email = ?", accountId, clientEmail).First(assoc).Error; err == nil {
+		if inbound, err := s.inboundService.GetInbound(assoc.InboundId); err == nil && inbound.ReplicaOf > 0 {
+			replicatedService := ReplicatedInboundService{}
+			return replicatedService.RemoveReplicaClientAccount(inbound, clientEmail)
+		}
+	}
+
 	return db.Transaction(func(tx *gorm.DB) error {
 		// Delete association
 		if err := tx.Where("account_id = ? AND client_email = ?", accountId, clientEmail).Delete(&model.AccountClient{}).Error; err != nil {
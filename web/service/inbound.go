@@ -82,6 +82,40 @@ func (s *InboundService) GetInboundsForSlave(slaveId int) ([]*model.Inbound, err
 	return inbounds, nil
 }
 
+// GetInboundsForSlaves retrieves the inbounds of several slaves.
+func (s *InboundService) GetInboundsForSlaves(slaveIds []int) ([]*model.Inbound, error) {
+	db := database.GetDB()
+	var inbounds []*model.Inbound
+	err := db.Model(model.Inbound{}).Preload("ClientStats").Where("slave_id IN ?", slaveIds).Find(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	s.enrichInbounds(inbounds)
+	return inbounds, nil
+}
+
+// SetInboundsEnableForSlaves enables or disables all inbounds of the given slaves, or only
+// those with the given tag when tag is not empty. It returns the slaves whose inbounds changed.
+func (s *InboundService) SetInboundsEnableForSlaves(slaveIds []int, tag string, enable bool) ([]int, error) {
+	db := database.GetDB()
+	query := db.Model(model.Inbound{}).Where("slave_id IN ? AND enable <> ?", slaveIds, enable)
+	if tag != "" {
+		query = query.Where("tag = ?", tag)
+	}
+	var changed []int
+	if err := query.Distinct().Pluck("slave_id", &changed).Error; err != nil {
+		return nil, err
+	}
+	if len(changed) == 0 {
+		return changed, nil
+	}
+	update := db.Model(model.Inbound{}).Where("slave_id IN ?", changed)
+	if tag != "" {
+		update = update.Where("tag = ?", tag)
+	}
+	return changed, update.Update("enable", enable).Error
+}
+
 func (s *InboundService) enrichInbounds(inbounds []*model.Inbound) {
 	// Enrich client stats with UUID/SubId from inbound settings
 	for _, inbound := range inbounds {
@@ -732,6 +766,11 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 
 	oldInbound.Settings = string(newSettings)
 
+	// Refuse clients that would make Xray reject the slave's config, before anything is saved
+	if err = s.checkSlaveClients(oldInbound); err != nil {
+		return false, err
+	}
+
 	db := database.GetDB()
 	tx := db.Begin()
 
@@ -786,6 +825,16 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 	return needRestart, tx.Save(oldInbound).Error
 }
 
+// checkSlaveClients validates the config of the inbound's slave with the inbound's changed clients,
+// as CheckSlaveInbound does for a changed inbound. Inbounds of the master are not checked here.
+func (s *InboundService) checkSlaveClients(inbound *model.Inbound) error {
+	if inbound.SlaveId <= 0 {
+		return nil
+	}
+	slaveService := SlaveService{}
+	return slaveService.CheckSlaveInbound(inbound, inbound.Tag)
+}
+
 func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool, error) {
 	oldInbound, err := s.GetInbound(inboundId)
 	if err != nil {
@@ -833,6 +882,11 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 
 	oldInbound.Settings = string(newSettings)
 
+	// Refuse clients that would make Xray reject the slave's config, before anything is saved
+	if err = s.checkSlaveClients(oldInbound); err != nil {
+		return false, err
+	}
+
 	db := database.GetDB()
 
 	err = s.DelClientIPs(db, email)
@@ -979,6 +1033,12 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 	}
 
 	oldInbound.Settings = string(newSettings)
+
+	// Refuse clients that would make Xray reject the slave's config, before anything is saved
+	if err = s.checkSlaveClients(oldInbound); err != nil {
+		return false, err
+	}
+
 	db := database.GetDB()
 	tx := db.Begin()
 
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// configPushCount counts the config pushes to a slave and how the slave applied them.
+type configPushCount struct {
+	pushed      int64
+	pushFailed  int64
+	pushSkipped int64 // the slave was offline; it gets the config when it reconnects
+	applied     int64
+	applyFailed int64
+}
+
+// Config push counters of each slave since the panel started
+var (
+	configPushCounts = make(map[int]*configPushCount)
+	configPushMutex  sync.Mutex
+)
+
+// countConfigPush records a config push to a slave that failed with err, or succeeded if err is nil.
+// A push to an offline slave is counted as skipped, so offline slaves do not look like failures.
+func countConfigPush(slaveId int, err error) {
+	configPushMutex.Lock()
+	defer configPushMutex.Unlock()
+	count := configPushCounts[slaveId]
+	if count == nil {
+		count = &configPushCount{}
+		configPushCounts[slaveId] = count
+	}
+	if errors.Is(err, ErrSlaveNotConnected) {
+		count.pushSkipped++
+	} else if err != nil {
+		count.pushFailed++
+	} else {
+		count.pushed++
+	}
+}
+
+// countConfigApplied records a slave's report on applying a pushed config.
+func countConfigApplied(slaveId int, success bool) {
+	configPushMutex.Lock()
+	defer configPushMutex.Unlock()
+	count := configPushCounts[slaveId]
+	if count == nil {
+		count = &configPushCount{}
+		configPushCounts[slaveId] = count
+	}
+	if success {
+		count.applied++
+	} else {
+		count.applyFailed++
+	}
+}
+
+// MetricsService exposes the state of the cluster in the Prometheus text format.
+type MetricsService struct {
+	slaveService SlaveService
+}
+
+// WriteMetrics writes the current metrics of the slaves, inbounds, outbounds, clients and
+// accounts to w.
+func (s *MetricsService) WriteMetrics(w io.Writer) error {
+	m := &metricsBuilder{}
+	if err := s.writeSlaveMetrics(m); err != nil {
+		return err
+	}
+	if err := s.writeTrafficMetrics(m); err != nil {
+		return err
+	}
+	if err := s.writeClientMetrics(m); err != nil {
+		return err
+	}
+	if err := s.writeAccountMetrics(m); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, m.String())
+	return err
+}
+
+func (s *MetricsService) writeSlaveMetrics(m *metricsBuilder) error {
+	var slaves []model.Slave
+	err := database.GetDB().Select("id", "name", "status", "last_seen", "system_stats").Order("id").Find(&slaves).Error
+	if err != nil {
+		return err
+	}
+	slaveLock.RLock()
+	online := make(map[int]int, len(slaveOnlineClients))
+	for id, clients := range slaveOnlineClients {
+		online[id] = len(clients)
+	}
+	slaveLock.RUnlock()
+	configPushMutex.Lock()
+	counts := make(map[int]configPushCount, len(configPushCounts))
+	for id, count := range configPushCounts {
+		counts[id] = *count
+	}
+	configPushMutex.Unlock()
+
+	now := time.Now().Unix()
+	stats := make(map[int]protocol.SystemStats, len(slaves))
+	for _, slave := range slaves {
+		var stat protocol.SystemStats
+		if slave.Status == "online" && slave.SystemStats != "" && json.Unmarshal([]byte(slave.SystemStats), &stat) == nil {
+			stats[slave.Id] = stat
+		}
+	}
+	labels := func(slave model.Slave, extra ...string) []string {
+		return append([]string{"slave_id", strconv.Itoa(slave.Id), "slave", slave.Name}, extra...)
+	}
+
+	m.header("xui_slave_up", "gauge", "Whether the slave is online (1) or not (0).")
+	for _, slave := range slaves {
+		up := 0.0
+		if slave.Status == "online" {
+			up = 1
+		}
+		m.sample("xui_slave_up", up, labels(slave)...)
+	}
+	m.header("xui_slave_last_seen_seconds", "gauge", "Seconds since the last heartbeat of the slave.")
+	for _, slave := range slaves {
+		if slave.LastSeen > 0 {
+			m.sample("xui_slave_last_seen_seconds", float64(now-slave.LastSeen), labels(slave)...)
+		}
+	}
+	m.header("xui_slave_cpu_percent", "gauge", "CPU usage of the slave host in percent.")
+	for _, slave := range slaves {
+		if stat, ok := stats[slave.Id]; ok {
+			m.sample("xui_slave_cpu_percent", stat.Cpu, labels(slave)...)
+		}
+	}
+	m.header("xui_slave_memory_percent", "gauge", "Memory usage of the slave host in percent.")
+	for _, slave := range slaves {
+		if stat, ok := stats[slave.Id]; ok {
+			m.sample("xui_slave_memory_percent", stat.Mem, labels(slave)...)
+		}
+	}
+	m.header("xui_slave_online_clients", "gauge", "Clients connected to the slave.")
+	for _, slave := range slaves {
+		m.sample("xui_slave_online_clients", float64(online[slave.Id]), labels(slave)...)
+	}
+	m.header("xui_slave_config_pushes_total", "counter", "Config pushes to the slave since the panel started, by result; skipped while it was offline.")
+	for _, slave := range slaves {
+		count := counts[slave.Id]
+		m.sample("xui_slave_config_pushes_total", float64(count.pushed), labels(slave, "result", "success")...)
+		m.sample("xui_slave_config_pushes_total", float64(count.pushFailed), labels(slave, "result", "failure")...)
+		m.sample("xui_slave_config_pushes_total", float64(count.pushSkipped), labels(slave, "result", "skipped")...)
+	}
+	m.header("xui_slave_config_applies_total", "counter", "Pushed configs the slave reported as applied since the panel started, by result.")
+	for _, slave := range slaves {
+		count := counts[slave.Id]
+		m.sample("xui_slave_config_applies_total", float64(count.applied), labels(slave, "result", "success")...)
+		m.sample("xui_slave_config_applies_total", float64(count.applyFailed), labels(slave, "result", "failure")...)
+	}
+
+	m.header("xui_online_clients", "gauge", "Distinct clients connected to any slave.")
+	m.sample("xui_online_clients", float64(len(s.slaveService.GetAllOnlineClients())))
+	return nil
+}
+
+func (s *MetricsService) writeTrafficMetrics(m *metricsBuilder) error {
+	db := database.GetDB()
+	var inbounds []model.Inbound
+	if err := db.Select("id", "slave_id", "tag", "remark", "up", "down").Order("id").Find(&inbounds).Error; err != nil {
+		return err
+	}
+	var outbounds []model.OutboundTraffics
+	if err := db.Order("slave_id, tag").Find(&outbounds).Error; err != nil {
+		return err
+	}
+
+	inboundLabels := func(inbound model.Inbound) []string {
+		return []string{"inbound_id", strconv.Itoa(inbound.Id), "slave_id", strconv.Itoa(inbound.SlaveId), "tag", inbound.Tag, "remark", inbound.Remark}
+	}
+	m.header("xui_inbound_up_bytes_total", "counter", "Bytes uploaded through the inbound.")
+	for _, inbound := range inbounds {
+		m.sample("xui_inbound_up_bytes_total", float64(inbound.Up), inboundLabels(inbound)...)
+	}
+	m.header("xui_inbound_down_bytes_total", "counter", "Bytes downloaded through the inbound.")
+	for _, inbound := range inbounds {
+		m.sample("xui_inbound_down_bytes_total", float64(inbound.Down), inboundLabels(inbound)...)
+	}
+
+	m.header("xui_outbound_up_bytes_total", "counter", "Bytes uploaded through the outbound.")
+	for _, outbound := range outbounds {
+		m.sample("xui_outbound_up_bytes_total", float64(outbound.Up), "slave_id", strconv.Itoa(outbound.SlaveId), "tag", outbound.Tag)
+	}
+	m.header("xui_outbound_down_bytes_total", "counter", "Bytes downloaded through the outbound.")
+	for _, outbound := range outbounds {
+		m.sample("xui_outbound_down_bytes_total", float64(outbound.Down), "slave_id", strconv.Itoa(outbound.SlaveId), "tag", outbound.Tag)
+	}
+	return nil
+}
+
+func (s *MetricsService) writeClientMetrics(m *metricsBuilder) error {
+	var traffics []xray.ClientTraffic
+	if err := database.GetDB().Order("email").Find(&traffics).Error; err != nil {
+		return err
+	}
+	online := make(map[string]bool)
+	for _, email := range s.slaveService.GetAllOnlineClients() {
+		online[email] = true
+	}
+	labels := func(traffic xray.ClientTraffic) []string {
+		return []string{"email", traffic.Email, "inbound_id", strconv.Itoa(traffic.InboundId)}
+	}
+
+	m.header("xui_client_up_bytes_total", "counter", "Bytes uploaded by the client since its last traffic reset.")
+	for _, traffic := range traffics {
+		m.sample("xui_client_up_bytes_total", float64(traffic.Up), labels(traffic)...)
+	}
+	m.header("xui_client_down_bytes_total", "counter", "Bytes downloaded by the client since its last traffic reset.")
+	for _, traffic := range traffics {
+		m.sample("xui_client_down_bytes_total", float64(traffic.Down), labels(traffic)...)
+	}
+	m.header("xui_client_quota_bytes", "gauge", "Traffic quota of the client in bytes, 0 if unlimited.")
+	for _, traffic := range traffics {
+		m.sample("xui_client_quota_bytes", float64(traffic.Total), labels(traffic)...)
+	}
+	m.header("xui_client_enabled", "gauge", "Whether the client is enabled (1) or not (0).")
+	for _, traffic := range traffics {
+		m.sample("xui_client_enabled", boolValue(traffic.Enable), labels(traffic)...)
+	}
+	m.header("xui_client_online", "gauge", "Whether the client is connected to any slave (1) or not (0).")
+	for _, traffic := range traffics {
+		m.sample("xui_client_online", boolValue(online[traffic.Email]), labels(traffic)...)
+	}
+	return nil
+}
+
+func (s *MetricsService) writeAccountMetrics(m *metricsBuilder) error {
+	var accounts []model.Account
+	if err := database.GetDB().Order("id").Find(&accounts).Error; err != nil {
+		return err
+	}
+	labels := func(account model.Account) []string {
+		return []string{"account_id", strconv.Itoa(account.Id), "username", account.Username}
+	}
+
+	m.header("xui_account_up_bytes_total", "counter", "Bytes uploaded by all clients of the account since its last traffic reset.")
+	for _, account := range accounts {
+		m.sample("xui_account_up_bytes_total", float64(account.Up), labels(account)...)
+	}
+	m.header("xui_account_down_bytes_total", "counter", "Bytes downloaded by all clients of the account since its last traffic reset.")
+	for _, account := range accounts {
+		m.sample("xui_account_down_bytes_total", float64(account.Down), labels(account)...)
+	}
+	m.header("xui_account_quota_bytes", "gauge", "Traffic quota of the account in bytes, 0 if unlimited.")
+	for _, account := range accounts {
+		m.sample("xui_account_quota_bytes", float64(account.TotalGB*1024*1024*1024), labels(account)...)
+	}
+	m.header("xui_account_enabled", "gauge", "Whether the account is enabled (1) or not (0).")
+	for _, account := range accounts {
+		m.sample("xui_account_enabled", boolValue(account.Enable), labels(account)...)
+	}
+	return nil
+}
+
+// metricsBuilder renders metrics in the Prometheus text exposition format.
+type metricsBuilder struct {
+	strings.Builder
+}
+
+func (m *metricsBuilder) header(name, metricType, help string) {
+	fmt.Fprintf(m, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// sample writes one value of a metric; labels are given as name and value pairs.
+func (m *metricsBuilder) sample(name string, value float64, labels ...string) {
+	m.WriteString(name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, labels[i]+`="`+escapeLabelValue(labels[i+1])+`"`)
+		}
+		m.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	m.WriteString(" " + strconv.FormatFloat(value, 'f', -1, 64) + "\n")
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -161,6 +161,12 @@ func (s *OutboundService) saveTemplateOutbounds(slaveId int, outbounds []map[str
 		return fmt.Errorf("failed to marshal xray template config: %v", err)
 	}
 
+	// Refuse outbounds that would make Xray reject the slave's config
+	slaveService := SlaveService{}
+	if err := slaveService.CheckSlaveTemplate(slaveId, string(newJson)); err != nil {
+		return err
+	}
+
 	return s.SlaveSettingService.SaveXrayConfigForSlave(slaveId, string(newJson))
 }
 
@@ -0,0 +1,915 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/common"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+	"gorm.io/gorm"
+)
+
+// replicaLock serializes rendering, so concurrent edits and membership changes cannot create a replica twice.
+var replicaLock sync.Mutex
+
+// ReplicatedInboundService manages replicated inbounds: one definition deployed as a concrete
+// inbound on every slave its selector matches, with clients and account membership kept once.
+type ReplicatedInboundService struct {
+	inboundService InboundService
+	slaveService   SlaveService
+}
+
+// ReplicatedInboundWithReplicas is a definition together with the slaves that run a replica of it.
+type ReplicatedInboundWithReplicas struct {
+	model.ReplicatedInbound
+	SlaveIds []int `json:"slaveIds"`
+}
+
+// clientEdits carries client changes that replicas must follow instead of keeping their own state.
+type clientEdits struct {
+	renamed map[string]string // old email to new email
+	enabled map[string]bool   // emails whose enable state was set by the user
+}
+
+// ReplicaClientEmail is the email a client of a replicated inbound has on the replica of a slave.
+// Client emails are unique across inbounds, so every replica needs its own.
+func ReplicaClientEmail(email string, slaveId int) string {
+	return fmt.Sprintf("%s-s%d", email, slaveId)
+}
+
+// canonicalClientEmail reverses ReplicaClientEmail for the replica on slaveId.
+func canonicalClientEmail(email string, slaveId int) string {
+	return strings.TrimSuffix(email, fmt.Sprintf("-s%d", slaveId))
+}
+
+func replicaTag(replicatedId, slaveId int) string {
+	return fmt.Sprintf("replica-%d-s%d", replicatedId, slaveId)
+}
+
+// GetReplicatedInbounds returns all definitions with their overrides and replica slaves.
+func (s *ReplicatedInboundService) GetReplicatedInbounds() ([]ReplicatedInboundWithReplicas, error) {
+	db := database.GetDB()
+	var defs []model.ReplicatedInbound
+	if err := db.Preload("Overrides").Order("id").Find(&defs).Error; err != nil {
+		return nil, err
+	}
+	var replicas []model.Inbound
+	if err := db.Select("replica_of", "slave_id").Where("replica_of > 0").Order("slave_id").Find(&replicas).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]ReplicatedInboundWithReplicas, len(defs))
+	for i, def := range defs {
+		result[i] = ReplicatedInboundWithReplicas{ReplicatedInbound: def, SlaveIds: []int{}}
+		for _, replica := range replicas {
+			if replica.ReplicaOf == def.Id {
+				result[i].SlaveIds = append(result[i].SlaveIds, replica.SlaveId)
+			}
+		}
+	}
+	return result, nil
+}
+
+// GetReplicatedInbound returns a definition with its overrides.
+func (s *ReplicatedInboundService) GetReplicatedInbound(id int) (*model.ReplicatedInbound, error) {
+	def := &model.ReplicatedInbound{}
+	if err := database.GetDB().Preload("Overrides").First(def, id).Error; err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+// SaveReplicatedInbound creates or updates a definition and its overrides and renders the replicas.
+// It returns the slaves whose config changed.
+func (s *ReplicatedInboundService) SaveReplicatedInbound(def *model.ReplicatedInbound) ([]int, error) {
+	if def.Id > 0 {
+		if _, err := s.GetReplicatedInbound(def.Id); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateReplicaOverrides(def.Overrides); err != nil {
+		return nil, err
+	}
+	if err := stampReplicatedClients(def, nil); err != nil {
+		return nil, err
+	}
+
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+	return s.render(def, true, clientEdits{})
+}
+
+// DelReplicatedInbound removes a definition and all its replicas. It returns the slaves that had one.
+func (s *ReplicatedInboundService) DelReplicatedInbound(id int) ([]int, error) {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+
+	db := database.GetDB()
+	var replicas []*model.Inbound
+	if err := db.Where("replica_of = ?", id).Find(&replicas).Error; err != nil {
+		return nil, err
+	}
+	affected := []int{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, replica := range replicas {
+			if err := s.deleteReplica(tx, replica); err != nil {
+				return err
+			}
+			affected = append(affected, replica.SlaveId)
+		}
+		if err := tx.Where("replicated_inbound_id = ?", id).Delete(&model.ReplicaOverride{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("replicated_inbound_id = ?", id).Delete(&model.ReplicatedClientAccount{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.ReplicatedInbound{}, id).Error
+	})
+	return affected, err
+}
+
+// SyncAllReplicatedInbounds renders every definition again, for example after slaves joined or left
+// a group. It returns the slaves whose config changed.
+func (s *ReplicatedInboundService) SyncAllReplicatedInbounds() ([]int, error) {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+
+	var defs []*model.ReplicatedInbound
+	if err := database.GetDB().Preload("Overrides").Find(&defs).Error; err != nil {
+		return nil, err
+	}
+	var affected []int
+	var errs []error
+	for _, def := range defs {
+		slaveIds, err := s.render(def, false, clientEdits{})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("replicated inbound %d: %v", def.Id, err))
+			continue
+		}
+		for _, slaveId := range slaveIds {
+			if !slices.Contains(affected, slaveId) {
+				affected = append(affected, slaveId)
+			}
+		}
+	}
+	return affected, errors.Join(errs...)
+}
+
+// AddReplicaClients adds the clients posted for a replica to its definition.
+func (s *ReplicatedInboundService) AddReplicaClients(replica *model.Inbound, data *model.Inbound) ([]int, error) {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+
+	def, settings, clients, err := s.definitionClients(replica.ReplicaOf)
+	if err != nil {
+		return nil, err
+	}
+	newClients, err := postedClients(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, client := range newClients {
+		if email, _ := client["email"].(string); email != "" {
+			client["email"] = canonicalClientEmail(email, replica.SlaveId)
+		}
+	}
+	settings["clients"] = append(clients, newClients...)
+	if err := setDefinitionClients(def, settings); err != nil {
+		return nil, err
+	}
+	if err := stampReplicatedClients(def, nil); err != nil {
+		return nil, err
+	}
+	return s.render(def, false, clientEdits{})
+}
+
+// UpdateReplicaClient replaces a client of a replica's definition with the posted one; clientId
+// identifies it the way UpdateInboundClient does.
+func (s *ReplicatedInboundService) UpdateReplicaClient(replica *model.Inbound, clientId string, data *model.Inbound) ([]int, error) {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+
+	def, settings, clients, err := s.definitionClients(replica.ReplicaOf)
+	if err != nil {
+		return nil, err
+	}
+	posted, err := postedClients(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(posted) == 0 {
+		return nil, common.NewError("no client to update")
+	}
+	client := posted[0]
+	newEmail, _ := client["email"].(string)
+	newEmail = canonicalClientEmail(newEmail, replica.SlaveId)
+	client["email"] = newEmail
+
+	index := findReplicatedClient(def.Protocol, clients, clientId, replica.SlaveId)
+	if index < 0 {
+		return nil, common.NewError("client not found:", clientId)
+	}
+	oldEmail, _ := clients[index]["email"].(string)
+	if createdAt, ok := clients[index]["created_at"]; ok {
+		client["created_at"] = createdAt
+	}
+	clients[index] = client
+	settings["clients"] = clients
+	if err := setDefinitionClients(def, settings); err != nil {
+		return nil, err
+	}
+	if err := stampReplicatedClients(def, []string{newEmail}); err != nil {
+		return nil, err
+	}
+
+	edits := clientEdits{enabled: map[string]bool{newEmail: true}}
+	if !strings.EqualFold(oldEmail, newEmail) {
+		edits.renamed = map[string]string{oldEmail: newEmail}
+	}
+	return s.render(def, false, edits)
+}
+
+// DelReplicaClient removes a client from a replica's definition; clientId identifies it the way
+// DelInboundClient does.
+func (s *ReplicatedInboundService) DelReplicaClient(replica *model.Inbound, clientId string) ([]int, error) {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+
+	def, settings, clients, err := s.definitionClients(replica.ReplicaOf)
+	if err != nil {
+		return nil, err
+	}
+	index := findReplicatedClient(def.Protocol, clients, clientId, replica.SlaveId)
+	if index < 0 {
+		return nil, common.NewError("client not found:", clientId)
+	}
+	settings["clients"] = slices.Delete(clients, index, index+1)
+	if err := setDefinitionClients(def, settings); err != nil {
+		return nil, err
+	}
+	return s.render(def, false, clientEdits{})
+}
+
+// DelReplicaClientByEmail removes the client with the given email of a replica from its definition.
+func (s *ReplicatedInboundService) DelReplicaClientByEmail(replica *model.Inbound, email string) ([]int, error) {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+
+	def, settings, clients, err := s.definitionClients(replica.ReplicaOf)
+	if err != nil {
+		return nil, err
+	}
+	email = canonicalClientEmail(email, replica.SlaveId)
+	index := slices.IndexFunc(clients, func(client map[string]any) bool {
+		clientEmail, _ := client["email"].(string)
+		return strings.EqualFold(clientEmail, email)
+	})
+	if index < 0 {
+		return nil, common.NewError("client not found:", email)
+	}
+	settings["clients"] = slices.Delete(clients, index, index+1)
+	if err := setDefinitionClients(def, settings); err != nil {
+		return nil, err
+	}
+	return s.render(def, false, clientEdits{})
+}
+
+// SetReplicaClientAccount puts a client of a replica's definition in an account, which then covers
+// the client's copy on every replica, including replicas created later.
+func (s *ReplicatedInboundService) SetReplicaClientAccount(replica *model.Inbound, email string, accountId int) error {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+
+	_, _, clients, err := s.definitionClients(replica.ReplicaOf)
+	if err != nil {
+		return err
+	}
+	email = canonicalClientEmail(email, replica.SlaveId)
+	if !slices.ContainsFunc(clients, func(client map[string]any) bool { return client["email"] == email }) {
+		return common.NewError("Client does not exist in inbound. Please add client to inbound first.")
+	}
+
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		membership := model.ReplicatedClientAccount{}
+		err := tx.Where("replicated_inbound_id = ? AND client_email = ?", replica.ReplicaOf, email).First(&membership).Error
+		if err == nil {
+			return common.NewError("Client email already associated with another account:", email)
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		membership = model.ReplicatedClientAccount{ReplicatedInboundId: replica.ReplicaOf, ClientEmail: email, AccountId: accountId}
+		if err := tx.Create(&membership).Error; err != nil {
+			return err
+		}
+		var replicas []*model.Inbound
+		if err := tx.Where("replica_of = ?", replica.ReplicaOf).Find(&replicas).Error; err != nil {
+			return err
+		}
+		return s.syncReplicaAccounts(tx, replica.ReplicaOf, replicas)
+	})
+}
+
+// RemoveReplicaClientAccount takes a client of a replica's definition out of its account on every replica.
+func (s *ReplicatedInboundService) RemoveReplicaClientAccount(replica *model.Inbound, email string) error {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+
+	email = canonicalClientEmail(email, replica.SlaveId)
+	db := database.GetDB()
+	var replicas []*model.Inbound
+	if err := db.Where("replica_of = ?", replica.ReplicaOf).Find(&replicas).Error; err != nil {
+		return err
+	}
+	emails := make([]string, len(replicas))
+	for i, r := range replicas {
+		emails[i] = ReplicaClientEmail(email, r.SlaveId)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("replicated_inbound_id = ? AND client_email = ?", replica.ReplicaOf, email).
+			Delete(&model.ReplicatedClientAccount{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_email IN ?", emails).Delete(&model.AccountClient{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&xray.ClientTraffic{}).Where("email IN ?", emails).Update("account_id", 0).Error
+	})
+}
+
+// render brings the replicas of a definition in line with it: every slave the selector matches gets
+// a replica and replicas on other slaves are removed. With save the definition itself and its
+// overrides are stored in the same transaction. It returns the slaves whose config changed.
+func (s *ReplicatedInboundService) render(def *model.ReplicatedInbound, save bool, edits clientEdits) ([]int, error) {
+	if err := validateReplicatedInbound(def); err != nil {
+		return nil, err
+	}
+	slaveIds, err := s.slaveService.SelectSlaves(def.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	db := database.GetDB()
+	bySlave := make(map[int]*model.Inbound)
+	var existing []*model.Inbound
+	if def.Id > 0 {
+		if err := db.Where("replica_of = ?", def.Id).Find(&existing).Error; err != nil {
+			return nil, err
+		}
+	}
+	existingIds := make([]int, len(existing))
+	for i, replica := range existing {
+		bySlave[replica.SlaveId] = replica
+		existingIds[i] = replica.Id
+	}
+	var traffics []xray.ClientTraffic
+	if err := db.Select("email", "enable").Where("inbound_id IN ?", existingIds).Find(&traffics).Error; err != nil {
+		return nil, err
+	}
+	clientEnabled := make(map[string]bool, len(traffics))
+	for _, traffic := range traffics {
+		clientEnabled[traffic.Email] = traffic.Enable
+	}
+	foreign, err := s.foreignClientEmails(def.Id)
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[int]model.ReplicaOverride)
+	for _, override := range def.Overrides {
+		overrides[override.SlaveId] = override
+	}
+
+	// Render and check everything before writing
+	replicas := make([]*model.Inbound, 0, len(slaveIds))
+	for _, slaveId := range slaveIds {
+		replica, err := renderReplica(def, slaveId, overrides[slaveId], bySlave[slaveId], clientEnabled, edits)
+		if err != nil {
+			return nil, err
+		}
+		exist, err := s.inboundService.checkPortExist(replica.Listen, replica.Port, replica.Id, slaveId)
+		if err != nil {
+			return nil, err
+		}
+		if exist {
+			return nil, common.NewErrorf("Port %d already exists on slave %s", replica.Port, s.slaveName(slaveId))
+		}
+		clients, err := s.inboundService.GetClients(replica)
+		if err != nil {
+			return nil, err
+		}
+		for _, client := range clients {
+			if foreign[strings.ToLower(client.Email)] {
+				return nil, common.NewError("Duplicate email:", client.Email)
+			}
+		}
+		replicas = append(replicas, replica)
+	}
+
+	var affected []int
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if save {
+			if err := tx.Omit("Overrides").Save(def).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("replicated_inbound_id = ?", def.Id).Delete(&model.ReplicaOverride{}).Error; err != nil {
+				return err
+			}
+			for i := range def.Overrides {
+				def.Overrides[i].Id = 0
+				def.Overrides[i].ReplicatedInboundId = def.Id
+			}
+			if len(def.Overrides) > 0 {
+				if err := tx.Create(&def.Overrides).Error; err != nil {
+					return err
+				}
+			}
+		} else if err := tx.Omit("Overrides").Save(def).Error; err != nil {
+			return err
+		}
+
+		for oldEmail, newEmail := range edits.renamed {
+			if err := tx.Model(&model.ReplicatedClientAccount{}).
+				Where("replicated_inbound_id = ? AND client_email = ?", def.Id, oldEmail).
+				Update("client_email", newEmail).Error; err != nil {
+				return err
+			}
+		}
+		for _, replica := range replicas {
+			replica.ReplicaOf = def.Id
+			replica.Tag = replicaTag(def.Id, replica.SlaveId)
+			old := bySlave[replica.SlaveId]
+			delete(bySlave, replica.SlaveId)
+			if old != nil && !replicaChanged(old, replica) {
+				if err := tx.Save(replica).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.saveReplica(tx, old, replica, edits); err != nil {
+				return err
+			}
+			affected = append(affected, replica.SlaveId)
+		}
+		for slaveId, old := range bySlave {
+			if err := s.deleteReplica(tx, old); err != nil {
+				return err
+			}
+			affected = append(affected, slaveId)
+		}
+		return s.syncReplicaAccounts(tx, def.Id, replicas)
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(affected)
+	return affected, nil
+}
+
+// renderReplica builds the inbound a definition has on one slave. Traffic counters of an existing
+// replica are kept, and so is the state of clients that were disabled there, e.g. for running out of traffic.
+func renderReplica(def *model.ReplicatedInbound, slaveId int, override model.ReplicaOverride, old *model.Inbound,
+	clientEnabled map[string]bool, edits clientEdits) (*model.Inbound, error) {
+	replica := &model.Inbound{
+		UserId:         def.UserId,
+		SlaveId:        slaveId,
+		Remark:         def.Remark,
+		Enable:         def.Enable,
+		Total:          def.Total,
+		ExpiryTime:     def.ExpiryTime,
+		TrafficReset:   def.TrafficReset,
+		Listen:         def.Listen,
+		Port:           def.Port,
+		Protocol:       def.Protocol,
+		StreamSettings: def.StreamSettings,
+		Sniffing:       def.Sniffing,
+		Address:        def.Address,
+	}
+	if old != nil {
+		replica.Id = old.Id
+		replica.Up = old.Up
+		replica.Down = old.Down
+		replica.AllTime = old.AllTime
+		replica.LastTrafficResetTime = old.LastTrafficResetTime
+	}
+	if override.Port > 0 {
+		replica.Port = override.Port
+	}
+	if override.Address != "" {
+		replica.Address = override.Address
+	}
+	if override.Sni != "" {
+		streamSettings, err := overrideSni(def.StreamSettings, override.Sni)
+		if err != nil {
+			return nil, err
+		}
+		replica.StreamSettings = streamSettings
+	}
+
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(def.Settings), &settings); err != nil {
+		return nil, err
+	}
+	if clients, ok := settings["clients"].([]any); ok {
+		replicaClients := make([]any, len(clients))
+		for i, c := range clients {
+			client, ok := c.(map[string]any)
+			if !ok {
+				return nil, common.NewError("invalid client in settings")
+			}
+			copied := make(map[string]any, len(client))
+			for key, value := range client {
+				copied[key] = value
+			}
+			email, _ := client["email"].(string)
+			replicaEmail := ReplicaClientEmail(email, slaveId)
+			copied["email"] = replicaEmail
+			if enabled, ok := clientEnabled[replicaEmail]; ok && !enabled && !edits.enabled[email] {
+				copied["enable"] = false
+			}
+			replicaClients[i] = copied
+		}
+		settings["clients"] = replicaClients
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	replica.Settings = string(data)
+	return replica, nil
+}
+
+// overrideSni sets the server name a TLS or Reality inbound presents.
+func overrideSni(streamSettings string, sni string) (string, error) {
+	var stream map[string]any
+	if err := json.Unmarshal([]byte(streamSettings), &stream); err != nil {
+		return "", fmt.Errorf("invalid stream settings: %v", err)
+	}
+	switch stream["security"] {
+	case "tls":
+		tlsSettings, _ := stream["tlsSettings"].(map[string]any)
+		if tlsSettings == nil {
+			tlsSettings = map[string]any{}
+		}
+		tlsSettings["serverName"] = sni
+		stream["tlsSettings"] = tlsSettings
+	case "reality":
+		realitySettings, _ := stream["realitySettings"].(map[string]any)
+		if realitySettings == nil {
+			realitySettings = map[string]any{}
+		}
+		realitySettings["serverNames"] = []string{sni}
+		if clientSettings, ok := realitySettings["settings"].(map[string]any); ok {
+			clientSettings["serverName"] = sni
+		}
+		stream["realitySettings"] = realitySettings
+	default:
+		return "", common.NewError("SNI can only be overridden for TLS or Reality inbounds")
+	}
+	data, err := json.MarshalIndent(stream, "", "  ")
+	return string(data), err
+}
+
+// replicaChanged reports whether a replica's Xray config differs from the stored one.
+func replicaChanged(old, replica *model.Inbound) bool {
+	return old.Enable != replica.Enable || old.Tag != replica.Tag || old.Listen != replica.Listen ||
+		old.Port != replica.Port || old.Protocol != replica.Protocol || old.Settings != replica.Settings ||
+		old.StreamSettings != replica.StreamSettings || old.Sniffing != replica.Sniffing
+}
+
+// saveReplica stores a rendered replica and keeps the traffic records of its clients in step.
+func (s *ReplicatedInboundService) saveReplica(tx *gorm.DB, old, replica *model.Inbound, edits clientEdits) error {
+	clients, err := s.inboundService.GetClients(replica)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		if err := tx.Create(replica).Error; err != nil {
+			return err
+		}
+		for _, client := range clients {
+			if err := s.inboundService.AddClientStat(tx, replica.Id, &client); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	oldClients, err := s.inboundService.GetClients(old)
+	if err != nil {
+		return err
+	}
+	if err := tx.Save(replica).Error; err != nil {
+		return err
+	}
+	// Renamed clients keep their traffic records under the new email
+	existing := make(map[string]string)
+	for _, client := range oldClients {
+		email := client.Email
+		if newEmail, ok := edits.renamed[canonicalClientEmail(email, replica.SlaveId)]; ok {
+			newReplicaEmail := ReplicaClientEmail(newEmail, replica.SlaveId)
+			if err := s.inboundService.UpdateClientIPs(tx, email, newReplicaEmail); err != nil {
+				return err
+			}
+			if err := tx.Model(&model.AccountClient{}).Where("client_email = ?", email).
+				Update("client_email", newReplicaEmail).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&xray.ClientTraffic{}).Where("email = ?", email).
+				Update("email", newReplicaEmail).Error; err != nil {
+				return err
+			}
+			email = newReplicaEmail
+		}
+		existing[strings.ToLower(email)] = email
+	}
+	for _, client := range clients {
+		email := strings.ToLower(client.Email)
+		if _, ok := existing[email]; ok {
+			err = s.inboundService.UpdateClientStat(tx, client.Email, &client)
+			delete(existing, email)
+		} else {
+			err = s.inboundService.AddClientStat(tx, replica.Id, &client)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, email := range existing {
+		if err := s.deleteReplicaClient(tx, email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteReplica removes a replica together with the records of its clients.
+func (s *ReplicatedInboundService) deleteReplica(tx *gorm.DB, replica *model.Inbound) error {
+	clients, err := s.inboundService.GetClients(replica)
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		if err := s.deleteReplicaClient(tx, client.Email); err != nil {
+			return err
+		}
+	}
+	if err := tx.Where("inbound_id = ?", replica.Id).Delete(&xray.ClientTraffic{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&model.Inbound{}, replica.Id).Error
+}
+
+func (s *ReplicatedInboundService) deleteReplicaClient(tx *gorm.DB, email string) error {
+	if err := s.inboundService.DelClientStat(tx, email); err != nil {
+		return err
+	}
+	if err := s.inboundService.DelClientIPs(tx, email); err != nil {
+		return err
+	}
+	return tx.Where("client_email = ?", email).Delete(&model.AccountClient{}).Error
+}
+
+// syncReplicaAccounts associates every replica's copy of a client with the client's account and
+// drops memberships of clients that no longer exist.
+func (s *ReplicatedInboundService) syncReplicaAccounts(tx *gorm.DB, replicatedId int, replicas []*model.Inbound) error {
+	var memberships []model.ReplicatedClientAccount
+	if err := tx.Where("replicated_inbound_id = ?", replicatedId).Find(&memberships).Error; err != nil {
+		return err
+	}
+	var def model.ReplicatedInbound
+	if err := tx.First(&def, replicatedId).Error; err != nil {
+		return err
+	}
+	var settings struct {
+		Clients []model.Client `json:"clients"`
+	}
+	if err := json.Unmarshal([]byte(def.Settings), &settings); err != nil {
+		return err
+	}
+	for _, membership := range memberships {
+		if !slices.ContainsFunc(settings.Clients, func(client model.Client) bool { return client.Email == membership.ClientEmail }) {
+			if err := tx.Delete(&membership).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		for _, replica := range replicas {
+			email := ReplicaClientEmail(membership.ClientEmail, replica.SlaveId)
+			assoc := model.AccountClient{}
+			err := tx.Where(model.AccountClient{ClientEmail: email}).
+				Attrs(model.AccountClient{CreatedAt: time.Now().UnixMilli()}).
+				Assign(model.AccountClient{AccountId: membership.AccountId, InboundId: replica.Id}).
+				FirstOrCreate(&assoc).Error
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&xray.ClientTraffic{}).Where("email = ?", email).
+				Update("account_id", membership.AccountId).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// definitionClients loads a definition with its settings and clients for editing the clients.
+func (s *ReplicatedInboundService) definitionClients(id int) (*model.ReplicatedInbound, map[string]any, []map[string]any, error) {
+	def, err := s.GetReplicatedInbound(id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(def.Settings), &settings); err != nil {
+		return nil, nil, nil, err
+	}
+	var clients []map[string]any
+	if list, ok := settings["clients"].([]any); ok {
+		for _, c := range list {
+			if client, ok := c.(map[string]any); ok {
+				clients = append(clients, client)
+			}
+		}
+	}
+	return def, settings, clients, nil
+}
+
+func setDefinitionClients(def *model.ReplicatedInbound, settings map[string]any) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	def.Settings = string(data)
+	return nil
+}
+
+// postedClients returns the clients of settings posted for adding or updating clients.
+func postedClients(data *model.Inbound) ([]map[string]any, error) {
+	var settings struct {
+		Clients []map[string]any `json:"clients"`
+	}
+	if err := json.Unmarshal([]byte(data.Settings), &settings); err != nil {
+		return nil, err
+	}
+	return settings.Clients, nil
+}
+
+// findReplicatedClient finds a definition client by the id the inbound API uses for its protocol.
+func findReplicatedClient(protocol model.Protocol, clients []map[string]any, clientId string, slaveId int) int {
+	key := "id"
+	switch protocol {
+	case "trojan":
+		key = "password"
+	case "shadowsocks":
+		key = "email"
+		clientId = canonicalClientEmail(clientId, slaveId)
+	}
+	return slices.IndexFunc(clients, func(client map[string]any) bool {
+		value, _ := client[key].(string)
+		return value == clientId
+	})
+}
+
+// stampReplicatedClients sets creation times on new clients and the update time on the given ones.
+func stampReplicatedClients(def *model.ReplicatedInbound, updated []string) error {
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(def.Settings), &settings); err != nil {
+		return err
+	}
+	clients, ok := settings["clients"].([]any)
+	if !ok {
+		return nil
+	}
+	now := time.Now().Unix() * 1000
+	for _, c := range clients {
+		client, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		if createdAt, _ := client["created_at"].(float64); createdAt == 0 {
+			client["created_at"] = now
+			client["updated_at"] = now
+		} else if email, _ := client["email"].(string); slices.Contains(updated, email) {
+			client["updated_at"] = now
+		}
+	}
+	return setDefinitionClients(def, settings)
+}
+
+// validateReplicatedInbound checks a definition before it is rendered.
+func validateReplicatedInbound(def *model.ReplicatedInbound) error {
+	if strings.TrimSpace(def.Selector) == "" {
+		return common.NewError("Replicated inbounds need a slave selector, e.g. group=edge")
+	}
+	sel, err := ParseSlaveSelector(def.Selector)
+	if err != nil {
+		return err
+	}
+	if sel.hasKey(selectorKeyStatus) {
+		return common.NewError("Replicated inbounds cannot select slaves by status")
+	}
+	if def.Protocol == "" {
+		return common.NewError("Protocol is required")
+	}
+	if def.Port <= 0 || def.Port > 65535 {
+		return common.NewError("Invalid port:", def.Port)
+	}
+
+	var settings struct {
+		Clients []model.Client `json:"clients"`
+	}
+	if err := json.Unmarshal([]byte(def.Settings), &settings); err != nil {
+		return fmt.Errorf("invalid settings: %v", err)
+	}
+	var emails []string
+	for _, client := range settings.Clients {
+		if client.Email == "" {
+			return common.NewError("Clients of replicated inbounds need an email")
+		}
+		for _, email := range emails {
+			if strings.EqualFold(email, client.Email) {
+				return common.NewError("Duplicate email:", client.Email)
+			}
+		}
+		emails = append(emails, client.Email)
+		switch def.Protocol {
+		case "trojan":
+			if client.Password == "" {
+				return common.NewError("empty client ID")
+			}
+		case "shadowsocks":
+		default:
+			if client.ID == "" {
+				return common.NewError("empty client ID")
+			}
+		}
+	}
+	return nil
+}
+
+func validateReplicaOverrides(overrides []model.ReplicaOverride) error {
+	seen := make(map[int]bool)
+	for _, override := range overrides {
+		if override.SlaveId <= 0 {
+			return common.NewError("Override without slave")
+		}
+		if seen[override.SlaveId] {
+			return common.NewError("Duplicate override for slave", override.SlaveId)
+		}
+		seen[override.SlaveId] = true
+		if override.Port < 0 || override.Port > 65535 {
+			return common.NewError("Invalid port:", override.Port)
+		}
+	}
+	return nil
+}
+
+// foreignClientEmails returns the lower-cased client emails of all inbounds that are not replicas of a definition.
+func (s *ReplicatedInboundService) foreignClientEmails(replicatedId int) (map[string]bool, error) {
+	var emails []string
+	err := database.GetDB().Raw(`
+		SELECT JSON_EXTRACT(client.value, '$.email')
+		FROM inbounds,
+			JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client
+		WHERE inbounds.replica_of <> ? OR ? = 0
+		`, replicatedId, replicatedId).Scan(&emails).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		result[strings.ToLower(email)] = true
+	}
+	return result, nil
+}
+
+func (s *ReplicatedInboundService) slaveName(slaveId int) string {
+	if slave, err := s.slaveService.GetSlave(slaveId); err == nil {
+		return slave.Name
+	}
+	return fmt.Sprint(slaveId)
+}
+
+// resyncReplicatedInbounds renders all replicated inbounds again after slave membership may have
+// changed and pushes the config of the slaves that gained, lost or changed a replica.
+func resyncReplicatedInbounds() {
+	replicatedService := ReplicatedInboundService{}
+	affected, err := replicatedService.SyncAllReplicatedInbounds()
+	if err != nil {
+		logger.Warning("Failed to sync replicated inbounds:", err)
+	}
+	slaveService := SlaveService{}
+	for _, result := range slaveService.PushConfigToSlaves(affected) {
+		if !result.Success {
+			logger.Warningf("Failed to push config to slave %d: %s", result.SlaveId, result.Error)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+	"gorm.io/gorm"
+)
+
+// replicaClientUsage is the traffic a client of a replicated inbound used across all replicas.
+type replicaClientUsage struct {
+	used     int64
+	total    int64
+	expired  bool
+	emails   []string
+	slaveIds []int // slaves where the client is still enabled
+}
+
+// DisableExhaustedReplicas enforces the limits of replicated inbounds and their clients on the
+// traffic of all replicas together, since a client copied to N slaves would otherwise get N times
+// its quota. A client or inbound over its limit is disabled on every replica at once. It returns
+// the slaves whose config has to be pushed.
+func (s *ReplicatedInboundService) DisableExhaustedReplicas(tx *gorm.DB) ([]int, error) {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+
+	now := time.Now().UnixMilli()
+	var affected []int
+	addAffected := func(slaveIds ...int) {
+		for _, slaveId := range slaveIds {
+			if !slices.Contains(affected, slaveId) {
+				affected = append(affected, slaveId)
+			}
+		}
+	}
+
+	// Inbound limits, on the traffic of all replicas of a definition
+	var inbounds []struct {
+		ReplicaOf  int
+		Total      int64
+		ExpiryTime int64
+		Used       int64
+	}
+	err := tx.Table("replicated_inbounds").
+		Select("replicated_inbounds.id AS replica_of, replicated_inbounds.total, replicated_inbounds.expiry_time, COALESCE(SUM(inbounds.up + inbounds.down), 0) AS used").
+		Joins("JOIN inbounds ON inbounds.replica_of = replicated_inbounds.id").
+		Where("replicated_inbounds.enable = ?", true).
+		Group("replicated_inbounds.id").
+		Scan(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, inbound := range inbounds {
+		if !limitReached(inbound.Used, inbound.Total, inbound.ExpiryTime, now) {
+			continue
+		}
+		var slaveIds []int
+		if err := tx.Model(&model.Inbound{}).Where("replica_of = ?", inbound.ReplicaOf).Pluck("slave_id", &slaveIds).Error; err != nil {
+			return nil, err
+		}
+		if err := tx.Model(&model.ReplicatedInbound{}).Where("id = ?", inbound.ReplicaOf).Update("enable", false).Error; err != nil {
+			return nil, err
+		}
+		if err := tx.Model(&model.Inbound{}).Where("replica_of = ?", inbound.ReplicaOf).Update("enable", false).Error; err != nil {
+			return nil, err
+		}
+		logger.Infof("Disabled replicated inbound %d on slaves %v: traffic or expiry limit reached", inbound.ReplicaOf, slaveIds)
+		addAffected(slaveIds...)
+	}
+
+	// Client limits, on the traffic of every replica's copy of a client
+	var rows []struct {
+		ReplicaOf  int
+		SlaveId    int
+		Email      string
+		Up         int64
+		Down       int64
+		Total      int64
+		ExpiryTime int64
+		Enable     bool
+	}
+	err = tx.Table("client_traffics").
+		Select("inbounds.replica_of, inbounds.slave_id, client_traffics.email, client_traffics.up, client_traffics.down, client_traffics.total, client_traffics.expiry_time, client_traffics.enable").
+		Joins("JOIN inbounds ON inbounds.id = client_traffics.inbound_id").
+		Where("inbounds.replica_of > 0").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	usages := make(map[string]*replicaClientUsage)
+	var keys []string
+	for _, row := range rows {
+		key := fmt.Sprintf("%d/%s", row.ReplicaOf, strings.ToLower(canonicalClientEmail(row.Email, row.SlaveId)))
+		usage, ok := usages[key]
+		if !ok {
+			usage = &replicaClientUsage{}
+			usages[key] = usage
+			keys = append(keys, key)
+		}
+		usage.used += row.Up + row.Down
+		usage.total = max(usage.total, row.Total)
+		usage.expired = usage.expired || (row.ExpiryTime > 0 && row.ExpiryTime <= now)
+		usage.emails = append(usage.emails, row.Email)
+		if row.Enable {
+			usage.slaveIds = append(usage.slaveIds, row.SlaveId)
+		}
+	}
+	for _, key := range keys {
+		usage := usages[key]
+		if len(usage.slaveIds) == 0 || !(usage.expired || limitReached(usage.used, usage.total, 0, now)) {
+			continue
+		}
+		if err := tx.Model(&xray.ClientTraffic{}).Where("email IN ?", usage.emails).Update("enable", false).Error; err != nil {
+			return nil, err
+		}
+		logger.Infof("Disabled replicated client %v on slaves %v: traffic or expiry limit reached", usage.emails, usage.slaveIds)
+		addAffected(usage.slaveIds...)
+	}
+	slices.Sort(affected)
+	return affected, nil
+}
+
+// limitReached reports whether used traffic reached a total or an expiry time passed; zero means no limit.
+func limitReached(used, total, expiryTime, now int64) bool {
+	return (total > 0 && used >= total) || (expiryTime > 0 && expiryTime <= now)
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/mhsanaei/3x-ui/v2/config"
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
 	"github.com/mhsanaei/3x-ui/v2/util/common"
 	"github.com/mhsanaei/3x-ui/v2/util/sys"
 	"github.com/mhsanaei/3x-ui/v2/xray"
@@ -37,62 +38,18 @@ import (
 )
 
 // ProcessState represents the current state of a system process.
-type ProcessState string
+type ProcessState = protocol.ProcessState
 
 // Process state constants
 const (
-	Running ProcessState = "running" // Process is running normally
-	Stop    ProcessState = "stop"    // Process is stopped
-	Error   ProcessState = "error"   // Process is in error state
+	Running = protocol.ProcessRunning // Process is running normally
+	Stop    = protocol.ProcessStop    // Process is stopped
+	Error   = protocol.ProcessError   // Process is in error state
 )
 
 // Status represents comprehensive system and application status information.
 // It includes CPU, memory, disk, network statistics, and Xray process status.
-type Status struct {
-	T           time.Time `json:"-"`
-	Cpu         float64   `json:"cpu"`
-	CpuCores    int       `json:"cpuCores"`
-	LogicalPro  int       `json:"logicalPro"`
-	CpuSpeedMhz float64   `json:"cpuSpeedMhz"`
-	Mem         struct {
-		Current uint64 `json:"current"`
-		Total   uint64 `json:"total"`
-	} `json:"mem"`
-	Swap struct {
-		Current uint64 `json:"current"`
-		Total   uint64 `json:"total"`
-	} `json:"swap"`
-	Disk struct {
-		Current uint64 `json:"current"`
-		Total   uint64 `json:"total"`
-	} `json:"disk"`
-	Xray struct {
-		State    ProcessState `json:"state"`
-		ErrorMsg string       `json:"errorMsg"`
-		Version  string       `json:"version"`
-	} `json:"xray"`
-	Uptime   uint64    `json:"uptime"`
-	Loads    []float64 `json:"loads"`
-	TcpCount int       `json:"tcpCount"`
-	UdpCount int       `json:"udpCount"`
-	NetIO    struct {
-		Up   uint64 `json:"up"`
-		Down uint64 `json:"down"`
-	} `json:"netIO"`
-	NetTraffic struct {
-		Sent uint64 `json:"sent"`
-		Recv uint64 `json:"recv"`
-	} `json:"netTraffic"`
-	PublicIP struct {
-		IPv4 string `json:"ipv4"`
-		IPv6 string `json:"ipv6"`
-	} `json:"publicIP"`
-	AppStats struct {
-		Threads uint32 `json:"threads"`
-		Mem     uint64 `json:"mem"`
-		Uptime  uint64 `json:"uptime"`
-	} `json:"appStats"`
-}
+type Status = protocol.Status
 
 // Release represents information about a software release from GitHub.
 type Release struct {
@@ -593,35 +550,8 @@ func (s *ServerService) RestartXrayService() error {
 }
 
 func (s *ServerService) downloadXRay(version string) (string, error) {
-	osName := runtime.GOOS
-	arch := runtime.GOARCH
-
-	switch osName {
-	case "darwin":
-		osName = "macos"
-	case "windows":
-		osName = "windows"
-	}
-
-	switch arch {
-	case "amd64":
-		arch = "64"
-	case "arm64":
-		arch = "arm64-v8a"
-	case "armv7":
-		arch = "arm32-v7a"
-	case "armv6":
-		arch = "arm32-v6"
-	case "armv5":
-		arch = "arm32-v5"
-	case "386":
-		arch = "32"
-	case "s390x":
-		arch = "s390x"
-	}
-
-	fileName := fmt.Sprintf("Xray-%s-%s.zip", osName, arch)
-	url := fmt.Sprintf("https://github.com/XTLS/Xray-core/releases/download/%s/%s", version, fileName)
+	fileName := xray.ReleaseAssetName(runtime.GOOS, runtime.GOARCH)
+	url := xray.ReleaseAssetURL(version, fileName)
 	resp, err := http.Get(url)
 	if err != nil {
 		return "", err
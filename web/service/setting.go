@@ -51,6 +51,7 @@ var defaultValueMap = map[string]string{
 	"tgLang":                      "en-US",
 	"twoFactorEnable":             "false",
 	"twoFactorToken":              "",
+	"metricsToken":                "",
 	"subEnable":                   "true",
 	"subJsonEnable":               "false",
 	"subTitle":                    "",
@@ -75,11 +76,25 @@ var defaultValueMap = map[string]string{
 	"subJsonNoises":               "",
 	"subJsonMux":                  "",
 	"subJsonRules":                "",
+	"subOfflineMode":              "show",
+	"subJsonOfflineMode":          "show",
+	"subOfflineGrace":             "5",
+	"subOfflineTag":               "[offline]",
+	"subOrder":                    "none",
+	"subLoadCpuWeight":            "1",
+	"subLoadMemWeight":            "1",
+	"subLoadClientsWeight":        "1",
+	"subLoadLatencyWeight":        "0",
 	"datepicker":                  "gregorian",
 	"warp":                        "",
 	"externalTrafficInformEnable": "false",
 	"externalTrafficInformURI":    "",
 	"xrayOutboundTestUrl":         "https://www.google.com/generate_204",
+	"slaveMtlsRequired":           "false",
+	"slaveDriftRepush":            "false",
+	"slaveLegacySecret":           "false",
+	"slaveCaCert":                 "",
+	"slaveCaKey":                  "",
 
 	// LDAP defaults
 	"ldapEnable":            "false",
@@ -374,6 +389,11 @@ func (s *SettingService) SetTwoFactorToken(value string) error {
 	return s.setString("twoFactorToken", value)
 }
 
+// GetMetricsToken returns the token Prometheus must send to read /metrics; empty disables the endpoint.
+func (s *SettingService) GetMetricsToken() (string, error) {
+	return s.getString("metricsToken")
+}
+
 func (s *SettingService) GetPort() (int, error) {
 	return s.getInt("webPort")
 }
@@ -571,6 +591,45 @@ func (s *SettingService) GetSubJsonRules() (string, error) {
 	return s.getString("subJsonRules")
 }
 
+func (s *SettingService) GetSubOfflineMode() (string, error) {
+	return s.getString("subOfflineMode")
+}
+
+func (s *SettingService) GetSubJsonOfflineMode() (string, error) {
+	return s.getString("subJsonOfflineMode")
+}
+
+func (s *SettingService) GetSubOfflineGrace() (int, error) {
+	return s.getInt("subOfflineGrace")
+}
+
+func (s *SettingService) GetSubOfflineTag() (string, error) {
+	return s.getString("subOfflineTag")
+}
+
+func (s *SettingService) GetSubOrder() (string, error) {
+	return s.getString("subOrder")
+}
+
+// GetSubLoadWeights returns how much each load figure counts when subscriptions are ordered by load.
+func (s *SettingService) GetSubLoadWeights() (SlaveLoadWeights, error) {
+	var weights SlaveLoadWeights
+	var err error
+	if weights.Cpu, err = s.getInt("subLoadCpuWeight"); err != nil {
+		return weights, err
+	}
+	if weights.Mem, err = s.getInt("subLoadMemWeight"); err != nil {
+		return weights, err
+	}
+	if weights.Clients, err = s.getInt("subLoadClientsWeight"); err != nil {
+		return weights, err
+	}
+	if weights.Latency, err = s.getInt("subLoadLatencyWeight"); err != nil {
+		return weights, err
+	}
+	return weights, nil
+}
+
 func (s *SettingService) GetDatepicker() (string, error) {
 	return s.getString("datepicker")
 }
@@ -591,6 +650,50 @@ func (s *SettingService) SetExternalTrafficInformEnable(value bool) error {
 	return s.setBool("externalTrafficInformEnable", value)
 }
 
+func (s *SettingService) GetSlaveMtlsRequired() (bool, error) {
+	return s.getBool("slaveMtlsRequired")
+}
+
+func (s *SettingService) SetSlaveMtlsRequired(value bool) error {
+	return s.setBool("slaveMtlsRequired", value)
+}
+
+func (s *SettingService) GetSlaveDriftRepush() (bool, error) {
+	return s.getBool("slaveDriftRepush")
+}
+
+func (s *SettingService) SetSlaveDriftRepush(value bool) error {
+	return s.setBool("slaveDriftRepush", value)
+}
+
+func (s *SettingService) GetSlaveLegacySecret() (bool, error) {
+	return s.getBool("slaveLegacySecret")
+}
+
+func (s *SettingService) SetSlaveLegacySecret(value bool) error {
+	return s.setBool("slaveLegacySecret", value)
+}
+
+// GetSlaveCA returns the PEM encoded cluster CA used to issue slave client certificates.
+func (s *SettingService) GetSlaveCA() (string, string, error) {
+	cert, err := s.getString("slaveCaCert")
+	if err != nil {
+		return "", "", err
+	}
+	key, err := s.getString("slaveCaKey")
+	if err != nil {
+		return "", "", err
+	}
+	return cert, key, nil
+}
+
+func (s *SettingService) SetSlaveCA(cert, key string) error {
+	if err := s.setString("slaveCaCert", cert); err != nil {
+		return err
+	}
+	return s.setString("slaveCaKey", key)
+}
+
 func (s *SettingService) GetExternalTrafficInformURI() (string, error) {
 	return s.getString("externalTrafficInformURI")
 }
@@ -1,16 +1,21 @@
 package service
 
 import (
+	"errors"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
 	ws "github.com/mhsanaei/3x-ui/v2/web/websocket"
 	"github.com/mhsanaei/3x-ui/v2/xray"
 	"gorm.io/gorm"
@@ -23,12 +28,38 @@ type SlaveService struct {
 
 // In-memory store for active connections
 var (
-	slaveConns      = make(map[int]*websocket.Conn)
+	slaveConns      = make(map[int]*protocol.Conn)
 	slaveLock       sync.RWMutex
 	slaveOnlineClients = make(map[int][]string) // Store online clients per slave
+	slavePendingConfigs = make(map[int]pendingConfig) // Last pushed, not yet acknowledged config per slave
 )
 
-func (s *SlaveService) AddSlaveConn(slaveId int, conn *websocket.Conn) {
+// ErrSlaveNotConnected is returned for operations on a slave that has no open connection.
+var ErrSlaveNotConnected = errors.New("slave is not connected")
+
+// pendingConfig is a config pushed to a slave that has not been acknowledged yet.
+type pendingConfig struct {
+	revision int64
+	config   string
+}
+
+// masterCapabilities lists the protocol features the master understands.
+var masterCapabilities = []string{
+	protocol.CapConfigFull,
+	protocol.CapRestartXray,
+	protocol.CapCertReport,
+	protocol.CapConfigAck,
+	protocol.CapConfigDelta,
+	protocol.CapTrafficSeq,
+	protocol.CapSecretRotate,
+	protocol.CapClientCert,
+	protocol.CapRpc,
+	protocol.CapLogTail,
+	protocol.CapClientIps,
+	protocol.CapConfigHash,
+}
+
+func (s *SlaveService) AddSlaveConn(slaveId int, conn *protocol.Conn) {
 	slaveLock.Lock()
 	defer slaveLock.Unlock()
 	if old, ok := slaveConns[slaveId]; ok {
@@ -50,18 +81,40 @@ func (s *SlaveService) RemoveSlaveConn(slaveId int) {
 	logger.Infof("Slave %d disconnected", slaveId)
 }
 
-func (s *SlaveService) PushConfig(slaveId int) error {
+// ReleaseSlaveConn closes conn and removes it if it is still the slave's current connection. A
+// slave that reconnected already replaced it, so the newer connection is left alone. It reports
+// whether conn was the current one.
+func (s *SlaveService) ReleaseSlaveConn(slaveId int, conn *protocol.Conn) bool {
+	slaveLock.Lock()
+	defer slaveLock.Unlock()
+	conn.Close()
+	if slaveConns[slaveId] != conn {
+		return false
+	}
+	delete(slaveConns, slaveId)
+	delete(slaveOnlineClients, slaveId)
+	logger.Infof("Slave %d disconnected", slaveId)
+	return true
+}
+
+// BuildSlaveConfig generates the complete Xray config for a slave from its template and inbounds.
+func (s *SlaveService) BuildSlaveConfig(slaveId int) (*xray.Config, error) {
 	// 1. Get the Full Template from Slave Settings (contains Log, API, DNS, Outbounds/Routing)
 	templateJson, err := s.SlaveSettingService.GetXrayConfigForSlave(slaveId)
 	if err != nil {
-		return fmt.Errorf("failed to get xray template config for slave %d: %v", slaveId, err)
+		return nil, fmt.Errorf("failed to get xray template config for slave %d: %v", slaveId, err)
 	}
 	logger.Infof("PushConfig: retrieved template for slave %d, length: %d", slaveId, len(templateJson))
+	return s.buildSlaveConfigFromTemplate(slaveId, templateJson)
+}
 
+// buildSlaveConfigFromTemplate generates the Xray config for a slave from the given template and
+// the slave's inbounds.
+func (s *SlaveService) buildSlaveConfigFromTemplate(slaveId int, templateJson string) (*xray.Config, error) {
 	// 2. Parse Template into xray.Config struct
 	var xrayConfig xray.Config
 	if err := json.Unmarshal([]byte(templateJson), &xrayConfig); err != nil {
-		return fmt.Errorf("failed to unmarshal xray template config: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal xray template config: %v", err)
 	}
 
 	// 3. Clean up config (remove helper fields like slaveId from routing/outbounds)
@@ -98,7 +151,12 @@ func (s *SlaveService) PushConfig(slaveId int) error {
 	// 4. Fetch Inbounds from Database for this Slave
 	inbounds, err := s.InboundService.GetInboundsForSlave(slaveId)
 	if err != nil {
-		return fmt.Errorf("failed to get inbounds for slave %d: %v", slaveId, err)
+		return nil, fmt.Errorf("failed to get inbounds for slave %d: %v", slaveId, err)
+	}
+
+	certNames, err := storedCertNames()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stored certificates: %v", err)
 	}
 
 	// 4. Convert DB Inbounds to Xray InboundConfigs and Append to Template's Inbounds
@@ -113,54 +171,341 @@ func (s *SlaveService) PushConfig(slaveId int) error {
 				filteredInbound = inbound
 			}
 			xrayInbound := filteredInbound.GenXrayInboundConfig()
+			// Certificates from the master's store are referenced by id and installed as files on the slave
+			if err := resolveStoredCerts(xrayInbound, certNames); err != nil {
+				return nil, err
+			}
 			xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, *xrayInbound)
 		}
 	}
 
-	// 5. Marshal the Final Config to JSON
+	return &xrayConfig, nil
+}
+
+func (s *SlaveService) PushConfig(slaveId int) (err error) {
+	if s.isXrayStoppedForDrain(slaveId) {
+		logger.Infof("PushConfig: Xray on slave %d is stopped for maintenance, config is pushed when it ends", slaveId)
+		return nil
+	}
+	defer func() { countConfigPush(slaveId, err) }()
+
+	// The slave must have the stored certificates before a config using them
+	if err := s.assignReferencedCerts(slaveId); err != nil {
+		return fmt.Errorf("failed to install certificates on slave %d: %v", slaveId, err)
+	}
+
+	xrayConfig, err := s.BuildSlaveConfig(slaveId)
+	if err != nil {
+		return err
+	}
+
+	// A config Xray rejects would leave the slave without a working Xray
+	if err := s.validateSlaveConfig(slaveId, xrayConfig); err != nil {
+		return err
+	}
+
+	// Marshal the Final Config to JSON
 	finalConfigBytes, err := json.Marshal(xrayConfig)
 	if err != nil {
 		return fmt.Errorf("failed to marshal final xray config: %v", err)
 	}
 
-	// 6. Send to Slave
-	data, err := json.Marshal(map[string]interface{}{
-		"type":   "update_config_full",
-		"config": string(finalConfigBytes),
-	})
+	// Assign the next revision; the master's intent changes even if the slave is offline
+	revision, err := s.nextConfigRevision(slaveId)
+	if err != nil {
+		return fmt.Errorf("failed to assign config revision for slave %d: %v", slaveId, err)
+	}
+
+	// Send to Slave
+	conn, err := s.getSlaveConn(slaveId)
 	if err != nil {
 		return err
 	}
 
-	slaveLock.RLock()
-	conn, ok := slaveConns[slaveId]
-	slaveLock.RUnlock()
+	// Prefer live changes through the slave's Xray API over a restart
+	if conn.Has(protocol.CapConfigDelta) {
+		if sent, err := s.pushConfigDelta(slaveId, conn, revision, xrayConfig, finalConfigBytes); err != nil {
+			return err
+		} else if sent {
+			return s.markConfigPushed(slaveId, conn, revision, finalConfigBytes)
+		}
+	}
 
-	if !ok {
-		return fmt.Errorf("slave %d not connected", slaveId)
+	logger.Infof("PushConfig: sending update_config_full revision %d to slave %d, config size: %d", revision, slaveId, len(finalConfigBytes))
+	if err := conn.Send(protocol.TypeUpdateConfigFull, "", protocol.ConfigFull{
+		Revision: revision,
+		Config:   string(finalConfigBytes),
+	}); err != nil {
+		return err
 	}
+	return s.markConfigPushed(slaveId, conn, revision, finalConfigBytes)
+}
 
-	logger.Infof("PushConfig: sending update_config_full to slave %d, payload size: %d", slaveId, len(data))
-	return conn.WriteMessage(websocket.TextMessage, data)
+// markConfigPushed remembers the config sent with revision until the slave acknowledges it.
+func (s *SlaveService) markConfigPushed(slaveId int, conn *protocol.Conn, revision int64, configBytes []byte) error {
+	applyStatus := model.ApplyStatusPending
+	if !conn.Has(protocol.CapConfigAck) {
+		applyStatus = model.ApplyStatusUnacknowledged
+	}
+
+	slaveLock.Lock()
+	slavePendingConfigs[slaveId] = pendingConfig{revision: revision, config: string(configBytes)}
+	slaveLock.Unlock()
+
+	return database.GetDB().Model(&model.Slave{}).Where("id = ?", slaveId).
+		Update("apply_status", applyStatus).Error
 }
 
-func (s *SlaveService) RestartSlaveXray(slaveId int) error {
-	data, err := json.Marshal(map[string]interface{}{
-		"type": "restart_xray",
+// nextConfigRevision increments and returns the desired config revision of a slave.
+func (s *SlaveService) nextConfigRevision(slaveId int) (int64, error) {
+	db := database.GetDB()
+	var revision int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Slave{}).Where("id = ?", slaveId).
+			Update("desired_revision", gorm.Expr("desired_revision + 1")).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.Slave{}).Where("id = ?", slaveId).
+			Select("desired_revision").Row().Scan(&revision)
 	})
+	return revision, err
+}
+
+// ProcessConfigApplied records a slave's acknowledgement of a config push.
+// A failed apply leaves AppliedRevision at the last revision that worked, since the slave rolls back to it.
+func (s *SlaveService) ProcessConfigApplied(slaveId int, result *protocol.ConfigApplied) error {
+	updates := map[string]interface{}{
+		"applied_at": time.Now().Unix(),
+	}
+
+	var problems []string
+	if result.Error != "" {
+		problems = append(problems, result.Error)
+	}
+	for _, inboundErr := range result.InboundErrors {
+		problems = append(problems, fmt.Sprintf("inbound %s (port %d): %s", inboundErr.Tag, inboundErr.Port, inboundErr.Error))
+	}
+	updates["apply_error"] = strings.Join(problems, "; ")
+
+	countConfigApplied(slaveId, result.Success)
+	if result.Success {
+		updates["applied_revision"] = result.Revision
+		updates["apply_status"] = model.ApplyStatusApplied
+		updates["config_drift"] = false
+		updates["drift_detected_at"] = 0
+		logger.Infof("Slave %d applied config revision %d", slaveId, result.Revision)
+
+		// Keep the acknowledged config as the base for the next delta
+		slaveLock.Lock()
+		pending, ok := slavePendingConfigs[slaveId]
+		if ok && pending.revision == result.Revision {
+			delete(slavePendingConfigs, slaveId)
+		}
+		slaveLock.Unlock()
+		if ok && pending.revision == result.Revision {
+			if err := s.SlaveSettingService.SaveAppliedConfigForSlave(slaveId, pending.revision, pending.config); err != nil {
+				logger.Warningf("Failed to store applied config for slave %d: %v", slaveId, err)
+			}
+		}
+	} else {
+		updates["apply_status"] = model.ApplyStatusFailed
+		s.alertf(slaveId, "Slave %d failed to apply config revision %d: %s", slaveId, result.Revision, updates["apply_error"])
+	}
+
+	// Ignore acknowledgements of pushes that have since been superseded
+	return database.GetDB().Model(&model.Slave{}).
+		Where("id = ? AND desired_revision <= ?", slaveId, result.Revision).
+		Updates(updates).Error
+}
+
+func (s *SlaveService) RestartSlaveXray(slaveId int) error {
+	conn, err := s.getSlaveConn(slaveId)
 	if err != nil {
 		return err
 	}
+	return conn.Send(protocol.TypeRestartXray, "", nil)
+}
 
+// getSlaveConn returns the active connection of a slave.
+func (s *SlaveService) getSlaveConn(slaveId int) (*protocol.Conn, error) {
 	slaveLock.RLock()
 	conn, ok := slaveConns[slaveId]
 	slaveLock.RUnlock()
 
 	if !ok {
-		return fmt.Errorf("slave %d not connected", slaveId)
+		return nil, fmt.Errorf("%w: %d", ErrSlaveNotConnected, slaveId)
+	}
+	return conn, nil
+}
+
+// HandleHello completes the protocol handshake with a slave and acknowledges the negotiated version.
+// On version mismatch the slave is told why before the error is returned, so the caller can drop it.
+// tlsState is the TLS state of the connect request, whose client certificate decides whether the
+// slave is issued a new one.
+func (s *SlaveService) HandleHello(slaveId int, conn *protocol.Conn, env *protocol.Envelope, tlsState *tls.ConnectionState) error {
+	var hello protocol.Hello
+	if err := env.DecodePayload(&hello); err != nil {
+		conn.SendError(env.RequestId, protocol.ErrCodeBadPayload, err.Error())
+		return err
+	}
+
+	version, err := protocol.Negotiate(&hello)
+	if err != nil {
+		conn.SendError(env.RequestId, protocol.ErrCodeUnsupportedVersion, err.Error())
+		return err
+	}
+
+	conn.SetNegotiated(version, hello.Capabilities)
+	logger.Infof("Slave %d handshake: protocol v%d (slave supports v%d-v%d), capabilities: %v, 3x-ui %s, running revision %d",
+		slaveId, version, hello.MinProtocolVersion, hello.ProtocolVersion, hello.Capabilities, hello.UIVersion, hello.AppliedRevision)
+
+	// The slave may have restarted from its cached config while we were away. Trust what it
+	// reports it is running so the next push diffs against the right base or goes out in full.
+	if err := s.reconcileAppliedRevision(slaveId, hello.AppliedRevision); err != nil {
+		logger.Warningf("Failed to reconcile config revision of slave %d: %v", slaveId, err)
+	}
+
+	var slave model.Slave
+	if err := database.GetDB().Select("traffic_seq").First(&slave, slaveId).Error; err != nil {
+		return err
+	}
+
+	if err := conn.Send(protocol.TypeHelloAck, env.RequestId, protocol.HelloAck{
+		ProtocolVersion: version,
+		Capabilities:    masterCapabilities,
+		TrafficSeq:      slave.TrafficSeq,
+	}); err != nil {
+		return err
+	}
+
+	if err := s.syncCredentials(slaveId, conn, tlsState); err != nil {
+		logger.Warningf("Failed to update credentials of slave %d: %v", slaveId, err)
+	}
+
+	// Waits for the slave's answer, which arrives through this connection's read loop
+	if conn.Has(protocol.CapRpc) {
+		go s.syncGeodataOnConnect(slaveId, hello.Geodata)
+		go s.syncStoredCertsOnConnect(slaveId)
 	}
+	return nil
+}
 
-	return conn.WriteMessage(websocket.TextMessage, data)
+// reconcileAppliedRevision records the revision a reconnecting slave reports it is running.
+func (s *SlaveService) reconcileAppliedRevision(slaveId int, revision int64) error {
+	db := database.GetDB()
+	var slave model.Slave
+	if err := db.Select("applied_revision").First(&slave, slaveId).Error; err != nil {
+		return err
+	}
+	if slave.AppliedRevision == revision {
+		return nil
+	}
+	logger.Infof("Slave %d is running config revision %d, expected %d", slaveId, revision, slave.AppliedRevision)
+	return db.Model(&model.Slave{}).Where("id = ?", slaveId).Update("applied_revision", revision).Error
+}
+
+// HandleMessage dispatches a message received from a slave after the handshake.
+// Messages of unknown type are rejected instead of being mistaken for system stats.
+func (s *SlaveService) HandleMessage(slaveId int, conn *protocol.Conn, env *protocol.Envelope) error {
+	// Legacy slaves send their heartbeat untyped. Once a version is negotiated
+	// an untyped frame is an error rather than something to store as stats.
+	if env.Type == "" {
+		if conn.Version() > protocol.LegacyVersion {
+			conn.SendError(env.RequestId, protocol.ErrCodeUnknownType, "message has no type")
+			return fmt.Errorf("slave %d sent an untyped message after handshake", slaveId)
+		}
+		env.Type = protocol.TypeSystemStats
+	}
+
+	switch env.Type {
+	case protocol.TypeSystemStats:
+		var stats protocol.SystemStats
+		if err := env.DecodePayload(&stats); err != nil {
+			conn.SendError(env.RequestId, protocol.ErrCodeBadPayload, err.Error())
+			return err
+		}
+		return s.UpdateSlaveStatus(slaveId, "online", &stats)
+
+	case protocol.TypeTrafficStats:
+		var stats protocol.TrafficStats
+		if err := env.DecodePayload(&stats); err != nil {
+			conn.SendError(env.RequestId, protocol.ErrCodeBadPayload, err.Error())
+			return err
+		}
+		if err := s.ProcessTrafficStats(slaveId, &stats); err != nil {
+			return err
+		}
+		if stats.Seq > 0 && conn.Has(protocol.CapTrafficSeq) {
+			return conn.Send(protocol.TypeTrafficAck, env.RequestId, protocol.TrafficAck{Seq: stats.Seq})
+		}
+		return nil
+
+	case protocol.TypeSecretRotated:
+		return s.promoteSecret(slaveId)
+
+	case protocol.TypeCertReport:
+		var report protocol.CertReport
+		if err := env.DecodePayload(&report); err != nil {
+			conn.SendError(env.RequestId, protocol.ErrCodeBadPayload, err.Error())
+			return err
+		}
+		return s.ProcessCertReport(slaveId, &report)
+
+	case protocol.TypeConfigApplied:
+		var result protocol.ConfigApplied
+		if err := env.DecodePayload(&result); err != nil {
+			conn.SendError(env.RequestId, protocol.ErrCodeBadPayload, err.Error())
+			return err
+		}
+		return s.ProcessConfigApplied(slaveId, &result)
+
+	case protocol.TypeRpcResponse:
+		delivered, err := conn.Deliver(env)
+		if err != nil {
+			return err
+		}
+		if !delivered {
+			logger.Debugf("Slave %d answered call %s after it timed out", slaveId, env.RequestId)
+		}
+		return nil
+
+	case protocol.TypeRpcRequest:
+		// The master offers no methods to slaves
+		return conn.Reply(env.RequestId, nil, &protocol.RpcError{
+			Code:    protocol.ErrCodeMethodNotFound,
+			Message: "master accepts no remote calls",
+		})
+
+	case protocol.TypeLogLines:
+		var lines protocol.LogLines
+		if err := env.DecodePayload(&lines); err != nil {
+			conn.SendError(env.RequestId, protocol.ErrCodeBadPayload, err.Error())
+			return err
+		}
+		ws.BroadcastSlaveLogs(SlaveLogLines{SlaveId: slaveId, LogLines: lines})
+		return nil
+
+	case protocol.TypeClientIps:
+		var report protocol.ClientIps
+		if err := env.DecodePayload(&report); err != nil {
+			conn.SendError(env.RequestId, protocol.ErrCodeBadPayload, err.Error())
+			return err
+		}
+		return s.ProcessClientIps(slaveId, &report)
+
+	case protocol.TypeError:
+		var payload protocol.ErrorPayload
+		if err := env.DecodePayload(&payload); err == nil {
+			logger.Warningf("Slave %d rejected a message: %s: %s", slaveId, payload.Code, payload.Message)
+		}
+		return nil
+
+	default:
+		if conn.Version() > protocol.LegacyVersion {
+			conn.SendError(env.RequestId, protocol.ErrCodeUnknownType, fmt.Sprintf("unknown message type %q", env.Type))
+		}
+		return fmt.Errorf("slave %d sent unknown message type %q", slaveId, env.Type)
+	}
 }
 
 func (s *SlaveService) GetAllSlaves() ([]*model.Slave, error) {
@@ -170,15 +515,38 @@ func (s *SlaveService) GetAllSlaves() ([]*model.Slave, error) {
 	return slaves, err
 }
 
-func (s *SlaveService) GetAllSlavesWithTraffic() ([]map[string]interface{}, error) {
+// GetAllSlavesWithTraffic lists the slaves matching selector (all of them when it is empty).
+func (s *SlaveService) GetAllSlavesWithTraffic(selector string) ([]map[string]interface{}, error) {
 	db := database.GetDB()
+	query := db.Model(model.Slave{})
+	if selector != "" {
+		ids, err := s.SelectSlaves(selector)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("id IN ?", ids)
+	}
 	var slaves []*model.Slave
-	if err := db.Model(model.Slave{}).Find(&slaves).Error; err != nil {
+	if err := query.Find(&slaves).Error; err != nil {
+		return nil, err
+	}
+	groups, err := slaveGroupNames(db)
+	if err != nil {
 		return nil, err
 	}
 
 	result := make([]map[string]interface{}, len(slaves))
 	for i, slave := range slaves {
+		// Protocol details are only known while the slave is connected
+		protocolVersion := -1
+		var capabilities []string
+		slaveLock.RLock()
+		if conn, ok := slaveConns[slave.Id]; ok {
+			protocolVersion = conn.Version()
+			capabilities = conn.Capabilities()
+		}
+		slaveLock.RUnlock()
+
 		// Get traffic stats from inbounds table
 		var totalUplink, totalDownlink int64
 		type TrafficSum struct {
@@ -206,6 +574,26 @@ func (s *SlaveService) GetAllSlavesWithTraffic() ([]map[string]interface{}, erro
 			"systemStats":  slave.SystemStats,
 			"totalUplink":  totalUplink,
 			"totalDownlink": totalDownlink,
+			"protocolVersion": protocolVersion,
+			"capabilities":    capabilities,
+			"desiredRevision": slave.DesiredRevision,
+			"appliedRevision": slave.AppliedRevision,
+			"applyStatus":     slave.ApplyStatus,
+			"applyError":      slave.ApplyError,
+			"appliedAt":       slave.AppliedAt,
+			"hasClientCert":   slave.CertFingerprint != "",
+			"secretRotating":  slave.NextSecret != "",
+			"geodata":         slave.Geodata,
+			"labels":          ParseSlaveLabels(slave.Labels),
+			"weight":          slave.Weight,
+			"addressSource":   slave.AddressSource,
+			"observedAddress": slave.ObservedAddress,
+			"groups":          groups[slave.Id],
+			"draining":        slave.Draining,
+			"drainStartedAt":  slave.DrainStartedAt,
+			"drainXrayStopAt": slave.DrainXrayStopAt,
+			"configDrift":     slave.ConfigDrift,
+			"driftDetectedAt": slave.DriftDetectedAt,
 		}
 	}
 
@@ -219,32 +607,25 @@ func (s *SlaveService) GetSlave(id int) (*model.Slave, error) {
 	return &slave, err
 }
 
-func (s *SlaveService) GetSlaveBySecret(secret string) (*model.Slave, error) {
-	db := database.GetDB()
-	var slave model.Slave
-	err := db.Where("secret = ?", secret).First(&slave).Error
-	return &slave, err
-}
-
 func (s *SlaveService) AddSlave(slave *model.Slave) error {
 	// Auto-generate secret if not provided
 	if slave.Secret == "" {
-		slave.Secret = generateRandomSecret(32)
+		slave.Secret = random.Seq(32)
 	}
 	slave.Status = "offline"
 	slave.LastSeen = time.Now().Unix()
+	slave.DesiredRevision = 0
+	slave.AppliedRevision = 0
+	slave.ApplyStatus = ""
+	slave.ApplyError = ""
 	
 	db := database.GetDB()
-	return db.Create(slave).Error
-}
-
-func generateRandomSecret(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[time.Now().UnixNano()%int64(len(charset))]
+	if err := db.Create(slave).Error; err != nil {
+		return err
 	}
-	return string(b)
+	// A new slave may match the selector of a replicated inbound
+	go resyncReplicatedInbounds()
+	return nil
 }
 
 func (s *SlaveService) DeleteSlave(id int) error {
@@ -319,6 +700,30 @@ func (s *SlaveService) DeleteSlave(id int) error {
 			return err
 		}
 		
+		// 6b. Delete geofile assignments
+		if err := tx.Where("slave_id = ?", id).Delete(&model.SlaveGeofile{}).Error; err != nil {
+			logger.Errorf("Failed to delete geofile assignments for slave %d: %v", id, err)
+			return err
+		}
+
+		// 6c. Delete stored certificate assignments
+		if err := tx.Where("slave_id = ?", id).Delete(&model.SlaveStoredCert{}).Error; err != nil {
+			logger.Errorf("Failed to delete certificate assignments for slave %d: %v", id, err)
+			return err
+		}
+
+		// 6d. Remove the slave from its groups
+		if err := tx.Where("slave_id = ?", id).Delete(&model.SlaveGroupMember{}).Error; err != nil {
+			logger.Errorf("Failed to delete group memberships for slave %d: %v", id, err)
+			return err
+		}
+
+		// 6e. Drop its replicated inbound overrides
+		if err := tx.Where("slave_id = ?", id).Delete(&model.ReplicaOverride{}).Error; err != nil {
+			logger.Errorf("Failed to delete replica overrides for slave %d: %v", id, err)
+			return err
+		}
+
 		// 7. Finally, delete the slave itself
 		logger.Infof("Deleting slave record %d", id)
 		if err := tx.Delete(&model.Slave{}, id).Error; err != nil {
@@ -331,53 +736,63 @@ func (s *SlaveService) DeleteSlave(id int) error {
 		go func() {
 			s.RemoveSlaveConn(id)
 		}()
+		forgetSlaveHistory(id)
 		
 		logger.Infof("Successfully completed cascade delete for slave %d", id)
 		return nil
 	})
 }
 
-func (s *SlaveService) UpdateSlaveStatus(id int, status string, stats string) error {
+// UpdateSlaveStatus stores the slave's status and latest heartbeat. A nil stats clears SystemStats.
+func (s *SlaveService) UpdateSlaveStatus(id int, status string, stats *protocol.SystemStats) error {
     db := database.GetDB()
     
     updates := map[string]interface{}{
         "status":      status,
-        "systemStats": stats,
+        "systemStats": "",
         "lastSeen":    time.Now().Unix(),
     }
     
-    // Extract address from stats JSON if present
-    if stats != "" {
-        var statsData map[string]interface{}
-        if err := json.Unmarshal([]byte(stats), &statsData); err == nil {
-            if address, ok := statsData["address"].(string); ok && address != "" {
+    if stats != nil {
+        statsJson, err := json.Marshal(stats)
+        if err != nil {
+            return err
+        }
+        updates["systemStats"] = string(statsJson)
+        s.recordSlaveSample(id, stats)
+
+        // Subscription links point at the address, so a change is worth a warning
+        var slave model.Slave
+        if err := db.Select("id", "address", "address_source", "observed_address").First(&slave, id).Error; err == nil {
+            if address := s.resolveSlaveAddress(&slave, stats.Address); address != slave.Address {
+                s.warnAddressChange(&slave, address)
                 updates["address"] = address
             }
-            
-            // Extract versions if present
-            xrayVersion, _ := statsData["xrayVersion"].(string)
-            uiVersion, _ := statsData["uiVersion"].(string)
-            
-            if xrayVersion != "" || uiVersion != "" {
-                if xrayVersion == "" { xrayVersion = "Unknown" }
-                if uiVersion == "" { uiVersion = "Unknown" }
-                updates["version"] = fmt.Sprintf("Xray: %s / 3x-ui: %s", xrayVersion, uiVersion)
-            }
+        }
+        
+        // Extract versions if present
+        xrayVersion := stats.XrayVersion
+        uiVersion := stats.UIVersion
+        
+        if xrayVersion != "" || uiVersion != "" {
+            if xrayVersion == "" { xrayVersion = "Unknown" }
+            if uiVersion == "" { uiVersion = "Unknown" }
+            updates["version"] = fmt.Sprintf("Xray: %s / 3x-ui: %s", xrayVersion, uiVersion)
         }
     }
     
     return db.Model(&model.Slave{}).Where("id = ?", id).Updates(updates).Error
 }
 
-func (s *SlaveService) ProcessTrafficStats(slaveId int, data map[string]interface{}) error {
+func (s *SlaveService) ProcessTrafficStats(slaveId int, data *protocol.TrafficStats) error {
 	db := database.GetDB()
 	now := time.Now()
 
 	// Process online clients list
-	if onlineClients, ok := data["online_clients"].([]interface{}); ok {
-		clients := make([]string, 0, len(onlineClients))
-		for _, client := range onlineClients {
-			if email, ok := client.(string); ok && email != "" {
+	if data.OnlineClients != nil {
+		clients := make([]string, 0, len(data.OnlineClients))
+		for _, email := range data.OnlineClients {
+			if email != "" {
 				clients = append(clients, email)
 			}
 		}
@@ -390,165 +805,31 @@ func (s *SlaveService) ProcessTrafficStats(slaveId int, data map[string]interfac
 		logger.Debugf("Updated online clients for slave %d: %d clients", slaveId, len(clients))
 	}
 
-	// Process inbound traffic stats
-	if inbounds, ok := data["inbounds"].(map[string]interface{}); ok {
-		logger.Infof("ProcessTrafficStats: Processing %d inbounds for slave %d", len(inbounds), slaveId)
-		
-		for inboundTag, statsInterface := range inbounds {
-			stats, ok := statsInterface.(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			uplink, _ := stats["uplink"].(float64)
-			downlink, _ := stats["downlink"].(float64)
-
-			// Update inbounds table directly
-			result := db.Model(&model.Inbound{}).
-				Where("tag = ? AND slave_id = ?", inboundTag, slaveId).
-				Updates(map[string]interface{}{
-					"up":       gorm.Expr("up + ?", int64(uplink)),
-					"down":     gorm.Expr("down + ?", int64(downlink)),
-					"all_time": gorm.Expr("COALESCE(all_time, 0) + ?", int64(uplink+downlink)),
-				})
-
-			if result.Error != nil {
-				logger.Errorf("Failed to update inbound traffic: slave=%d, tag=%s, error=%v",
-					slaveId, inboundTag, result.Error)
-			} else {
-				logger.Infof("Updated inbound traffic: slave=%d, tag=%s, up=%d, down=%d, rows=%d",
-					slaveId, inboundTag, int64(uplink), int64(downlink), result.RowsAffected)
-			}
-		}
-	}
-
-	// Process user traffic stats
-
-	
-	if users, ok := data["users"].([]interface{}); ok {
-		logger.Infof("ProcessTrafficStats: Processing %d users for slave %d", len(users), slaveId)
-		
-		for _, userInterface := range users {
-			userData, ok := userInterface.(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			email, _ := userData["email"].(string)
-			uplink, _ := userData["uplink"].(float64)
-			downlink, _ := userData["downlink"].(float64)
-
-			if email == "" || (uplink == 0 && downlink == 0) {
-				continue
-			}
-
-			// Update client traffic
-			var clientTraffic xray.ClientTraffic
-			result := db.Where("email = ?", email).First(&clientTraffic)
-
-			if result.Error == nil {
-				// Update existing client
-				clientTraffic.Up += int64(uplink)
-				clientTraffic.Down += int64(downlink)
-				clientTraffic.AllTime += int64(uplink) + int64(downlink)
-				clientTraffic.LastOnline = now.Unix()
-				db.Save(&clientTraffic)
-
-
-
-				logger.Infof("Updated user traffic: email=%s, up=%d, down=%d, inbound_id=%d",
-					email, int64(uplink), int64(downlink), clientTraffic.InboundId)
-			} else {
-				logger.Debugf("User not found in database: %s", email)
-			}
-		}
-		
-		// Sync account traffic: aggregate from all clients belonging to each account
-		accountTrafficMap := make(map[int]struct {
-			Up   int64
-			Down int64
-		})
-		
-		for _, userInterface := range users {
-			userData, ok := userInterface.(map[string]interface{})
-			if !ok {
-				continue
+	// Count the deltas and record the sequence number atomically, so a report replayed
+	// after a crash or reconnect is either fully counted once or ignored
+	duplicate := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if data.Seq > 0 {
+			var slave model.Slave
+			if err := tx.Select("traffic_seq").First(&slave, slaveId).Error; err != nil {
+				return err
 			}
-			
-			email, _ := userData["email"].(string)
-			if email == "" {
-				continue
+			if data.Seq <= slave.TrafficSeq {
+				duplicate = true
+				return nil
 			}
-			
-			// Get account association
-			var clientTraffic xray.ClientTraffic
-			if err := db.Where("email = ?", email).First(&clientTraffic).Error; err == nil && clientTraffic.AccountId > 0 {
-				uplink, _ := userData["uplink"].(float64)
-				downlink, _ := userData["downlink"].(float64)
-				
-				at := accountTrafficMap[clientTraffic.AccountId]
-				at.Up += int64(uplink)
-				at.Down += int64(downlink)
-				accountTrafficMap[clientTraffic.AccountId] = at
-			}
-		}
-		
-		// Update account traffic by aggregating from all its clients
-		for accountId := range accountTrafficMap {
-			var totalUp, totalDown int64
-			err := db.Model(&xray.ClientTraffic{}).
-				Select("COALESCE(SUM(up), 0) as up, COALESCE(SUM(down), 0) as down").
-				Where("account_id = ?", accountId).
-				Row().Scan(&totalUp, &totalDown)
-			if err == nil {
-				db.Model(&model.Account{}).Where("id = ?", accountId).
-					Updates(map[string]interface{}{
-						"up":        totalUp,
-						"down":      totalDown,
-						"updatedAt": now.UnixMilli(),
-					})
-				logger.Debugf("Updated account %d traffic: up=%d, down=%d", accountId, totalUp, totalDown)
+			if err := tx.Model(&model.Slave{}).Where("id = ?", slaveId).Update("traffic_seq", data.Seq).Error; err != nil {
+				return err
 			}
 		}
+		return s.addTrafficDeltas(tx, slaveId, data, now)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record traffic of slave %d: %v", slaveId, err)
 	}
-
-
-
-	// Process outbound traffic stats
-	if outbounds, ok := data["outbounds"].(map[string]interface{}); ok {
-		logger.Infof("ProcessTrafficStats: Processing %d outbounds for slave %d", len(outbounds), slaveId)
-		
-		for outboundTag, statsInterface := range outbounds {
-			stats, ok := statsInterface.(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			uplink, _ := stats["uplink"].(float64)
-			downlink, _ := stats["downlink"].(float64)
-
-			if uplink == 0 && downlink == 0 {
-				continue
-			}
-
-			// Update or create outbound traffic record
-			var outbound model.OutboundTraffics
-			result := db.Where("tag = ? AND slave_id = ?", outboundTag, slaveId).
-				FirstOrCreate(&outbound, model.OutboundTraffics{Tag: outboundTag, SlaveId: slaveId})
-
-			if result.Error == nil {
-				outbound.Up += int64(uplink)
-				outbound.Down += int64(downlink)
-				outbound.Total = outbound.Up + outbound.Down
-				db.Save(&outbound)
-
-				logger.Infof("Updated outbound traffic: slave=%d, tag=%s, up=%d, down=%d, total=%d",
-					slaveId, outboundTag, int64(uplink), int64(downlink), outbound.Total)
-			} else {
-				logger.Errorf("Failed to update outbound traffic: slave=%d, tag=%s, error=%v",
-					slaveId, outboundTag, result.Error)
-			}
-		}
+	if duplicate {
+		logger.Infof("ProcessTrafficStats: ignoring already counted report %d from slave %d", data.Seq, slaveId)
+		return nil
 	}
 
 	// Check and disable clients that exceeded traffic or expiry limits
@@ -565,6 +846,27 @@ func (s *SlaveService) ProcessTrafficStats(slaveId int, data map[string]interfac
 		needConfigPush = true
 	}
 	
+	// Replicated inbounds and clients are limited on the traffic of all their replicas together
+	replicatedService := ReplicatedInboundService{}
+	replicaSlaves, err := replicatedService.DisableExhaustedReplicas(db)
+	if err != nil {
+		logger.Warning("Error checking replicated inbound limits:", err)
+	} else if len(replicaSlaves) > 0 {
+		if slices.Contains(replicaSlaves, slaveId) {
+			needConfigPush = true
+		}
+		otherSlaves := slices.DeleteFunc(replicaSlaves, func(id int) bool { return id == slaveId })
+		if len(otherSlaves) > 0 {
+			go func() {
+				for _, result := range s.PushConfigToSlaves(otherSlaves) {
+					if !result.Success {
+						logger.Warningf("Failed to push config to slave %d after disabling replicated clients: %s", result.SlaveId, result.Error)
+					}
+				}
+			}()
+		}
+	}
+
 	// 2. Check account-level traffic limits
 	trafficLimitSlaves, err := accountService.DisableClientsExceedingAccountLimit()
 	if err != nil {
@@ -652,34 +954,181 @@ func (s *SlaveService) ProcessTrafficStats(slaveId int, data map[string]interfac
 	return nil
 }
 
+// addTrafficDeltas adds a traffic report's counters to inbounds, clients, accounts and outbounds.
+// It stops at the first failed write, so the caller rolls the whole report back and does not
+// acknowledge it.
+func (s *SlaveService) addTrafficDeltas(tx *gorm.DB, slaveId int, data *protocol.TrafficStats, now time.Time) error {
+	// Process inbound traffic stats
+	if len(data.Inbounds) > 0 {
+		logger.Infof("ProcessTrafficStats: Processing %d inbounds for slave %d", len(data.Inbounds), slaveId)
+		
+		for inboundTag, stats := range data.Inbounds {
+			uplink := stats.Uplink
+			downlink := stats.Downlink
+
+			// Update inbounds table directly
+			result := tx.Model(&model.Inbound{}).
+				Where("tag = ? AND slave_id = ?", inboundTag, slaveId).
+				Updates(map[string]interface{}{
+					"up":       gorm.Expr("up + ?", uplink),
+					"down":     gorm.Expr("down + ?", downlink),
+					"all_time": gorm.Expr("COALESCE(all_time, 0) + ?", uplink+downlink),
+				})
+
+			if result.Error != nil {
+				return fmt.Errorf("failed to update traffic of inbound %s: %w", inboundTag, result.Error)
+			}
+			logger.Infof("Updated inbound traffic: slave=%d, tag=%s, up=%d, down=%d, rows=%d",
+				slaveId, inboundTag, uplink, downlink, result.RowsAffected)
+		}
+	}
+
+	// Process user traffic stats
+
+	
+	if len(data.Users) > 0 {
+		users := data.Users
+		logger.Infof("ProcessTrafficStats: Processing %d users for slave %d", len(users), slaveId)
+		
+		for _, userData := range users {
+			email := userData.Email
+			uplink := userData.Uplink
+			downlink := userData.Downlink
+
+			if email == "" || (uplink == 0 && downlink == 0) {
+				continue
+			}
+
+			// Update client traffic
+			var clientTraffic xray.ClientTraffic
+			result := tx.Where("email = ?", email).First(&clientTraffic)
+
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				logger.Debugf("User not found in database: %s", email)
+				continue
+			}
+			if result.Error != nil {
+				return fmt.Errorf("failed to load traffic of client %s: %w", email, result.Error)
+			}
+			// Update existing client
+			clientTraffic.Up += uplink
+			clientTraffic.Down += downlink
+			clientTraffic.AllTime += uplink + downlink
+			clientTraffic.LastOnline = now.Unix()
+			if err := tx.Save(&clientTraffic).Error; err != nil {
+				return fmt.Errorf("failed to update traffic of client %s: %w", email, err)
+			}
+			logger.Infof("Updated user traffic: email=%s, up=%d, down=%d, inbound_id=%d",
+				email, uplink, downlink, clientTraffic.InboundId)
+		}
+		
+		// Sync account traffic: aggregate from all clients belonging to each account
+		accountTrafficMap := make(map[int]struct {
+			Up   int64
+			Down int64
+		})
+		
+		for _, userData := range users {
+			email := userData.Email
+			if email == "" {
+				continue
+			}
+			
+			// Get account association
+			var clientTraffic xray.ClientTraffic
+			err := tx.Where("email = ?", email).First(&clientTraffic).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("failed to load traffic of client %s: %w", email, err)
+			}
+			if err == nil && clientTraffic.AccountId > 0 {
+				at := accountTrafficMap[clientTraffic.AccountId]
+				at.Up += userData.Uplink
+				at.Down += userData.Downlink
+				accountTrafficMap[clientTraffic.AccountId] = at
+			}
+		}
+		
+		// Update account traffic by aggregating from all its clients
+		for accountId := range accountTrafficMap {
+			var totalUp, totalDown int64
+			err := tx.Model(&xray.ClientTraffic{}).
+				Select("COALESCE(SUM(up), 0) as up, COALESCE(SUM(down), 0) as down").
+				Where("account_id = ?", accountId).
+				Row().Scan(&totalUp, &totalDown)
+			if err != nil {
+				return fmt.Errorf("failed to sum traffic of account %d: %w", accountId, err)
+			}
+			err = tx.Model(&model.Account{}).Where("id = ?", accountId).
+				Updates(map[string]interface{}{
+					"up":        totalUp,
+					"down":      totalDown,
+					"updatedAt": now.UnixMilli(),
+				}).Error
+			if err != nil {
+				return fmt.Errorf("failed to update traffic of account %d: %w", accountId, err)
+			}
+			logger.Debugf("Updated account %d traffic: up=%d, down=%d", accountId, totalUp, totalDown)
+		}
+	}
+
+
+
+	// Process outbound traffic stats
+	if len(data.Outbounds) > 0 {
+		logger.Infof("ProcessTrafficStats: Processing %d outbounds for slave %d", len(data.Outbounds), slaveId)
+		
+		for outboundTag, stats := range data.Outbounds {
+			uplink := stats.Uplink
+			downlink := stats.Downlink
+
+			if uplink == 0 && downlink == 0 {
+				continue
+			}
+
+			// Update or create outbound traffic record
+			var outbound model.OutboundTraffics
+			result := tx.Where("tag = ? AND slave_id = ?", outboundTag, slaveId).
+				FirstOrCreate(&outbound, model.OutboundTraffics{Tag: outboundTag, SlaveId: slaveId})
+
+			if result.Error != nil {
+				return fmt.Errorf("failed to load traffic of outbound %s: %w", outboundTag, result.Error)
+			}
+			outbound.Up += uplink
+			outbound.Down += downlink
+			outbound.Total = outbound.Up + outbound.Down
+			if err := tx.Save(&outbound).Error; err != nil {
+				return fmt.Errorf("failed to update traffic of outbound %s: %w", outboundTag, err)
+			}
+			logger.Infof("Updated outbound traffic: slave=%d, tag=%s, up=%d, down=%d, total=%d",
+				slaveId, outboundTag, uplink, downlink, outbound.Total)
+		}
+	}
+	return nil
+}
+
+// GenerateInstallCommand returns an install command for an existing slave. It carries a single-use
+// enrollment token instead of the slave's secret; enrolling gives the slave a new secret.
 func (s *SlaveService) GenerateInstallCommand(slaveId int, req *http.Request, basePath string) (string, error) {
-	slave, err := s.GetSlave(slaveId)
+	token, _, err := s.CreateEnrollToken("", 0, 0, slaveId, 0)
 	if err != nil {
 		return "", err
 	}
-	
-	// Get master server address from request
-	scheme := "http"
-	if req.TLS != nil {
-		scheme = "https"
+	return buildInstallCommand(req, basePath, token), nil
+}
+
+// GenerateEnrollCommand returns an install command that enrolls a new slave with the given presets.
+func (s *SlaveService) GenerateEnrollCommand(name string, templateSlaveId int, groupId int, ttl time.Duration, req *http.Request, basePath string) (string, *model.SlaveEnrollToken, error) {
+	token, record, err := s.CreateEnrollToken(name, templateSlaveId, groupId, 0, ttl)
+	if err != nil {
+		return "", nil, err
 	}
-	host := req.Host
-	
-	// Build the full URL with basePath
-	// basePath already includes leading and trailing slashes (e.g., "/ixUwrIpIWgOzE7ZS9w/")
-	masterUrl := fmt.Sprintf("%s://%s%s", scheme, host, basePath)
-	
-	// Generate install command
-	command := fmt.Sprintf("bash <(curl -Ls https://raw.githubusercontent.com/Copperchaleu/3x-ui-cluster/main/install.sh) slave %s %s",
-		masterUrl, slave.Secret)
-	
-	return command, nil
+	return buildInstallCommand(req, basePath, token), record, nil
 }
 
 // ProcessCertReport processes certificate information reported by slave
-func (s *SlaveService) ProcessCertReport(slaveId int, data map[string]interface{}) error {
-	certs, ok := data["certs"].([]interface{})
-	if !ok || len(certs) == 0 {
+func (s *SlaveService) ProcessCertReport(slaveId int, data *protocol.CertReport) error {
+	certs := data.Certs
+	if len(certs) == 0 {
 		logger.Debugf("No certificates in report from slave %d", slaveId)
 		return nil
 	}
@@ -689,16 +1138,11 @@ func (s *SlaveService) ProcessCertReport(slaveId int, data map[string]interface{
 	certService := SlaveCertService{}
 	var certModels []model.SlaveCert
 	
-	for _, certInterface := range certs {
-		certData, ok := certInterface.(map[string]interface{})
-		if !ok {
-			continue
-		}
-		
-		domain, _ := certData["domain"].(string)
-		certPath, _ := certData["certPath"].(string)
-		keyPath, _ := certData["keyPath"].(string)
-		expiryTime, _ := certData["expiryTime"].(float64)
+	for _, certData := range certs {
+		domain := certData.Domain
+		certPath := certData.CertPath
+		keyPath := certData.KeyPath
+		expiryTime := certData.ExpiryTime
 		
 		if domain == "" || certPath == "" || keyPath == "" {
 			continue
@@ -709,7 +1153,7 @@ func (s *SlaveService) ProcessCertReport(slaveId int, data map[string]interface{
 			Domain:     domain,
 			CertPath:   certPath,
 			KeyPath:    keyPath,
-			ExpiryTime: int64(expiryTime),
+			ExpiryTime: expiryTime,
 		})
 		
 		logger.Infof("Certificate reported: slave=%d, domain=%s, cert=%s", slaveId, domain, certPath)
@@ -726,14 +1170,15 @@ func (s *SlaveService) ProcessCertReport(slaveId int, data map[string]interface{
 	return nil
 }
 
-// checkAndDisableInvalidClients checks for clients that exceeded limits and disables them in the database
+// checkAndDisableInvalidClients checks for clients that exceeded limits and disables them in the database.
+// Clients of replicated inbounds are checked on the traffic of all replicas by DisableExhaustedReplicas.
 func (s *SlaveService) checkAndDisableInvalidClients(db *gorm.DB, slaveId int) (int64, error) {
 	now := time.Now().Unix() * 1000
 
 	// Find all clients on this slave that exceeded traffic or expiry limits
 	result := db.Model(&xray.ClientTraffic{}).
 		Where(`inbound_id IN (
-			SELECT id FROM inbounds WHERE slave_id = ?
+			SELECT id FROM inbounds WHERE slave_id = ? AND replica_of = 0
 		) AND ((total > 0 AND up + down >= total) OR (expiry_time > 0 AND expiry_time <= ?)) AND enable = ?`,
 			slaveId, now, true).
 		Update("enable", false)
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"gorm.io/gorm"
+)
+
+const (
+	// slaveCertIssueTimeout covers an ACME order including the server validating the challenge.
+	slaveCertIssueTimeout = 5 * time.Minute
+	// certRenewBefore is how long before expiry ACME certificates are renewed.
+	certRenewBefore = 30 * 24 * time.Hour
+	// certRenewRetry is how long a failed renewal waits before it is attempted again.
+	certRenewRetry = 6 * time.Hour
+)
+
+// ObtainAcmeCert has a slave obtain a certificate through ACME and records it, together with the
+// parameters used, so that it is renewed before it expires.
+func (s *SlaveService) ObtainAcmeCert(slaveId int, req protocol.CertIssue) (*model.SlaveCert, error) {
+	db := database.GetDB()
+	var cert model.SlaveCert
+	findErr := db.Where("slave_id = ? AND domain = ?", slaveId, req.Domain).First(&cert).Error
+	if findErr != nil && !errors.Is(findErr, gorm.ErrRecordNotFound) {
+		return nil, findErr
+	}
+
+	now := time.Now().Unix()
+	var result protocol.CertIssueResult
+	if err := s.CallSlave(slaveId, protocol.MethodIssueCert, req, &result, slaveCertIssueTimeout); err != nil {
+		if findErr == nil {
+			db.Model(&cert).Updates(map[string]any{"last_attempt": now, "last_error": err.Error()})
+		}
+		return nil, err
+	}
+
+	cert.SlaveId = slaveId
+	cert.Domain = result.Cert.Domain
+	cert.CertPath = result.Cert.CertPath
+	cert.KeyPath = result.Cert.KeyPath
+	cert.ExpiryTime = result.Cert.ExpiryTime
+	cert.LastUpdated = now
+	cert.Acme = true
+	cert.AcmeEmail = req.Email
+	cert.AcmeDirectory = req.DirectoryUrl
+	cert.AcmeChallenge = req.Challenge
+	cert.AcmePort = req.Port
+	cert.LastAttempt = now
+	cert.LastError = ""
+	if err := db.Save(&cert).Error; err != nil {
+		return nil, err
+	}
+	logger.Infof("Slave %d obtained a certificate for %s, valid until %s", slaveId, cert.Domain,
+		time.Unix(cert.ExpiryTime, 0).Format(time.DateOnly))
+	return &cert, nil
+}
+
+// RenewAcmeCert renews an ACME certificate now, with the parameters it was issued with.
+func (s *SlaveService) RenewAcmeCert(certId int) (*model.SlaveCert, error) {
+	var cert model.SlaveCert
+	if err := database.GetDB().First(&cert, certId).Error; err != nil {
+		return nil, err
+	}
+	if !cert.Acme {
+		return nil, errors.New("certificate was not obtained through ACME")
+	}
+	return s.ObtainAcmeCert(cert.SlaveId, acmeRequest(&cert))
+}
+
+// RenewExpiringAcmeCerts renews the ACME certificates of connected slaves that expire within
+// certRenewBefore. A failed renewal is retried after certRenewRetry.
+func (s *SlaveService) RenewExpiringAcmeCerts() {
+	now := time.Now()
+	var certs []model.SlaveCert
+	err := database.GetDB().
+		Where("acme = ? AND expiry_time < ? AND last_attempt < ?", true,
+			now.Add(certRenewBefore).Unix(), now.Add(-certRenewRetry).Unix()).
+		Find(&certs).Error
+	if err != nil {
+		logger.Warning("Failed to load certificates due for renewal:", err)
+		return
+	}
+	for _, cert := range certs {
+		if _, err := s.getSlaveConn(cert.SlaveId); err != nil {
+			continue
+		}
+		logger.Infof("Renewing certificate for %s on slave %d", cert.Domain, cert.SlaveId)
+		if _, err := s.ObtainAcmeCert(cert.SlaveId, acmeRequest(&cert)); err != nil {
+			logger.Warningf("Failed to renew certificate for %s on slave %d: %v", cert.Domain, cert.SlaveId, err)
+		}
+	}
+}
+
+func acmeRequest(cert *model.SlaveCert) protocol.CertIssue {
+	return protocol.CertIssue{
+		Domain:       cert.Domain,
+		Email:        cert.AcmeEmail,
+		DirectoryUrl: cert.AcmeDirectory,
+		Challenge:    cert.AcmeChallenge,
+		Port:         cert.AcmePort,
+	}
+}
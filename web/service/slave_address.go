@@ -0,0 +1,99 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+)
+
+// SetSlaveObservedAddress records the remote address a slave connects from. A slave following the
+// observed address, or one without any address yet, takes it right away; otherwise the next
+// heartbeat decides.
+func (s *SlaveService) SetSlaveObservedAddress(slaveId int, remote string) error {
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	var slave model.Slave
+	if err := database.GetDB().Select("id", "address", "address_source").First(&slave, slaveId).Error; err != nil {
+		return err
+	}
+	slave.ObservedAddress = remote
+	updates := map[string]any{"observed_address": remote}
+	if slave.AddressSource == model.AddressSourceObserved || (slave.Address == "" && slave.AddressSource != model.AddressSourceManual) {
+		if address := s.resolveSlaveAddress(&slave, ""); address != slave.Address {
+			s.warnAddressChange(&slave, address)
+			updates["address"] = address
+		}
+	}
+	return database.GetDB().Model(&model.Slave{}).Where("id = ?", slaveId).Updates(updates).Error
+}
+
+// SetSlaveAddress sets where a slave's address comes from. A manual source takes the given address;
+// the others are resolved with the next heartbeat, or right away from the observed address.
+func (s *SlaveService) SetSlaveAddress(slaveId int, source string, address string) error {
+	address = strings.TrimSpace(address)
+	switch source {
+	case model.AddressSourceManual:
+		if address == "" {
+			return errors.New("a manual address must not be empty")
+		}
+	case model.AddressSourceAuto, model.AddressSourceObserved:
+	default:
+		return errors.New("unknown address source: " + source)
+	}
+
+	var slave model.Slave
+	if err := database.GetDB().First(&slave, slaveId).Error; err != nil {
+		return err
+	}
+	slave.AddressSource = source
+	if source == model.AddressSourceManual {
+		slave.Address = address
+	} else if source == model.AddressSourceObserved && slave.ObservedAddress != "" {
+		slave.Address = slave.ObservedAddress
+	}
+	return database.GetDB().Model(&model.Slave{}).Where("id = ?", slaveId).Updates(map[string]any{
+		"address_source": slave.AddressSource,
+		"address":        slave.Address,
+	}).Error
+}
+
+// resolveSlaveAddress returns the address a slave should have given the address it reported in a
+// heartbeat, which is empty when the slave cannot tell. The current address is kept when nothing
+// better is known.
+func (s *SlaveService) resolveSlaveAddress(slave *model.Slave, reported string) string {
+	switch slave.AddressSource {
+	case model.AddressSourceManual:
+		return slave.Address
+	case model.AddressSourceObserved:
+		if slave.ObservedAddress != "" {
+			return slave.ObservedAddress
+		}
+	default:
+		if reported != "" {
+			return reported
+		}
+		// A loopback address is a reverse proxy on the master's host, not the slave
+		if ip := net.ParseIP(slave.ObservedAddress); slave.ObservedAddress != "" && (ip == nil || !ip.IsLoopback()) {
+			return slave.ObservedAddress
+		}
+	}
+	return slave.Address
+}
+
+// warnAddressChange reports a change of a slave's address, which changes its subscription links.
+func (s *SlaveService) warnAddressChange(slave *model.Slave, address string) {
+	if slave.Address == "" || address == "" || slave.Address == address {
+		return
+	}
+	format := "Address of slave %d changed from %s to %s; subscription links now point at the new address"
+	if s.IsSlaveDraining(slave.Id) {
+		logger.Infof("[maintenance] "+format, slave.Id, slave.Address, address)
+		return
+	}
+	logger.Warningf(format, slave.Id, slave.Address, address)
+}
@@ -0,0 +1,372 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+	"gorm.io/gorm"
+)
+
+const (
+	slaveCAValidity   = 10 * 365 * 24 * time.Hour
+	slaveCertValidity = 5 * 365 * 24 * time.Hour
+)
+
+// The cluster CA is loaded from the settings once and cached, since it is consulted on every TLS handshake.
+var (
+	slaveCALock sync.Mutex
+	slaveCACert *x509.Certificate
+	slaveCAKey  crypto.Signer
+)
+
+// AuthenticateSlave identifies the slave behind a connect request. The secret is read from the
+// SecretHeader. The secret query parameter of slaves predating the header ends up in access logs,
+// so it is only accepted while legacy secrets are turned on. When mutual TLS is required, the
+// slave must also present the client certificate issued to it.
+func (s *SlaveService) AuthenticateSlave(r *http.Request) (*model.Slave, error) {
+	settingService := SettingService{}
+	secret := r.Header.Get(protocol.SecretHeader)
+	fromQuery := false
+	if secret == "" && r.URL.Query().Has("secret") {
+		legacy, err := settingService.GetSlaveLegacySecret()
+		if err != nil {
+			return nil, err
+		}
+		if !legacy {
+			return nil, errors.New("slave secret in the connect URL is not accepted, upgrade the slave or allow legacy secrets")
+		}
+		secret = r.URL.Query().Get("secret")
+		fromQuery = true
+	}
+	if secret == "" {
+		return nil, errors.New("missing slave secret")
+	}
+
+	db := database.GetDB()
+	var slave model.Slave
+	if err := db.Where("secret = ? OR (next_secret <> '' AND next_secret = ?)", secret, secret).First(&slave).Error; err != nil {
+		return nil, errors.New("invalid slave secret")
+	}
+
+	// The slave stored the new secret but its confirmation got lost
+	if slave.NextSecret != "" && slave.NextSecret == secret {
+		if err := s.promoteSecret(slave.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	if fromQuery {
+		logger.Warningf("Slave %d sent its secret in the connect URL; upgrade it to keep the secret out of logs", slave.Id)
+	}
+
+	required, err := settingService.GetSlaveMtlsRequired()
+	if err != nil {
+		return nil, err
+	}
+	if required {
+		if err := s.verifyClientCert(&slave, r.TLS); err != nil {
+			return nil, fmt.Errorf("slave %d: %v", slave.Id, err)
+		}
+	}
+	return &slave, nil
+}
+
+// verifyClientCert checks that the TLS peer presented the certificate last issued to the slave.
+func (s *SlaveService) verifyClientCert(slave *model.Slave, state *tls.ConnectionState) error {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return errors.New("client certificate required")
+	}
+	pool := s.ClientCAPool()
+	if pool == nil {
+		return errors.New("no cluster CA to verify client certificates")
+	}
+
+	cert := state.PeerCertificates[0]
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return fmt.Errorf("invalid client certificate: %v", err)
+	}
+	if slave.CertFingerprint == "" || protocol.CertFingerprint(cert.Raw) != slave.CertFingerprint {
+		return errors.New("client certificate was not issued to this slave")
+	}
+	return nil
+}
+
+// ClientCAPool returns the cluster CA for verifying slave client certificates, or nil if none was created yet.
+func (s *SlaveService) ClientCAPool() *x509.CertPool {
+	cert, _, err := getSlaveCA(false)
+	if err != nil {
+		logger.Warning("Failed to load slave CA:", err)
+		return nil
+	}
+	if cert == nil {
+		return nil
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return pool
+}
+
+// getSlaveCA returns the cluster CA, generating it first when create is set and none exists.
+func getSlaveCA(create bool) (*x509.Certificate, crypto.Signer, error) {
+	slaveCALock.Lock()
+	defer slaveCALock.Unlock()
+
+	if slaveCACert != nil {
+		return slaveCACert, slaveCAKey, nil
+	}
+
+	settingService := SettingService{}
+	certPem, keyPem, err := settingService.GetSlaveCA()
+	if err != nil {
+		return nil, nil, err
+	}
+	if certPem != "" && keyPem != "" {
+		cert, key, err := parseCertAndKey(certPem, keyPem)
+		if err != nil {
+			return nil, nil, err
+		}
+		slaveCACert, slaveCAKey = cert, key
+		return cert, key, nil
+	}
+	if !create {
+		return nil, nil, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "3x-ui cluster CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(slaveCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPem, keyPem, err = encodeCertAndKey(der, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := settingService.SetSlaveCA(certPem, keyPem); err != nil {
+		return nil, nil, err
+	}
+
+	logger.Info("Created cluster CA for slave client certificates")
+	slaveCACert, slaveCAKey = cert, key
+	return cert, key, nil
+}
+
+// IssueSlaveCert issues a new client certificate for the slave and records its fingerprint,
+// which invalidates any certificate issued to the slave before.
+func (s *SlaveService) IssueSlaveCert(slaveId int) (string, string, error) {
+	caCert, caKey, err := getSlaveCA(true)
+	if err != nil {
+		return "", "", err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("slave-%d", slaveId)},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(slaveCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return "", "", err
+	}
+	certPem, keyPem, err := encodeCertAndKey(der, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := database.GetDB().Model(&model.Slave{}).Where("id = ?", slaveId).
+		Update("cert_fingerprint", protocol.CertFingerprint(der)).Error; err != nil {
+		return "", "", err
+	}
+	return certPem, keyPem, nil
+}
+
+// RotateSecret generates a new secret for the slave and hands it over if the slave is connected.
+// The old secret stays valid until the slave confirms it stored the new one; an offline slave
+// receives it when it reconnects.
+func (s *SlaveService) RotateSecret(slaveId int) error {
+	slave, err := s.GetSlave(slaveId)
+	if err != nil {
+		return err
+	}
+	conn, err := s.getSlaveConn(slaveId)
+	if err == nil && !conn.Has(protocol.CapSecretRotate) {
+		return fmt.Errorf("slave %s does not support secret rotation, reinstall it with a new secret", slave.Name)
+	}
+
+	next := random.Seq(32)
+	if err := database.GetDB().Model(&model.Slave{}).Where("id = ?", slaveId).
+		Update("next_secret", next).Error; err != nil {
+		return err
+	}
+
+	if conn == nil {
+		logger.Infof("Slave %d is offline, its secret will be rotated when it reconnects", slaveId)
+		return nil
+	}
+	return conn.Send(protocol.TypeRotateSecret, "", protocol.RotateSecret{Secret: next})
+}
+
+// promoteSecret makes the rotated secret the slave's only valid one.
+func (s *SlaveService) promoteSecret(slaveId int) error {
+	result := database.GetDB().Model(&model.Slave{}).
+		Where("id = ? AND next_secret <> ''", slaveId).
+		Updates(map[string]any{
+			"secret":      gorm.Expr("next_secret"),
+			"next_secret": "",
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		logger.Infof("Secret of slave %d rotated", slaveId)
+	}
+	return nil
+}
+
+// syncCredentials hands a freshly connected slave any pending secret and a new client certificate
+// unless it presented the one last issued to it. The decision rests on the certificate presented
+// in the TLS handshake, never on what the slave reports, so a client that only knows the secret
+// cannot replace the certificate of the real node.
+func (s *SlaveService) syncCredentials(slaveId int, conn *protocol.Conn, tlsState *tls.ConnectionState) error {
+	slave, err := s.GetSlave(slaveId)
+	if err != nil {
+		return err
+	}
+
+	if slave.NextSecret != "" && conn.Has(protocol.CapSecretRotate) {
+		if err := conn.Send(protocol.TypeRotateSecret, "", protocol.RotateSecret{Secret: slave.NextSecret}); err != nil {
+			return err
+		}
+	}
+
+	presented := presentedCertFingerprint(tlsState)
+	if conn.Has(protocol.CapClientCert) && (slave.CertFingerprint == "" || presented != slave.CertFingerprint) {
+		certPem, keyPem, err := s.IssueSlaveCert(slaveId)
+		if err != nil {
+			return err
+		}
+		logger.Infof("Issued client certificate to slave %d", slaveId)
+		if err := conn.Send(protocol.TypeClientCert, "", protocol.ClientCert{Cert: certPem, Key: keyPem}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// presentedCertFingerprint returns the fingerprint of the client certificate presented in the TLS
+// handshake, or "" if there was none. The panel only accepts certificates of the cluster CA.
+func presentedCertFingerprint(state *tls.ConnectionState) string {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return protocol.CertFingerprint(state.PeerCertificates[0].Raw)
+}
+
+// SetSlaveMtlsRequired turns mandatory client certificates for slaves on or off. It refuses to turn
+// them on while the panel does not terminate TLS itself or a slave has no certificate yet, since
+// those slaves would be locked out.
+func (s *SlaveService) SetSlaveMtlsRequired(required bool) error {
+	settingService := SettingService{}
+	if required {
+		certFile, err := settingService.GetCertFile()
+		if err != nil {
+			return err
+		}
+		if certFile == "" {
+			return errors.New("the panel must serve HTTPS itself to verify slave certificates")
+		}
+
+		var missing []string
+		if err := database.GetDB().Model(&model.Slave{}).Where("cert_fingerprint = '' OR cert_fingerprint IS NULL").
+			Pluck("name", &missing).Error; err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("slaves without a client certificate, connect them first: %s", strings.Join(missing, ", "))
+		}
+	}
+	return settingService.SetSlaveMtlsRequired(required)
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func encodeCertAndKey(der []byte, key *ecdsa.PrivateKey) (string, string, error) {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return string(certPem), string(keyPem), nil
+}
+
+func parseCertAndKey(certPem, keyPem string) (*x509.Certificate, crypto.Signer, error) {
+	pair, err := tls.X509KeyPair([]byte(certPem), []byte(keyPem))
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("unsupported CA key type")
+	}
+	return cert, key, nil
+}
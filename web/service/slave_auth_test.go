@@ -0,0 +1,212 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/op/go-logging"
+)
+
+// initTestDB opens a fresh database for the test.
+func initTestDB(t *testing.T) {
+	t.Helper()
+	logger.InitLogger(logging.ERROR)
+	if err := database.InitDB(filepath.Join(t.TempDir(), "x-ui.db")); err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+	t.Cleanup(func() { database.CloseDB() })
+}
+
+// parseTestCert decodes a PEM certificate.
+func parseTestCert(t *testing.T, certPem string) *x509.Certificate {
+	t.Helper()
+	block, _ := pem.Decode([]byte(certPem))
+	if block == nil {
+		t.Fatal("no PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+	return cert
+}
+
+// selfSignedClientCert returns a client certificate that was not issued by the cluster CA.
+func selfSignedClientCert(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: randomTestSerial(t),
+		Subject:      pkix.Name{CommonName: "slave-1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func randomTestSerial(t *testing.T) *big.Int {
+	t.Helper()
+	serial, err := randomSerial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serial
+}
+
+func TestAuthenticateSlave(t *testing.T) {
+	initTestDB(t)
+	slaveService := &SlaveService{}
+	settingService := &SettingService{}
+
+	slave := &model.Slave{Name: "edge", Secret: "current-secret"}
+	if err := slaveService.AddSlave(slave); err != nil {
+		t.Fatalf("AddSlave() error = %v", err)
+	}
+	other := &model.Slave{Name: "other", Secret: "other-secret"}
+	if err := slaveService.AddSlave(other); err != nil {
+		t.Fatalf("AddSlave() error = %v", err)
+	}
+	certPem, _, err := slaveService.IssueSlaveCert(slave.Id)
+	if err != nil {
+		t.Fatalf("IssueSlaveCert() error = %v", err)
+	}
+	issued := parseTestCert(t, certPem)
+	otherPem, _, err := slaveService.IssueSlaveCert(other.Id)
+	if err != nil {
+		t.Fatalf("IssueSlaveCert() error = %v", err)
+	}
+	otherCert := parseTestCert(t, otherPem)
+
+	tests := []struct {
+		name       string
+		header     string
+		query      string
+		legacy     bool
+		mtls       bool
+		peer       *x509.Certificate
+		nextSecret string
+		wantId     int
+		wantSecret string // the slave's secret after authenticating, if it changed
+	}{
+		{name: "header secret", header: "current-secret", wantId: slave.Id},
+		{name: "wrong header secret", header: "guess"},
+		{name: "missing secret"},
+		{name: "query secret is refused by default", query: "current-secret"},
+		{name: "query secret with legacy secrets on", query: "current-secret", legacy: true, wantId: slave.Id},
+		{name: "header wins over the query", header: "guess", query: "current-secret", legacy: true},
+		{name: "current secret while a rotation is pending", header: "current-secret", nextSecret: "next-secret", wantId: slave.Id},
+		{
+			name:       "rotated secret is promoted",
+			header:     "next-secret",
+			nextSecret: "next-secret",
+			wantId:     slave.Id,
+			wantSecret: "next-secret",
+		},
+		{name: "mTLS without a certificate", header: "current-secret", mtls: true},
+		{name: "mTLS with the issued certificate", header: "current-secret", mtls: true, peer: issued, wantId: slave.Id},
+		{name: "mTLS with another slave's certificate", header: "current-secret", mtls: true, peer: otherCert},
+		{name: "mTLS with a certificate of another CA", header: "current-secret", mtls: true, peer: selfSignedClientCert(t)},
+		{name: "certificate is not checked without mTLS", header: "current-secret", peer: selfSignedClientCert(t), wantId: slave.Id},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := database.GetDB()
+			if err := db.Model(&model.Slave{}).Where("id = ?", slave.Id).
+				Updates(map[string]any{"secret": "current-secret", "next_secret": tt.nextSecret}).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := settingService.SetSlaveLegacySecret(tt.legacy); err != nil {
+				t.Fatal(err)
+			}
+			if err := settingService.SetSlaveMtlsRequired(tt.mtls); err != nil {
+				t.Fatal(err)
+			}
+
+			target := "/panel/api/slave/connect"
+			if tt.query != "" {
+				target += "?secret=" + tt.query
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if tt.header != "" {
+				req.Header.Set(protocol.SecretHeader, tt.header)
+			}
+			if tt.peer != nil {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.peer}}
+			}
+
+			got, err := slaveService.AuthenticateSlave(req)
+			if tt.wantId == 0 {
+				if err == nil {
+					t.Fatalf("AuthenticateSlave() = slave %d, want error", got.Id)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AuthenticateSlave() error = %v", err)
+			}
+			if got.Id != tt.wantId {
+				t.Fatalf("AuthenticateSlave() = slave %d, want %d", got.Id, tt.wantId)
+			}
+
+			stored, err := slaveService.GetSlave(slave.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantSecret, wantNext := "current-secret", tt.nextSecret
+			if tt.wantSecret != "" {
+				wantSecret, wantNext = tt.wantSecret, ""
+			}
+			if stored.Secret != wantSecret || stored.NextSecret != wantNext {
+				t.Fatalf("secrets after auth = %q/%q, want %q/%q", stored.Secret, stored.NextSecret, wantSecret, wantNext)
+			}
+		})
+	}
+}
+
+func TestPresentedCertFingerprint(t *testing.T) {
+	cert := selfSignedClientCert(t)
+	tests := []struct {
+		name  string
+		state *tls.ConnectionState
+		want  string
+	}{
+		{"plain HTTP", nil, ""},
+		{"no client certificate", &tls.ConnectionState{}, ""},
+		{"client certificate", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, protocol.CertFingerprint(cert.Raw)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := presentedCertFingerprint(tt.state); got != tt.want {
+				t.Fatalf("presentedCertFingerprint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
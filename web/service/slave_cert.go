@@ -81,9 +81,14 @@ func (s *SlaveCertService) BatchUpsertCerts(slaveId int, certs []model.SlaveCert
 		err := tx.Where("slave_id = ? AND domain = ?", slaveId, cert.Domain).First(&existing).Error
 		
 		if err == nil {
-			// Update existing
-			cert.Id = existing.Id
-			if err := tx.Save(&cert).Error; err != nil {
+			// Update what the slave reports, keeping how the certificate is managed
+			err := tx.Model(&existing).Updates(map[string]any{
+				"cert_path":    cert.CertPath,
+				"key_path":     cert.KeyPath,
+				"expiry_time":  cert.ExpiryTime,
+				"last_updated": cert.LastUpdated,
+			}).Error
+			if err != nil {
 				tx.Rollback()
 				return err
 			}
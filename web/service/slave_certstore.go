@@ -0,0 +1,500 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/util/crypto"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// slaveCertInstallTimeout covers a slave writing its certificates and restarting Xray.
+const slaveCertInstallTimeout = time.Minute
+
+// storedCertName restricts names to what slaves accept as a directory under protocol.CertDir.
+var storedCertName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,127}$`)
+
+// The key encrypting stored private keys lives in a file next to the database rather than in it,
+// so a copy of the database alone does not reveal them. Database backups therefore do not carry
+// it: restoring stored certificates on another master needs the key file copied along.
+var (
+	certStoreKeyLock sync.Mutex
+	certStoreKey     []byte
+)
+
+// ErrCertStoreKey is returned when the stored private keys cannot be decrypted with the key file,
+// typically after the database was restored without it.
+var ErrCertStoreKey = errors.New("stored certificates cannot be decrypted")
+
+// StoredCertWithSlaves is a stored certificate together with the slaves it is assigned to.
+type StoredCertWithSlaves struct {
+	model.StoredCert
+	SlaveIds []int `json:"slaveIds"`
+}
+
+// SlaveCertInstallResult is the outcome of installing stored certificates on one slave.
+type SlaveCertInstallResult struct {
+	SlaveId   int      `json:"slaveId"`
+	Name      string   `json:"name"`
+	Success   bool     `json:"success"`
+	Updated   []string `json:"updated,omitempty"`
+	Restarted bool     `json:"restarted"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// getCertStoreKeyPath returns where the key encrypting stored private keys is kept.
+func getCertStoreKeyPath() string {
+	return filepath.Join(config.GetDBFolderPath(), "cert-store.key")
+}
+
+// getCertStoreKey returns the key encrypting stored private keys, creating it on first use. A
+// missing key is only created while the store is empty, as stored keys could not be read with it.
+func getCertStoreKey() ([]byte, error) {
+	certStoreKeyLock.Lock()
+	defer certStoreKeyLock.Unlock()
+	if certStoreKey != nil {
+		return certStoreKey, nil
+	}
+
+	path := getCertStoreKeyPath()
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("%s is not a valid key", path)
+		}
+		certStoreKey = key
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	var stored int64
+	if err := database.GetDB().Model(&model.StoredCert{}).Count(&stored).Error; err != nil {
+		return nil, err
+	}
+	if stored > 0 {
+		return nil, fmt.Errorf("%w: %s is missing, copy it from the master the database was backed up on, or delete and add the stored certificates again",
+			ErrCertStoreKey, path)
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, key, 0o600); err != nil {
+		return nil, err
+	}
+	logger.Info("Created key for the certificate store at", path)
+	certStoreKey = key
+	return key, nil
+}
+
+// GetStoredCerts returns the stored certificates and their assignments.
+func (s *SlaveService) GetStoredCerts() ([]StoredCertWithSlaves, error) {
+	db := database.GetDB()
+	var certs []model.StoredCert
+	if err := db.Order("name").Find(&certs).Error; err != nil {
+		return nil, err
+	}
+	var assignments []model.SlaveStoredCert
+	if err := db.Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]StoredCertWithSlaves, len(certs))
+	for i, cert := range certs {
+		result[i] = StoredCertWithSlaves{StoredCert: cert, SlaveIds: []int{}}
+		for _, assignment := range assignments {
+			if assignment.CertId == cert.Id {
+				result[i].SlaveIds = append(result[i].SlaveIds, assignment.SlaveId)
+			}
+		}
+	}
+	return result, nil
+}
+
+// SaveStoredCert stores a certificate and its key under name, replacing the certificate stored
+// under that name before. Without a name, the first name the certificate covers is used, with a
+// wildcard written as "wildcard". Slaves the certificate is assigned to receive a changed one right away.
+func (s *SlaveService) SaveStoredCert(name, certPem, keyPem string) (*model.StoredCert, error) {
+	certPem = strings.TrimSpace(certPem) + "\n"
+	keyPem = strings.TrimSpace(keyPem) + "\n"
+	pair, err := tls.X509KeyPair([]byte(certPem), []byte(keyPem))
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate or key: %v", err)
+	}
+	leaf := pair.Leaf
+	domains := leaf.DNSNames
+	if len(domains) == 0 && leaf.Subject.CommonName != "" {
+		domains = []string{leaf.Subject.CommonName}
+	}
+	if name == "" && len(domains) > 0 {
+		name = strings.Replace(domains[0], "*", "wildcard", 1)
+	}
+	if !storedCertName.MatchString(name) {
+		return nil, fmt.Errorf("invalid certificate name %q", name)
+	}
+
+	sum := sha256.Sum256([]byte(certPem + keyPem))
+	hash := hex.EncodeToString(sum[:])
+
+	db := database.GetDB()
+	var cert model.StoredCert
+	err = db.Where("name = ?", name).First(&cert).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if err == nil && cert.Sha256 == hash {
+		return &cert, nil
+	}
+
+	storeKey, err := getCertStoreKey()
+	if err != nil {
+		return nil, err
+	}
+	keyEnc, err := crypto.Seal(storeKey, []byte(keyPem))
+	if err != nil {
+		return nil, err
+	}
+	cert.Name = name
+	cert.Domains = strings.Join(domains, ",")
+	cert.Cert = certPem
+	cert.KeyEnc = keyEnc
+	cert.Sha256 = hash
+	cert.ExpiryTime = leaf.NotAfter.Unix()
+	cert.Version++
+	cert.UpdatedAt = time.Now().Unix()
+	if err := db.Save(&cert).Error; err != nil {
+		return nil, err
+	}
+	logger.Infof("Stored certificate %s version %d, valid until %s", name, cert.Version, leaf.NotAfter.Format(time.DateOnly))
+
+	if cert.Version > 1 {
+		go s.syncStoredCertSlaves(cert.Id)
+	}
+	return &cert, nil
+}
+
+// DeleteStoredCert stops distributing a certificate. Slaves keep the copy they have installed.
+// Certificates still referenced by an inbound cannot be deleted.
+func (s *SlaveService) DeleteStoredCert(id int) error {
+	referenced, err := s.storedCertInbounds()
+	if err != nil {
+		return err
+	}
+	if tags := referenced[id]; len(tags) > 0 {
+		return fmt.Errorf("certificate is used by inbound %s", strings.Join(tags, ", "))
+	}
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("cert_id = ?", id).Delete(&model.SlaveStoredCert{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.StoredCert{}, id).Error
+	})
+}
+
+// PushStoredCerts assigns the certificates to the slaves and installs them on the connected ones
+// right away. Offline slaves receive them when they reconnect.
+func (s *SlaveService) PushStoredCerts(slaveIds []int, certIds []int) ([]SlaveCertInstallResult, error) {
+	db := database.GetDB()
+	var count int64
+	if err := db.Model(&model.StoredCert{}).Where("id IN ?", certIds).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count != int64(len(certIds)) {
+		return nil, errors.New("unknown certificate")
+	}
+
+	var assignments []model.SlaveStoredCert
+	for _, slaveId := range slaveIds {
+		for _, certId := range certIds {
+			assignments = append(assignments, model.SlaveStoredCert{SlaveId: slaveId, CertId: certId})
+		}
+	}
+	if len(assignments) > 0 {
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&assignments).Error; err != nil {
+			return nil, err
+		}
+	}
+	return s.installStoredCerts(slaveIds), nil
+}
+
+// UnassignStoredCerts stops keeping the certificates up to date on the slaves.
+func (s *SlaveService) UnassignStoredCerts(slaveIds []int, certIds []int) error {
+	return database.GetDB().Where("slave_id IN ? AND cert_id IN ?", slaveIds, certIds).
+		Delete(&model.SlaveStoredCert{}).Error
+}
+
+// installStoredCerts installs the assigned certificates on each of the slaves in parallel.
+func (s *SlaveService) installStoredCerts(slaveIds []int) []SlaveCertInstallResult {
+	results := make([]SlaveCertInstallResult, len(slaveIds))
+	var wg sync.WaitGroup
+	for i, slaveId := range slaveIds {
+		wg.Add(1)
+		go func(i, slaveId int) {
+			defer wg.Done()
+			result := SlaveCertInstallResult{SlaveId: slaveId}
+			if slave, err := s.GetSlave(slaveId); err == nil {
+				result.Name = slave.Name
+			}
+			if _, err := s.getSlaveConn(slaveId); err != nil {
+				result.Error = "offline, receives the certificates when it reconnects"
+				results[i] = result
+				return
+			}
+			installed, err := s.syncSlaveStoredCerts(slaveId)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Success = true
+				result.Updated = installed.Updated
+				result.Restarted = installed.Restarted
+			}
+			results[i] = result
+		}(i, slaveId)
+	}
+	wg.Wait()
+	return results
+}
+
+// syncStoredCertSlaves brings a changed certificate to the connected slaves it is assigned to.
+func (s *SlaveService) syncStoredCertSlaves(certId int) {
+	var slaveIds []int
+	if err := database.GetDB().Model(&model.SlaveStoredCert{}).Where("cert_id = ?", certId).
+		Pluck("slave_id", &slaveIds).Error; err != nil {
+		logger.Warningf("Failed to load slaves of certificate %d: %v", certId, err)
+		return
+	}
+	for _, result := range s.installStoredCerts(slaveIds) {
+		if result.Error != "" {
+			logger.Warningf("Failed to install certificate %d on slave %d: %s", certId, result.SlaveId, result.Error)
+		}
+	}
+}
+
+// syncSlaveStoredCerts hands a slave its assigned certificates and records what it installed
+// with the certificates the slave reports.
+func (s *SlaveService) syncSlaveStoredCerts(slaveId int) (*protocol.CertInstallResult, error) {
+	certs, err := s.assignedStoredCerts(slaveId)
+	if err != nil {
+		return nil, err
+	}
+	var result protocol.CertInstallResult
+	if err := s.CallSlave(slaveId, protocol.MethodInstallCerts, protocol.CertInstall{Certs: certs}, &result, slaveCertInstallTimeout); err != nil {
+		return nil, err
+	}
+
+	reported := make([]model.SlaveCert, len(result.Certs))
+	for i, info := range result.Certs {
+		reported[i] = model.SlaveCert{
+			Domain:     info.Domain,
+			CertPath:   info.CertPath,
+			KeyPath:    info.KeyPath,
+			ExpiryTime: info.ExpiryTime,
+		}
+	}
+	certService := SlaveCertService{}
+	if err := certService.BatchUpsertCerts(slaveId, reported); err != nil {
+		logger.Warningf("Failed to record certificates of slave %d: %v", slaveId, err)
+	}
+	return &result, nil
+}
+
+// syncStoredCertsOnConnect installs the assigned certificates on a reconnecting slave, which
+// leaves those it already has untouched.
+func (s *SlaveService) syncStoredCertsOnConnect(slaveId int) {
+	var count int64
+	if err := database.GetDB().Model(&model.SlaveStoredCert{}).Where("slave_id = ?", slaveId).
+		Count(&count).Error; err != nil || count == 0 {
+		return
+	}
+	if result, err := s.syncSlaveStoredCerts(slaveId); err != nil {
+		logger.Warningf("Failed to install certificates on slave %d: %v", slaveId, err)
+	} else if len(result.Updated) > 0 {
+		logger.Infof("Installed certificates %v on slave %d", result.Updated, slaveId)
+	}
+}
+
+// assignReferencedCerts assigns the stored certificates the slave's inbounds refer to, and installs
+// them before a config using them is pushed if the slave did not have them yet.
+func (s *SlaveService) assignReferencedCerts(slaveId int) error {
+	db := database.GetDB()
+	var streams []string
+	if err := db.Model(&model.Inbound{}).Where("slave_id = ? AND stream_settings LIKE ?", slaveId, "%certId%").
+		Pluck("stream_settings", &streams).Error; err != nil {
+		return err
+	}
+	var assignments []model.SlaveStoredCert
+	for _, stream := range streams {
+		for _, certId := range storedCertIds(stream) {
+			assignments = append(assignments, model.SlaveStoredCert{SlaveId: slaveId, CertId: certId})
+		}
+	}
+	if len(assignments) == 0 {
+		return nil
+	}
+	created := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&assignments)
+	if created.Error != nil {
+		return created.Error
+	}
+	if created.RowsAffected == 0 {
+		return nil
+	}
+	if _, err := s.getSlaveConn(slaveId); err != nil {
+		return nil
+	}
+	_, err := s.syncSlaveStoredCerts(slaveId)
+	return err
+}
+
+func (s *SlaveService) assignedStoredCerts(slaveId int) ([]protocol.StoredCert, error) {
+	var stored []model.StoredCert
+	err := database.GetDB().Model(&model.StoredCert{}).
+		Joins("JOIN slave_stored_certs ON slave_stored_certs.cert_id = stored_certs.id").
+		Where("slave_stored_certs.slave_id = ?", slaveId).
+		Find(&stored).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) == 0 {
+		return []protocol.StoredCert{}, nil
+	}
+	storeKey, err := getCertStoreKey()
+	if err != nil {
+		return nil, err
+	}
+	certs := make([]protocol.StoredCert, len(stored))
+	for i, cert := range stored {
+		key, err := crypto.Open(storeKey, cert.KeyEnc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: certificate %s was saved with another %s, restore that key file or add the certificate again",
+				ErrCertStoreKey, cert.Name, getCertStoreKeyPath())
+		}
+		certs[i] = protocol.StoredCert{
+			Name:   cert.Name,
+			Cert:   cert.Cert,
+			Key:    string(key),
+			Sha256: cert.Sha256,
+		}
+	}
+	return certs, nil
+}
+
+// storedCertInbounds returns the tags of the inbounds referring to each stored certificate.
+func (s *SlaveService) storedCertInbounds() (map[int][]string, error) {
+	var inbounds []model.Inbound
+	if err := database.GetDB().Select("tag", "stream_settings").Where("stream_settings LIKE ?", "%certId%").
+		Find(&inbounds).Error; err != nil {
+		return nil, err
+	}
+	referenced := make(map[int][]string)
+	for _, inbound := range inbounds {
+		for _, certId := range storedCertIds(inbound.StreamSettings) {
+			referenced[certId] = append(referenced[certId], inbound.Tag)
+		}
+	}
+	return referenced, nil
+}
+
+// resolveStoredCerts replaces the stored certificate references in an inbound's TLS settings with
+// the files the certificate is installed as on the slave.
+func resolveStoredCerts(inbound *xray.InboundConfig, names map[int]string) error {
+	var stream map[string]any
+	if err := json.Unmarshal(inbound.StreamSettings, &stream); err != nil {
+		return nil
+	}
+	certs := tlsCertificates(stream)
+	changed := false
+	for _, cert := range certs {
+		certId, ok := storedCertRef(cert)
+		if !ok {
+			continue
+		}
+		name, ok := names[certId]
+		if !ok {
+			return fmt.Errorf("inbound %s refers to unknown certificate %d", inbound.Tag, certId)
+		}
+		dir := filepath.Join(protocol.CertDir, name)
+		delete(cert, "certId")
+		delete(cert, "certificate")
+		delete(cert, "key")
+		cert["certificateFile"] = filepath.Join(dir, "fullchain.pem")
+		cert["keyFile"] = filepath.Join(dir, "privkey.pem")
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	data, err := json.Marshal(stream)
+	if err != nil {
+		return err
+	}
+	inbound.StreamSettings = data
+	return nil
+}
+
+// storedCertNames returns the names of the stored certificates by id.
+func storedCertNames() (map[int]string, error) {
+	var certs []model.StoredCert
+	if err := database.GetDB().Select("id", "name").Find(&certs).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(certs))
+	for _, cert := range certs {
+		names[cert.Id] = cert.Name
+	}
+	return names, nil
+}
+
+// storedCertIds returns the stored certificates referenced by the TLS settings in streamSettings.
+func storedCertIds(streamSettings string) []int {
+	var stream map[string]any
+	if err := json.Unmarshal([]byte(streamSettings), &stream); err != nil {
+		return nil
+	}
+	var ids []int
+	for _, cert := range tlsCertificates(stream) {
+		if certId, ok := storedCertRef(cert); ok {
+			ids = append(ids, certId)
+		}
+	}
+	return ids
+}
+
+func tlsCertificates(stream map[string]any) []map[string]any {
+	tlsSettings, _ := stream["tlsSettings"].(map[string]any)
+	entries, _ := tlsSettings["certificates"].([]any)
+	var certs []map[string]any
+	for _, entry := range entries {
+		if cert, ok := entry.(map[string]any); ok {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+func storedCertRef(cert map[string]any) (int, bool) {
+	certId, ok := cert["certId"].(float64)
+	if !ok || certId <= 0 {
+		return 0, false
+	}
+	return int(certId), true
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"gorm.io/gorm"
+)
+
+// clientIpMaxAge is how long an address a client was not seen from keeps counting toward its IP
+// limit, matching the default ban time of the 3x-ipl fail2ban jail.
+const clientIpMaxAge = 30 * time.Minute
+
+var (
+	// clientIpsLock serializes client IP reports, which read and rewrite the same rows.
+	clientIpsLock sync.Mutex
+	// bannedClientIps remembers, by member email and address, when an address over the limit was
+	// last seen at the time it was banned, so it is only banned again after it came back.
+	bannedClientIps = make(map[string]int64)
+)
+
+// clientIpMember is the entry of a client on one slave. All members of a client share its IP limit.
+type clientIpMember struct {
+	slaveId int
+	inbound *model.Inbound
+	email   string
+}
+
+// clientIpEntry is a stored source address of a client: when it was last seen and on which slaves.
+// It extends the {ip, timestamp} format of the master's own IP limit job.
+type clientIpEntry struct {
+	IP        string `json:"ip"`
+	Timestamp int64  `json:"timestamp"`
+	SlaveIds  []int  `json:"slaveIds,omitempty"`
+}
+
+// clientIpBan is an address over a client's IP limit to be banned on one slave.
+type clientIpBan struct {
+	member clientIpMember
+	ips    []string
+}
+
+// ProcessClientIps merges the client source addresses a slave reported with those seen on the
+// other slaves and enforces each client's IP limit across the cluster. A client of a replicated
+// inbound is one client on every replica, so its addresses are counted together. Addresses over
+// the limit are banned on the slaves they were seen on.
+func (s *SlaveService) ProcessClientIps(slaveId int, report *protocol.ClientIps) error {
+	if len(report.Clients) == 0 {
+		return nil
+	}
+	clientIpsLock.Lock()
+	defer clientIpsLock.Unlock()
+
+	var inbounds []*model.Inbound
+	if err := database.GetDB().Where("slave_id = ?", slaveId).Find(&inbounds).Error; err != nil {
+		return err
+	}
+	inboundByEmail := make(map[string]*model.Inbound)
+	clientByEmail := make(map[string]model.Client)
+	for _, inbound := range inbounds {
+		clients, err := s.InboundService.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			inboundByEmail[client.Email] = inbound
+			clientByEmail[client.Email] = client
+		}
+	}
+
+	since := time.Now().Add(-clientIpMaxAge).Unix()
+	bans := make(map[int][]clientIpBan)
+	for email, ips := range report.Clients {
+		inbound, ok := inboundByEmail[email]
+		if !ok {
+			logger.Debugf("Slave %d reported addresses of unknown client %s", slaveId, email)
+			continue
+		}
+		members, err := s.clientIpMembers(slaveId, inbound, email)
+		if err != nil {
+			logger.Warningf("Failed to find the replicas of client %s: %v", email, err)
+			continue
+		}
+
+		client := clientByEmail[email]
+		limit := 0
+		if inbound.Enable && client.Enable {
+			limit = client.LimitIP
+		}
+		excess, err := s.saveClientIps(members, slaveId, ips, limit, since)
+		if err != nil {
+			logger.Warningf("Failed to save the addresses of client %s: %v", email, err)
+			continue
+		}
+		for _, member := range members {
+			var banned []string
+			for _, entry := range excess {
+				key := member.email + "|" + entry.IP
+				if slices.Contains(entry.SlaveIds, member.slaveId) && entry.Timestamp > bannedClientIps[key] {
+					bannedClientIps[key] = entry.Timestamp
+					banned = append(banned, entry.IP)
+				}
+			}
+			if len(banned) > 0 {
+				logger.Infof("[LIMIT_IP] Client %s is over its limit of %d IPs, banning %v on slave %d", member.email, limit, banned, member.slaveId)
+				bans[member.slaveId] = append(bans[member.slaveId], clientIpBan{member: member, ips: banned})
+			}
+		}
+	}
+	for key, timestamp := range bannedClientIps {
+		if timestamp < since {
+			delete(bannedClientIps, key)
+		}
+	}
+
+	// Banning waits for the slaves to answer, which must not hold up this slave's messages
+	if len(bans) > 0 {
+		go s.banClientIps(bans)
+	}
+	return nil
+}
+
+// clientIpMembers returns the entries of the client with the given email on slaveId: the same
+// client on every replica for a replicated inbound, or just this one otherwise.
+func (s *SlaveService) clientIpMembers(slaveId int, inbound *model.Inbound, email string) ([]clientIpMember, error) {
+	if inbound.ReplicaOf == 0 {
+		return []clientIpMember{{slaveId: slaveId, inbound: inbound, email: email}}, nil
+	}
+	var replicas []*model.Inbound
+	if err := database.GetDB().Where("replica_of = ?", inbound.ReplicaOf).Find(&replicas).Error; err != nil {
+		return nil, err
+	}
+	canonical := canonicalClientEmail(email, slaveId)
+	members := make([]clientIpMember, 0, len(replicas))
+	for _, replica := range replicas {
+		members = append(members, clientIpMember{
+			slaveId: replica.SlaveId,
+			inbound: replica,
+			email:   ReplicaClientEmail(canonical, replica.SlaveId),
+		})
+	}
+	return members, nil
+}
+
+// saveClientIps stores the addresses slaveId reported for a client on the rows of all its members
+// and returns the addresses over the limit.
+func (s *SlaveService) saveClientIps(members []clientIpMember, slaveId int, reported []protocol.ClientIp, limit int, since int64) ([]clientIpEntry, error) {
+	emails := make([]string, len(members))
+	for i, member := range members {
+		emails[i] = member.email
+	}
+	db := database.GetDB()
+	var rows []model.InboundClientIps
+	if err := db.Where("client_email IN ?", emails).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	// Every member row holds the same list; the longest survives a member added later
+	var stored []clientIpEntry
+	for _, row := range rows {
+		var entries []clientIpEntry
+		if row.Ips != "" && json.Unmarshal([]byte(row.Ips), &entries) == nil && len(entries) > len(stored) {
+			stored = entries
+		}
+	}
+
+	merged, excess := mergeClientIps(stored, slaveId, reported, limit, since)
+	ips, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]model.InboundClientIps, len(rows))
+	for _, row := range rows {
+		existing[row.ClientEmail] = row
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, email := range emails {
+			row, ok := existing[email]
+			if !ok {
+				row = model.InboundClientIps{ClientEmail: email}
+			}
+			row.Ips = string(ips)
+			if err := tx.Save(&row).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return excess, err
+}
+
+// mergeClientIps adds the addresses slaveId reported to the stored ones and forgets those not seen
+// since the given unix time. Stored addresses keep their order and new ones are appended, so with a
+// limit above zero the addresses that were there first are allowed and the later ones are returned
+// as excess. Excess addresses stay stored and keep counting until they age out.
+func mergeClientIps(stored []clientIpEntry, slaveId int, reported []protocol.ClientIp, limit int, since int64) (merged, excess []clientIpEntry) {
+	latest := make(map[string]int64, len(reported))
+	for _, ip := range reported {
+		if ip.Timestamp > latest[ip.IP] {
+			latest[ip.IP] = ip.Timestamp
+		}
+	}
+
+	merged = make([]clientIpEntry, 0, len(stored)+len(latest))
+	for _, entry := range stored {
+		if timestamp, ok := latest[entry.IP]; ok {
+			delete(latest, entry.IP)
+			entry.Timestamp = max(entry.Timestamp, timestamp)
+			if !slices.Contains(entry.SlaveIds, slaveId) {
+				entry.SlaveIds = append(slices.Clone(entry.SlaveIds), slaveId)
+				slices.Sort(entry.SlaveIds)
+			}
+		}
+		if entry.Timestamp >= since {
+			merged = append(merged, entry)
+		}
+	}
+	added := make([]clientIpEntry, 0, len(latest))
+	for ip, timestamp := range latest {
+		if timestamp >= since {
+			added = append(added, clientIpEntry{IP: ip, Timestamp: timestamp, SlaveIds: []int{slaveId}})
+		}
+	}
+	sort.Slice(added, func(i, j int) bool {
+		if added[i].Timestamp != added[j].Timestamp {
+			return added[i].Timestamp < added[j].Timestamp
+		}
+		return added[i].IP < added[j].IP
+	})
+	merged = append(merged, added...)
+
+	if limit > 0 && len(merged) > limit {
+		excess = merged[limit:]
+	}
+	return merged, excess
+}
+
+// banClientIps has each slave ban the given addresses and drop the connections of their clients,
+// so the banned addresses are cut off right away.
+func (s *SlaveService) banClientIps(bans map[int][]clientIpBan) {
+	slaveIds := make([]int, 0, len(bans))
+	for slaveId := range bans {
+		slaveIds = append(slaveIds, slaveId)
+	}
+	results := s.forEachSlave(slaveIds, func(slaveId int) error {
+		conn, err := s.getSlaveConn(slaveId)
+		if err != nil {
+			return err
+		}
+		if !conn.Has(protocol.CapClientIps) {
+			return fmt.Errorf("slave does not support banning client addresses")
+		}
+		req := protocol.KickClients{Ips: make(map[string][]string)}
+		for _, ban := range bans[slaveId] {
+			req.Ips[ban.member.email] = ban.ips
+			user, err := kickUser(ban.member)
+			if err != nil {
+				logger.Warningf("[LIMIT_IP] Cannot disconnect client %s on slave %d: %v", ban.member.email, slaveId, err)
+				continue
+			}
+			req.Users = append(req.Users, user)
+		}
+		var result protocol.KickClientsResult
+		return s.CallSlave(slaveId, protocol.MethodKickClients, req, &result, 0)
+	})
+	for _, result := range results {
+		if !result.Success {
+			logger.Warningf("[LIMIT_IP] Failed to ban client addresses on slave %d: %s", result.SlaveId, result.Error)
+		}
+	}
+}
+
+// kickUser builds the add_user operation that brings a member back after it was removed.
+func kickUser(member clientIpMember) (protocol.DeltaOp, error) {
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(member.inbound.Settings), &settings); err != nil {
+		return protocol.DeltaOp{}, err
+	}
+	protocolName := string(member.inbound.Protocol)
+	cipher, ok := liveUserCipher(protocolName, settings)
+	if !ok {
+		return protocol.DeltaOp{}, fmt.Errorf("%s clients cannot be managed live", protocolName)
+	}
+	clients, ok := clientsByEmail(settings)
+	if !ok {
+		return protocol.DeltaOp{}, fmt.Errorf("inbound %s has clients without a unique email", member.inbound.Tag)
+	}
+	client, ok := clients[member.email]
+	if !ok {
+		return protocol.DeltaOp{}, fmt.Errorf("client is not in inbound %s", member.inbound.Tag)
+	}
+	if enable, ok := client["enable"].(bool); ok && !enable {
+		return protocol.DeltaOp{}, fmt.Errorf("client is disabled")
+	}
+	return protocol.DeltaOp{
+		Op:       protocol.OpAddUser,
+		Tag:      member.inbound.Tag,
+		Protocol: protocolName,
+		User:     liveUser(member.email, client, cipher),
+	}, nil
+}
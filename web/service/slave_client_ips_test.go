@@ -0,0 +1,101 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+)
+
+// ipSummaries describes entries as "ip timestamp [slaveIds]".
+func ipSummaries(entries []clientIpEntry) []string {
+	var summaries []string
+	for _, entry := range entries {
+		summaries = append(summaries, fmt.Sprintf("%s %d %v", entry.IP, entry.Timestamp, entry.SlaveIds))
+	}
+	return summaries
+}
+
+func TestMergeClientIps(t *testing.T) {
+	tests := []struct {
+		name       string
+		stored     []clientIpEntry
+		slaveId    int
+		reported   []protocol.ClientIp
+		limit      int
+		since      int64
+		wantMerged []string
+		wantExcess []string
+	}{
+		{
+			name:       "new addresses are appended by time, then address",
+			slaveId:    1,
+			reported:   []protocol.ClientIp{{IP: "10.0.0.3", Timestamp: 200}, {IP: "10.0.0.2", Timestamp: 100}, {IP: "10.0.0.1", Timestamp: 200}},
+			wantMerged: []string{"10.0.0.2 100 [1]", "10.0.0.1 200 [1]", "10.0.0.3 200 [1]"},
+		},
+		{
+			name:       "repeated addresses keep the latest time",
+			slaveId:    1,
+			reported:   []protocol.ClientIp{{IP: "10.0.0.1", Timestamp: 300}, {IP: "10.0.0.1", Timestamp: 100}},
+			wantMerged: []string{"10.0.0.1 300 [1]"},
+		},
+		{
+			name:       "stored addresses keep their order and learn the slave",
+			stored:     []clientIpEntry{{IP: "10.0.0.2", Timestamp: 100, SlaveIds: []int{3}}, {IP: "10.0.0.1", Timestamp: 150, SlaveIds: []int{3}}},
+			slaveId:    2,
+			reported:   []protocol.ClientIp{{IP: "10.0.0.2", Timestamp: 400}, {IP: "10.0.0.9", Timestamp: 50}},
+			wantMerged: []string{"10.0.0.2 400 [2 3]", "10.0.0.1 150 [3]", "10.0.0.9 50 [2]"},
+		},
+		{
+			name:       "an older report does not move a stored time back",
+			stored:     []clientIpEntry{{IP: "10.0.0.1", Timestamp: 500, SlaveIds: []int{1}}},
+			slaveId:    1,
+			reported:   []protocol.ClientIp{{IP: "10.0.0.1", Timestamp: 100}},
+			wantMerged: []string{"10.0.0.1 500 [1]"},
+		},
+		{
+			name:       "addresses not seen since the cutoff are forgotten",
+			stored:     []clientIpEntry{{IP: "10.0.0.1", Timestamp: 100, SlaveIds: []int{1}}, {IP: "10.0.0.2", Timestamp: 300, SlaveIds: []int{1}}},
+			slaveId:    1,
+			reported:   []protocol.ClientIp{{IP: "10.0.0.3", Timestamp: 150}, {IP: "10.0.0.4", Timestamp: 250}},
+			since:      200,
+			wantMerged: []string{"10.0.0.2 300 [1]", "10.0.0.4 250 [1]"},
+		},
+		{
+			name:       "addresses after the limit are excess",
+			stored:     []clientIpEntry{{IP: "10.0.0.1", Timestamp: 100, SlaveIds: []int{1}}, {IP: "10.0.0.2", Timestamp: 100, SlaveIds: []int{2}}},
+			slaveId:    1,
+			reported:   []protocol.ClientIp{{IP: "10.0.0.3", Timestamp: 200}, {IP: "10.0.0.1", Timestamp: 200}},
+			limit:      2,
+			wantMerged: []string{"10.0.0.1 200 [1]", "10.0.0.2 100 [2]", "10.0.0.3 200 [1]"},
+			wantExcess: []string{"10.0.0.3 200 [1]"},
+		},
+		{
+			name:       "no excess within the limit",
+			slaveId:    1,
+			reported:   []protocol.ClientIp{{IP: "10.0.0.1", Timestamp: 100}},
+			limit:      1,
+			wantMerged: []string{"10.0.0.1 100 [1]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, excess := mergeClientIps(tt.stored, tt.slaveId, tt.reported, tt.limit, tt.since)
+			if got := ipSummaries(merged); !slices.Equal(got, tt.wantMerged) {
+				t.Fatalf("mergeClientIps() merged = %q, want %q", got, tt.wantMerged)
+			}
+			if got := ipSummaries(excess); !slices.Equal(got, tt.wantExcess) {
+				t.Fatalf("mergeClientIps() excess = %q, want %q", got, tt.wantExcess)
+			}
+		})
+	}
+}
+
+func TestMergeClientIpsKeepsStored(t *testing.T) {
+	stored := []clientIpEntry{{IP: "10.0.0.1", Timestamp: 100, SlaveIds: []int{3}}}
+	mergeClientIps(stored, 1, []protocol.ClientIp{{IP: "10.0.0.1", Timestamp: 200}}, 0, 0)
+	if got := ipSummaries(stored); !slices.Equal(got, []string{"10.0.0.1 100 [3]"}) {
+		t.Fatalf("mergeClientIps() changed the stored entries to %q", got)
+	}
+}
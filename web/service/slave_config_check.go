@@ -0,0 +1,331 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// ErrInvalidSlaveConfig is returned when Xray on the master rejects a config generated for a slave.
+var ErrInvalidSlaveConfig = errors.New("xray rejected the config")
+
+var (
+	// Stands in for certificate files that only exist on the slaves
+	placeholderCertOnce sync.Once
+	placeholderCertPem  string
+	placeholderKeyPem   string
+	placeholderCertErr  error
+
+	missingXrayWarning sync.Once
+
+	// Validation results by master Xray version, geofiles and config hash, since the same config
+	// is checked on every edit of a slave and for every slave sharing a template
+	configCheckLock  sync.Mutex
+	configCheckCache = make(map[string]string)
+
+	// Version of the master's Xray binary, refreshed when the binary changes
+	masterXrayLock    sync.Mutex
+	masterXrayStamp   string
+	masterXrayVersion string
+
+	// Xray version pair last warned about per slave, so a mismatch is not logged on every check
+	versionWarningLock sync.Mutex
+	versionWarnings    = make(map[int]string)
+)
+
+// configCheckCacheSize bounds the remembered validation results; the cache starts over when full.
+const configCheckCacheSize = 256
+
+// CheckSlaveTemplate generates the slave's config from the given template instead of the saved
+// one and validates it, so a template change can be refused before it is saved.
+func (s *SlaveService) CheckSlaveTemplate(slaveId int, templateJson string) error {
+	xrayConfig, err := s.buildSlaveConfigFromTemplate(slaveId, templateJson)
+	if err != nil {
+		return err
+	}
+	return s.validateSlaveConfig(slaveId, xrayConfig)
+}
+
+// CheckSlaveInbound validates the config of the inbound's slave as it would be with the inbound
+// saved. previousTag is the tag the inbound had before an edit, whose entry it replaces.
+func (s *SlaveService) CheckSlaveInbound(inbound *model.Inbound, previousTag string) error {
+	xrayConfig, err := s.BuildSlaveConfig(inbound.SlaveId)
+	if err != nil {
+		return err
+	}
+	inbounds := xrayConfig.InboundConfigs[:0]
+	for _, existing := range xrayConfig.InboundConfigs {
+		if existing.Tag != inbound.Tag && (previousTag == "" || existing.Tag != previousTag) {
+			inbounds = append(inbounds, existing)
+		}
+	}
+	xrayConfig.InboundConfigs = inbounds
+
+	if inbound.Enable {
+		filtered, err := s.filterDisabledClients(inbound)
+		if err != nil {
+			filtered = inbound
+		}
+		xrayInbound := filtered.GenXrayInboundConfig()
+		certNames, err := storedCertNames()
+		if err != nil {
+			return fmt.Errorf("failed to load stored certificates: %v", err)
+		}
+		if err := resolveStoredCerts(xrayInbound, certNames); err != nil {
+			return err
+		}
+		xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, *xrayInbound)
+	}
+	return s.validateSlaveConfig(inbound.SlaveId, xrayConfig)
+}
+
+// validateSlaveConfig runs "xray -test" on the master against a config generated for a slave.
+// Certificate files only exist on the slave, so they are replaced by a placeholder certificate,
+// and geofiles are taken from the master's Xray and the geofile store. Without an Xray binary
+// on the master the config cannot be checked and is let through, as it is for a slave running
+// another Xray version, whose verdict could differ from the master's. SlaveValidationNotice
+// tells the admin about those.
+func (s *SlaveService) validateSlaveConfig(slaveId int, xrayConfig *xray.Config) error {
+	version, skipReason := s.checkedXrayVersion(slaveId)
+	if skipReason != "" {
+		return nil
+	}
+
+	hash, err := xrayConfig.Hash()
+	if err != nil {
+		return err
+	}
+	key := version + "|" + geofileStamp(config.GetBinFolderPath(), getGeofileDir()) + "|" + hash
+	configCheckLock.Lock()
+	reason, cached := configCheckCache[key]
+	configCheckLock.Unlock()
+
+	if !cached {
+		if reason, err = testSlaveConfig(xrayConfig); err != nil {
+			return err
+		}
+		configCheckLock.Lock()
+		if len(configCheckCache) >= configCheckCacheSize {
+			clear(configCheckCache)
+		}
+		configCheckCache[key] = reason
+		configCheckLock.Unlock()
+	}
+	if reason != "" {
+		logger.Warningf("Config of slave %d failed validation: %s", slaveId, reason)
+		return fmt.Errorf("%w of slave %d: %s", ErrInvalidSlaveConfig, slaveId, reason)
+	}
+	return nil
+}
+
+// testSlaveConfig runs the Xray test and returns why Xray rejected the config, or "" if it passed.
+func testSlaveConfig(xrayConfig *xray.Config) (string, error) {
+	testConfig, err := withPlaceholderCerts(xrayConfig)
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp("", "xray-check-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	linkGeofiles(dir, config.GetBinFolderPath())
+	linkGeofiles(dir, getGeofileDir())
+
+	process := xray.NewTestProcess(testConfig, filepath.Join(dir, "config.json"))
+	if err := process.Test(dir); err != nil {
+		return err.Error(), nil
+	}
+	return "", nil
+}
+
+// checkedXrayVersion returns the master's Xray version to check the slave's configs with, or why
+// they cannot be checked. A slave that did not report its version is checked anyway. Each reason
+// is logged once rather than on every check.
+func (s *SlaveService) checkedXrayVersion(slaveId int) (string, string) {
+	stat, err := os.Stat(xray.GetBinaryPath())
+	if err != nil {
+		missingXrayWarning.Do(func() {
+			logger.Warning("Xray is not installed on the master, slave configs are pushed without validation:", err)
+		})
+		return "", "Xray is not installed on the master, slave configs are not validated"
+	}
+	masterVersion := getMasterXrayVersion(stat)
+
+	var slave model.Slave
+	if err := database.GetDB().Select("system_stats").First(&slave, slaveId).Error; err != nil {
+		return masterVersion, ""
+	}
+	var stats protocol.SystemStats
+	if slave.SystemStats == "" || json.Unmarshal([]byte(slave.SystemStats), &stats) != nil {
+		return masterVersion, ""
+	}
+	slaveVersion := stats.XrayVersion
+	if slaveVersion == "" || slaveVersion == "Unknown" || masterVersion == "Unknown" || slaveVersion == masterVersion {
+		return masterVersion, ""
+	}
+
+	pair := masterVersion + "/" + slaveVersion
+	versionWarningLock.Lock()
+	warned := versionWarnings[slaveId] == pair
+	versionWarnings[slaveId] = pair
+	versionWarningLock.Unlock()
+	if !warned {
+		logger.Warningf("Slave %d runs Xray %s and the master %s, its configs are pushed without validation",
+			slaveId, slaveVersion, masterVersion)
+	}
+	return masterVersion, fmt.Sprintf("slave %d runs Xray %s and the master %s, its config is not validated",
+		slaveId, slaveVersion, masterVersion)
+}
+
+// SlaveValidationNotice tells why the configs of the given slaves go out without being checked by
+// Xray on the master, or returns "" if they are all checked. Slave id 0 is the master itself.
+func (s *SlaveService) SlaveValidationNotice(slaveIds ...int) string {
+	var notices []string
+	for _, slaveId := range slaveIds {
+		if slaveId <= 0 {
+			continue
+		}
+		if _, reason := s.checkedXrayVersion(slaveId); reason != "" && !slices.Contains(notices, reason) {
+			notices = append(notices, reason)
+		}
+	}
+	return strings.Join(notices, "; ")
+}
+
+// getMasterXrayVersion returns the version of the master's Xray binary, running it only when
+// the binary changed since the last call.
+func getMasterXrayVersion(stat os.FileInfo) string {
+	stamp := fmt.Sprintf("%d/%d", stat.Size(), stat.ModTime().UnixNano())
+	masterXrayLock.Lock()
+	defer masterXrayLock.Unlock()
+	if stamp != masterXrayStamp {
+		masterXrayVersion = xray.GetBinaryVersion()
+		masterXrayStamp = stamp
+	}
+	return masterXrayVersion
+}
+
+// geofileStamp describes the geofiles in dirs by name, size and modification time, so a cached
+// validation result is not reused once a geofile changed.
+func geofileStamp(dirs ...string) string {
+	var stamp strings.Builder
+	for _, dir := range dirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.dat"))
+		for _, path := range matches {
+			if info, err := os.Stat(path); err == nil {
+				fmt.Fprintf(&stamp, "%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+			}
+		}
+	}
+	return stamp.String()
+}
+
+// withPlaceholderCerts returns a copy of the config whose inbound certificate files are replaced
+// by an inline placeholder certificate.
+func withPlaceholderCerts(xrayConfig *xray.Config) (*xray.Config, error) {
+	testConfig := *xrayConfig
+	testConfig.InboundConfigs = make([]xray.InboundConfig, len(xrayConfig.InboundConfigs))
+	copy(testConfig.InboundConfigs, xrayConfig.InboundConfigs)
+
+	for i := range testConfig.InboundConfigs {
+		inbound := &testConfig.InboundConfigs[i]
+		var stream map[string]any
+		if len(inbound.StreamSettings) == 0 || json.Unmarshal(inbound.StreamSettings, &stream) != nil {
+			continue
+		}
+		changed := false
+		for _, cert := range tlsCertificates(stream) {
+			_, hasCertFile := cert["certificateFile"]
+			_, hasKeyFile := cert["keyFile"]
+			if !hasCertFile && !hasKeyFile {
+				continue
+			}
+			certPem, keyPem, err := placeholderCert()
+			if err != nil {
+				return nil, err
+			}
+			delete(cert, "certificateFile")
+			delete(cert, "keyFile")
+			cert["certificate"] = strings.Split(strings.TrimSpace(certPem), "\n")
+			cert["key"] = strings.Split(strings.TrimSpace(keyPem), "\n")
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		data, err := json.Marshal(stream)
+		if err != nil {
+			return nil, err
+		}
+		inbound.StreamSettings = data
+	}
+	return &testConfig, nil
+}
+
+// placeholderCert returns a self-signed CA certificate usable for both serving and issuing.
+func placeholderCert() (string, string, error) {
+	placeholderCertOnce.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			placeholderCertErr = err
+			return
+		}
+		serial, err := randomSerial()
+		if err != nil {
+			placeholderCertErr = err
+			return
+		}
+		now := time.Now()
+		template := &x509.Certificate{
+			SerialNumber:          serial,
+			Subject:               pkix.Name{CommonName: "3x-ui config check"},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.AddDate(10, 0, 0),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			placeholderCertErr = err
+			return
+		}
+		placeholderCertPem, placeholderKeyPem, placeholderCertErr = encodeCertAndKey(der, key)
+	})
+	return placeholderCertPem, placeholderKeyPem, placeholderCertErr
+}
+
+// linkGeofiles links the .dat files of src into dir, replacing files of the same name.
+func linkGeofiles(dir string, src string) {
+	matches, _ := filepath.Glob(filepath.Join(src, "*.dat"))
+	for _, path := range matches {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		target := filepath.Join(dir, filepath.Base(path))
+		os.Remove(target)
+		if err := os.Symlink(path, target); err != nil {
+			logger.Debugf("Failed to link geofile %s for config check: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+const testVlessSettings = `{"decryption":"none","clients":[{"id":"b831381d-6324-4d53-ad4f-8cda48b30811","email":"alice","enable":true}]}`
+
+// initConfigCheck opens a fresh database with one slave for config checks, skipping the test
+// when the master has no Xray binary to check with.
+func initConfigCheck(t *testing.T) (*SlaveService, *model.Slave) {
+	t.Helper()
+	if _, err := os.Stat(xray.GetBinaryPath()); err != nil {
+		t.Skip("Xray is not installed, set XUI_BIN_FOLDER to run config checks")
+	}
+	initTestDB(t)
+	configCheckLock.Lock()
+	clear(configCheckCache)
+	configCheckLock.Unlock()
+
+	slaveService := &SlaveService{}
+	slave := &model.Slave{Name: "edge"}
+	if err := slaveService.AddSlave(slave); err != nil {
+		t.Fatalf("AddSlave() error = %v", err)
+	}
+	return slaveService, slave
+}
+
+// setSlaveXrayVersion stores the Xray version the slave reported.
+func setSlaveXrayVersion(t *testing.T, slaveId int, version string) {
+	t.Helper()
+	stats, err := json.Marshal(protocol.SystemStats{XrayVersion: version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.GetDB().Model(&model.Slave{}).Where("id = ?", slaveId).
+		Update("system_stats", string(stats)).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckSlaveInbound(t *testing.T) {
+	slaveService, slave := initConfigCheck(t)
+
+	tests := []struct {
+		name      string
+		inbound   model.Inbound
+		wantError bool
+	}{
+		{
+			name:    "valid inbound",
+			inbound: model.Inbound{Enable: true, Port: 4431, Protocol: model.VLESS, Settings: testVlessSettings, StreamSettings: `{"network":"tcp"}`},
+		},
+		{
+			name:      "unknown transport",
+			inbound:   model.Inbound{Enable: true, Port: 4432, Protocol: model.VLESS, Settings: testVlessSettings, StreamSettings: `{"network":"carrier-pigeon"}`},
+			wantError: true,
+		},
+		{
+			name:      "unsupported flow",
+			inbound:   model.Inbound{Enable: true, Port: 4433, Protocol: model.VLESS, Settings: `{"decryption":"none","clients":[{"id":"b831381d-6324-4d53-ad4f-8cda48b30811","email":"bob","flow":"bogus"}]}`, StreamSettings: `{"network":"tcp"}`},
+			wantError: true,
+		},
+		{
+			name:    "disabled inbound is left out",
+			inbound: model.Inbound{Port: 4434, Protocol: model.VLESS, Settings: testVlessSettings, StreamSettings: `{"network":"carrier-pigeon"}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inbound := tt.inbound
+			inbound.SlaveId = slave.Id
+			inbound.Tag = "in-" + strings.ReplaceAll(tt.name, " ", "-")
+			err := slaveService.CheckSlaveInbound(&inbound, "")
+			if tt.wantError != (err != nil) {
+				t.Fatalf("CheckSlaveInbound() error = %v, want error %v", err, tt.wantError)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSlaveConfig) {
+				t.Fatalf("CheckSlaveInbound() error = %v, want %v", err, ErrInvalidSlaveConfig)
+			}
+		})
+	}
+}
+
+func TestCheckSlaveTemplate(t *testing.T) {
+	slaveService, slave := initConfigCheck(t)
+
+	tests := []struct {
+		name      string
+		template  string
+		wantError bool
+	}{
+		{"valid template", `{"outbounds":[{"protocol":"freedom","tag":"direct"}]}`, false},
+		{"unknown outbound protocol", `{"outbounds":[{"protocol":"teleport","tag":"direct"}]}`, true},
+		{"invalid routing rule", `{"outbounds":[{"protocol":"freedom","tag":"direct"}],"routing":{"rules":[{"ip":["not-an-ip"],"outboundTag":"direct"}]}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := slaveService.CheckSlaveTemplate(slave.Id, tt.template)
+			if tt.wantError != (err != nil) {
+				t.Fatalf("CheckSlaveTemplate() error = %v, want error %v", err, tt.wantError)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSlaveConfig) {
+				t.Fatalf("CheckSlaveTemplate() error = %v, want %v", err, ErrInvalidSlaveConfig)
+			}
+		})
+	}
+}
+
+func TestValidateSlaveConfigCache(t *testing.T) {
+	slaveService, slave := initConfigCheck(t)
+	xrayConfig, err := slaveService.buildSlaveConfigFromTemplate(slave.Id, `{"outbounds":[{"protocol":"freedom","tag":"direct"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := slaveService.validateSlaveConfig(slave.Id, xrayConfig); err != nil {
+		t.Fatalf("validateSlaveConfig() error = %v", err)
+	}
+	configCheckLock.Lock()
+	if len(configCheckCache) != 1 {
+		t.Fatalf("cache holds %d results, want 1", len(configCheckCache))
+	}
+	// A cached verdict is used instead of running Xray again
+	for key := range configCheckCache {
+		configCheckCache[key] = "cached verdict"
+	}
+	configCheckLock.Unlock()
+
+	err = slaveService.validateSlaveConfig(slave.Id, xrayConfig)
+	if !errors.Is(err, ErrInvalidSlaveConfig) || !strings.Contains(err.Error(), "cached verdict") {
+		t.Fatalf("validateSlaveConfig() error = %v, want the cached verdict", err)
+	}
+
+	// Another slave with the same config shares the result
+	other := &model.Slave{Name: "other"}
+	if err := slaveService.AddSlave(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := slaveService.validateSlaveConfig(other.Id, xrayConfig); err == nil {
+		t.Fatal("validateSlaveConfig() of another slave did not reuse the cached verdict")
+	}
+
+	// A changed config is checked on its own
+	xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, testInbound("in-1", 4431, "vless", testVlessSettings))
+	if err := slaveService.validateSlaveConfig(slave.Id, xrayConfig); err != nil {
+		t.Fatalf("validateSlaveConfig() of a changed config error = %v", err)
+	}
+	configCheckLock.Lock()
+	defer configCheckLock.Unlock()
+	if len(configCheckCache) != 2 {
+		t.Fatalf("cache holds %d results, want 2", len(configCheckCache))
+	}
+}
+
+func TestValidateSlaveConfigVersionMismatch(t *testing.T) {
+	slaveService, slave := initConfigCheck(t)
+	invalid := &model.Inbound{
+		SlaveId:        slave.Id,
+		Enable:         true,
+		Port:           4431,
+		Protocol:       model.VLESS,
+		Settings:       testVlessSettings,
+		StreamSettings: `{"network":"carrier-pigeon"}`,
+		Tag:            "in-1",
+	}
+
+	if notice := slaveService.SlaveValidationNotice(slave.Id); notice != "" {
+		t.Fatalf("SlaveValidationNotice() = %q for a slave without a reported version", notice)
+	}
+	if err := slaveService.CheckSlaveInbound(invalid, ""); err == nil {
+		t.Fatal("CheckSlaveInbound() passed an invalid inbound")
+	}
+
+	setSlaveXrayVersion(t, slave.Id, "0.0.1")
+	if err := slaveService.CheckSlaveInbound(invalid, ""); err != nil {
+		t.Fatalf("CheckSlaveInbound() error = %v, want the check skipped", err)
+	}
+	if notice := slaveService.SlaveValidationNotice(slave.Id, 0); !strings.Contains(notice, "0.0.1") {
+		t.Fatalf("SlaveValidationNotice() = %q, want the slave's version", notice)
+	}
+}
+
+func TestAddInboundClientChecksSlaveConfig(t *testing.T) {
+	_, slave := initConfigCheck(t)
+	inboundService := &InboundService{}
+	inbound := &model.Inbound{
+		SlaveId:        slave.Id,
+		Enable:         true,
+		Port:           4431,
+		Protocol:       model.VLESS,
+		Settings:       testVlessSettings,
+		StreamSettings: `{"network":"tcp"}`,
+		Tag:            "in-1",
+	}
+	if err := database.GetDB().Create(inbound).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := inboundService.AddInboundClient(&model.Inbound{
+		Id:       inbound.Id,
+		Settings: `{"clients":[{"id":"5f2b6bb4-5a8a-4d52-9a4c-0e1bde1f1d1c","email":"bob","flow":"bogus","enable":true}]}`,
+	})
+	if !errors.Is(err, ErrInvalidSlaveConfig) {
+		t.Fatalf("AddInboundClient() error = %v, want %v", err, ErrInvalidSlaveConfig)
+	}
+	stored, err := inboundService.GetInbound(inbound.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Settings != testVlessSettings {
+		t.Fatalf("rejected client was saved: %s", stored.Settings)
+	}
+}
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// apiInboundTag is the template inbound serving the Xray API; it is never changed live.
+const apiInboundTag = "api"
+
+// pushConfigDelta sends newConfig as live operations against the config the slave last acknowledged.
+// It reports false without sending anything when no usable base exists or the template changed,
+// in which case the caller falls back to a full push.
+func (s *SlaveService) pushConfigDelta(slaveId int, conn *protocol.Conn, revision int64, newConfig *xray.Config, newConfigBytes []byte) (bool, error) {
+	var slave model.Slave
+	if err := database.GetDB().Select("applied_revision").First(&slave, slaveId).Error; err != nil {
+		return false, err
+	}
+	if slave.AppliedRevision == 0 {
+		return false, nil
+	}
+
+	baseRevision, baseJson, err := s.SlaveSettingService.GetAppliedConfigForSlave(slaveId)
+	if err != nil || baseRevision != slave.AppliedRevision {
+		logger.Debugf("PushConfig: no applied config base for slave %d, using full push", slaveId)
+		return false, nil
+	}
+
+	var baseConfig xray.Config
+	if err := json.Unmarshal([]byte(baseJson), &baseConfig); err != nil {
+		logger.Warningf("PushConfig: stored config of slave %d is invalid: %v", slaveId, err)
+		return false, nil
+	}
+
+	ops, ok := diffSlaveConfigs(&baseConfig, newConfig)
+	if !ok {
+		logger.Infof("PushConfig: template of slave %d changed, full restart required", slaveId)
+		return false, nil
+	}
+
+	logger.Infof("PushConfig: sending update_config_delta revision %d (base %d, %d ops) to slave %d",
+		revision, baseRevision, len(ops), slaveId)
+	return true, conn.Send(protocol.TypeUpdateConfigDelta, "", protocol.ConfigDelta{
+		Revision:     revision,
+		BaseRevision: baseRevision,
+		Ops:          ops,
+		Config:       string(newConfigBytes),
+	})
+}
+
+// diffSlaveConfigs computes the live operations turning oldConfig into newConfig.
+// It returns false when anything besides the generated inbounds changed (routing, outbounds,
+// DNS and the rest of the template), since those can only be applied by restarting Xray.
+func diffSlaveConfigs(oldConfig, newConfig *xray.Config) ([]protocol.DeltaOp, bool) {
+	if !templateEqual(oldConfig, newConfig) {
+		return nil, false
+	}
+
+	newInbounds := make(map[string]*xray.InboundConfig, len(newConfig.InboundConfigs))
+	for i := range newConfig.InboundConfigs {
+		newInbounds[newConfig.InboundConfigs[i].Tag] = &newConfig.InboundConfigs[i]
+	}
+
+	var removals, additions []protocol.DeltaOp
+	oldTags := make(map[string]bool, len(oldConfig.InboundConfigs))
+	for i := range oldConfig.InboundConfigs {
+		oldInbound := &oldConfig.InboundConfigs[i]
+		oldTags[oldInbound.Tag] = true
+
+		newInbound, exists := newInbounds[oldInbound.Tag]
+		if exists && inboundEqual(oldInbound, newInbound) {
+			continue
+		}
+		if oldInbound.Tag == apiInboundTag || oldInbound.Tag == "" {
+			return nil, false
+		}
+		if !exists {
+			removals = append(removals, protocol.DeltaOp{Op: protocol.OpRemoveInbound, Tag: oldInbound.Tag})
+			continue
+		}
+
+		// Client-only changes are applied per user so other users keep their connections
+		if userRemovals, userAdditions, ok := diffInboundClients(oldInbound, newInbound); ok {
+			removals = append(removals, userRemovals...)
+			additions = append(additions, userAdditions...)
+			continue
+		}
+
+		addOp, ok := addInboundOp(newInbound)
+		if !ok {
+			return nil, false
+		}
+		removals = append(removals, protocol.DeltaOp{Op: protocol.OpRemoveInbound, Tag: oldInbound.Tag})
+		additions = append(additions, addOp)
+	}
+
+	for i := range newConfig.InboundConfigs {
+		newInbound := &newConfig.InboundConfigs[i]
+		if oldTags[newInbound.Tag] {
+			continue
+		}
+		if newInbound.Tag == apiInboundTag || newInbound.Tag == "" {
+			return nil, false
+		}
+		addOp, ok := addInboundOp(newInbound)
+		if !ok {
+			return nil, false
+		}
+		additions = append(additions, addOp)
+	}
+
+	// Removals go first so a re-added inbound can take over its port
+	return append(removals, additions...), true
+}
+
+// templateEqual reports whether every config section other than the inbounds is unchanged.
+func templateEqual(a, b *xray.Config) bool {
+	sections := [][2][]byte{
+		{a.LogConfig, b.LogConfig},
+		{a.RouterConfig, b.RouterConfig},
+		{a.DNSConfig, b.DNSConfig},
+		{a.OutboundConfigs, b.OutboundConfigs},
+		{a.Transport, b.Transport},
+		{a.Policy, b.Policy},
+		{a.API, b.API},
+		{a.Stats, b.Stats},
+		{a.Reverse, b.Reverse},
+		{a.FakeDNS, b.FakeDNS},
+		{a.Observatory, b.Observatory},
+		{a.BurstObservatory, b.BurstObservatory},
+		{a.Metrics, b.Metrics},
+	}
+	for _, section := range sections {
+		if !jsonEqual(section[0], section[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// inboundEqual is InboundConfig.Equals with semantic JSON comparison, so a config that went
+// through a marshal round trip still matches the freshly generated one.
+func inboundEqual(a, b *xray.InboundConfig) bool {
+	return a.Tag == b.Tag &&
+		a.Port == b.Port &&
+		a.Protocol == b.Protocol &&
+		jsonEqual(a.Listen, b.Listen) &&
+		jsonEqual(a.Settings, b.Settings) &&
+		jsonEqual(a.StreamSettings, b.StreamSettings) &&
+		jsonEqual(a.Sniffing, b.Sniffing)
+}
+
+// jsonEqual compares two JSON documents semantically; empty input counts as null.
+func jsonEqual(a, b []byte) bool {
+	var va, vb any
+	if len(a) > 0 && json.Unmarshal(a, &va) != nil {
+		return false
+	}
+	if len(b) > 0 && json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func addInboundOp(inbound *xray.InboundConfig) (protocol.DeltaOp, bool) {
+	data, err := json.Marshal(inbound)
+	if err != nil {
+		return protocol.DeltaOp{}, false
+	}
+	return protocol.DeltaOp{Op: protocol.OpAddInbound, Tag: inbound.Tag, Inbound: data}, true
+}
+
+// diffInboundClients returns user operations when the two inbounds differ only in their client list
+// and the protocol supports adding users through the API.
+func diffInboundClients(oldInbound, newInbound *xray.InboundConfig) ([]protocol.DeltaOp, []protocol.DeltaOp, bool) {
+	if oldInbound.Protocol != newInbound.Protocol ||
+		oldInbound.Port != newInbound.Port ||
+		!jsonEqual(oldInbound.Listen, newInbound.Listen) ||
+		!jsonEqual(oldInbound.StreamSettings, newInbound.StreamSettings) ||
+		!jsonEqual(oldInbound.Sniffing, newInbound.Sniffing) {
+		return nil, nil, false
+	}
+
+	var oldSettings, newSettings map[string]any
+	if json.Unmarshal(oldInbound.Settings, &oldSettings) != nil || json.Unmarshal(newInbound.Settings, &newSettings) != nil {
+		return nil, nil, false
+	}
+
+	cipher, ok := liveUserCipher(newInbound.Protocol, newSettings)
+	if !ok {
+		return nil, nil, false
+	}
+
+	oldClients, ok := clientsByEmail(oldSettings)
+	if !ok {
+		return nil, nil, false
+	}
+	newClients, ok := clientsByEmail(newSettings)
+	if !ok {
+		return nil, nil, false
+	}
+
+	// Everything but the clients must match
+	delete(oldSettings, "clients")
+	delete(newSettings, "clients")
+	if !reflect.DeepEqual(oldSettings, newSettings) {
+		return nil, nil, false
+	}
+
+	var removals, additions []protocol.DeltaOp
+	for email, oldClient := range oldClients {
+		if newClient, exists := newClients[email]; exists && reflect.DeepEqual(oldClient, newClient) {
+			continue
+		}
+		removals = append(removals, protocol.DeltaOp{Op: protocol.OpRemoveUser, Tag: oldInbound.Tag, Email: email})
+	}
+	for email, newClient := range newClients {
+		if oldClient, exists := oldClients[email]; exists && reflect.DeepEqual(oldClient, newClient) {
+			continue
+		}
+		additions = append(additions, protocol.DeltaOp{
+			Op:       protocol.OpAddUser,
+			Tag:      newInbound.Tag,
+			Protocol: newInbound.Protocol,
+			User:     liveUser(email, newClient, cipher),
+		})
+	}
+	return removals, additions, true
+}
+
+// liveUserCipher reports whether users of the protocol can be managed through the Xray API,
+// and the cipher to use for Shadowsocks users.
+func liveUserCipher(protocolName string, settings map[string]any) (string, bool) {
+	switch protocolName {
+	case "vmess", "vless", "trojan":
+		return "", true
+	case "shadowsocks":
+		// Shadowsocks 2022 users need the server key, which AddUser does not handle
+		method, _ := settings["method"].(string)
+		if method == "" || strings.HasPrefix(method, "2022-") {
+			return "", false
+		}
+		return method, true
+	default:
+		return "", false
+	}
+}
+
+// clientsByEmail indexes the settings' clients by email. Clients without a unique email cannot be
+// addressed through the API, so their presence makes the result unusable.
+func clientsByEmail(settings map[string]any) (map[string]map[string]any, bool) {
+	result := make(map[string]map[string]any)
+	clients, _ := settings["clients"].([]any)
+	for _, c := range clients {
+		client, ok := c.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		email, _ := client["email"].(string)
+		if email == "" {
+			return nil, false
+		}
+		if _, dup := result[email]; dup {
+			return nil, false
+		}
+		result[email] = client
+	}
+	return result, true
+}
+
+// liveUser builds the user map expected by XrayAPI.AddUser from a client entry.
+func liveUser(email string, client map[string]any, cipher string) map[string]any {
+	str := func(key string) string {
+		v, _ := client[key].(string)
+		return v
+	}
+	return map[string]any{
+		"email":    email,
+		"id":       str("id"),
+		"security": str("security"),
+		"flow":     str("flow"),
+		"password": str("password"),
+		"cipher":   cipher,
+	}
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/util/json_util"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+func testInbound(tag string, port int, protocolName string, settings string) xray.InboundConfig {
+	return xray.InboundConfig{
+		Tag:            tag,
+		Port:           port,
+		Protocol:       protocolName,
+		Settings:       json_util.RawMessage(settings),
+		StreamSettings: json_util.RawMessage(`{"network":"tcp"}`),
+	}
+}
+
+func testConfig(routing string, inbounds ...xray.InboundConfig) *xray.Config {
+	return &xray.Config{
+		RouterConfig:   json_util.RawMessage(routing),
+		InboundConfigs: inbounds,
+	}
+}
+
+// opSummaries describes operations as "op tag email".
+func opSummaries(ops []protocol.DeltaOp) []string {
+	var summaries []string
+	for _, op := range ops {
+		email := op.Email
+		if op.User != nil {
+			email, _ = op.User["email"].(string)
+		}
+		summaries = append(summaries, op.Op+" "+op.Tag+" "+email)
+	}
+	return summaries
+}
+
+func TestDiffSlaveConfigs(t *testing.T) {
+	vless := func(clients string) string {
+		return `{"decryption":"none","clients":[` + clients + `]}`
+	}
+	alice := `{"id":"1","email":"alice"}`
+	bob := `{"id":"2","email":"bob"}`
+	api := testInbound(apiInboundTag, 62789, "dokodemo-door", `{"address":"127.0.0.1"}`)
+
+	tests := []struct {
+		name    string
+		old     *xray.Config
+		new     *xray.Config
+		wantOps []string
+		wantOk  bool
+	}{
+		{
+			name:   "unchanged",
+			old:    testConfig(`{"rules":[]}`, api, testInbound("in-1", 443, "vless", vless(alice))),
+			new:    testConfig(`{"rules": []}`, api, testInbound("in-1", 443, "vless", vless(alice))),
+			wantOk: true,
+		},
+		{
+			name:   "routing change needs a restart",
+			old:    testConfig(`{"rules":[]}`, testInbound("in-1", 443, "vless", vless(alice))),
+			new:    testConfig(`{"rules":[{"outboundTag":"block"}]}`, testInbound("in-1", 443, "vless", vless(alice))),
+			wantOk: false,
+		},
+		{
+			name:    "client added and removed",
+			old:     testConfig(`{}`, testInbound("in-1", 443, "vless", vless(alice))),
+			new:     testConfig(`{}`, testInbound("in-1", 443, "vless", vless(bob))),
+			wantOps: []string{"remove_user in-1 alice", "add_user in-1 bob"},
+			wantOk:  true,
+		},
+		{
+			name:    "changed client is replaced",
+			old:     testConfig(`{}`, testInbound("in-1", 443, "vless", vless(alice))),
+			new:     testConfig(`{}`, testInbound("in-1", 443, "vless", vless(`{"id":"9","email":"alice"}`))),
+			wantOps: []string{"remove_user in-1 alice", "add_user in-1 alice"},
+			wantOk:  true,
+		},
+		{
+			name:    "port change replaces the inbound",
+			old:     testConfig(`{}`, testInbound("in-1", 443, "vless", vless(alice))),
+			new:     testConfig(`{}`, testInbound("in-1", 8443, "vless", vless(alice))),
+			wantOps: []string{"remove_inbound in-1 ", "add_inbound in-1 "},
+			wantOk:  true,
+		},
+		{
+			name:    "inbound added and removed",
+			old:     testConfig(`{}`, testInbound("in-1", 443, "vless", vless(alice))),
+			new:     testConfig(`{}`, testInbound("in-2", 444, "trojan", `{"clients":[{"password":"p","email":"carol"}]}`)),
+			wantOps: []string{"remove_inbound in-1 ", "add_inbound in-2 "},
+			wantOk:  true,
+		},
+		{
+			name:    "shadowsocks 2022 clients are replaced with the inbound",
+			old:     testConfig(`{}`, testInbound("ss", 8388, "shadowsocks", `{"method":"2022-blake3-aes-128-gcm","clients":[{"password":"a","email":"alice"}]}`)),
+			new:     testConfig(`{}`, testInbound("ss", 8388, "shadowsocks", `{"method":"2022-blake3-aes-128-gcm","clients":[{"password":"b","email":"bob"}]}`)),
+			wantOps: []string{"remove_inbound ss ", "add_inbound ss "},
+			wantOk:  true,
+		},
+		{
+			name:    "clients without email replace the inbound",
+			old:     testConfig(`{}`, testInbound("in-1", 443, "vless", vless(`{"id":"1"}`))),
+			new:     testConfig(`{}`, testInbound("in-1", 443, "vless", vless(`{"id":"2"}`))),
+			wantOps: []string{"remove_inbound in-1 ", "add_inbound in-1 "},
+			wantOk:  true,
+		},
+		{
+			name:   "api inbound change needs a restart",
+			old:    testConfig(`{}`, api),
+			new:    testConfig(`{}`, testInbound(apiInboundTag, 10085, "dokodemo-door", `{"address":"127.0.0.1"}`)),
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, ok := diffSlaveConfigs(tt.old, tt.new)
+			if ok != tt.wantOk {
+				t.Fatalf("diffSlaveConfigs() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if got := opSummaries(ops); !slices.Equal(got, tt.wantOps) {
+				t.Fatalf("diffSlaveConfigs() ops = %q, want %q", got, tt.wantOps)
+			}
+			for _, op := range ops {
+				if op.Op == protocol.OpAddInbound && !json.Valid(op.Inbound) {
+					t.Fatalf("add_inbound %s carries invalid JSON", op.Tag)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// SlaveConfigPreview is the config a push would send to a slave, compared with the config the
+// slave last confirmed running.
+type SlaveConfigPreview struct {
+	Config       json.RawMessage  `json:"config"`       // exactly what PushConfig would send
+	BaseRevision int64            `json:"baseRevision"` // revision of the applied config, 0 if none is known
+	Restart      bool             `json:"restart"`      // the push restarts Xray instead of applying live changes
+	LiveOps      int              `json:"liveOps"`      // live operations the push consists of otherwise
+	Diff         *SlaveConfigDiff `json:"diff"`
+}
+
+// SlaveConfigDiff lists what a push changes in a slave's config.
+type SlaveConfigDiff struct {
+	InboundsAdded    []string            `json:"inboundsAdded"`
+	InboundsRemoved  []string            `json:"inboundsRemoved"`
+	InboundsChanged  []InboundConfigDiff `json:"inboundsChanged"`
+	OutboundsAdded   []string            `json:"outboundsAdded"`
+	OutboundsRemoved []string            `json:"outboundsRemoved"`
+	OutboundsChanged []string            `json:"outboundsChanged"`
+	RulesAdded       []json.RawMessage   `json:"rulesAdded"`
+	RulesRemoved     []json.RawMessage   `json:"rulesRemoved"`
+	RoutingChanged   []string            `json:"routingChanged"`  // routing settings other than the rules
+	SectionsChanged  []string            `json:"sectionsChanged"` // other top level sections, e.g. dns or policy
+}
+
+// InboundConfigDiff describes the changes to an inbound that exists before and after a push.
+type InboundConfigDiff struct {
+	Tag            string   `json:"tag"`
+	Fields         []string `json:"fields"` // changed inbound fields; a change limited to the clients is listed as "clients"
+	ClientsAdded   []string `json:"clientsAdded"`
+	ClientsRemoved []string `json:"clientsRemoved"`
+	ClientsChanged []string `json:"clientsChanged"`
+}
+
+// PreviewSlaveConfig generates the config PushConfig would send to a slave and diffs it against the
+// config the slave last acknowledged. A non-empty templateJson replaces the slave's saved template,
+// so a template edit can be previewed before it is saved. Nothing is sent to the slave, which may be
+// offline.
+func (s *SlaveService) PreviewSlaveConfig(slaveId int, templateJson string) (*SlaveConfigPreview, error) {
+	if _, err := s.GetSlave(slaveId); err != nil {
+		return nil, err
+	}
+	var newConfig *xray.Config
+	var err error
+	if templateJson != "" {
+		newConfig, err = s.buildSlaveConfigFromTemplate(slaveId, templateJson)
+	} else {
+		newConfig, err = s.BuildSlaveConfig(slaveId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	configBytes, err := json.Marshal(newConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal xray config: %v", err)
+	}
+
+	preview := &SlaveConfigPreview{Config: configBytes, Restart: true}
+	baseConfig := &xray.Config{}
+	if revision, baseJson, err := s.SlaveSettingService.GetAppliedConfigForSlave(slaveId); err == nil {
+		var applied xray.Config
+		if err := json.Unmarshal([]byte(baseJson), &applied); err == nil {
+			baseConfig = &applied
+			preview.BaseRevision = revision
+			if ops, ok := diffSlaveConfigs(baseConfig, newConfig); ok {
+				preview.Restart = false
+				preview.LiveOps = len(ops)
+			}
+		}
+	}
+	preview.Diff = describeConfigDiff(baseConfig, newConfig)
+	return preview, nil
+}
+
+// describeConfigDiff lists the differences between two configs section by section.
+func describeConfigDiff(oldConfig, newConfig *xray.Config) *SlaveConfigDiff {
+	diff := &SlaveConfigDiff{}
+	diffInbounds(diff, oldConfig.InboundConfigs, newConfig.InboundConfigs)
+	diff.OutboundsAdded, diff.OutboundsRemoved, diff.OutboundsChanged = diffOutbounds(oldConfig.OutboundConfigs, newConfig.OutboundConfigs)
+	diff.RulesAdded, diff.RulesRemoved, diff.RoutingChanged = diffRouting(oldConfig.RouterConfig, newConfig.RouterConfig)
+
+	sections := []struct {
+		name     string
+		old, new []byte
+	}{
+		{"log", oldConfig.LogConfig, newConfig.LogConfig},
+		{"dns", oldConfig.DNSConfig, newConfig.DNSConfig},
+		{"transport", oldConfig.Transport, newConfig.Transport},
+		{"policy", oldConfig.Policy, newConfig.Policy},
+		{"api", oldConfig.API, newConfig.API},
+		{"stats", oldConfig.Stats, newConfig.Stats},
+		{"reverse", oldConfig.Reverse, newConfig.Reverse},
+		{"fakedns", oldConfig.FakeDNS, newConfig.FakeDNS},
+		{"observatory", oldConfig.Observatory, newConfig.Observatory},
+		{"burstObservatory", oldConfig.BurstObservatory, newConfig.BurstObservatory},
+		{"metrics", oldConfig.Metrics, newConfig.Metrics},
+	}
+	for _, section := range sections {
+		if !jsonEqual(section.old, section.new) {
+			diff.SectionsChanged = append(diff.SectionsChanged, section.name)
+		}
+	}
+	return diff
+}
+
+func diffInbounds(diff *SlaveConfigDiff, oldInbounds, newInbounds []xray.InboundConfig) {
+	oldByTag := make(map[string]*xray.InboundConfig, len(oldInbounds))
+	for i := range oldInbounds {
+		oldByTag[oldInbounds[i].Tag] = &oldInbounds[i]
+	}
+	newTags := make(map[string]bool, len(newInbounds))
+	for i := range newInbounds {
+		newInbound := &newInbounds[i]
+		newTags[newInbound.Tag] = true
+		oldInbound, exists := oldByTag[newInbound.Tag]
+		if !exists {
+			diff.InboundsAdded = append(diff.InboundsAdded, newInbound.Tag)
+			continue
+		}
+		if change, changed := diffInbound(oldInbound, newInbound); changed {
+			diff.InboundsChanged = append(diff.InboundsChanged, change)
+		}
+	}
+	for i := range oldInbounds {
+		if !newTags[oldInbounds[i].Tag] {
+			diff.InboundsRemoved = append(diff.InboundsRemoved, oldInbounds[i].Tag)
+		}
+	}
+}
+
+// diffInbound lists the changed fields of an inbound and, when its settings differ, which clients
+// were added, removed or changed.
+func diffInbound(oldInbound, newInbound *xray.InboundConfig) (InboundConfigDiff, bool) {
+	change := InboundConfigDiff{Tag: newInbound.Tag}
+	if !jsonEqual(oldInbound.Listen, newInbound.Listen) {
+		change.Fields = append(change.Fields, "listen")
+	}
+	if oldInbound.Port != newInbound.Port {
+		change.Fields = append(change.Fields, "port")
+	}
+	if oldInbound.Protocol != newInbound.Protocol {
+		change.Fields = append(change.Fields, "protocol")
+	}
+	if !jsonEqual(oldInbound.Settings, newInbound.Settings) {
+		change.Fields = append(change.Fields, diffInboundSettings(&change, oldInbound.Settings, newInbound.Settings))
+	}
+	if !jsonEqual(oldInbound.StreamSettings, newInbound.StreamSettings) {
+		change.Fields = append(change.Fields, "streamSettings")
+	}
+	if !jsonEqual(oldInbound.Sniffing, newInbound.Sniffing) {
+		change.Fields = append(change.Fields, "sniffing")
+	}
+	return change, len(change.Fields) > 0
+}
+
+// diffInboundSettings fills in the client changes between two differing inbound settings and
+// returns "clients" if nothing else changed, or "settings" otherwise.
+func diffInboundSettings(change *InboundConfigDiff, oldSettingsJson, newSettingsJson []byte) string {
+	var oldSettings, newSettings map[string]any
+	if json.Unmarshal(oldSettingsJson, &oldSettings) != nil || json.Unmarshal(newSettingsJson, &newSettings) != nil {
+		return "settings"
+	}
+	oldClients, oldOk := clientsByEmail(oldSettings)
+	newClients, newOk := clientsByEmail(newSettings)
+	if !oldOk || !newOk {
+		return "settings"
+	}
+	for email, newClient := range newClients {
+		oldClient, exists := oldClients[email]
+		switch {
+		case !exists:
+			change.ClientsAdded = append(change.ClientsAdded, email)
+		case !reflect.DeepEqual(oldClient, newClient):
+			change.ClientsChanged = append(change.ClientsChanged, email)
+		}
+	}
+	for email := range oldClients {
+		if _, exists := newClients[email]; !exists {
+			change.ClientsRemoved = append(change.ClientsRemoved, email)
+		}
+	}
+	sort.Strings(change.ClientsAdded)
+	sort.Strings(change.ClientsRemoved)
+	sort.Strings(change.ClientsChanged)
+
+	delete(oldSettings, "clients")
+	delete(newSettings, "clients")
+	if reflect.DeepEqual(oldSettings, newSettings) {
+		return "clients"
+	}
+	return "settings"
+}
+
+// diffOutbounds compares two outbound lists by tag; untagged outbounds are named by their position.
+func diffOutbounds(oldJson, newJson []byte) (added, removed, changed []string) {
+	oldOutbounds := outboundsByTag(oldJson)
+	newOutbounds := outboundsByTag(newJson)
+	for tag, newOutbound := range newOutbounds {
+		oldOutbound, exists := oldOutbounds[tag]
+		switch {
+		case !exists:
+			added = append(added, tag)
+		case !reflect.DeepEqual(oldOutbound, newOutbound):
+			changed = append(changed, tag)
+		}
+	}
+	for tag := range oldOutbounds {
+		if _, exists := newOutbounds[tag]; !exists {
+			removed = append(removed, tag)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+func outboundsByTag(data []byte) map[string]any {
+	var outbounds []map[string]any
+	if len(data) > 0 {
+		json.Unmarshal(data, &outbounds)
+	}
+	result := make(map[string]any, len(outbounds))
+	for i, outbound := range outbounds {
+		tag, _ := outbound["tag"].(string)
+		if tag == "" {
+			tag = fmt.Sprintf("#%d", i+1)
+		}
+		result[tag] = outbound
+	}
+	return result
+}
+
+// diffRouting returns the routing rules only found in the new or only in the old config, and the
+// other routing settings that changed. Rules are compared by content, so reordering alone shows
+// up as a change of neither.
+func diffRouting(oldJson, newJson []byte) (added, removed []json.RawMessage, changed []string) {
+	var oldRouting, newRouting map[string]any
+	if len(oldJson) > 0 {
+		json.Unmarshal(oldJson, &oldRouting)
+	}
+	if len(newJson) > 0 {
+		json.Unmarshal(newJson, &newRouting)
+	}
+
+	counts := make(map[string]int)
+	for _, rule := range routingRules(oldRouting) {
+		counts[string(rule)]++
+	}
+	for _, rule := range routingRules(newRouting) {
+		if counts[string(rule)] > 0 {
+			counts[string(rule)]--
+			continue
+		}
+		added = append(added, rule)
+	}
+	for _, rule := range routingRules(oldRouting) {
+		if counts[string(rule)] > 0 {
+			counts[string(rule)]--
+			removed = append(removed, rule)
+		}
+	}
+
+	keys := make(map[string]bool)
+	for key := range oldRouting {
+		keys[key] = true
+	}
+	for key := range newRouting {
+		keys[key] = true
+	}
+	for key := range keys {
+		if key != "rules" && !reflect.DeepEqual(oldRouting[key], newRouting[key]) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+// routingRules returns the rules of a routing config in a canonical JSON form.
+func routingRules(routing map[string]any) []json.RawMessage {
+	rules, _ := routing["rules"].([]any)
+	result := make([]json.RawMessage, 0, len(rules))
+	for _, rule := range rules {
+		if data, err := json.Marshal(rule); err == nil {
+			result = append(result, data)
+		}
+	}
+	return result
+}
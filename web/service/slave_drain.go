@@ -0,0 +1,129 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"gorm.io/gorm"
+)
+
+// ErrSlaveDraining is returned when something would be placed on a slave that is in maintenance.
+var ErrSlaveDraining = errors.New("slave is in maintenance")
+
+// StartSlaveDrain puts a slave into maintenance. Its inbounds drop out of subscriptions, no
+// account clients are placed on it and its alerts are muted. With stopXray, Xray is shut down
+// once the grace period has passed, so connected users can move to other nodes first.
+func (s *SlaveService) StartSlaveDrain(slaveId int, stopXray bool, grace time.Duration) error {
+	now := time.Now()
+	var stopAt int64
+	if stopXray {
+		stopAt = now.Add(grace).Unix()
+	}
+	result := database.GetDB().Model(&model.Slave{}).Where("id = ?", slaveId).Updates(map[string]any{
+		"draining":           true,
+		"drain_started_at":   now.Unix(),
+		"drain_xray_stop_at": stopAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	logger.Infof("Slave %d entered maintenance", slaveId)
+	if stopXray && grace <= 0 {
+		s.StopDrainedSlavesXray()
+	}
+	return nil
+}
+
+// EndSlaveDrain takes a slave out of maintenance and pushes its config, which also starts Xray
+// again if it was shut down.
+func (s *SlaveService) EndSlaveDrain(slaveId int) error {
+	result := database.GetDB().Model(&model.Slave{}).Where("id = ?", slaveId).Updates(map[string]any{
+		"draining":           false,
+		"drain_started_at":   0,
+		"drain_xray_stop_at": 0,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	logger.Infof("Slave %d left maintenance", slaveId)
+	if err := s.PushConfig(slaveId); err != nil {
+		// An offline slave gets its config when it reconnects
+		logger.Infof("Config of slave %d not pushed after maintenance: %v", slaveId, err)
+	}
+	return nil
+}
+
+// StopDrainedSlavesXray shuts Xray down on draining slaves whose grace period has passed. It runs
+// periodically, so Xray is stopped again after a slave restarted during its maintenance.
+func (s *SlaveService) StopDrainedSlavesXray() {
+	var slaves []model.Slave
+	err := database.GetDB().Where("draining = ? AND drain_xray_stop_at > 0 AND drain_xray_stop_at <= ?", true, time.Now().Unix()).
+		Find(&slaves).Error
+	if err != nil {
+		logger.Warning("Failed to load draining slaves:", err)
+		return
+	}
+	for _, slave := range slaves {
+		if _, err := s.getSlaveConn(slave.Id); err != nil {
+			continue
+		}
+		var result protocol.StopXrayResult
+		if err := s.CallSlave(slave.Id, protocol.MethodStopXray, nil, &result, 0); err != nil {
+			logger.Infof("Failed to stop Xray on draining slave %d: %v", slave.Id, err)
+			continue
+		}
+		if result.Stopped {
+			logger.Infof("Stopped Xray on slave %d for maintenance", slave.Id)
+		}
+	}
+}
+
+// IsSlaveDraining reports whether a slave is in maintenance.
+func (s *SlaveService) IsSlaveDraining(slaveId int) bool {
+	var slave model.Slave
+	if err := database.GetDB().Select("draining").First(&slave, slaveId).Error; err != nil {
+		return false
+	}
+	return slave.Draining
+}
+
+// isXrayStoppedForDrain reports whether Xray on the slave is meant to be down for maintenance.
+// Config pushes would start it again, so they wait until the maintenance ends.
+func (s *SlaveService) isXrayStoppedForDrain(slaveId int) bool {
+	var slave model.Slave
+	if err := database.GetDB().Select("draining", "drain_xray_stop_at").First(&slave, slaveId).Error; err != nil {
+		return false
+	}
+	return slave.Draining && slave.DrainXrayStopAt > 0 && slave.DrainXrayStopAt <= time.Now().Unix()
+}
+
+// checkSlaveNotDraining returns ErrSlaveDraining for a slave in maintenance.
+func (s *SlaveService) checkSlaveNotDraining(slaveId int) error {
+	var slave model.Slave
+	err := database.GetDB().Select("name", "draining").First(&slave, slaveId).Error
+	if err == nil && slave.Draining {
+		return fmt.Errorf("%w: %s", ErrSlaveDraining, slave.Name)
+	}
+	return nil
+}
+
+// alertf reports a problem with a slave. While the slave is in maintenance, where outages and
+// failures are expected, it is only logged as information.
+func (s *SlaveService) alertf(slaveId int, format string, args ...any) {
+	if s.IsSlaveDraining(slaveId) {
+		logger.Infof("[maintenance] "+format, args...)
+		return
+	}
+	logger.Errorf(format, args...)
+}
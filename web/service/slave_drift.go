@@ -0,0 +1,99 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+)
+
+// CheckConfigDrift regenerates the config the master expects on each connected slave and compares
+// its hash with the one of the config the slave reports running. A missed push, an edit on the
+// node or an Xray that did not come back all leave the slave drifted until a push brings it back
+// in line, which happens right away when automatic re-push is turned on.
+func (s *SlaveService) CheckConfigDrift() {
+	var slaves []model.Slave
+	err := database.GetDB().Select("id", "name", "status", "last_seen", "system_stats", "apply_status",
+		"applied_at", "config_drift", "drift_detected_at").Find(&slaves).Error
+	if err != nil {
+		logger.Warning("Failed to load slaves for drift check:", err)
+		return
+	}
+	settingService := SettingService{}
+	repush, err := settingService.GetSlaveDriftRepush()
+	if err != nil {
+		logger.Warning("Failed to read drift re-push setting:", err)
+	}
+
+	for _, slave := range slaves {
+		drifted, ok := s.isConfigDrifted(&slave)
+		if !ok {
+			continue
+		}
+		if err := s.setConfigDrift(&slave, drifted); err != nil {
+			logger.Warningf("Failed to record config drift of slave %d: %v", slave.Id, err)
+		}
+		if !drifted {
+			continue
+		}
+		if !repush {
+			s.alertf(slave.Id, "Config of slave %s (%d) has drifted from the master", slave.Name, slave.Id)
+			continue
+		}
+		logger.Warningf("Config of slave %s (%d) has drifted from the master, pushing it again", slave.Name, slave.Id)
+		if err := s.PushConfig(slave.Id); err != nil {
+			s.alertf(slave.Id, "Failed to push config to drifted slave %d: %v", slave.Id, err)
+		}
+	}
+}
+
+// isConfigDrifted compares the config the slave runs with the one the master would push now. The
+// second result is false when the slave's state cannot be judged: it is offline or in maintenance
+// with Xray stopped, a push is still being applied, or the slave does not report its config hash.
+func (s *SlaveService) isConfigDrifted(slave *model.Slave) (bool, bool) {
+	if slave.Status != "online" || slave.ApplyStatus == model.ApplyStatusPending || s.isXrayStoppedForDrain(slave.Id) {
+		return false, false
+	}
+	conn, err := s.getSlaveConn(slave.Id)
+	if err != nil || !conn.Has(protocol.CapConfigHash) {
+		return false, false
+	}
+	// The heartbeat must postdate the last acknowledgement to describe the config now running
+	if slave.LastSeen <= slave.AppliedAt {
+		return false, false
+	}
+	var stats protocol.SystemStats
+	if slave.SystemStats == "" || json.Unmarshal([]byte(slave.SystemStats), &stats) != nil {
+		return false, false
+	}
+
+	expected, err := s.BuildSlaveConfig(slave.Id)
+	if err != nil {
+		logger.Warningf("Failed to build config of slave %d for drift check: %v", slave.Id, err)
+		return false, false
+	}
+	expectedHash, err := expected.Hash()
+	if err != nil {
+		logger.Warningf("Failed to hash config of slave %d: %v", slave.Id, err)
+		return false, false
+	}
+	// An empty hash means Xray is not running at all
+	return stats.ConfigHash != expectedHash, true
+}
+
+// setConfigDrift stores whether the slave's config has drifted, keeping the time it was first seen.
+func (s *SlaveService) setConfigDrift(slave *model.Slave, drifted bool) error {
+	if slave.ConfigDrift == drifted {
+		return nil
+	}
+	updates := map[string]any{"config_drift": drifted, "drift_detected_at": 0}
+	if drifted {
+		updates["drift_detected_at"] = time.Now().Unix()
+	} else {
+		logger.Infof("Config of slave %s (%d) is in line with the master again", slave.Name, slave.Id)
+	}
+	return database.GetDB().Model(&model.Slave{}).Where("id = ?", slave.Id).Updates(updates).Error
+}
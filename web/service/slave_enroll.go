@@ -0,0 +1,202 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultEnrollTokenTTL = time.Hour
+	maxEnrollTokenTTL     = 7 * 24 * time.Hour
+)
+
+// ErrInvalidEnrollToken is returned for unknown, used and expired enrollment tokens alike,
+// so a caller cannot tell which tokens once existed.
+var ErrInvalidEnrollToken = errors.New("invalid, used or expired enrollment token")
+
+// CreateEnrollToken creates a single-use enrollment token and returns it in clear text; only its
+// hash is stored. slaveId targets an existing slave, 0 creates a new one named name with the
+// settings of templateSlaveId (or the defaults) in group groupId (or none) when the token is used.
+func (s *SlaveService) CreateEnrollToken(name string, templateSlaveId int, groupId int, slaveId int, ttl time.Duration) (string, *model.SlaveEnrollToken, error) {
+	if ttl <= 0 {
+		ttl = defaultEnrollTokenTTL
+	}
+	if ttl > maxEnrollTokenTTL {
+		ttl = maxEnrollTokenTTL
+	}
+	if slaveId > 0 {
+		if _, err := s.GetSlave(slaveId); err != nil {
+			return "", nil, err
+		}
+	}
+	if templateSlaveId > 0 {
+		if _, err := s.GetSlave(templateSlaveId); err != nil {
+			return "", nil, fmt.Errorf("template slave %d not found", templateSlaveId)
+		}
+	}
+	if groupId > 0 {
+		if err := database.GetDB().First(&model.SlaveGroup{}, groupId).Error; err != nil {
+			return "", nil, fmt.Errorf("group %d not found", groupId)
+		}
+	}
+
+	token := random.Seq(40)
+	now := time.Now()
+	record := &model.SlaveEnrollToken{
+		TokenHash:       hashEnrollToken(token),
+		Name:            name,
+		TemplateSlaveId: templateSlaveId,
+		GroupId:         groupId,
+		SlaveId:         slaveId,
+		ExpiresAt:       now.Add(ttl).Unix(),
+		CreatedAt:       now.Unix(),
+	}
+	if err := database.GetDB().Create(record).Error; err != nil {
+		return "", nil, err
+	}
+	return token, record, nil
+}
+
+// EnrollSlave redeems an enrollment token. It creates the slave (or picks the existing one the token
+// was issued for), gives it a new permanent secret and records the enrollment on the token.
+func (s *SlaveService) EnrollSlave(token string, remoteIp string, hostname string) (*model.Slave, error) {
+	if token == "" {
+		return nil, ErrInvalidEnrollToken
+	}
+
+	var slave model.Slave
+	var record model.SlaveEnrollToken
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		now := time.Now().Unix()
+		if err := tx.Where("token_hash = ? AND used_at = 0 AND expires_at > ?", hashEnrollToken(token), now).
+			First(&record).Error; err != nil {
+			return ErrInvalidEnrollToken
+		}
+
+		// Claim the token first so two nodes racing with the same token cannot both succeed
+		claim := tx.Model(&model.SlaveEnrollToken{}).Where("id = ? AND used_at = 0", record.Id).
+			Updates(map[string]any{"used_at": now, "used_ip": remoteIp, "used_hostname": hostname})
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			return ErrInvalidEnrollToken
+		}
+
+		secret := random.Seq(32)
+		if record.SlaveId > 0 {
+			if err := tx.First(&slave, record.SlaveId).Error; err != nil {
+				return err
+			}
+			// A reinstalled node starts over with a new secret and certificate
+			if err := tx.Model(&slave).Updates(map[string]any{
+				"secret":           secret,
+				"next_secret":      "",
+				"cert_fingerprint": "",
+			}).Error; err != nil {
+				return err
+			}
+			slave.Secret = secret
+			return nil
+		}
+
+		slave.Name = record.Name
+		if slave.Name == "" {
+			slave.Name = hostname
+		}
+		slave.Secret = secret
+		slave.Status = "offline"
+		slave.LastSeen = now
+		if err := tx.Create(&slave).Error; err != nil {
+			return err
+		}
+		if record.GroupId > 0 {
+			// The group may have been deleted since the token was created
+			var group model.SlaveGroup
+			if err := tx.First(&group, record.GroupId).Error; err == nil {
+				if err := tx.Create(&model.SlaveGroupMember{GroupId: group.Id, SlaveId: slave.Id}).Error; err != nil {
+					return err
+				}
+			} else {
+				logger.Warningf("Group %d of enrollment token %d no longer exists", record.GroupId, record.Id)
+			}
+		}
+		return tx.Model(&model.SlaveEnrollToken{}).Where("id = ?", record.Id).
+			Update("slave_id", slave.Id).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if record.SlaveId > 0 {
+		// Whatever is connected with the old secret is no longer this slave
+		if conn, err := s.getSlaveConn(slave.Id); err == nil {
+			conn.Close()
+		}
+	} else {
+		if slave.Name == "" {
+			slave.Name = fmt.Sprintf("slave-%d", slave.Id)
+			database.GetDB().Model(&slave).Update("name", slave.Name)
+		}
+		if record.TemplateSlaveId > 0 {
+			err = s.SlaveSettingService.CopySettingsToNewSlave(record.TemplateSlaveId, slave.Id)
+		} else {
+			err = s.SlaveSettingService.InitializeSlaveWithDefaults(slave.Id)
+		}
+		if err != nil {
+			logger.Warningf("Failed to initialize settings for enrolled slave %d: %v", slave.Id, err)
+		}
+		go resyncReplicatedInbounds()
+	}
+
+	logger.Infof("Slave %d (%s) enrolled from %s", slave.Id, slave.Name, remoteIp)
+	return &slave, nil
+}
+
+// GetEnrollTokens returns all enrollment tokens, newest first.
+func (s *SlaveService) GetEnrollTokens() ([]model.SlaveEnrollToken, error) {
+	var tokens []model.SlaveEnrollToken
+	err := database.GetDB().Order("id desc").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeEnrollToken deletes an unused token. Used tokens are kept as the enrollment record.
+func (s *SlaveService) RevokeEnrollToken(id int) error {
+	result := database.GetDB().Where("id = ? AND used_at = 0", id).Delete(&model.SlaveEnrollToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("token not found or already used")
+	}
+	return nil
+}
+
+// buildInstallCommand returns the shell command that installs a slave enrolling with token.
+func buildInstallCommand(req *http.Request, basePath string, token string) string {
+	// Get master server address from request
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	// basePath already includes leading and trailing slashes (e.g., "/ixUwrIpIWgOzE7ZS9w/")
+	masterUrl := fmt.Sprintf("%s://%s%s", scheme, req.Host, basePath)
+	return fmt.Sprintf("bash <(curl -Ls https://raw.githubusercontent.com/Copperchaleu/3x-ui-cluster/main/install.sh) slave %s --token %s",
+		masterUrl, token)
+}
+
+func hashEnrollToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
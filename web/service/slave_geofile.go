@@ -0,0 +1,309 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/config"
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// slaveGeodataSyncTimeout covers a slave downloading its geofiles and restarting Xray.
+const slaveGeodataSyncTimeout = 10 * time.Minute
+
+// GeofileWithSlaves is a stored geofile together with the slaves it is assigned to.
+type GeofileWithSlaves struct {
+	model.Geofile
+	SlaveIds []int `json:"slaveIds"`
+}
+
+// SlaveGeodataResult is the outcome of syncing geofiles to one slave.
+type SlaveGeodataResult struct {
+	SlaveId   int      `json:"slaveId"`
+	Name      string   `json:"name"`
+	Success   bool     `json:"success"`
+	Updated   []string `json:"updated,omitempty"`
+	Restarted bool     `json:"restarted"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// getGeofileDir returns the folder holding the geofiles distributed to slaves.
+func getGeofileDir() string {
+	return filepath.Join(config.GetDBFolderPath(), "geodata")
+}
+
+// GetGeofilePath returns the stored copy of a geofile for download by a slave.
+func (s *SlaveService) GetGeofilePath(name string) (string, error) {
+	var geofile model.Geofile
+	if err := database.GetDB().Where("name = ?", name).First(&geofile).Error; err != nil {
+		return "", err
+	}
+	return filepath.Join(getGeofileDir(), geofile.Name), nil
+}
+
+// GetGeofiles returns the stored geofiles and their assignments.
+func (s *SlaveService) GetGeofiles() ([]GeofileWithSlaves, error) {
+	db := database.GetDB()
+	var geofiles []model.Geofile
+	if err := db.Order("name").Find(&geofiles).Error; err != nil {
+		return nil, err
+	}
+	var assignments []model.SlaveGeofile
+	if err := db.Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]GeofileWithSlaves, len(geofiles))
+	for i, geofile := range geofiles {
+		result[i] = GeofileWithSlaves{Geofile: geofile, SlaveIds: []int{}}
+		for _, assignment := range assignments {
+			if assignment.Name == geofile.Name {
+				result[i].SlaveIds = append(result[i].SlaveIds, assignment.SlaveId)
+			}
+		}
+	}
+	return result, nil
+}
+
+// SaveGeofile stores content as the latest version of the named geofile. The version only
+// changes when the content does.
+func (s *SlaveService) SaveGeofile(name string, content io.Reader) (*model.Geofile, error) {
+	serverService := ServerService{}
+	if !serverService.IsValidGeofileName(name) {
+		return nil, fmt.Errorf("invalid geofile name %q", name)
+	}
+	dir := getGeofileDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, errors.New("geofile is empty")
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	db := database.GetDB()
+	var geofile model.Geofile
+	err = db.Where("name = ?", name).First(&geofile).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if err == nil && geofile.Sha256 == sum {
+		return &geofile, nil
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return nil, err
+	}
+	geofile.Name = name
+	geofile.Version++
+	geofile.Sha256 = sum
+	geofile.Size = size
+	geofile.UpdatedAt = time.Now().Unix()
+	if err := db.Save(&geofile).Error; err != nil {
+		return nil, err
+	}
+	logger.Infof("Stored geofile %s version %d", name, geofile.Version)
+	return &geofile, nil
+}
+
+// ImportMasterGeofiles stores the geofiles of the master's own Xray, e.g. after refreshing them there.
+func (s *SlaveService) ImportMasterGeofiles() ([]model.Geofile, error) {
+	paths, err := filepath.Glob(filepath.Join(config.GetBinFolderPath(), "*.dat"))
+	if err != nil {
+		return nil, err
+	}
+	var imported []model.Geofile
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return imported, err
+		}
+		geofile, err := s.SaveGeofile(filepath.Base(path), file)
+		file.Close()
+		if err != nil {
+			return imported, err
+		}
+		imported = append(imported, *geofile)
+	}
+	return imported, nil
+}
+
+// DeleteGeofile stops distributing a geofile. Slaves keep the copy they have installed.
+func (s *SlaveService) DeleteGeofile(name string) error {
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("name = ?", name).Delete(&model.SlaveGeofile{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("name = ?", name).Delete(&model.Geofile{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(getGeofileDir(), name)); err != nil && !os.IsNotExist(err) {
+		logger.Warningf("Failed to remove geofile %s: %v", name, err)
+	}
+	return nil
+}
+
+// PushGeofiles assigns the geofiles to the slaves and syncs the connected ones right away.
+// Offline slaves receive them when they reconnect.
+func (s *SlaveService) PushGeofiles(slaveIds []int, names []string) ([]SlaveGeodataResult, error) {
+	db := database.GetDB()
+	var count int64
+	if err := db.Model(&model.Geofile{}).Where("name IN ?", names).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count != int64(len(names)) {
+		return nil, errors.New("unknown geofile")
+	}
+
+	var assignments []model.SlaveGeofile
+	for _, slaveId := range slaveIds {
+		for _, name := range names {
+			assignments = append(assignments, model.SlaveGeofile{SlaveId: slaveId, Name: name})
+		}
+	}
+	if len(assignments) > 0 {
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&assignments).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]SlaveGeodataResult, len(slaveIds))
+	var wg sync.WaitGroup
+	for i, slaveId := range slaveIds {
+		wg.Add(1)
+		go func(i, slaveId int) {
+			defer wg.Done()
+			result := SlaveGeodataResult{SlaveId: slaveId}
+			if slave, err := s.GetSlave(slaveId); err == nil {
+				result.Name = slave.Name
+			}
+			if _, err := s.getSlaveConn(slaveId); err != nil {
+				result.Error = "offline, receives the geofiles when it reconnects"
+				results[i] = result
+				return
+			}
+			synced, err := s.syncSlaveGeodata(slaveId)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Success = true
+				result.Updated = synced.Updated
+				result.Restarted = synced.Restarted
+			}
+			results[i] = result
+		}(i, slaveId)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// UnassignGeofiles stops keeping the geofiles up to date on the slaves.
+func (s *SlaveService) UnassignGeofiles(slaveIds []int, names []string) error {
+	return database.GetDB().Where("slave_id IN ? AND name IN ?", slaveIds, names).
+		Delete(&model.SlaveGeofile{}).Error
+}
+
+// syncSlaveGeodata hands a slave the current versions of its assigned geofiles and records what it installed.
+func (s *SlaveService) syncSlaveGeodata(slaveId int) (*protocol.GeodataSyncResult, error) {
+	files, err := s.assignedGeofiles(slaveId)
+	if err != nil {
+		return nil, err
+	}
+	var result protocol.GeodataSyncResult
+	if err := s.CallSlave(slaveId, protocol.MethodSyncGeodata, protocol.GeodataSync{Files: files}, &result, slaveGeodataSyncTimeout); err != nil {
+		return nil, err
+	}
+	if err := s.recordSlaveGeodata(slaveId, result.Geodata); err != nil {
+		logger.Warningf("Failed to record geodata of slave %d: %v", slaveId, err)
+	}
+	return &result, nil
+}
+
+// syncGeodataOnConnect records the geofile versions a reconnecting slave reports and brings outdated ones up to date.
+func (s *SlaveService) syncGeodataOnConnect(slaveId int, installed map[string]int64) {
+	if err := s.recordSlaveGeodata(slaveId, installed); err != nil {
+		logger.Warningf("Failed to record geodata of slave %d: %v", slaveId, err)
+	}
+	files, err := s.assignedGeofiles(slaveId)
+	if err != nil {
+		logger.Warningf("Failed to load geofiles of slave %d: %v", slaveId, err)
+		return
+	}
+	outdated := false
+	for _, file := range files {
+		if installed[file.Name] != file.Version {
+			outdated = true
+			break
+		}
+	}
+	if !outdated {
+		return
+	}
+	if result, err := s.syncSlaveGeodata(slaveId); err != nil {
+		logger.Warningf("Failed to sync geofiles to slave %d: %v", slaveId, err)
+	} else if len(result.Updated) > 0 {
+		logger.Infof("Synced geofiles %v to slave %d", result.Updated, slaveId)
+	}
+}
+
+func (s *SlaveService) assignedGeofiles(slaveId int) ([]protocol.GeofileInfo, error) {
+	var geofiles []model.Geofile
+	err := database.GetDB().Model(&model.Geofile{}).
+		Joins("JOIN slave_geofiles ON slave_geofiles.name = geofiles.name").
+		Where("slave_geofiles.slave_id = ?", slaveId).
+		Find(&geofiles).Error
+	if err != nil {
+		return nil, err
+	}
+	files := make([]protocol.GeofileInfo, len(geofiles))
+	for i, geofile := range geofiles {
+		files[i] = protocol.GeofileInfo{
+			Name:    geofile.Name,
+			Version: geofile.Version,
+			Sha256:  geofile.Sha256,
+			Size:    geofile.Size,
+		}
+	}
+	return files, nil
+}
+
+func (s *SlaveService) recordSlaveGeodata(slaveId int, installed map[string]int64) error {
+	if installed == nil {
+		installed = map[string]int64{}
+	}
+	data, err := json.Marshal(installed)
+	if err != nil {
+		return err
+	}
+	return database.GetDB().Model(&model.Slave{}).Where("id = ?", slaveId).
+		Update("geodata", string(data)).Error
+}
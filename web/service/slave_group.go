@@ -0,0 +1,325 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"gorm.io/gorm"
+)
+
+// Selector keys that match slave properties instead of labels.
+const (
+	selectorKeyGroup  = "group"
+	selectorKeyName   = "name"
+	selectorKeyStatus = "status"
+)
+
+// labelName restricts label and group names to what selectors can express.
+var labelName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,62}$`)
+
+// SlaveOpResult is the outcome of an operation on one of the selected slaves.
+type SlaveOpResult struct {
+	SlaveId int    `json:"slaveId"`
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// SlaveGroupWithSlaves is a group together with its members.
+type SlaveGroupWithSlaves struct {
+	model.SlaveGroup
+	SlaveIds []int `json:"slaveIds"`
+}
+
+// SlaveSelector selects slaves by their labels, groups, name and status. It is written as
+// comma separated terms that all have to match:
+//
+//	region=eu           label region is eu
+//	region=eu|us        label region is eu or us
+//	tier!=free          label tier is not free, or not set
+//	gpu / !gpu          label gpu is set / not set
+//	group=edge          slave is in group edge
+//	status=online       slave is online; name=... matches the slave name
+type SlaveSelector struct {
+	terms []selectorTerm
+}
+
+type selectorTerm struct {
+	key    string
+	values []string // nil when the term only checks that a label is set
+	negate bool
+}
+
+// ParseSlaveSelector parses a selector. An empty selector matches every slave.
+func ParseSlaveSelector(selector string) (*SlaveSelector, error) {
+	sel := &SlaveSelector{}
+	for _, part := range strings.Split(selector, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var term selectorTerm
+		key, value, hasValue := strings.Cut(part, "=")
+		if hasValue {
+			if strings.HasSuffix(key, "!") {
+				key, term.negate = strings.TrimSuffix(key, "!"), true
+			}
+			for _, v := range strings.Split(value, "|") {
+				if v = strings.TrimSpace(v); v != "" {
+					term.values = append(term.values, v)
+				}
+			}
+			if len(term.values) == 0 {
+				return nil, fmt.Errorf("selector term %q has no value", part)
+			}
+		} else if strings.HasPrefix(key, "!") {
+			key, term.negate = strings.TrimPrefix(key, "!"), true
+		}
+		term.key = strings.TrimSpace(key)
+		if !labelName.MatchString(term.key) {
+			return nil, fmt.Errorf("invalid selector term %q", part)
+		}
+		if term.values == nil && isSelectorKey(term.key) {
+			return nil, fmt.Errorf("selector term %q needs a value", part)
+		}
+		sel.terms = append(sel.terms, term)
+	}
+	return sel, nil
+}
+
+// Empty reports whether the selector has no terms and thus matches every slave.
+func (sel *SlaveSelector) Empty() bool {
+	return len(sel.terms) == 0
+}
+
+// hasKey reports whether any term of the selector tests the given key.
+func (sel *SlaveSelector) hasKey(key string) bool {
+	return slices.ContainsFunc(sel.terms, func(term selectorTerm) bool { return term.key == key })
+}
+
+func (sel *SlaveSelector) matches(slave *model.Slave, labels map[string]string, groups []string) bool {
+	for _, term := range sel.terms {
+		var found bool
+		switch term.key {
+		case selectorKeyGroup:
+			found = slices.ContainsFunc(term.values, func(v string) bool { return slices.Contains(groups, v) })
+		case selectorKeyName:
+			found = slices.Contains(term.values, slave.Name)
+		case selectorKeyStatus:
+			found = slices.Contains(term.values, slave.Status)
+		default:
+			value, ok := labels[term.key]
+			found = ok && (term.values == nil || slices.Contains(term.values, value))
+		}
+		if found == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func isSelectorKey(key string) bool {
+	return key == selectorKeyGroup || key == selectorKeyName || key == selectorKeyStatus
+}
+
+// ParseSlaveLabels decodes the labels stored on a slave.
+func ParseSlaveLabels(labels string) map[string]string {
+	result := make(map[string]string)
+	if labels != "" {
+		if err := json.Unmarshal([]byte(labels), &result); err != nil {
+			logger.Warning("Ignoring invalid slave labels:", err)
+		}
+	}
+	return result
+}
+
+// SelectSlaves returns the ids of the slaves the selector matches, in id order.
+func (s *SlaveService) SelectSlaves(selector string) ([]int, error) {
+	sel, err := ParseSlaveSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	var slaves []*model.Slave
+	if err := db.Select("id", "name", "status", "labels").Order("id").Find(&slaves).Error; err != nil {
+		return nil, err
+	}
+	groups, err := slaveGroupNames(db)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for _, slave := range slaves {
+		if sel.matches(slave, ParseSlaveLabels(slave.Labels), groups[slave.Id]) {
+			ids = append(ids, slave.Id)
+		}
+	}
+	return ids, nil
+}
+
+// SetSlaveLabels replaces the labels of a slave.
+func (s *SlaveService) SetSlaveLabels(slaveId int, labels map[string]string) error {
+	for key, value := range labels {
+		if !labelName.MatchString(key) || isSelectorKey(key) {
+			return fmt.Errorf("invalid label name %q", key)
+		}
+		if value == "" || len(value) > 128 || strings.ContainsAny(value, ",|=!") {
+			return fmt.Errorf("invalid value for label %s", key)
+		}
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	result := database.GetDB().Model(&model.Slave{}).Where("id = ?", slaveId).Update("labels", string(data))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	go resyncReplicatedInbounds()
+	return nil
+}
+
+// GetSlaveGroups returns the groups and their members.
+func (s *SlaveService) GetSlaveGroups() ([]SlaveGroupWithSlaves, error) {
+	db := database.GetDB()
+	var groups []model.SlaveGroup
+	if err := db.Order("name").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	var members []model.SlaveGroupMember
+	if err := db.Find(&members).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]SlaveGroupWithSlaves, len(groups))
+	for i, group := range groups {
+		result[i] = SlaveGroupWithSlaves{SlaveGroup: group, SlaveIds: []int{}}
+		for _, member := range members {
+			if member.GroupId == group.Id {
+				result[i].SlaveIds = append(result[i].SlaveIds, member.SlaveId)
+			}
+		}
+	}
+	return result, nil
+}
+
+// SaveSlaveGroup creates or updates a group and sets its members.
+func (s *SlaveService) SaveSlaveGroup(group *model.SlaveGroup, slaveIds []int) error {
+	if !labelName.MatchString(group.Name) {
+		return fmt.Errorf("invalid group name %q", group.Name)
+	}
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&model.SlaveGroup{}).Where("name = ? AND id <> ?", group.Name, group.Id).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("group %s already exists", group.Name)
+		}
+		if err := tx.Save(group).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", group.Id).Delete(&model.SlaveGroupMember{}).Error; err != nil {
+			return err
+		}
+		if len(slaveIds) == 0 {
+			return nil
+		}
+		var known int64
+		if err := tx.Model(&model.Slave{}).Where("id IN ?", slaveIds).Count(&known).Error; err != nil {
+			return err
+		}
+		if known != int64(len(slaveIds)) {
+			return errors.New("unknown slave")
+		}
+		members := make([]model.SlaveGroupMember, len(slaveIds))
+		for i, slaveId := range slaveIds {
+			members[i] = model.SlaveGroupMember{GroupId: group.Id, SlaveId: slaveId}
+		}
+		return tx.Create(&members).Error
+	})
+	if err == nil {
+		go resyncReplicatedInbounds()
+	}
+	return err
+}
+
+// DeleteSlaveGroup removes a group. Its slaves are kept, but lose the replicas the group gave them.
+func (s *SlaveService) DeleteSlaveGroup(id int) error {
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", id).Delete(&model.SlaveGroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.SlaveGroup{}, id).Error
+	})
+	if err == nil {
+		go resyncReplicatedInbounds()
+	}
+	return err
+}
+
+// PushConfigToSlaves rebuilds and pushes the config of each slave.
+func (s *SlaveService) PushConfigToSlaves(slaveIds []int) []SlaveOpResult {
+	return s.forEachSlave(slaveIds, s.PushConfig)
+}
+
+// RestartSlavesXray restarts Xray on each slave.
+func (s *SlaveService) RestartSlavesXray(slaveIds []int) []SlaveOpResult {
+	return s.forEachSlave(slaveIds, s.RestartSlaveXray)
+}
+
+// forEachSlave runs op for each slave in parallel and collects the outcomes.
+func (s *SlaveService) forEachSlave(slaveIds []int, op func(slaveId int) error) []SlaveOpResult {
+	results := make([]SlaveOpResult, len(slaveIds))
+	var wg sync.WaitGroup
+	for i, slaveId := range slaveIds {
+		wg.Add(1)
+		go func(i, slaveId int) {
+			defer wg.Done()
+			result := SlaveOpResult{SlaveId: slaveId}
+			if slave, err := s.GetSlave(slaveId); err == nil {
+				result.Name = slave.Name
+			}
+			if err := op(slaveId); err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Success = true
+			}
+			results[i] = result
+		}(i, slaveId)
+	}
+	wg.Wait()
+	return results
+}
+
+// slaveGroupNames returns the names of the groups of each slave.
+func slaveGroupNames(db *gorm.DB) (map[int][]string, error) {
+	var rows []struct {
+		SlaveId int
+		Name    string
+	}
+	err := db.Model(&model.SlaveGroupMember{}).
+		Select("slave_group_members.slave_id, slave_groups.name").
+		Joins("JOIN slave_groups ON slave_groups.id = slave_group_members.group_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[int][]string)
+	for _, row := range rows {
+		groups[row.SlaveId] = append(groups[row.SlaveId], row.Name)
+	}
+	return groups, nil
+}
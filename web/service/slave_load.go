@@ -0,0 +1,115 @@
+package service
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/database"
+	"github.com/mhsanaei/3x-ui/v2/database/model"
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"gorm.io/gorm"
+)
+
+// defaultSlaveWeight is the weight of a slave that has none set.
+const defaultSlaveWeight = 100
+
+// slaveStatsMaxAge is how old the last heartbeat of a slave may be for its stats to be scored.
+// Slaves report every few seconds; one that stopped may still be stored as online.
+const slaveStatsMaxAge = time.Minute
+
+// Round trips of the last ping to each connected slave, in milliseconds
+var (
+	slaveLatencies    = make(map[int]int64)
+	slaveLatencyMutex sync.RWMutex
+)
+
+// MeasureSlaveLatencies pings every connected slave and records the round trip.
+func (s *SlaveService) MeasureSlaveLatencies() {
+	slaveLock.RLock()
+	ids := make([]int, 0, len(slaveConns))
+	for id := range slaveConns {
+		ids = append(ids, id)
+	}
+	slaveLock.RUnlock()
+
+	s.forEachSlave(ids, func(id int) error {
+		start := time.Now()
+		var result protocol.PingResult
+		err := s.CallSlave(id, protocol.MethodPing, nil, &result, 0)
+		slaveLatencyMutex.Lock()
+		defer slaveLatencyMutex.Unlock()
+		if err != nil {
+			delete(slaveLatencies, id)
+			logger.Debugf("Failed to ping slave %d: %v", id, err)
+			return err
+		}
+		slaveLatencies[id] = time.Since(start).Milliseconds()
+		return nil
+	})
+}
+
+// SlaveLoadWeights sets how much each load figure counts towards a slave's score.
+type SlaveLoadWeights struct {
+	Cpu     int // per CPU percent
+	Mem     int // per memory percent
+	Clients int // per online client
+	Latency int // per 10 ms round trip from the master
+}
+
+// GetSlaveLoadScores scores the load of every slave that recently reported its stats; a lower
+// score means a less busy slave. The load figures are combined with the given weights and divided
+// by the slave's own weight, so a slave with twice the weight takes twice the load for the same
+// score.
+func (s *SlaveService) GetSlaveLoadScores(weights SlaveLoadWeights) (map[int]float64, error) {
+	var slaves []model.Slave
+	err := database.GetDB().Select("id", "system_stats", "weight").
+		Where("status = ? AND last_seen > ?", "online", time.Now().Add(-slaveStatsMaxAge).Unix()).
+		Find(&slaves).Error
+	if err != nil {
+		return nil, err
+	}
+
+	slaveLock.RLock()
+	online := make(map[int]int, len(slaveOnlineClients))
+	for id, clients := range slaveOnlineClients {
+		online[id] = len(clients)
+	}
+	slaveLock.RUnlock()
+	slaveLatencyMutex.RLock()
+	defer slaveLatencyMutex.RUnlock()
+
+	scores := make(map[int]float64, len(slaves))
+	for _, slave := range slaves {
+		var stats protocol.SystemStats
+		if slave.SystemStats == "" || json.Unmarshal([]byte(slave.SystemStats), &stats) != nil {
+			continue
+		}
+		load := float64(weights.Cpu)*stats.Cpu +
+			float64(weights.Mem)*stats.Mem +
+			float64(weights.Clients*online[slave.Id]) +
+			float64(weights.Latency)*float64(slaveLatencies[slave.Id])/10
+		weight := slave.Weight
+		if weight <= 0 {
+			weight = defaultSlaveWeight
+		}
+		scores[slave.Id] = load * defaultSlaveWeight / float64(weight)
+	}
+	return scores, nil
+}
+
+// SetSlaveWeight sets the share of subscription users a slave takes relative to the others.
+func (s *SlaveService) SetSlaveWeight(slaveId int, weight int) error {
+	if weight <= 0 {
+		weight = defaultSlaveWeight
+	}
+	result := database.GetDB().Model(&model.Slave{}).Where("id = ?", slaveId).Update("weight", weight)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/util/random"
+)
+
+// SlaveLogLines is a batch of lines followed on a slave, as relayed to the UI over the WebSocket hub.
+type SlaveLogLines struct {
+	SlaveId int `json:"slaveId"`
+	protocol.LogLines
+}
+
+// GetSlaveLogs fetches the latest lines of a slave's log. The slave applies the query's filters.
+func (s *SlaveService) GetSlaveLogs(slaveId int, query protocol.LogQuery) ([]string, error) {
+	var result protocol.LogsResult
+	if err := s.CallSlave(slaveId, protocol.MethodGetLogs, query, &result, 0); err != nil {
+		return nil, err
+	}
+	return result.Lines, nil
+}
+
+// StartSlaveLogTail makes a slave send new lines of a log as they are written until StopSlaveLogTail.
+// The lines reach the UI as slave_logs messages carrying the returned tail id.
+func (s *SlaveService) StartSlaveLogTail(slaveId int, query protocol.LogQuery) (string, error) {
+	conn, err := s.getSlaveConn(slaveId)
+	if err != nil {
+		return "", err
+	}
+	if !conn.Has(protocol.CapLogTail) {
+		return "", fmt.Errorf("slave %d does not support following logs", slaveId)
+	}
+	tailId := random.Seq(16)
+	if err := s.CallSlave(slaveId, protocol.MethodTailLogs, protocol.LogTail{TailId: tailId, LogQuery: query}, nil, 0); err != nil {
+		return "", err
+	}
+	return tailId, nil
+}
+
+// StopSlaveLogTail ends a tail started with StartSlaveLogTail.
+func (s *SlaveService) StopSlaveLogTail(slaveId int, tailId string) error {
+	return s.CallSlave(slaveId, protocol.MethodStopTail, protocol.LogTailStop{TailId: tailId}, nil, 0)
+}
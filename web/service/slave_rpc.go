@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+)
+
+// defaultSlaveRpcTimeout bounds remote calls whose caller does not pass a timeout.
+const defaultSlaveRpcTimeout = 10 * time.Second
+
+// SlaveStatus is a slave's runtime state together with the round trip it took to fetch it.
+type SlaveStatus struct {
+	protocol.StatusResult
+	LatencyMs int64 `json:"latencyMs"`
+}
+
+// CallSlave runs method on a connected slave and decodes the answer into result, which may be nil.
+// A zero timeout uses the default. Errors the slave reports are returned as *protocol.RpcError.
+func (s *SlaveService) CallSlave(slaveId int, method string, params any, result any, timeout time.Duration) error {
+	conn, err := s.getSlaveConn(slaveId)
+	if err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		timeout = defaultSlaveRpcTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := conn.Call(ctx, method, params, result); err != nil {
+		return fmt.Errorf("slave %d: %s: %w", slaveId, method, err)
+	}
+	return nil
+}
+
+// GetSlaveStatus asks a slave for its runtime state.
+func (s *SlaveService) GetSlaveStatus(slaveId int) (*SlaveStatus, error) {
+	var status SlaveStatus
+	start := time.Now()
+	if err := s.CallSlave(slaveId, protocol.MethodStatus, nil, &status.StatusResult, 0); err != nil {
+		return nil, err
+	}
+	status.LatencyMs = time.Since(start).Milliseconds()
+	return &status, nil
+}
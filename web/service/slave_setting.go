@@ -1,11 +1,13 @@
 package service
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/mhsanaei/3x-ui/v2/database"
 	"github.com/mhsanaei/3x-ui/v2/database/model"
 	"github.com/mhsanaei/3x-ui/v2/logger"
+	"gorm.io/gorm"
 )
 
 // SlaveSettingService provides business logic for slave-specific settings management.
@@ -69,6 +71,58 @@ func (s *SlaveSettingService) SaveXrayConfigForSlave(slaveId int, config string)
 	return s.SaveSettingForSlave(slaveId, "xrayTemplateConfig", config)
 }
 
+// SaveXrayConfigForSlaves saves the same xrayTemplateConfig for several slaves at once;
+// either all of them get it or none does.
+func (s *SlaveSettingService) SaveXrayConfigForSlaves(slaveIds []int, config string) error {
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		for _, slaveId := range slaveIds {
+			setting := model.SlaveSetting{SlaveId: slaveId, SettingKey: "xrayTemplateConfig"}
+			if err := tx.Where(&setting).Assign(model.SlaveSetting{SettingValue: config}).
+				FirstOrCreate(&setting).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// appliedConfigKey stores the last config a slave acknowledged, used as the base for config deltas.
+const appliedConfigKey = "appliedXrayConfig"
+
+// appliedConfig is the stored form of the last acknowledged slave config.
+type appliedConfig struct {
+	Revision int64  `json:"revision"`
+	Config   string `json:"config"`
+}
+
+// SaveAppliedConfigForSlave stores the config a slave confirmed running together with its revision.
+func (s *SlaveSettingService) SaveAppliedConfigForSlave(slaveId int, revision int64, config string) error {
+	value, err := json.Marshal(appliedConfig{Revision: revision, Config: config})
+	if err != nil {
+		return err
+	}
+	return s.SaveSettingForSlave(slaveId, appliedConfigKey, string(value))
+}
+
+// GetAppliedConfigForSlave returns the last config a slave confirmed running and its revision.
+// Unlike GetSettingForSlave it never falls back to a global setting.
+func (s *SlaveSettingService) GetAppliedConfigForSlave(slaveId int) (int64, string, error) {
+	db := database.GetDB()
+
+	var slaveSetting model.SlaveSetting
+	err := db.Where("slave_id = ? AND setting_key = ?", slaveId, appliedConfigKey).
+		First(&slaveSetting).Error
+	if err != nil {
+		return 0, "", err
+	}
+
+	var stored appliedConfig
+	if err := json.Unmarshal([]byte(slaveSetting.SettingValue), &stored); err != nil {
+		return 0, "", fmt.Errorf("invalid applied config for slave %d: %v", slaveId, err)
+	}
+	return stored.Revision, stored.Config, nil
+}
+
 // DeleteAllSettingsForSlave deletes all settings for a specific slave.
 // This should be called when a slave is deleted.
 func (s *SlaveSettingService) DeleteAllSettingsForSlave(slaveId int) error {
@@ -92,6 +146,10 @@ func (s *SlaveSettingService) CopySettingsToNewSlave(fromSlaveId, toSlaveId int)
 	}
 	
 	for _, setting := range sourceSettings {
+		// The applied config describes the source slave's runtime, not a setting
+		if setting.SettingKey == appliedConfigKey {
+			continue
+		}
 		newSetting := model.SlaveSetting{
 			SlaveId:      toSlaveId,
 			SettingKey:   setting.SettingKey,
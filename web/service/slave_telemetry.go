@@ -0,0 +1,148 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+)
+
+// slaveHistoryCapacity is the number of heartbeats kept per slave, about 6 hours at one every 5 seconds.
+const slaveHistoryCapacity = 4320
+
+// SlaveSample is the telemetry of a slave at one point in time, or the average over a bucket.
+type SlaveSample struct {
+	T       int64   `json:"t"`       // unix seconds
+	Cpu     float64 `json:"cpu"`     // percent 0..100
+	Mem     float64 `json:"mem"`     // percent 0..100
+	Swap    float64 `json:"swap"`    // percent 0..100
+	Disk    float64 `json:"disk"`    // percent 0..100
+	Load    float64 `json:"load"`    // 1 minute load average
+	NetUp   float64 `json:"netUp"`   // bytes per second
+	NetDown float64 `json:"netDown"` // bytes per second
+	Tcp     float64 `json:"tcp"`     // open TCP connections
+	Udp     float64 `json:"udp"`     // open UDP connections
+	Clients float64 `json:"clients"` // online clients
+}
+
+// Recent telemetry of each slave, oldest first
+var (
+	slaveHistory      = make(map[int][]SlaveSample)
+	slaveHistoryMutex sync.Mutex
+)
+
+// recordSlaveSample adds a heartbeat to the slave's history. Slaves that only report CPU and
+// memory leave the other figures at zero.
+func (s *SlaveService) recordSlaveSample(slaveId int, stats *protocol.SystemStats) {
+	sample := SlaveSample{T: time.Now().Unix(), Cpu: stats.Cpu, Mem: stats.Mem}
+	if status := stats.Status; status != nil {
+		sample.Swap = percentOf(status.Swap.Current, status.Swap.Total)
+		sample.Disk = percentOf(status.Disk.Current, status.Disk.Total)
+		if len(status.Loads) > 0 {
+			sample.Load = status.Loads[0]
+		}
+		sample.NetUp = float64(status.NetIO.Up)
+		sample.NetDown = float64(status.NetIO.Down)
+		sample.Tcp = float64(status.TcpCount)
+		sample.Udp = float64(status.UdpCount)
+	}
+	slaveLock.RLock()
+	sample.Clients = float64(len(slaveOnlineClients[slaveId]))
+	slaveLock.RUnlock()
+
+	slaveHistoryMutex.Lock()
+	defer slaveHistoryMutex.Unlock()
+	history := slaveHistory[slaveId]
+	if n := len(history); n > 0 && history[n-1].T == sample.T {
+		history[n-1] = sample
+	} else {
+		history = append(history, sample)
+	}
+	if len(history) > slaveHistoryCapacity {
+		history = history[len(history)-slaveHistoryCapacity:]
+	}
+	slaveHistory[slaveId] = history
+}
+
+// forgetSlaveHistory drops the telemetry of a deleted slave.
+func forgetSlaveHistory(slaveId int) {
+	slaveHistoryMutex.Lock()
+	defer slaveHistoryMutex.Unlock()
+	delete(slaveHistory, slaveId)
+}
+
+// GetSlaveHistory returns up to maxPoints averaged buckets of size bucketSeconds over the
+// slave's recent telemetry, oldest first.
+func (s *SlaveService) GetSlaveHistory(slaveId int, bucketSeconds int, maxPoints int) []SlaveSample {
+	out := []SlaveSample{}
+	if bucketSeconds <= 0 || maxPoints <= 0 {
+		return out
+	}
+	cutoff := time.Now().Add(-time.Duration(bucketSeconds*maxPoints) * time.Second).Unix()
+
+	slaveHistoryMutex.Lock()
+	history := slaveHistory[slaveId]
+	start := len(history)
+	for start > 0 && history[start-1].T >= cutoff {
+		start--
+	}
+	samples := make([]SlaveSample, len(history)-start)
+	copy(samples, history[start:])
+	slaveHistoryMutex.Unlock()
+
+	bucketSize := int64(bucketSeconds)
+	var sum SlaveSample
+	count := 0
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		n := float64(count)
+		out = append(out, SlaveSample{
+			T:       sum.T,
+			Cpu:     sum.Cpu / n,
+			Mem:     sum.Mem / n,
+			Swap:    sum.Swap / n,
+			Disk:    sum.Disk / n,
+			Load:    sum.Load / n,
+			NetUp:   sum.NetUp / n,
+			NetDown: sum.NetDown / n,
+			Tcp:     sum.Tcp / n,
+			Udp:     sum.Udp / n,
+			Clients: sum.Clients / n,
+		})
+		sum = SlaveSample{}
+		count = 0
+	}
+	for _, p := range samples {
+		bucket := (p.T / bucketSize) * bucketSize
+		if count > 0 && bucket != sum.T {
+			flush()
+		}
+		sum.T = bucket
+		sum.Cpu += p.Cpu
+		sum.Mem += p.Mem
+		sum.Swap += p.Swap
+		sum.Disk += p.Disk
+		sum.Load += p.Load
+		sum.NetUp += p.NetUp
+		sum.NetDown += p.NetDown
+		sum.Tcp += p.Tcp
+		sum.Udp += p.Udp
+		sum.Clients += p.Clients
+		count++
+	}
+	flush()
+	if len(out) > maxPoints {
+		out = out[len(out)-maxPoints:]
+	}
+	return out
+}
+
+// percentOf returns current as a percentage of total, or 0 when the total is unknown.
+func percentOf(current, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(current) * 100 / float64(total)
+}
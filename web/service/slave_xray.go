@@ -0,0 +1,113 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/mhsanaei/3x-ui/v2/logger"
+	"github.com/mhsanaei/3x-ui/v2/slave/protocol"
+	"github.com/mhsanaei/3x-ui/v2/xray"
+)
+
+// slaveXrayUpdateTimeout covers downloading the release archive on the slave and restarting Xray.
+const slaveXrayUpdateTimeout = 5 * time.Minute
+
+var xrayReleaseVersion = regexp.MustCompile(`^v\d+\.\d+\.\d+$`)
+
+// SlaveXrayUpdateResult is the outcome of updating the Xray core on one slave.
+type SlaveXrayUpdateResult struct {
+	SlaveId     int    `json:"slaveId"`
+	Name        string `json:"name"`
+	Success     bool   `json:"success"`
+	XrayVersion string `json:"xrayVersion,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// UpdateSlavesXray installs the given Xray-core release on the slaves in parallel. The checksum of each
+// platform's archive is pinned from the release digest here, so a slave only installs what the master vouched for.
+func (s *SlaveService) UpdateSlavesXray(slaveIds []int, version string) ([]SlaveXrayUpdateResult, error) {
+	if !xrayReleaseVersion.MatchString(version) {
+		return nil, fmt.Errorf("invalid Xray version %q", version)
+	}
+
+	checksums := &releaseChecksums{sums: make(map[string]string)}
+	results := make([]SlaveXrayUpdateResult, len(slaveIds))
+	var wg sync.WaitGroup
+	for i, slaveId := range slaveIds {
+		wg.Add(1)
+		go func(i, slaveId int) {
+			defer wg.Done()
+			result := SlaveXrayUpdateResult{SlaveId: slaveId}
+			if slave, err := s.GetSlave(slaveId); err == nil {
+				result.Name = slave.Name
+			}
+			xrayVersion, err := s.updateSlaveXray(slaveId, version, checksums)
+			if err != nil {
+				logger.Warningf("Failed to update Xray on slave %d to %s: %v", slaveId, version, err)
+				result.Error = err.Error()
+			} else {
+				logger.Infof("Slave %d now runs Xray %s", slaveId, xrayVersion)
+				result.Success = true
+				result.XrayVersion = xrayVersion
+			}
+			results[i] = result
+		}(i, slaveId)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func (s *SlaveService) updateSlaveXray(slaveId int, version string, checksums *releaseChecksums) (string, error) {
+	status, err := s.GetSlaveStatus(slaveId)
+	if err != nil {
+		return "", err
+	}
+	url := xray.ReleaseAssetURL(version, xray.ReleaseAssetName(status.Os, status.Arch))
+	sum, err := checksums.get(url)
+	if err != nil {
+		return "", err
+	}
+
+	var result protocol.XrayUpdateResult
+	if err := s.CallSlave(slaveId, protocol.MethodUpdateXray, protocol.XrayUpdate{
+		Version: version,
+		Url:     url,
+		Sha256:  sum,
+	}, &result, slaveXrayUpdateTimeout); err != nil {
+		return "", err
+	}
+	return result.XrayVersion, nil
+}
+
+// releaseChecksums fetches the SHA-256 of release archives once per update run.
+type releaseChecksums struct {
+	mu   sync.Mutex
+	sums map[string]string
+}
+
+func (r *releaseChecksums) get(url string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sum, ok := r.sums[url]; ok {
+		return sum, nil
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url + ".dgst")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("no checksum published for %s: %s", url, resp.Status)
+	}
+	sum, err := xray.ParseReleaseDigest(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	r.sums[url] = sum
+	return sum, nil
+}
@@ -87,6 +87,12 @@ func (s *RoutingService) saveTemplateRoutingRules(slaveId int, rules []map[strin
 		return fmt.Errorf("failed to marshal xray template config: %v", err)
 	}
 
+	// Refuse rules that would make Xray reject the slave's config
+	slaveService := SlaveService{}
+	if err := slaveService.CheckSlaveTemplate(slaveId, string(newJson)); err != nil {
+		return err
+	}
+
 	return s.SlaveSettingService.SaveXrayConfigForSlave(slaveId, string(newJson))
 }
 
@@ -98,10 +98,11 @@ type Server struct {
 	httpServer *http.Server
 	listener   net.Listener
 
-	index *controller.IndexController
-	panel *controller.XUIController
-	api   *controller.APIController
-	ws    *controller.WebSocketController
+	index   *controller.IndexController
+	panel   *controller.XUIController
+	api     *controller.APIController
+	metrics *controller.MetricsController
+	ws      *controller.WebSocketController
 
 	xrayService    service.XrayService
 	settingService service.SettingService
@@ -274,6 +275,7 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	s.index = controller.NewIndexController(g)
 	s.panel = controller.NewXUIController(g)
 	s.api = controller.NewAPIController(g, s.slaveService)
+	s.metrics = controller.NewMetricsController(g)
 
 	// Initialize WebSocket hub
 	s.wsHub = websocket.NewHub()
@@ -330,6 +332,18 @@ func (s *Server) startTask() {
 	// Check account traffic limits and expiry every 2 minutes
 	s.cron.AddJob("@every 2m", job.NewCheckAccountLimitJob())
 
+	// Renew ACME certificates on slaves before they expire
+	s.cron.AddJob("@hourly", job.NewRenewSlaveCertsJob())
+
+	// Shut Xray down on slaves in maintenance once their grace period is over
+	s.cron.AddJob("@every 30s", job.NewDrainSlavesJob())
+
+	// Measure slave round trips for load-ordered subscriptions
+	s.cron.AddJob("@every 1m", job.NewSlaveLatencyJob())
+
+	// Flag slaves whose running config no longer matches the master's
+	s.cron.AddJob("@every 5m", job.NewSlaveDriftJob())
+
 	// LDAP sync scheduling
 	if ldapEnabled, _ := s.settingService.GetLdapEnable(); ldapEnabled {
 		runtime, err := s.settingService.GetLdapSyncCron()
@@ -418,6 +432,20 @@ func (s *Server) Start() (err error) {
 			c := &tls.Config{
 				Certificates: []tls.Certificate{cert},
 			}
+			// Ask for client certificates from the cluster CA so slaves can authenticate with mutual TLS.
+			// They are optional at this layer; the slave connect endpoint decides whether to require them.
+			c.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				pool := s.slaveService.ClientCAPool()
+				if pool == nil {
+					return nil, nil
+				}
+				clientConfig := &tls.Config{
+					Certificates: c.Certificates,
+					ClientAuth:   tls.VerifyClientCertIfGiven,
+					ClientCAs:    pool,
+				}
+				return clientConfig, nil
+			}
 			listener = network.NewAutoHttpsListener(listener)
 			listener = tls.NewListener(listener, c)
 			logger.Info("Web server running HTTPS on", listener.Addr())
@@ -21,6 +21,7 @@ const (
 	MessageTypeNotification MessageType = "notification" // System notification
 	MessageTypeXrayState    MessageType = "xray_state"   // Xray state change
 	MessageTypeOutbounds    MessageType = "outbounds"    // Outbounds list update
+	MessageTypeSlaveLogs    MessageType = "slave_logs"   // Log lines followed on a slave
 )
 
 // Message represents a WebSocket message
@@ -89,3 +89,11 @@ func BroadcastXrayState(state string, errorMsg string) {
 		hub.Broadcast(MessageTypeXrayState, stateUpdate)
 	}
 }
+
+// BroadcastSlaveLogs broadcasts log lines followed on a slave to clients subscribed to them
+func BroadcastSlaveLogs(logs any) {
+	hub := GetHub()
+	if hub != nil {
+		hub.BroadcastToTopic(MessageTypeSlaveLogs, logs)
+	}
+}
@@ -77,6 +77,7 @@ mkdir -p "${log_folder}"
 iplimit_log_path="${log_folder}/3xipl.log"
 iplimit_banned_log_path="${log_folder}/3xipl-banned.log"
 slave_mode_file="/etc/x-ui/.slave"
+slave_env_file="/etc/x-ui/slave.env"
 
 is_slave_mode() {
     [[ -f "${slave_mode_file}" ]]
@@ -127,8 +128,14 @@ get_slave_params() {
     fi
 
     exec_cmd="${exec_line#ExecStart=}"
-    slave_ws_url=$(echo "${exec_cmd}" | awk '{print $(NF-1)}')
-    slave_secret=$(echo "${exec_cmd}" | awk '{print $NF}')
+    if [[ -f "${slave_env_file}" ]]; then
+        slave_ws_url=$(echo "${exec_cmd}" | awk '{print $NF}')
+        slave_secret=$(grep -E '^XUI_SLAVE_SECRET=' "${slave_env_file}" | head -n 1 | cut -d '=' -f2-)
+    else
+        # Installed before the secret moved out of the command line
+        slave_ws_url=$(echo "${exec_cmd}" | awk '{print $(NF-1)}')
+        slave_secret=$(echo "${exec_cmd}" | awk '{print $NF}')
+    fi
 
     if [[ -z "${slave_ws_url}" || -z "${slave_secret}" ]]; then
         LOGE "Failed to parse slave parameters from service unit."
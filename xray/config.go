@@ -2,6 +2,9 @@ package xray
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 
 	"github.com/mhsanaei/3x-ui/v2/util/json_util"
 )
@@ -70,3 +73,23 @@ func (c *Config) Equals(other *Config) bool {
 	}
 	return true
 }
+
+// Hash returns a SHA-256 digest of the config in a canonical JSON form, with object keys sorted
+// and insignificant whitespace removed, so equal configs hash the same however they were formatted.
+func (c *Config) Hash() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var canonical any
+	if err := decoder.Decode(&canonical); err != nil {
+		return "", err
+	}
+	if data, err = json.Marshal(canonical); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
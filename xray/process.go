@@ -69,6 +69,17 @@ func GetAccessPersistentPrevLogPath() string {
 
 // GetAccessLogPath reads the Xray config and returns the access log file path.
 func GetAccessLogPath() (string, error) {
+	return getLogPath("access")
+}
+
+// GetErrorLogPath reads the Xray config and returns the error log file path.
+// It is empty when Xray writes its error log to stdout, where the panel logger picks it up.
+func GetErrorLogPath() (string, error) {
+	return getLogPath("error")
+}
+
+// getLogPath returns the named path from the log section of the Xray config.
+func getLogPath(name string) (string, error) {
 	config, err := os.ReadFile(GetConfigPath())
 	if err != nil {
 		// In Master-only mode, config.json doesn't exist - this is expected
@@ -82,11 +93,9 @@ func GetAccessLogPath() (string, error) {
 		return "", err
 	}
 
-	if jsonConfig["log"] != nil {
-		jsonLog := jsonConfig["log"].(map[string]any)
-		if jsonLog["access"] != nil {
-			accessLogPath := jsonLog["access"].(string)
-			return accessLogPath, nil
+	if jsonLog, ok := jsonConfig["log"].(map[string]any); ok {
+		if logPath, ok := jsonLog[name].(string); ok {
+			return logPath, nil
 		}
 	}
 	return "", err
@@ -189,6 +198,11 @@ func (p *Process) GetConfig() *Config {
 	return p.config
 }
 
+// SetConfig replaces the configuration used on the next Start, e.g. after it was changed live through the API.
+func (p *Process) SetConfig(config *Config) {
+	p.config = config
+}
+
 // GetOnlineClients returns the list of online clients for the Xray process.
 func (p *Process) GetOnlineClients() []string {
 	return p.onlineClients
@@ -216,18 +230,21 @@ func (p *process) refreshAPIPort() {
 
 // refreshVersion updates the version string by running the Xray binary with -version.
 func (p *process) refreshVersion() {
+	p.version = GetBinaryVersion()
+}
+
+// GetBinaryVersion runs the Xray binary with -version and returns its version, or "Unknown".
+func GetBinaryVersion() string {
 	cmd := exec.Command(GetBinaryPath(), "-version")
 	data, err := cmd.Output()
 	if err != nil {
-		p.version = "Unknown"
-	} else {
-		datas := bytes.Split(data, []byte(" "))
-		if len(datas) <= 1 {
-			p.version = "Unknown"
-		} else {
-			p.version = string(datas[1])
-		}
+		return "Unknown"
+	}
+	datas := bytes.Split(data, []byte(" "))
+	if len(datas) <= 1 {
+		return "Unknown"
 	}
+	return string(datas[1])
 }
 
 // Start launches the Xray process with the current configuration.
@@ -264,6 +281,9 @@ func (p *process) Start() (err error) {
 	}
 
 	configPath := GetConfigPath()
+	if p.configPath != "" {
+		configPath = p.configPath
+	}
 	logger.Debugf("Writing Xray configuration to: %s", configPath)
 	// Use more restrictive permissions (0600 = owner read/write only)
 	err = os.WriteFile(configPath, data, 0600)
@@ -300,6 +320,39 @@ func (p *process) Start() (err error) {
 	return nil
 }
 
+// Test checks the config with "xray -test" instead of starting Xray. It only works on a test
+// process, whose config file is removed afterwards. Geofiles are looked up in assetDir if it is
+// not empty. A rejected config is returned as an error carrying Xray's reason.
+func (p *process) Test(assetDir string) error {
+	if p.configPath == "" {
+		return errors.New("a config test needs its own config path")
+	}
+	data, err := json.MarshalIndent(p.config, "", "  ")
+	if err != nil {
+		return common.NewErrorf("Failed to generate XRAY configuration files: %v", err)
+	}
+	if err := os.WriteFile(p.configPath, data, 0600); err != nil {
+		return common.NewErrorf("Failed to write configuration file: %v", err)
+	}
+	defer os.Remove(p.configPath)
+
+	cmd := exec.Command(GetBinaryPath(), "-test", "-c", p.configPath)
+	if assetDir != "" {
+		cmd.Env = append(os.Environ(), "XRAY_LOCATION_ASSET="+assetDir)
+	}
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if err == nil || !errors.As(err, &exitErr) {
+		return err
+	}
+	// Xray prints its banner first and the reason last
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if reason := strings.TrimSpace(lines[len(lines)-1]); reason != "" {
+		return errors.New(reason)
+	}
+	return err
+}
+
 // Stop terminates the running Xray process and waits for it to fully exit.
 func (p *process) Stop() error {
 	logger.Info("Stopping Xray process...")
@@ -0,0 +1,57 @@
+package xray
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ReleaseDownloadURL is where Xray-core release assets are published.
+const ReleaseDownloadURL = "https://github.com/XTLS/Xray-core/releases/download"
+
+// ReleaseAssetName returns the name of the Xray-core release archive for the given GOOS and GOARCH.
+func ReleaseAssetName(goos, goarch string) string {
+	switch goos {
+	case "darwin":
+		goos = "macos"
+	}
+
+	switch goarch {
+	case "amd64":
+		goarch = "64"
+	case "arm64":
+		goarch = "arm64-v8a"
+	case "armv7":
+		goarch = "arm32-v7a"
+	case "armv6":
+		goarch = "arm32-v6"
+	case "armv5":
+		goarch = "arm32-v5"
+	case "386":
+		goarch = "32"
+	}
+
+	return fmt.Sprintf("Xray-%s-%s.zip", goos, goarch)
+}
+
+// ReleaseAssetURL returns the download URL of a release archive.
+func ReleaseAssetURL(version, asset string) string {
+	return fmt.Sprintf("%s/%s/%s", ReleaseDownloadURL, version, asset)
+}
+
+// ParseReleaseDigest extracts the SHA-256 checksum from a release's .dgst file,
+// which lists one "ALGORITHM= hex" line per hash.
+func ParseReleaseDigest(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		algorithm, sum, ok := strings.Cut(scanner.Text(), "=")
+		if ok && strings.TrimSpace(algorithm) == "SHA2-256" {
+			return strings.ToLower(strings.TrimSpace(sum)), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no SHA2-256 checksum in digest")
+}